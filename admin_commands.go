@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"github.com/treefix50/primetime/internal/storage"
+)
+
+const adminUsage = `usage:
+  primetime user list
+  primetime user add [-admin] [-password-stdin] <username>
+  primetime user reset-password [-password-stdin] [-reset-totp] <username>
+  primetime user set-admin <username> [true|false]
+  primetime user delete <username>
+  primetime session purge [-user <username> | -all]
+  primetime apikey create -user <username> -name <name> [-scopes read-only,playback,admin] [-expires <duration>]
+
+Every command accepts -db <path> and -db-busy-timeout <duration>, and the
+-password-* and -argon2-* flags of the server.
+
+The commands only write the database. A running server keeps validated sessions
+in its cache for up to 5 minutes, so sessions revoked by "session purge",
+"user reset-password" or "user delete" may be accepted there until the cache
+entry expires. Restart the server to revoke them at once. API keys are not
+cached.`
+
+// adminCommand reports whether the first argument selects an offline admin command
+// instead of starting the server.
+func adminCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "user", "session", "apikey":
+		return true
+	}
+	return false
+}
+
+// adminEnv bundles the auth manager and streams of an admin command
+type adminEnv struct {
+	manager *auth.Manager
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// runAdminCommand runs an offline admin command such as "user add". It works directly
+// on the database, so a locked-out admin can recover without a running server.
+func runAdminCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing subcommand\n%s", adminUsage)
+	}
+	command := args[0] + " " + args[1]
+
+	fs := flag.NewFlagSet("primetime "+command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	db := fs.String("db", defaultDBPath(), "sqlite database path")
+	busyTimeout := fs.Duration("db-busy-timeout", 5*time.Second, "sqlite busy timeout")
+	isAdmin := fs.Bool("admin", false, "create the user as admin")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	resetTOTP := fs.Bool("reset-totp", false, "also turn off two-factor authentication")
+	username := fs.String("user", "", "username")
+	all := fs.Bool("all", false, "purge the sessions of all users")
+	name := fs.String("name", "", "API key name")
+	scopes := fs.String("scopes", "", "comma-separated API key scopes")
+	expires := fs.Duration("expires", 0, "API key lifetime (0 never expires)")
+	passwords := addPasswordFlags(fs)
+	if err := fs.Parse(args[2:]); err != nil {
+		return fmt.Errorf("%v\n%s", err, adminUsage)
+	}
+	positional := fs.Args()
+
+	switch command {
+	case "user list", "user add", "user reset-password", "user set-admin", "user delete", "session purge", "apikey create":
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, adminUsage)
+	}
+
+	passwordHasher, err := passwords.hasher()
+	if err != nil {
+		return err
+	}
+	if err := ensureDBReadable(*db); err != nil {
+		return fmt.Errorf("open database %s: %w", *db, err)
+	}
+	store, err := storage.Open(*db, storage.Options{BusyTimeout: *busyTimeout})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	env := &adminEnv{
+		manager: passwords.newAuthManager(store, passwordHasher),
+		stdin:   stdin,
+		stdout:  stdout,
+	}
+
+	switch command {
+	case "user list":
+		return env.listUsers()
+	case "user add":
+		if len(positional) != 1 {
+			return fmt.Errorf("user add needs exactly one username\n%s", adminUsage)
+		}
+		return env.addUser(positional[0], *isAdmin, *passwordStdin)
+	case "user reset-password":
+		if len(positional) != 1 {
+			return fmt.Errorf("user reset-password needs exactly one username\n%s", adminUsage)
+		}
+		return env.resetPassword(positional[0], *passwordStdin, *resetTOTP)
+	case "user set-admin":
+		if len(positional) < 1 || len(positional) > 2 {
+			return fmt.Errorf("user set-admin needs a username and optionally true or false\n%s", adminUsage)
+		}
+		grant := true
+		if len(positional) == 2 {
+			if grant, err = strconv.ParseBool(positional[1]); err != nil {
+				return fmt.Errorf("invalid value %q, want true or false", positional[1])
+			}
+		}
+		return env.setAdmin(positional[0], grant)
+	case "user delete":
+		if len(positional) != 1 {
+			return fmt.Errorf("user delete needs exactly one username\n%s", adminUsage)
+		}
+		return env.deleteUser(positional[0])
+	case "session purge":
+		return env.purgeSessions(*username, *all)
+	default: // apikey create
+		return env.createAPIKey(*username, *name, *scopes, *expires)
+	}
+}
+
+func (e *adminEnv) listUsers() error {
+	users, err := e.manager.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tADMIN\tTOTP\tCREATED\tLAST LOGIN")
+	for _, user := range users {
+		lastLogin := "-"
+		if !user.LastLogin.IsZero() {
+			lastLogin = user.LastLogin.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\t%s\n", user.ID, user.Username, user.IsAdmin, user.TOTPEnabled,
+			user.CreatedAt.Format(time.RFC3339), lastLogin)
+	}
+	return tw.Flush()
+}
+
+func (e *adminEnv) addUser(username string, isAdmin, passwordStdin bool) error {
+	password, generated, err := e.password(passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	user, err := e.manager.CreateUser(username, password, isAdmin)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.stdout, "created user %s (id %s, admin %t)\n", user.Username, user.ID, user.IsAdmin)
+	if generated {
+		fmt.Fprintf(e.stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+func (e *adminEnv) resetPassword(username string, passwordStdin, resetTOTP bool) error {
+	user, err := e.manager.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	password, generated, err := e.password(passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	if err := e.manager.ResetPassword(user.ID, password); err != nil {
+		return err
+	}
+	// A reset is usually needed after a lockout, so the lockout goes as well
+	if err := e.manager.UnlockUser(user.ID); err != nil {
+		return err
+	}
+	if resetTOTP {
+		if err := e.manager.ResetTOTP(user.ID); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(e.stdout, "reset password of %s, sessions revoked, lockout cleared\n", user.Username)
+	if generated {
+		fmt.Fprintf(e.stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+func (e *adminEnv) setAdmin(username string, isAdmin bool) error {
+	user, err := e.manager.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if err := e.manager.SetAdmin(user.ID, isAdmin); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "%s admin: %t\n", user.Username, isAdmin)
+	return nil
+}
+
+func (e *adminEnv) deleteUser(username string) error {
+	user, err := e.manager.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if err := e.manager.DeleteUser(user.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "deleted user %s\n", user.Username)
+	return nil
+}
+
+// purgeSessions removes expired sessions, or all sessions of one or every user
+func (e *adminEnv) purgeSessions(username string, all bool) error {
+	switch {
+	case username != "" && all:
+		return fmt.Errorf("choose either -user or -all, not both")
+	case username != "":
+		user, err := e.manager.GetUserByUsername(username)
+		if err != nil {
+			return err
+		}
+		sessions, err := e.manager.ListSessions(user.ID)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if err := e.manager.RevokeSession(session.ID); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(e.stdout, "revoked %d session(s) of %s\n", len(sessions), user.Username)
+	case all:
+		users, err := e.manager.RevokeAllSessions()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "revoked the sessions of %d user(s)\n", users)
+	default:
+		if err := e.manager.CleanupExpiredSessions(); err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, "removed expired sessions")
+	}
+	return nil
+}
+
+func (e *adminEnv) createAPIKey(username, name, scopes string, expires time.Duration) error {
+	if username == "" || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("apikey create needs -user and -name\n%s", adminUsage)
+	}
+	if expires < 0 {
+		return fmt.Errorf("-expires must not be negative")
+	}
+	var expiresAt time.Time
+	if expires > 0 {
+		expiresAt = time.Now().Add(expires)
+	}
+	user, err := e.manager.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	key, plain, err := e.manager.CreateAPIKey(user.ID, strings.TrimSpace(name), strings.Split(scopes, ","), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.stdout, "created API key %s (id %s) for %s\n", key.Name, key.ID, user.Username)
+	fmt.Fprintf(e.stdout, "key: %s\n", plain)
+	return nil
+}
+
+// password reads the password from stdin or generates one. Passwords are never taken
+// from the command line, where they would end up in the shell history. Generated
+// passwords are extended until they satisfy the password policy.
+func (e *adminEnv) password(fromStdin bool) (string, bool, error) {
+	if !fromStdin {
+		password := auth.GenerateAdminPassword()
+		for e.manager.PasswordPolicy().Validate(password) != nil {
+			password += auth.GenerateAdminPassword()
+		}
+		return password, true, nil
+	}
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", false, fmt.Errorf("no password on stdin")
+	}
+	return password, false, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"github.com/treefix50/primetime/internal/storage"
+)
+
+// newAdminDB returns a migrated database file with the admin "root" and the user
+// "anna", who is logged in once
+func newAdminDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "primetime.db")
+	store, err := storage.Open(path, storage.Options{})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer store.Close()
+
+	manager := auth.NewManager(store, 24*time.Hour)
+	if _, err := manager.CreateUser("root", "root-password", true); err != nil {
+		t.Fatalf("CreateUser(root) error = %v", err)
+	}
+	if _, err := manager.CreateUser("anna", "anna-password", false); err != nil {
+		t.Fatalf("CreateUser(anna) error = %v", err)
+	}
+	if _, err := manager.Login("anna", "anna-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login(anna) error = %v", err)
+	}
+	return path
+}
+
+// openAdminDB opens the database file of a finished command for checks
+func openAdminDB(t *testing.T, path string) *auth.Manager {
+	t.Helper()
+	store, err := storage.Open(path, storage.Options{})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return auth.NewManager(store, 24*time.Hour)
+}
+
+func mustUser(t *testing.T, m *auth.Manager, username string) *auth.User {
+	t.Helper()
+	user, err := m.GetUserByUsername(username)
+	if err != nil {
+		t.Fatalf("GetUserByUsername(%s) error = %v", username, err)
+	}
+	return user
+}
+
+func wantLogin(t *testing.T, m *auth.Manager, username, password string) {
+	t.Helper()
+	if _, err := m.Login(username, password, auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login(%s) error = %v", username, err)
+	}
+}
+
+func wantSessions(t *testing.T, m *auth.Manager, username string, want int) {
+	t.Helper()
+	sessions, err := m.ListSessions(mustUser(t, m, username).ID)
+	if err != nil {
+		t.Fatalf("ListSessions(%s) error = %v", username, err)
+	}
+	if len(sessions) != want {
+		t.Fatalf("sessions of %s = %d; want %d", username, len(sessions), want)
+	}
+}
+
+func TestRunAdminCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantOut []string
+		wantErr string
+		check   func(t *testing.T, m *auth.Manager, out string)
+	}{
+		{
+			name:    "user list",
+			args:    []string{"user", "list"},
+			wantOut: []string{"USERNAME", "root", "anna"},
+		},
+		{
+			name:    "user add generates a password",
+			args:    []string{"user", "add", "bob"},
+			wantOut: []string{"created user bob", "admin false", "password: "},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				password := strings.TrimSpace(out[strings.Index(out, "password: ")+len("password: "):])
+				wantLogin(t, m, "bob", password)
+			},
+		},
+		{
+			name:    "user add reads the password from stdin",
+			args:    []string{"user", "add", "-admin", "-password-stdin", "bob"},
+			stdin:   "bob-password\n",
+			wantOut: []string{"created user bob", "admin true"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				if strings.Contains(out, "password: ") {
+					t.Fatalf("output %q shows the password from stdin", out)
+				}
+				if !mustUser(t, m, "bob").IsAdmin {
+					t.Fatalf("bob is no admin")
+				}
+				wantLogin(t, m, "bob", "bob-password")
+			},
+		},
+		{
+			name:    "user add without password on stdin",
+			args:    []string{"user", "add", "-password-stdin", "bob"},
+			wantErr: "no password on stdin",
+		},
+		{
+			name:    "user add rejects a weak password",
+			args:    []string{"user", "add", "-password-stdin", "bob"},
+			stdin:   "short\n",
+			wantErr: "password",
+		},
+		{
+			name:    "user add without username",
+			args:    []string{"user", "add"},
+			wantErr: "exactly one username",
+		},
+		{
+			name:    "user reset-password",
+			args:    []string{"user", "reset-password", "-password-stdin", "anna"},
+			stdin:   "anna-new-password\n",
+			wantOut: []string{"reset password of anna, sessions revoked"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				wantSessions(t, m, "anna", 0)
+				wantLogin(t, m, "anna", "anna-new-password")
+			},
+		},
+		{
+			name:    "user reset-password of an unknown user",
+			args:    []string{"user", "reset-password", "nobody"},
+			wantErr: auth.ErrUserNotFound.Error(),
+		},
+		{
+			name:    "user set-admin",
+			args:    []string{"user", "set-admin", "anna"},
+			wantOut: []string{"anna admin: true"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				if !mustUser(t, m, "anna").IsAdmin {
+					t.Fatalf("anna is no admin")
+				}
+			},
+		},
+		{
+			name:    "user set-admin keeps the last admin",
+			args:    []string{"user", "set-admin", "root", "false"},
+			wantErr: "admin",
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				if !mustUser(t, m, "root").IsAdmin {
+					t.Fatalf("root lost the admin role")
+				}
+			},
+		},
+		{
+			name:    "user set-admin with an invalid value",
+			args:    []string{"user", "set-admin", "anna", "maybe"},
+			wantErr: "want true or false",
+		},
+		{
+			name:    "user delete",
+			args:    []string{"user", "delete", "anna"},
+			wantOut: []string{"deleted user anna"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				if _, err := m.GetUserByUsername("anna"); !errors.Is(err, auth.ErrUserNotFound) {
+					t.Fatalf("GetUserByUsername(anna) error = %v; want ErrUserNotFound", err)
+				}
+			},
+		},
+		{
+			name:    "session purge of one user",
+			args:    []string{"session", "purge", "-user", "anna"},
+			wantOut: []string{"revoked 1 session(s) of anna"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				wantSessions(t, m, "anna", 0)
+			},
+		},
+		{
+			name:    "session purge of all users",
+			args:    []string{"session", "purge", "-all"},
+			wantOut: []string{"revoked the sessions of"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				wantSessions(t, m, "anna", 0)
+			},
+		},
+		{
+			name:    "session purge of expired sessions",
+			args:    []string{"session", "purge"},
+			wantOut: []string{"removed expired sessions"},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				wantSessions(t, m, "anna", 1)
+			},
+		},
+		{
+			name:    "session purge with -user and -all",
+			args:    []string{"session", "purge", "-user", "anna", "-all"},
+			wantErr: "not both",
+		},
+		{
+			name:    "apikey create",
+			args:    []string{"apikey", "create", "-user", "anna", "-name", "tv", "-scopes", "playback", "-expires", "24h"},
+			wantOut: []string{"created API key tv", "key: " + auth.APIKeyPrefix},
+			check: func(t *testing.T, m *auth.Manager, out string) {
+				keys, err := m.ListAPIKeys(mustUser(t, m, "anna").ID)
+				if err != nil {
+					t.Fatalf("ListAPIKeys() error = %v", err)
+				}
+				if len(keys) != 1 || !reflect.DeepEqual(keys[0].Scopes, []string{"playback"}) || keys[0].ExpiresAt.IsZero() {
+					t.Fatalf("keys = %+v; want one playback key with an expiry", keys)
+				}
+			},
+		},
+		{
+			name:    "apikey create without name",
+			args:    []string{"apikey", "create", "-user", "anna"},
+			wantErr: "needs -user and -name",
+		},
+		{
+			name:    "apikey create with a negative lifetime",
+			args:    []string{"apikey", "create", "-user", "anna", "-name", "tv", "-expires", "-1h"},
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "unknown command",
+			args:    []string{"user", "rename", "anna"},
+			wantErr: "unknown command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newAdminDB(t)
+			args := append([]string{tt.args[0], tt.args[1], "-db", db}, tt.args[2:]...)
+			var stdout bytes.Buffer
+
+			err := runAdminCommand(args, strings.NewReader(tt.stdin), &stdout)
+			out := stdout.String()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("runAdminCommand() error = %v; want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("runAdminCommand() error = %v", err)
+			}
+			for _, want := range tt.wantOut {
+				if !strings.Contains(out, want) {
+					t.Fatalf("output %q; want %q", out, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, openAdminDB(t, db), out)
+			}
+		})
+	}
+}
+
+func TestRunAdminCommandMissingDatabase(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "missing.db")
+	err := runAdminCommand([]string{"user", "list", "-db", db}, strings.NewReader(""), &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("runAdminCommand() on a missing database succeeded")
+	}
+}
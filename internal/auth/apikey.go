@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix marks tokens that are API keys rather than session tokens
+const APIKeyPrefix = "pt_"
+
+// API key scopes. A key without scopes has the full rights of its owner.
+const (
+	ScopeReadOnly = "read-only"
+	ScopePlayback = "playback"
+	ScopeAdmin    = "admin"
+)
+
+// apiKeyTouchInterval limits how often the last-used timestamp is written
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidScope   = errors.New("invalid scope")
+)
+
+// APIKey represents a named, revocable key for non-interactive clients
+type APIKey struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	KeyHash    string    `json:"-"` // Never expose key hash
+	Scopes     []string  `json:"scopes,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+	// ExpiresAt is zero for keys that never expire
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// HashAPIKey hashes an API key for storage. Keys are random, so a plain SHA-256 is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeScopes validates scopes and removes duplicates
+func NormalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+		switch scope {
+		case ScopeReadOnly, ScopePlayback, ScopeAdmin:
+		default:
+			return nil, ErrInvalidScope
+		}
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+	return normalized, nil
+}
+
+// HasScope reports whether the session is restricted to scopes that include scope
+func (s *Session) HasScope(scope string) bool {
+	for _, candidate := range s.Scopes {
+		if candidate == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates a new API key for a user, expiring at expiresAt unless it is zero.
+// The plain key is only returned here.
+func (m *Manager) CreateAPIKey(userID, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error) {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scopes, err = NormalizeScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAdmin && !user.IsAdmin {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	plain := APIKeyPrefix + GenerateToken()
+	key := APIKey{
+		ID:        generateID("key_"),
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    plain[:len(APIKeyPrefix)+8],
+		KeyHash:   HashAPIKey(plain),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := m.store.CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+
+	return &key, plain, nil
+}
+
+// ListAPIKeys lists API keys; an empty userID lists the keys of all users
+func (m *Manager) ListAPIKeys(userID string) ([]APIKey, error) {
+	return m.store.ListAPIKeys(userID)
+}
+
+// RevokeAPIKey deletes an API key
+func (m *Manager) RevokeAPIKey(id string) error {
+	return m.store.DeleteAPIKey(id)
+}
+
+// validateAPIKey resolves an API key to a session-like view of its owner
+func (m *Manager) validateAPIKey(token string) (*Session, error) {
+	key, err := m.store.GetAPIKeyByHash(HashAPIKey(token))
+	if err != nil {
+		if err == ErrAPIKeyNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	user, err := m.store.GetUser(key.UserID)
+	if err != nil {
+		if err == ErrUserNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if now.Sub(key.LastUsedAt) > apiKeyTouchInterval {
+		// Best effort, a failed update must not reject the request
+		_ = m.store.TouchAPIKey(key.ID, now)
+	}
+
+	session := &Session{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Scopes:    key.Scopes,
+		APIKeyID:  key.ID,
+		CreatedAt: key.CreatedAt,
+	}
+	session.IsAdmin = user.IsAdmin && (len(key.Scopes) == 0 || session.HasScope(ScopeAdmin))
+
+	return session, nil
+}
@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestAPIKeyValidation(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+	admin, err := manager.CreateUser("root", "correct-password", true)
+	if err != nil {
+		t.Fatalf("CreateUser(root) error = %v", err)
+	}
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser(alice) error = %v", err)
+	}
+
+	create := func(userID string, scopes []string, expiresAt time.Time) (*auth.APIKey, string) {
+		t.Helper()
+		key, plain, err := manager.CreateAPIKey(userID, "client", scopes, expiresAt)
+		if err != nil {
+			t.Fatalf("CreateAPIKey(%v) error = %v", scopes, err)
+		}
+		return key, plain
+	}
+	_, full := create(admin.ID, nil, time.Time{})
+	_, adminScope := create(admin.ID, []string{"Admin", "admin"}, time.Time{})
+	readKey, readOnly := create(admin.ID, []string{auth.ScopeReadOnly}, time.Time{})
+	_, playback := create(user.ID, []string{auth.ScopePlayback}, time.Now().Add(time.Hour))
+	revokedKey, revoked := create(user.ID, nil, time.Time{})
+	if err := manager.RevokeAPIKey(revokedKey.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() error = %v", err)
+	}
+	expiredKey, expired := create(user.ID, nil, time.Now().Add(-time.Minute))
+
+	// Keys carry the pt_ prefix and are stored by hash only
+	if !strings.HasPrefix(readOnly, auth.APIKeyPrefix) || !strings.HasPrefix(readOnly, readKey.Prefix) || len(readKey.Prefix) != len(auth.APIKeyPrefix)+8 {
+		t.Fatalf("key %q with prefix %q; want pt_ and 8 characters", readOnly, readKey.Prefix)
+	}
+	stored, err := store.GetAPIKeyByHash(auth.HashAPIKey(readOnly))
+	if err != nil || stored.ID != readKey.ID || stored.KeyHash == readOnly {
+		t.Fatalf("GetAPIKeyByHash() = %+v, %v; want the hashed key", stored, err)
+	}
+	if stored, err := store.GetAPIKeyByHash(auth.HashAPIKey(expired)); err != nil || !stored.ExpiresAt.Equal(expiredKey.ExpiresAt.Truncate(time.Second)) {
+		t.Fatalf("GetAPIKeyByHash(expired) = %+v, %v; want the expiry", stored, err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		userID  string
+		isAdmin bool
+		scopes  []string
+		err     error
+	}{
+		{"full key of an admin", full, admin.ID, true, nil, nil},
+		{"admin scope", adminScope, admin.ID, true, []string{auth.ScopeAdmin}, nil},
+		{"read-only key of an admin", readOnly, admin.ID, false, []string{auth.ScopeReadOnly}, nil},
+		{"playback key", playback, user.ID, false, []string{auth.ScopePlayback}, nil},
+		{"revoked", revoked, "", false, nil, auth.ErrInvalidToken},
+		{"expired", expired, "", false, nil, auth.ErrTokenExpired},
+		{"unknown", auth.APIKeyPrefix + "unknown", "", false, nil, auth.ErrInvalidToken},
+		{"without prefix", strings.TrimPrefix(full, auth.APIKeyPrefix), "", false, nil, auth.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := manager.ValidateSession(tt.token)
+			if err != tt.err {
+				t.Fatalf("ValidateSession() error = %v; want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if session.UserID != tt.userID || session.IsAdmin != tt.isAdmin || strings.Join(session.Scopes, ",") != strings.Join(tt.scopes, ",") || session.APIKeyID == "" {
+				t.Fatalf("ValidateSession() = %+v; want user %s, admin %v, scopes %v", session, tt.userID, tt.isAdmin, tt.scopes)
+			}
+		})
+	}
+
+	// Scopes are validated, and only admins may hand out the admin scope
+	if _, _, err := manager.CreateAPIKey(user.ID, "client", []string{"write"}, time.Time{}); err != auth.ErrInvalidScope {
+		t.Fatalf("CreateAPIKey(unknown scope) error = %v; want ErrInvalidScope", err)
+	}
+	if _, _, err := manager.CreateAPIKey(user.ID, "client", []string{auth.ScopeAdmin}, time.Time{}); err != auth.ErrInvalidScope {
+		t.Fatalf("CreateAPIKey(admin scope of a user) error = %v; want ErrInvalidScope", err)
+	}
+	if keys, err := manager.ListAPIKeys(user.ID); err != nil || len(keys) != 2 {
+		t.Fatalf("ListAPIKeys(alice) = %d keys, %v; want 2", len(keys), err)
+	}
+}
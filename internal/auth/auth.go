@@ -7,15 +7,15 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrUserExists         = errors.New("user already exists")
+	ErrLastAdmin          = errors.New("cannot remove the last admin")
 	ErrInvalidToken       = errors.New("invalid token")
 	ErrTokenExpired       = errors.New("token expired")
 )
@@ -28,16 +28,27 @@ type User struct {
 	IsAdmin      bool      `json:"isAdmin"`
 	CreatedAt    time.Time `json:"createdAt"`
 	LastLogin    time.Time `json:"lastLogin,omitempty"`
+	// TOTP second factor; the secret is set during enrollment and only used once enabled
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totpEnabled"`
+	TOTPLastStep int64  `json:"-"`
 }
 
 // Session represents an active user session
 type Session struct {
-	Token     string    `json:"token"`
-	UserID    string    `json:"userId"`
-	Username  string    `json:"username"`
-	IsAdmin   bool      `json:"isAdmin"`
-	CreatedAt time.Time `json:"createdAt"`
-	ExpiresAt time.Time `json:"expiresAt"`
+	ID         string    `json:"id,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	IsAdmin    bool      `json:"isAdmin"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	LastSeenAt time.Time `json:"lastSeenAt,omitempty"`
+	ClientName string    `json:"clientName,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	// Set when the request was authenticated with an API key
+	APIKeyID string   `json:"apiKeyId,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
 }
 
 // Store defines the interface for authentication storage
@@ -54,9 +65,42 @@ type Store interface {
 	// Session management
 	CreateSession(session Session) error
 	GetSession(token string) (*Session, error)
+	GetSessionByID(id string) (*Session, error)
+	ListUserSessions(userID string) ([]Session, error)
+	TouchSession(token string, lastSeenAt, expiresAt time.Time) error
 	DeleteSession(token string) error
 	DeleteUserSessions(userID string) error
 	CleanExpiredSessions() error
+
+	// API key management
+	CreateAPIKey(key APIKey) error
+	GetAPIKeyByHash(hash string) (*APIKey, error)
+	ListAPIKeys(userID string) ([]APIKey, error)
+	DeleteAPIKey(id string) error
+	TouchAPIKey(id string, usedAt time.Time) error
+
+	// Login failure tracking
+	GetLoginFailure(key string) (LoginFailure, error)
+	SaveLoginFailure(failure LoginFailure) error
+	RecordLoginFailure(key string, at, staleBefore time.Time) (LoginFailure, error)
+	LockLogin(key string, until time.Time) error
+	DeleteLoginFailure(key string) error
+
+	// Two-factor recovery codes
+	ReplaceRecoveryCodes(userID string, hashes []string) error
+	UseRecoveryCode(userID, hash string, usedAt time.Time) (bool, error)
+	UseTOTPStep(userID string, step int64) (bool, error)
+	CountRecoveryCodes(userID string) (int, error)
+
+	// Invites
+	CreateInvite(invite Invite) error
+	GetInviteByHash(hash string) (*Invite, error)
+	ListInvites() ([]Invite, error)
+	DeleteInvite(id string) error
+	UseInvite(id string, usedAt time.Time) (bool, error)
+	ReleaseInvite(id string) error
+	// CreateInvitedUser creates the user together with the library roots of the invite
+	CreateInvitedUser(user User, invite Invite) error
 }
 
 // Manager handles authentication operations
@@ -64,6 +108,9 @@ type Manager struct {
 	store           Store
 	sessionDuration time.Duration
 	sessionCache    *SessionCache
+	challenges      *challengeStore
+	passwordPolicy  PasswordPolicy
+	passwordHasher  PasswordHasher
 }
 
 // NewManager creates a new authentication manager
@@ -75,6 +122,9 @@ func NewManager(store Store, sessionDuration time.Duration) *Manager {
 		store:           store,
 		sessionDuration: sessionDuration,
 		sessionCache:    NewSessionCache(5 * time.Minute), // 5 minute cache TTL
+		challenges:      newChallengeStore(),
+		passwordPolicy:  DefaultPasswordPolicy(),
+		passwordHasher:  defaultPasswordHasher,
 	}
 }
 
@@ -89,19 +139,15 @@ func GenerateAdminPassword() string {
 	return base64.URLEncoding.EncodeToString(bytes)[:22] // 22 characters
 }
 
-// HashPassword hashes a password using bcrypt
+// HashPassword hashes a password using argon2id with the default parameters
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
+	return defaultPasswordHasher.Hash(password)
 }
 
-// VerifyPassword verifies a password against a hash
+// VerifyPassword verifies a password against an argon2id or bcrypt hash
 func VerifyPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	ok, _ := verifyWith(defaultPasswordHasher, password, hash)
+	return ok
 }
 
 // GenerateToken generates a secure random token
@@ -135,7 +181,7 @@ func (m *Manager) InitializeAdmin() (string, error) {
 
 	// Generate admin password
 	password := GenerateAdminPassword()
-	passwordHash, err := HashPassword(password)
+	passwordHash, err := m.hashPassword(password)
 	if err != nil {
 		return "", err
 	}
@@ -156,34 +202,83 @@ func (m *Manager) InitializeAdmin() (string, error) {
 	return password, nil
 }
 
-// Login authenticates a user and creates a session
-func (m *Manager) Login(username, password string) (*Session, error) {
+// Login authenticates a user and creates a session. Failed attempts are tracked per
+// username and per client IP; throttled attempts and the failure that locks the account
+// return a *ThrottleError. Users with two-factor authentication get a
+// *SecondFactorChallenge instead, see CompleteSecondFactor.
+func (m *Manager) Login(username, password string, client ClientInfo) (*Session, error) {
+	now := time.Now()
+	userFailure, err := m.loadLoginFailure(usernameFailureKey(username), now)
+	if err != nil {
+		return nil, err
+	}
+	var ipFailure LoginFailure
+	if client.IP != "" {
+		if ipFailure, err = m.loadLoginFailure(ipFailureKey(client.IP), now); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.checkLoginThrottle(userFailure, ipFailure, now); err != nil {
+		return nil, err
+	}
+
 	user, err := m.store.GetUserByUsername(username)
 	if err != nil {
 		if err == ErrUserNotFound {
+			if err := m.recordLoginFailure(userFailure, ipFailure, now); err != nil {
+				return nil, err
+			}
 			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 
-	if !VerifyPassword(password, user.PasswordHash) {
+	ok, rehash := m.verifyPassword(user, password)
+	if !ok {
+		if err := m.recordLoginFailure(userFailure, ipFailure, now); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
+	// Hashes of older algorithms or parameters are upgraded while the password is known
+	if rehash {
+		m.rehashPassword(user, password)
+	}
+
+	// The password is correct, the session is only created once the second factor is verified
+	if user.TOTPEnabled {
+		return nil, m.challenges.create(user.ID, client, now)
+	}
+
+	return m.completeLogin(user, userFailure, client, now)
+}
+
+// completeLogin resets the failure counter and creates the session for an authenticated user
+func (m *Manager) completeLogin(user *User, userFailure LoginFailure, client ClientInfo, now time.Time) (*Session, error) {
+	if userFailure.Failures > 0 {
+		if err := m.store.DeleteLoginFailure(userFailure.Key); err != nil {
+			return nil, err
+		}
+	}
 
 	// Update last login
-	user.LastLogin = time.Now()
+	user.LastLogin = now
 	if err := m.store.UpdateUser(*user); err != nil {
 		// Log error but don't fail login
 	}
 
 	// Create session
 	session := Session{
-		Token:     GenerateToken(),
-		UserID:    user.ID,
-		Username:  user.Username,
-		IsAdmin:   user.IsAdmin,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(m.sessionDuration),
+		ID:         generateID("sess_"),
+		Token:      GenerateToken(),
+		UserID:     user.ID,
+		Username:   user.Username,
+		IsAdmin:    user.IsAdmin,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(m.sessionDuration),
+		LastSeenAt: now,
+		ClientName: client.Name,
+		IP:         client.IP,
 	}
 
 	if err := m.store.CreateSession(session); err != nil {
@@ -210,10 +305,14 @@ func (m *Manager) Logout(token string) error {
 
 // ValidateSession validates a session token with caching
 func (m *Manager) ValidateSession(token string) (*Session, error) {
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return m.validateAPIKey(token)
+	}
+
 	// Try cache first for performance
 	if m.sessionCache != nil {
 		if session, found := m.sessionCache.Get(token); found {
-			return session, nil
+			return m.renewSession(session, time.Now())
 		}
 	}
 
@@ -236,35 +335,47 @@ func (m *Manager) ValidateSession(token string) (*Session, error) {
 		m.sessionCache.Set(session)
 	}
 
-	return session, nil
+	return m.renewSession(session, time.Now())
 }
 
 // CreateUser creates a new user (admin only)
 func (m *Manager) CreateUser(username, password string, isAdmin bool) (*User, error) {
+	user, err := m.newUser(username, password, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.CreateUser(user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// newUser checks the username and password and builds the user to store
+func (m *Manager) newUser(username, password string, isAdmin bool) (User, error) {
 	// Check if user exists
 	existing, err := m.store.GetUserByUsername(username)
 	if err == nil && existing != nil {
-		return nil, ErrUserExists
+		return User{}, ErrUserExists
+	}
+
+	if err := m.passwordPolicy.Validate(password); err != nil {
+		return User{}, err
 	}
 
-	passwordHash, err := HashPassword(password)
+	passwordHash, err := m.hashPassword(password)
 	if err != nil {
-		return nil, err
+		return User{}, err
 	}
 
-	user := User{
+	return User{
 		ID:           GenerateUserID(username),
 		Username:     username,
 		PasswordHash: passwordHash,
 		IsAdmin:      isAdmin,
 		CreatedAt:    time.Now(),
-	}
-
-	if err := m.store.CreateUser(user); err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	}, nil
 }
 
 // ChangePassword changes a user's password
@@ -274,11 +385,15 @@ func (m *Manager) ChangePassword(userID, oldPassword, newPassword string) error
 		return err
 	}
 
-	if !VerifyPassword(oldPassword, user.PasswordHash) {
+	if ok, _ := m.verifyPassword(user, oldPassword); !ok {
 		return ErrInvalidCredentials
 	}
 
-	newHash, err := HashPassword(newPassword)
+	if err := m.passwordPolicy.Validate(newPassword); err != nil {
+		return err
+	}
+
+	newHash, err := m.hashPassword(newPassword)
 	if err != nil {
 		return err
 	}
@@ -289,7 +404,7 @@ func (m *Manager) ChangePassword(userID, oldPassword, newPassword string) error
 	}
 
 	// Invalidate all sessions for this user
-	return m.store.DeleteUserSessions(userID)
+	return m.revokeUserSessions(userID)
 }
 
 // ResetPassword resets a user's password (admin only)
@@ -299,7 +414,11 @@ func (m *Manager) ResetPassword(userID, newPassword string) error {
 		return err
 	}
 
-	newHash, err := HashPassword(newPassword)
+	if err := m.passwordPolicy.Validate(newPassword); err != nil {
+		return err
+	}
+
+	newHash, err := m.hashPassword(newPassword)
 	if err != nil {
 		return err
 	}
@@ -310,19 +429,80 @@ func (m *Manager) ResetPassword(userID, newPassword string) error {
 	}
 
 	// Invalidate all sessions for this user
-	return m.store.DeleteUserSessions(userID)
+	return m.revokeUserSessions(userID)
 }
 
-// DeleteUser deletes a user (admin only)
+// DeleteUser deletes a user (admin only). The last admin cannot be deleted.
 func (m *Manager) DeleteUser(userID string) error {
+	if err := m.ensureOtherAdmin(userID); err != nil {
+		return err
+	}
+
 	// Delete all sessions first
-	if err := m.store.DeleteUserSessions(userID); err != nil {
+	if err := m.revokeUserSessions(userID); err != nil {
 		return err
 	}
 
 	return m.store.DeleteUser(userID)
 }
 
+// SetAdmin grants or revokes admin rights. The last admin cannot be demoted.
+func (m *Manager) SetAdmin(userID string, isAdmin bool) error {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.IsAdmin == isAdmin {
+		return nil
+	}
+	if !isAdmin {
+		if err := m.ensureOtherAdmin(userID); err != nil {
+			return err
+		}
+	}
+
+	user.IsAdmin = isAdmin
+	if err := m.store.UpdateUser(*user); err != nil {
+		return err
+	}
+
+	// Sessions carry the admin flag
+	return m.revokeUserSessions(userID)
+}
+
+// ensureOtherAdmin returns ErrLastAdmin when userID is the only admin
+func (m *Manager) ensureOtherAdmin(userID string) error {
+	users, err := m.store.ListUsers()
+	if err != nil {
+		return err
+	}
+	isAdmin, others := false, 0
+	for _, user := range users {
+		if !user.IsAdmin {
+			continue
+		}
+		if user.ID == userID {
+			isAdmin = true
+		} else {
+			others++
+		}
+	}
+	if isAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// GetUser retrieves a user by ID
+func (m *Manager) GetUser(userID string) (*User, error) {
+	return m.store.GetUser(userID)
+}
+
+// GetUserByUsername retrieves a user by username
+func (m *Manager) GetUserByUsername(username string) (*User, error) {
+	return m.store.GetUserByUsername(username)
+}
+
 // ListUsers lists all users (admin only)
 func (m *Manager) ListUsers() ([]User, error) {
 	return m.store.ListUsers()
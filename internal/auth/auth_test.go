@@ -0,0 +1,63 @@
+package auth_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"github.com/treefix50/primetime/internal/storage"
+)
+
+// newTestStore returns a migrated store in a temporary database file
+func newTestStore(t *testing.T) *storage.Store {
+	t.Helper()
+	store, err := storage.Open(filepath.Join(t.TempDir(), "primetime.db"), storage.Options{})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestLastAdminIsProtected(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	admin, err := manager.CreateUser("root", "correct-password", true)
+	if err != nil {
+		t.Fatalf("CreateUser(root) error = %v", err)
+	}
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser(alice) error = %v", err)
+	}
+
+	if err := manager.SetAdmin(admin.ID, false); err != auth.ErrLastAdmin {
+		t.Fatalf("SetAdmin(last admin, false) error = %v; want ErrLastAdmin", err)
+	}
+	if err := manager.DeleteUser(admin.ID); err != auth.ErrLastAdmin {
+		t.Fatalf("DeleteUser(last admin) error = %v; want ErrLastAdmin", err)
+	}
+
+	session, err := manager.Login("alice", "correct-password", auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if err := manager.SetAdmin(user.ID, true); err != nil {
+		t.Fatalf("SetAdmin(alice, true) error = %v", err)
+	}
+	// Sessions carry the admin flag and are revoked on change
+	if _, err := manager.ValidateSession(session.Token); err == nil {
+		t.Fatalf("ValidateSession() after SetAdmin succeeded; want revoked session")
+	}
+
+	if err := manager.SetAdmin(admin.ID, false); err != nil {
+		t.Fatalf("SetAdmin(root, false) with another admin error = %v", err)
+	}
+	if err := manager.DeleteUser(admin.ID); err != nil {
+		t.Fatalf("DeleteUser(root) error = %v", err)
+	}
+}
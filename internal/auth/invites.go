@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// InvitePrefix marks invite codes
+const InvitePrefix = "inv_"
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 90 * 24 * time.Hour
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	// ErrInvalidInvite covers unknown, expired and used-up codes alike
+	ErrInvalidInvite = errors.New("invalid or expired invite")
+)
+
+// Invite lets a new user register an account. IsAdmin, AllRoots and RootIDs form the
+// template applied to the account; RootIDs only apply when AllRoots is false.
+type Invite struct {
+	ID        string    `json:"id"`
+	Prefix    string    `json:"prefix"`
+	CodeHash  string    `json:"-"` // Never expose code hash
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	IsAdmin   bool      `json:"isAdmin"`
+	AllRoots  bool      `json:"allRoots"`
+	RootIDs   []string  `json:"rootIds,omitempty"`
+}
+
+// InviteOptions configure a new invite; zero values use the defaults of one use and seven days
+type InviteOptions struct {
+	TTL      time.Duration
+	MaxUses  int
+	IsAdmin  bool
+	AllRoots bool
+	RootIDs  []string
+}
+
+// usable reports whether the invite can still be redeemed
+func (i *Invite) usable(now time.Time) bool {
+	return now.Before(i.ExpiresAt) && i.Uses < i.MaxUses
+}
+
+// CreateInvite creates an invite. The plain code is only returned here.
+func (m *Manager) CreateInvite(createdBy string, options InviteOptions) (*Invite, string, error) {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl > maxInviteTTL {
+		ttl = maxInviteTTL
+	}
+	maxUses := options.MaxUses
+	if maxUses <= 0 {
+		maxUses = 1
+	}
+
+	now := time.Now()
+	plain := InvitePrefix + GenerateToken()[:32]
+	invite := Invite{
+		ID:        generateID("inv_"),
+		Prefix:    plain[:len(InvitePrefix)+8],
+		CodeHash:  HashAPIKey(plain),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		MaxUses:   maxUses,
+		IsAdmin:   options.IsAdmin,
+		AllRoots:  options.AllRoots,
+	}
+	if !invite.AllRoots {
+		invite.RootIDs = options.RootIDs
+	}
+
+	if err := m.store.CreateInvite(invite); err != nil {
+		return nil, "", err
+	}
+
+	return &invite, plain, nil
+}
+
+// ListInvites lists all invites, including expired and used-up ones
+func (m *Manager) ListInvites() ([]Invite, error) {
+	return m.store.ListInvites()
+}
+
+// RevokeInvite deletes an invite
+func (m *Manager) RevokeInvite(id string) error {
+	return m.store.DeleteInvite(id)
+}
+
+// Register redeems an invite and creates the account with the password chosen by the
+// user. The account gets the library roots of the invite when it is created, so it never
+// exists with more access than the invite grants.
+func (m *Manager) Register(code, username, password string) (*User, *Invite, error) {
+	now := time.Now()
+	invite, err := m.store.GetInviteByHash(HashAPIKey(strings.TrimSpace(code)))
+	if err != nil {
+		if err == ErrInviteNotFound {
+			return nil, nil, ErrInvalidInvite
+		}
+		return nil, nil, err
+	}
+	if !invite.usable(now) {
+		return nil, nil, ErrInvalidInvite
+	}
+
+	// Check everything that can fail before a use of the invite is spent
+	if err := m.passwordPolicy.Validate(password); err != nil {
+		return nil, nil, err
+	}
+	if existing, err := m.store.GetUserByUsername(username); err == nil && existing != nil {
+		return nil, nil, ErrUserExists
+	}
+
+	ok, err := m.store.UseInvite(invite.ID, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		// Used up or revoked by a concurrent request
+		return nil, nil, ErrInvalidInvite
+	}
+
+	user, err := m.newUser(username, password, invite.IsAdmin)
+	if err == nil {
+		err = m.store.CreateInvitedUser(user, *invite)
+	}
+	if err != nil {
+		// Best effort, the use was not consumed by an account
+		_ = m.store.ReleaseInvite(invite.ID)
+		return nil, nil, err
+	}
+
+	invite.Uses++
+	return &user, invite, nil
+}
@@ -0,0 +1,83 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestInviteRegistration(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+	manager.SetPasswordPolicy(auth.PasswordPolicy{MinLength: 10, RequireDigit: true})
+
+	if _, err := manager.CreateUser("weak", "short", false); !errors.Is(err, auth.ErrWeakPassword) {
+		t.Fatalf("CreateUser(short password) error = %v; want ErrWeakPassword", err)
+	}
+
+	root, err := store.AddRoot(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("AddRoot() error = %v", err)
+	}
+	invite, code, err := manager.CreateInvite("admin", auth.InviteOptions{MaxUses: 2, RootIDs: []string{root.ID, "removed-root"}})
+	if err != nil {
+		t.Fatalf("CreateInvite() error = %v", err)
+	}
+	if !strings.HasPrefix(code, auth.InvitePrefix) || invite.AllRoots || invite.MaxUses != 2 {
+		t.Fatalf("CreateInvite() = %#v, %q; want restricted invite with two uses", invite, code)
+	}
+
+	if _, _, err := manager.Register("inv_wrong", "bob", "long-password-1"); err != auth.ErrInvalidInvite {
+		t.Fatalf("Register(wrong code) error = %v; want ErrInvalidInvite", err)
+	}
+	if _, _, err := manager.Register(code, "bob", "no-digits-here"); !errors.Is(err, auth.ErrWeakPassword) {
+		t.Fatalf("Register(weak password) error = %v; want ErrWeakPassword", err)
+	}
+
+	user, redeemed, err := manager.Register(code, "bob", "long-password-1")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if user.IsAdmin || len(redeemed.RootIDs) != 2 {
+		t.Fatalf("Register() = %#v, %#v; want template of the invite", user, redeemed)
+	}
+	// The account is created with the roots of the invite that still exist
+	access, found, err := store.GetUserRootAccess(user.ID)
+	if err != nil || !found || access.AllRoots || len(access.RootIDs) != 1 || access.RootIDs[0] != root.ID {
+		t.Fatalf("GetUserRootAccess() = %+v, %v, %v; want only %s", access, found, err, root.ID)
+	}
+	if _, _, err := manager.Register(code, "bob", "long-password-1"); err != auth.ErrUserExists {
+		t.Fatalf("Register(existing user) error = %v; want ErrUserExists", err)
+	}
+	if _, _, err := manager.Register(code, "carol", "long-password-2"); err != nil {
+		t.Fatalf("Register(second use) error = %v", err)
+	}
+	if _, _, err := manager.Register(code, "dave", "long-password-3"); err != auth.ErrInvalidInvite {
+		t.Fatalf("Register(used up) error = %v; want ErrInvalidInvite", err)
+	}
+
+	invites, err := manager.ListInvites()
+	if err != nil || len(invites) != 1 || invites[0].Uses != 2 {
+		t.Fatalf("ListInvites() = %#v, %v; want one invite with two uses", invites, err)
+	}
+	if ok, err := store.UseInvite(invite.ID, time.Now()); err != nil || ok {
+		t.Fatalf("UseInvite(used up) = %v, %v; want false", ok, err)
+	}
+
+	expired, expiredCode, err := manager.CreateInvite("admin", auth.InviteOptions{AllRoots: true})
+	if err != nil {
+		t.Fatalf("CreateInvite() error = %v", err)
+	}
+	if ok, err := store.UseInvite(expired.ID, expired.ExpiresAt); err != nil || ok {
+		t.Fatalf("UseInvite(at expiry) = %v, %v; want false", ok, err)
+	}
+	if err := manager.RevokeInvite(expired.ID); err != nil {
+		t.Fatalf("RevokeInvite() error = %v", err)
+	}
+	if _, _, err := manager.Register(expiredCode, "erin", "long-password-4"); err != auth.ErrInvalidInvite {
+		t.Fatalf("Register(revoked) error = %v; want ErrInvalidInvite", err)
+	}
+}
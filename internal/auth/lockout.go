@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Login throttling policy. The first few failures are free, every further failure
+// doubles the wait before the next attempt. Too many failures lock the account.
+const (
+	loginFreeAttempts     = 3
+	loginBackoffBase      = time.Second
+	loginBackoffMax       = 5 * time.Minute
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 15 * time.Minute
+	loginFailureWindow    = time.Hour
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	ErrAccountLocked   = errors.New("account temporarily locked")
+)
+
+// ThrottleError is returned by Login when an attempt is rejected before the password is checked
+type ThrottleError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+// LoginFailure tracks failed logins for a username or a client IP
+type LoginFailure struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+func usernameFailureKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipFailureKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBackoff returns how long to wait after the given number of failures
+func loginBackoff(failures int) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+	backoff := loginBackoffBase
+	for i := loginFreeAttempts; i < failures; i++ {
+		backoff *= 2
+		if backoff >= loginBackoffMax {
+			return loginBackoffMax
+		}
+	}
+	return backoff
+}
+
+// loadLoginFailure returns the failure record for key, forgetting stale failures
+func (m *Manager) loadLoginFailure(key string, now time.Time) (LoginFailure, error) {
+	failure, err := m.store.GetLoginFailure(key)
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	failure.Key = key
+	if failure.Failures > 0 && now.Sub(failure.LastFailure) > loginFailureWindow && now.After(failure.LockedUntil) {
+		failure = LoginFailure{Key: key}
+	}
+	return failure, nil
+}
+
+// checkLoginThrottle rejects attempts while an account is locked or a backoff is pending
+func (m *Manager) checkLoginThrottle(userFailure, ipFailure LoginFailure, now time.Time) error {
+	if now.Before(userFailure.LockedUntil) {
+		return &ThrottleError{Err: ErrAccountLocked, RetryAfter: userFailure.LockedUntil.Sub(now)}
+	}
+
+	var wait time.Duration
+	for _, failure := range []LoginFailure{userFailure, ipFailure} {
+		if failure.Failures == 0 {
+			continue
+		}
+		next := failure.LastFailure.Add(loginBackoff(failure.Failures))
+		if next.After(now) && next.Sub(now) > wait {
+			wait = next.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return &ThrottleError{Err: ErrTooManyAttempts, RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt for the username and the client IP.
+// The store increments the counters atomically, concurrent failures all count
+// towards the lockout. The failure that locks the account returns a *ThrottleError.
+func (m *Manager) recordLoginFailure(userFailure, ipFailure LoginFailure, now time.Time) error {
+	staleBefore := now.Add(-loginFailureWindow)
+	failure, err := m.store.RecordLoginFailure(userFailure.Key, now, staleBefore)
+	if err != nil {
+		return err
+	}
+	var locked error
+	if failure.Failures >= loginLockoutThreshold {
+		if err := m.store.LockLogin(failure.Key, now.Add(loginLockoutDuration)); err != nil {
+			return err
+		}
+		locked = &ThrottleError{Err: ErrAccountLocked, RetryAfter: loginLockoutDuration}
+	}
+
+	if ipFailure.Key != "" {
+		if _, err := m.store.RecordLoginFailure(ipFailure.Key, now, staleBefore); err != nil {
+			return err
+		}
+	}
+	return locked
+}
+
+// UnlockUser clears the failed login counter and lockout of a user (admin only)
+func (m *Manager) UnlockUser(userID string) error {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	return m.store.DeleteLoginFailure(usernameFailureKey(user.Username))
+}
@@ -0,0 +1,77 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestLoginThrottlePersistsFailures(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := manager.Login("alice", "wrong", auth.ClientInfo{IP: "192.0.2.1"}); err != auth.ErrInvalidCredentials {
+			t.Fatalf("Login() attempt %d error = %v; want invalid credentials", i+1, err)
+		}
+	}
+
+	failure, err := store.GetLoginFailure("user:alice")
+	if err != nil || failure.Failures != 3 {
+		t.Fatalf("GetLoginFailure() = %#v, %v; want 3 failures", failure, err)
+	}
+	// Refresh the last failure so the 1s backoff does not depend on the hashing speed.
+	failure.LastFailure = time.Now()
+	if err := store.SaveLoginFailure(failure); err != nil {
+		t.Fatalf("SaveLoginFailure() error = %v", err)
+	}
+
+	// A new manager reads the persisted counters, like after a restart.
+	manager = auth.NewManager(store, time.Hour)
+	_, err = manager.Login("alice", "correct-password", auth.ClientInfo{IP: "192.0.2.2"})
+	var throttled *auth.ThrottleError
+	if !errors.As(err, &throttled) || !errors.Is(err, auth.ErrTooManyAttempts) {
+		t.Fatalf("Login() after failures error = %v; want too many attempts", err)
+	}
+	if throttled.RetryAfter <= 0 {
+		t.Fatalf("RetryAfter = %v; want positive", throttled.RetryAfter)
+	}
+
+	if err := manager.UnlockUser(user.ID); err != nil {
+		t.Fatalf("UnlockUser() error = %v", err)
+	}
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{IP: "192.0.2.2"}); err != nil {
+		t.Fatalf("Login() after unlock error = %v", err)
+	}
+}
+
+func TestFailedLoginLocksAccount(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+	if _, err := manager.CreateUser("alice", "correct-password", false); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	// Nine earlier failures, long enough ago that no backoff is pending
+	earlier := auth.LoginFailure{Key: "user:alice", Failures: 9, LastFailure: time.Now().Add(-10 * time.Minute)}
+	if err := store.SaveLoginFailure(earlier); err != nil {
+		t.Fatalf("SaveLoginFailure() error = %v", err)
+	}
+
+	// The failure that reaches the threshold reports the lockout
+	_, err := manager.Login("alice", "wrong", auth.ClientInfo{IP: "192.0.2.1"})
+	var throttled *auth.ThrottleError
+	if !errors.As(err, &throttled) || !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("Login() tenth failure error = %v; want account locked", err)
+	}
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{IP: "192.0.2.2"}); !errors.Is(err, auth.ErrAccountLocked) {
+		t.Fatalf("Login() while locked error = %v; want account locked", err)
+	}
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUnknownHash is returned for password hashes no registered hasher understands
+	ErrUnknownHash = errors.New("unknown password hash format")
+	// ErrInvalidHash is returned for hashes of a known format with malformed or unusable fields
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// Bounds of the key length accepted in stored argon2id hashes
+const (
+	argon2MinKeyLength = 16
+	argon2MaxKeyLength = 128
+)
+
+// PasswordHasher hashes passwords with one algorithm. Hashes are identified by their
+// PHC string prefix, e.g. "$argon2id$" or "$2a$" for bcrypt.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of a password
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash
+	Verify(password, encoded string) (bool, error)
+	// Identifies reports whether the encoded hash was produced by this algorithm
+	Identifies(encoded string) bool
+	// NeedsRehash reports whether the encoded hash uses other parameters than the hasher
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2Params are the cost parameters of argon2id
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Argon2idHasher hashes passwords with argon2id in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+// NewArgon2idHasher returns an argon2id hasher; zero parameters take the defaults
+func NewArgon2idHasher(params Argon2Params) *Argon2idHasher {
+	defaults := DefaultArgon2Params()
+	if params.Memory == 0 {
+		params.Memory = defaults.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = defaults.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = defaults.Parallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = defaults.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = defaults.KeyLength
+	}
+	return &Argon2idHasher{Params: params}
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (h *Argon2idHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params != h.Params
+}
+
+// decodeArgon2id parses a PHC encoded argon2id hash
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: version: %v", ErrInvalidHash, err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: unsupported version %d", ErrInvalidHash, version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: parameters: %v", ErrInvalidHash, err)
+	}
+	// argon2.IDKey panics on zero iterations or parallelism
+	if params.Iterations < 1 || params.Parallelism < 1 || params.Memory < 8*uint32(params.Parallelism) {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: parameters m=%d,t=%d,p=%d", ErrInvalidHash, params.Memory, params.Iterations, params.Parallelism)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: salt: %v", ErrInvalidHash, err)
+	}
+	if len(salt) == 0 {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: empty salt", ErrInvalidHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: key: %v", ErrInvalidHash, err)
+	}
+	if len(key) < argon2MinKeyLength || len(key) > argon2MaxKeyLength {
+		return params, nil, nil, fmt.Errorf("argon2id: %w: key length %d", ErrInvalidHash, len(key))
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt. Earlier versions stored bcrypt hashes only.
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher returns a bcrypt hasher; a zero cost takes bcrypt.DefaultCost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) Identifies(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// NewPasswordHasher returns the hasher for an algorithm name ("argon2id" or "bcrypt")
+func NewPasswordHasher(algorithm string, params Argon2Params) (PasswordHasher, error) {
+	switch strings.ToLower(strings.TrimSpace(algorithm)) {
+	case "", "argon2id":
+		return NewArgon2idHasher(params), nil
+	case "bcrypt":
+		return NewBcryptHasher(0), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// defaultPasswordHasher is used by HashPassword and new managers
+var defaultPasswordHasher PasswordHasher = NewArgon2idHasher(DefaultArgon2Params())
+
+// verifyWith checks a password with the hasher matching the hash prefix. The preferred
+// hasher is asked first, so its parameters decide whether a rehash is due.
+func verifyWith(preferred PasswordHasher, password, encoded string) (ok, rehash bool) {
+	hashers := []PasswordHasher{preferred, NewArgon2idHasher(Argon2Params{}), NewBcryptHasher(0)}
+	for _, hasher := range hashers {
+		if !hasher.Identifies(encoded) {
+			continue
+		}
+		ok, err := hasher.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false
+		}
+		return true, hasher != preferred || preferred.NeedsRehash(encoded)
+	}
+	return false, false
+}
+
+// SetPasswordHasher sets the algorithm for new hashes. Existing hashes of other
+// algorithms keep working and are replaced on the next successful login.
+func (m *Manager) SetPasswordHasher(hasher PasswordHasher) {
+	if hasher == nil {
+		hasher = defaultPasswordHasher
+	}
+	m.passwordHasher = hasher
+}
+
+func (m *Manager) hashPassword(password string) (string, error) {
+	return m.passwordHasher.Hash(password)
+}
+
+// verifyPassword checks a user's password against the stored hash
+func (m *Manager) verifyPassword(user *User, password string) (ok, rehash bool) {
+	return verifyWith(m.passwordHasher, password, user.PasswordHash)
+}
+
+// rehashPassword replaces the stored hash with one of the preferred algorithm. It is
+// best effort: the login already succeeded and the old hash keeps working.
+func (m *Manager) rehashPassword(user *User, password string) {
+	hash, err := m.hashPassword(password)
+	if err != nil {
+		return
+	}
+	previous := user.PasswordHash
+	user.PasswordHash = hash
+	if err := m.store.UpdateUser(*user); err != nil {
+		user.PasswordHash = previous
+	}
+}
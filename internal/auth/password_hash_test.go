@@ -0,0 +1,233 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapArgon2 keeps the tests fast
+var cheapArgon2 = auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestArgon2idFormat(t *testing.T) {
+	hasher := auth.NewArgon2idHasher(cheapArgon2)
+	encoded, err := hasher.Hash("correct-password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	// 16 byte salt and 32 byte key in unpadded base64
+	phc := regexp.MustCompile(`^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`)
+	if !phc.MatchString(encoded) {
+		t.Fatalf("Hash() = %q; want PHC string", encoded)
+	}
+	if again, _ := hasher.Hash("correct-password"); again == encoded {
+		t.Fatalf("Hash() twice = %q; want different salts", again)
+	}
+	if !hasher.Identifies(encoded) || hasher.NeedsRehash(encoded) {
+		t.Fatalf("Identifies/NeedsRehash(%q) = %v/%v; want true/false", encoded, hasher.Identifies(encoded), hasher.NeedsRehash(encoded))
+	}
+	if ok, err := hasher.Verify("correct-password", encoded); err != nil || !ok {
+		t.Fatalf("Verify(correct) = %v, %v; want true", ok, err)
+	}
+	if ok, err := hasher.Verify("wrong-password", encoded); err != nil || ok {
+		t.Fatalf("Verify(wrong) = %v, %v; want false", ok, err)
+	}
+}
+
+func TestArgon2idParsesForeignHashes(t *testing.T) {
+	// A hash built from the reference function with parameters the hasher does not use
+	salt := []byte("somesaltsomesalt")
+	key := argon2.IDKey([]byte("password"), salt, 3, 2048, 2, 24)
+	encoded := fmt.Sprintf("$argon2id$v=19$m=2048,t=3,p=2$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	hasher := auth.NewArgon2idHasher(cheapArgon2)
+	if ok, err := hasher.Verify("password", encoded); err != nil || !ok {
+		t.Fatalf("Verify() = %v, %v; want true", ok, err)
+	}
+	if ok, err := hasher.Verify("Password", encoded); err != nil || ok {
+		t.Fatalf("Verify(wrong) = %v, %v; want false", ok, err)
+	}
+	if !auth.VerifyPassword("password", encoded) {
+		t.Fatalf("VerifyPassword() = false; want true")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	hasher := auth.NewArgon2idHasher(cheapArgon2)
+	valid, _ := hasher.Hash("password")
+	parts := strings.Split(valid, "$")
+	join := func(replace int, value string) string {
+		changed := append([]string{}, parts...)
+		changed[replace] = value
+		return strings.Join(changed, "$")
+	}
+
+	key := parts[5]
+	for name, tt := range map[string]struct {
+		encoded string
+		want    error
+	}{
+		"too few parts":    {strings.Join(parts[:5], "$"), auth.ErrUnknownHash},
+		"argon2i":          {join(1, "argon2i"), auth.ErrUnknownHash},
+		"old version":      {join(2, "v=16"), auth.ErrInvalidHash},
+		"no version":       {join(2, "version"), auth.ErrInvalidHash},
+		"bad parameters":   {join(3, "m=1024,t=x,p=1"), auth.ErrInvalidHash},
+		"zero iterations":  {join(3, "m=1024,t=0,p=1"), auth.ErrInvalidHash},
+		"zero parallelism": {join(3, "m=1024,t=1,p=0"), auth.ErrInvalidHash},
+		"memory below 8*p": {join(3, "m=31,t=1,p=4"), auth.ErrInvalidHash},
+		"bad salt":         {join(4, "not base64!"), auth.ErrInvalidHash},
+		"empty salt":       {join(4, ""), auth.ErrInvalidHash},
+		"bad key":          {join(5, "not base64!"), auth.ErrInvalidHash},
+		"empty key":        {join(5, ""), auth.ErrInvalidHash},
+		"short key":        {join(5, key[:10]), auth.ErrInvalidHash},
+		"oversized key":    {join(5, strings.Repeat(key, 5)), auth.ErrInvalidHash},
+	} {
+		if ok, err := hasher.Verify("password", tt.encoded); !errors.Is(err, tt.want) || ok {
+			t.Errorf("Verify(%s) = %v, %v; want %v", name, ok, err, tt.want)
+		}
+		if !hasher.NeedsRehash(tt.encoded) {
+			t.Errorf("NeedsRehash(%s) = false; want true", name)
+		}
+		if auth.VerifyPassword("password", tt.encoded) {
+			t.Errorf("VerifyPassword(%s) = true; want false", name)
+		}
+	}
+}
+
+func TestNeedsRehashOnParameterChange(t *testing.T) {
+	encoded, err := auth.NewArgon2idHasher(cheapArgon2).Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		params auth.Argon2Params
+		want   bool
+	}{
+		{name: "same", params: cheapArgon2, want: false},
+		{name: "memory", params: auth.Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1}, want: true},
+		{name: "iterations", params: auth.Argon2Params{Memory: 1024, Iterations: 2, Parallelism: 1}, want: true},
+		{name: "parallelism", params: auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 2}, want: true},
+		{name: "salt length", params: auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 32}, want: true},
+		{name: "key length", params: auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, KeyLength: 64}, want: true},
+	}
+	for _, tt := range tests {
+		if got := auth.NewArgon2idHasher(tt.params).NeedsRehash(encoded); got != tt.want {
+			t.Errorf("NeedsRehash(%s) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+
+	bcryptHash, err := auth.NewBcryptHasher(bcrypt.MinCost).Hash("password")
+	if err != nil {
+		t.Fatalf("Hash(bcrypt) error = %v", err)
+	}
+	if auth.NewBcryptHasher(bcrypt.MinCost).NeedsRehash(bcryptHash) {
+		t.Errorf("NeedsRehash(bcrypt, same cost) = true; want false")
+	}
+	if !auth.NewBcryptHasher(bcrypt.MinCost + 1).NeedsRehash(bcryptHash) {
+		t.Errorf("NeedsRehash(bcrypt, other cost) = false; want true")
+	}
+}
+
+func TestBcryptHashes(t *testing.T) {
+	hasher := auth.NewBcryptHasher(bcrypt.MinCost)
+	raw, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	encoded := string(raw)
+
+	argon := auth.NewArgon2idHasher(cheapArgon2)
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		variant := prefix + strings.TrimPrefix(encoded, encoded[:4])
+		if !hasher.Identifies(variant) || argon.Identifies(variant) {
+			t.Errorf("Identifies(%s) = %v/%v; want bcrypt only", prefix, hasher.Identifies(variant), argon.Identifies(variant))
+		}
+	}
+	if ok, err := hasher.Verify("password", encoded); err != nil || !ok {
+		t.Fatalf("Verify(correct) = %v, %v; want true", ok, err)
+	}
+	if ok, err := hasher.Verify("wrong", encoded); err != nil || ok {
+		t.Fatalf("Verify(wrong) = %v, %v; want false without error", ok, err)
+	}
+	if !auth.VerifyPassword("password", encoded) || auth.VerifyPassword("wrong", encoded) {
+		t.Fatalf("VerifyPassword(bcrypt) does not match the password only")
+	}
+	if auth.VerifyPassword("password", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5") || auth.VerifyPassword("password", "password") {
+		t.Fatalf("VerifyPassword(unknown format) = true; want false")
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	for _, name := range []string{"", "argon2id", " Argon2ID "} {
+		hasher, err := auth.NewPasswordHasher(name, cheapArgon2)
+		if err != nil {
+			t.Fatalf("NewPasswordHasher(%q) error = %v", name, err)
+		}
+		if argon, ok := hasher.(*auth.Argon2idHasher); !ok || argon.Params.Memory != 1024 || argon.Params.KeyLength != 32 {
+			t.Fatalf("NewPasswordHasher(%q) = %#v; want argon2id with defaults filled in", name, hasher)
+		}
+	}
+	if hasher, err := auth.NewPasswordHasher("bcrypt", cheapArgon2); err != nil || hasher.(*auth.BcryptHasher).Cost != bcrypt.DefaultCost {
+		t.Fatalf("NewPasswordHasher(bcrypt) = %#v, %v; want the default cost", hasher, err)
+	}
+	if _, err := auth.NewPasswordHasher("md5", cheapArgon2); err == nil {
+		t.Fatalf("NewPasswordHasher(md5) error = nil; want an error")
+	}
+}
+
+func TestLoginRehashesLegacyPasswords(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+	manager.SetPasswordHasher(auth.NewBcryptHasher(bcrypt.MinCost))
+
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$2a$") {
+		t.Fatalf("PasswordHash = %q; want bcrypt hash", user.PasswordHash)
+	}
+
+	params := cheapArgon2
+	manager.SetPasswordHasher(auth.NewArgon2idHasher(params))
+	if _, err := manager.Login("alice", "wrong-password", auth.ClientInfo{}); err != auth.ErrInvalidCredentials {
+		t.Fatalf("Login(wrong password) error = %v; want ErrInvalidCredentials", err)
+	}
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login(bcrypt) error = %v", err)
+	}
+
+	stored, err := store.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("PasswordHash after login = %q; want argon2id hash", stored.PasswordHash)
+	}
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login(argon2id) error = %v", err)
+	}
+
+	// Changed parameters trigger another rehash
+	params.Iterations = 2
+	manager.SetPasswordHasher(auth.NewArgon2idHasher(params))
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login(new parameters) error = %v", err)
+	}
+	if stored, _ = store.GetUser(user.ID); !strings.Contains(stored.PasswordHash, "m=1024,t=2,p=1") {
+		t.Fatalf("PasswordHash after parameter change = %q; want t=2", stored.PasswordHash)
+	}
+	if !auth.VerifyPassword("correct-password", stored.PasswordHash) {
+		t.Fatalf("VerifyPassword() = false; want true")
+	}
+}
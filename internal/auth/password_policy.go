@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrWeakPassword is wrapped by every *PasswordPolicyError
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// PasswordPolicy describes the requirements for user-chosen passwords
+type PasswordPolicy struct {
+	MinLength        int  `json:"minLength"`
+	RequireMixedCase bool `json:"requireMixedCase"`
+	RequireDigit     bool `json:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol"`
+}
+
+// DefaultPasswordPolicy only requires a minimum length
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: 8}
+}
+
+// PasswordPolicyError lists the requirements a password violates
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return ErrWeakPassword.Error() + ": " + strings.Join(e.Violations, ", ")
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Validate checks a password against the policy and returns a *PasswordPolicyError
+func (p PasswordPolicy) Validate(password string) error {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	length := 0
+	for _, r := range password {
+		length++
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	violations := []string{}
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.RequireMixedCase && (!hasUpper || !hasLower) {
+		violations = append(violations, "upper and lower case letters")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "a symbol")
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// SetPasswordPolicy replaces the policy enforced for new passwords
+func (m *Manager) SetPasswordPolicy(policy PasswordPolicy) {
+	m.passwordPolicy = policy
+}
+
+// PasswordPolicy returns the policy enforced for new passwords
+func (m *Manager) PasswordPolicy() PasswordPolicy {
+	return m.passwordPolicy
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// sessionTouchInterval limits how often the last-seen timestamp is written
+const sessionTouchInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo describes the client that opens a session
+type ClientInfo struct {
+	Name string
+	IP   string
+}
+
+// renewSession records activity and slides the expiry forward once less than half
+// of the session duration is left, so clients in regular use stay logged in.
+func (m *Manager) renewSession(session *Session, now time.Time) (*Session, error) {
+	renew := session.ExpiresAt.Sub(now) < m.sessionDuration/2
+	if !renew && now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return session, nil
+	}
+
+	// Cached sessions are shared between requests, so update a copy
+	updated := *session
+	updated.LastSeenAt = now
+	if renew {
+		updated.ExpiresAt = now.Add(m.sessionDuration)
+	}
+
+	if err := m.store.TouchSession(updated.Token, updated.LastSeenAt, updated.ExpiresAt); err != nil {
+		// Best effort, e.g. a read-only database must not reject valid sessions
+		return session, nil
+	}
+	if m.sessionCache != nil {
+		m.sessionCache.Set(&updated)
+	}
+
+	return &updated, nil
+}
+
+// ListSessions lists the active sessions of a user. Tokens are not included.
+func (m *Manager) ListSessions(userID string) ([]Session, error) {
+	sessions, err := m.store.ListUserSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Token = ""
+	}
+	return sessions, nil
+}
+
+// GetSessionByID retrieves a session by its public ID
+func (m *Manager) GetSessionByID(id string) (*Session, error) {
+	return m.store.GetSessionByID(id)
+}
+
+// RevokeSession deletes a session by its public ID and evicts it from the cache
+func (m *Manager) RevokeSession(id string) error {
+	session, err := m.store.GetSessionByID(id)
+	if err != nil {
+		return err
+	}
+
+	if m.sessionCache != nil {
+		m.sessionCache.Delete(session.Token)
+	}
+
+	return m.store.DeleteSession(session.Token)
+}
+
+// RevokeAllSessions signs out every user and returns the number of affected users
+func (m *Manager) RevokeAllSessions() (int, error) {
+	users, err := m.store.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		if err := m.revokeUserSessions(user.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+// revokeUserSessions deletes all sessions of a user and evicts them from the cache
+func (m *Manager) revokeUserSessions(userID string) error {
+	if m.sessionCache != nil {
+		m.sessionCache.DeleteByUserID(userID)
+	}
+
+	return m.store.DeleteUserSessions(userID)
+}
+
+func generateID(prefix string) string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		sum := sha256.Sum256([]byte(time.Now().String()))
+		return prefix + hex.EncodeToString(sum[:8])
+	}
+	return prefix + hex.EncodeToString(bytes)
+}
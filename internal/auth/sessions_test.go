@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestSessionListAndRevoke(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	if _, err := manager.CreateUser("alice", "correct-password", false); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	first, err := manager.Login("alice", "correct-password", auth.ClientInfo{Name: "tv", IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Login(tv) error = %v", err)
+	}
+	second, err := manager.Login("alice", "correct-password", auth.ClientInfo{Name: "phone", IP: "192.0.2.2"})
+	if err != nil {
+		t.Fatalf("Login(phone) error = %v", err)
+	}
+
+	sessions, err := manager.ListSessions(first.UserID)
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("ListSessions() returned %d sessions; want 2", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.Token != "" {
+			t.Fatalf("ListSessions() exposed token for %s", session.ID)
+		}
+		if session.ID == first.ID && (session.ClientName != "tv" || session.IP != "192.0.2.1") {
+			t.Fatalf("session %s = %#v; want client tv from 192.0.2.1", session.ID, session)
+		}
+	}
+
+	// Validate once so the session sits in the cache before it is revoked.
+	if _, err := manager.ValidateSession(first.Token); err != nil {
+		t.Fatalf("ValidateSession() error = %v", err)
+	}
+	if err := manager.RevokeSession(first.ID); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if _, err := manager.ValidateSession(first.Token); err != auth.ErrInvalidToken {
+		t.Fatalf("ValidateSession() after revoke error = %v; want invalid token", err)
+	}
+	if _, err := manager.ValidateSession(second.Token); err != nil {
+		t.Fatalf("ValidateSession(second) error = %v", err)
+	}
+	if err := manager.RevokeSession(first.ID); err != auth.ErrSessionNotFound {
+		t.Fatalf("RevokeSession() twice error = %v; want session not found", err)
+	}
+}
+
+func TestSessionSlidingRenewal(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	if _, err := manager.CreateUser("alice", "correct-password", false); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	session, err := manager.Login("alice", "correct-password", auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	// Move the session close to its expiry; a fresh manager has an empty cache.
+	soon := time.Now().Add(10 * time.Minute)
+	if err := store.TouchSession(session.Token, time.Now().Add(-time.Hour), soon); err != nil {
+		t.Fatalf("TouchSession() error = %v", err)
+	}
+	manager = auth.NewManager(store, time.Hour)
+
+	renewed, err := manager.ValidateSession(session.Token)
+	if err != nil {
+		t.Fatalf("ValidateSession() error = %v", err)
+	}
+	if !renewed.ExpiresAt.After(soon.Add(30 * time.Minute)) {
+		t.Fatalf("ExpiresAt = %v; want renewed expiry", renewed.ExpiresAt)
+	}
+
+	stored, err := store.GetSessionByID(session.ID)
+	if err != nil {
+		t.Fatalf("GetSessionByID() error = %v", err)
+	}
+	if stored.ExpiresAt.Unix() != renewed.ExpiresAt.Unix() {
+		t.Fatalf("stored ExpiresAt = %v; want %v", stored.ExpiresAt, renewed.ExpiresAt)
+	}
+}
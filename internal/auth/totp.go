@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults, supported by all common authenticator apps)
+const (
+	totpIssuer     = "PrimeTime"
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSkew       = 1 // accepted steps before and after the current one
+	totpSecretSize = 20
+
+	recoveryCodeCount = 10
+
+	challengeTTL         = 5 * time.Minute
+	challengeMaxAttempts = 5
+)
+
+var (
+	ErrSecondFactorRequired = errors.New("second factor required")
+	ErrInvalidChallenge     = errors.New("invalid or expired challenge")
+	ErrInvalidCode          = errors.New("invalid code")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication already enabled")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication not enabled")
+	ErrTOTPNotEnrolled      = errors.New("two-factor enrollment not started")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SecondFactorChallenge is returned by Login when the password was correct but the
+// user has two-factor authentication enabled. The challenge token is completed with
+// CompleteSecondFactor.
+type SecondFactorChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (c *SecondFactorChallenge) Error() string {
+	return ErrSecondFactorRequired.Error()
+}
+
+func (c *SecondFactorChallenge) Unwrap() error {
+	return ErrSecondFactorRequired
+}
+
+// TOTPEnrollment holds the data an authenticator app needs to add the account
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPStatus describes the two-factor state of a user
+type TOTPStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// totpCode computes the code for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPCode returns the current code for a base32 secret
+func TOTPCode(secret string, now time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, now.Unix()/totpPeriod), nil
+}
+
+// verifyTOTP checks code against the steps around now and returns the matching step.
+// Steps up to lastStep are rejected so a code cannot be replayed.
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func generateTOTPSecret() (string, error) {
+	key := make([]byte, totpSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+func totpURI(username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + query.Encode()
+}
+
+// generateRecoveryCodes returns plain codes in the form xxxxx-xxxxx and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. The codes are random, so a
+// plain SHA-256 is sufficient, like for API keys.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return HashAPIKey(normalized)
+}
+
+// BeginTOTPEnrollment creates a new secret for a user. Two-factor authentication is
+// only enabled after ConfirmTOTPEnrollment verified a first code.
+func (m *Manager) BeginTOTPEnrollment(userID string) (*TOTPEnrollment, error) {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := m.store.UpdateUser(*user); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(user.Username, secret)}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the first code matches.
+// It returns the plain recovery codes, which are only shown here.
+func (m *Manager) ConfirmTOTPEnrollment(userID, code string) ([]string, error) {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	if err := m.store.UpdateUser(*user); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns off two-factor authentication after verifying a code or recovery code
+func (m *Manager) DisableTOTP(userID, code string) error {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	ok, err := m.verifySecondFactor(user, code, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	return m.ResetTOTP(userID)
+}
+
+// ResetTOTP turns off two-factor authentication without a code (admin only)
+func (m *Manager) ResetTOTP(userID string) error {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	if err := m.store.UpdateUser(*user); err != nil {
+		return err
+	}
+
+	return m.store.ReplaceRecoveryCodes(user.ID, nil)
+}
+
+// TOTPStatus reports whether a user has two-factor authentication enabled
+func (m *Manager) TOTPStatus(userID string) (*TOTPStatus, error) {
+	user, err := m.store.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TOTPStatus{Enabled: user.TOTPEnabled}
+	if user.TOTPEnabled {
+		if status.RecoveryCodesRemaining, err = m.store.CountRecoveryCodes(user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// verifySecondFactor accepts a current TOTP code or an unused recovery code. Both only
+// update the used step or code in the store, never the whole user.
+func (m *Manager) verifySecondFactor(user *User, code string, now time.Time) (bool, error) {
+	if step, ok := verifyTOTP(user.TOTPSecret, code, now, user.TOTPLastStep); ok {
+		used, err := m.store.UseTOTPStep(user.ID, step)
+		if err != nil || !used {
+			return false, err
+		}
+		user.TOTPLastStep = step
+		return true, nil
+	}
+
+	if strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+	return m.store.UseRecoveryCode(user.ID, hashRecoveryCode(code), now)
+}
+
+// CompleteSecondFactor finishes a login started by Login with the challenge token and
+// a TOTP code or recovery code. Wrong codes count as failed logins for the username.
+func (m *Manager) CompleteSecondFactor(challengeToken, code string) (*Session, error) {
+	now := time.Now()
+	challenge, ok := m.challenges.attempt(challengeToken, now)
+	if !ok {
+		return nil, ErrInvalidChallenge
+	}
+
+	user, err := m.store.GetUser(challenge.userID)
+	if err != nil {
+		if err == ErrUserNotFound {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	userFailure, err := m.loadLoginFailure(usernameFailureKey(user.Username), now)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkLoginThrottle(userFailure, LoginFailure{}, now); err != nil {
+		return nil, err
+	}
+
+	verified, err := m.verifySecondFactor(user, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		if err := m.recordLoginFailure(userFailure, LoginFailure{}, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCode
+	}
+
+	m.challenges.delete(challengeToken)
+	return m.completeLogin(user, userFailure, challenge.client, now)
+}
+
+// loginChallenge is a pending login waiting for the second factor
+type loginChallenge struct {
+	userID    string
+	client    ClientInfo
+	expiresAt time.Time
+	attempts  int
+}
+
+// challengeStore keeps pending second-factor challenges in memory. They are short
+// lived, so a restart simply requires the user to log in again.
+type challengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]*loginChallenge
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{challenges: make(map[string]*loginChallenge)}
+}
+
+func (c *challengeStore) create(userID string, client ClientInfo, now time.Time) *SecondFactorChallenge {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired challenges so abandoned logins do not accumulate
+	for token, challenge := range c.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(c.challenges, token)
+		}
+	}
+
+	token := GenerateToken()
+	expiresAt := now.Add(challengeTTL)
+	c.challenges[token] = &loginChallenge{userID: userID, client: client, expiresAt: expiresAt}
+	return &SecondFactorChallenge{Token: token, ExpiresAt: expiresAt}
+}
+
+// attempt returns a copy of the challenge and counts the attempt. A challenge is
+// invalidated after challengeMaxAttempts attempts.
+func (c *challengeStore) attempt(token string, now time.Time) (loginChallenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, ok := c.challenges[token]
+	if !ok {
+		return loginChallenge{}, false
+	}
+	if now.After(challenge.expiresAt) || challenge.attempts >= challengeMaxAttempts {
+		delete(c.challenges, token)
+		return loginChallenge{}, false
+	}
+	challenge.attempts++
+	return *challenge, true
+}
+
+func (c *challengeStore) delete(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.challenges, token)
+}
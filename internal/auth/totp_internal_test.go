@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyTOTPWindowAndReplay(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(offset int64) string {
+		value, err := TOTPCode(secret, now.Add(time.Duration(offset*totpPeriod)*time.Second))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", code(0), 0, current, true},
+		{"previous step", code(-1), 0, current - 1, true},
+		{"next step", code(1), 0, current + 1, true},
+		{"two steps back", code(-2), 0, 0, false},
+		{"two steps ahead", code(2), 0, 0, false},
+		{"with spaces", code(0)[:3] + " " + code(0)[3:], 0, current, true},
+		{"replayed step", code(0), current, 0, false},
+		{"earlier step after a later one", code(-1), current, 0, false},
+		{"later step after an earlier one", code(1), current, current + 1, true},
+		{"too short", code(0)[:5], 0, 0, false},
+		{"not a code", "abcdef", 0, 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := verifyTOTP(secret, tt.code, now, tt.lastStep)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("verifyTOTP(%s) = %d, %v; want %d, %v", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", code(0), now, 0); ok {
+		t.Errorf("verifyTOTP(invalid secret) = true; want false")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("generateRecoveryCodes() = %d codes, %d hashes; want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Fatalf("recovery code %q; want unique xxxxx-xxxxx", code)
+		}
+		seen[code] = true
+		// Dashes, case and surrounding space do not matter when a code is entered
+		for _, entered := range []string{code, " " + code[:5] + code[6:] + " ", strings.ToUpper(code)} {
+			if hashRecoveryCode(entered) != hashes[i] {
+				t.Fatalf("hashRecoveryCode(%q) does not match the hash of %q", entered, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 secret "12345678901234567890", truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		got, err := auth.TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != want {
+			t.Fatalf("TOTPCode(%d) = %s; want %s", unix, got, want)
+		}
+	}
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("alice", "correct-password", true)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	enrollment, err := manager.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment() error = %v", err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/PrimeTime:alice?") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Fatalf("URI = %q; want otpauth URI with secret", enrollment.URI)
+	}
+
+	// Until confirmed, the password alone is enough.
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login() before confirmation error = %v", err)
+	}
+	if _, err := manager.ConfirmTOTPEnrollment(user.ID, "000000"); err != auth.ErrInvalidCode {
+		t.Fatalf("ConfirmTOTPEnrollment(wrong) error = %v; want invalid code", err)
+	}
+
+	now := time.Now()
+	code, err := auth.TOTPCode(enrollment.Secret, now)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	recoveryCodes, err := manager.ConfirmTOTPEnrollment(user.ID, code)
+	if err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment() error = %v", err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("ConfirmTOTPEnrollment() returned %d recovery codes; want 10", len(recoveryCodes))
+	}
+
+	_, err = manager.Login("alice", "correct-password", auth.ClientInfo{Name: "tv"})
+	var challenge *auth.SecondFactorChallenge
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Login() error = %v; want second factor challenge", err)
+	}
+
+	// The code used for the confirmation cannot be replayed.
+	if _, err := manager.CompleteSecondFactor(challenge.Token, code); err != auth.ErrInvalidCode {
+		t.Fatalf("CompleteSecondFactor(replayed) error = %v; want invalid code", err)
+	}
+	next, err := auth.TOTPCode(enrollment.Secret, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	session, err := manager.CompleteSecondFactor(challenge.Token, next)
+	if err != nil {
+		t.Fatalf("CompleteSecondFactor() error = %v", err)
+	}
+	if session.UserID != user.ID || session.ClientName != "tv" {
+		t.Fatalf("session = %#v; want session for %s from tv", session, user.ID)
+	}
+	if _, err := manager.CompleteSecondFactor(challenge.Token, next); err != auth.ErrInvalidChallenge {
+		t.Fatalf("CompleteSecondFactor() reused challenge error = %v; want invalid challenge", err)
+	}
+
+	// Recovery codes work once.
+	_, err = manager.Login("alice", "correct-password", auth.ClientInfo{})
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Login() error = %v; want second factor challenge", err)
+	}
+	if _, err := manager.CompleteSecondFactor(challenge.Token, strings.ToUpper(recoveryCodes[0])); err != nil {
+		t.Fatalf("CompleteSecondFactor(recovery code) error = %v", err)
+	}
+	_, err = manager.Login("alice", "correct-password", auth.ClientInfo{})
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Login() error = %v; want second factor challenge", err)
+	}
+	if _, err := manager.CompleteSecondFactor(challenge.Token, recoveryCodes[0]); err != auth.ErrInvalidCode {
+		t.Fatalf("CompleteSecondFactor(used recovery code) error = %v; want invalid code", err)
+	}
+
+	status, err := manager.TOTPStatus(user.ID)
+	if err != nil {
+		t.Fatalf("TOTPStatus() error = %v", err)
+	}
+	if !status.Enabled || status.RecoveryCodesRemaining != 9 {
+		t.Fatalf("TOTPStatus() = %#v; want enabled with 9 recovery codes", status)
+	}
+
+	if err := manager.DisableTOTP(user.ID, recoveryCodes[1]); err != nil {
+		t.Fatalf("DisableTOTP() error = %v", err)
+	}
+	if _, err := manager.Login("alice", "correct-password", auth.ClientInfo{}); err != nil {
+		t.Fatalf("Login() after disable error = %v", err)
+	}
+}
+
+func TestTOTPCodeAcceptedOnce(t *testing.T) {
+	store := newTestStore(t)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	enrollment, err := manager.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment() error = %v", err)
+	}
+	now := time.Now()
+	code, err := auth.TOTPCode(enrollment.Secret, now.Add(-30*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	if _, err := manager.ConfirmTOTPEnrollment(user.ID, code); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment() error = %v", err)
+	}
+
+	// Two logins wait for the second factor, both send the same current code
+	var challenges []string
+	for i := 0; i < 2; i++ {
+		_, err := manager.Login("alice", "correct-password", auth.ClientInfo{})
+		var challenge *auth.SecondFactorChallenge
+		if !errors.As(err, &challenge) {
+			t.Fatalf("Login() error = %v; want second factor challenge", err)
+		}
+		challenges = append(challenges, challenge.Token)
+	}
+	if code, err = auth.TOTPCode(enrollment.Secret, now); err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(challenges))
+	for _, token := range challenges {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			_, err := manager.CompleteSecondFactor(token, code)
+			errs <- err
+		}(token)
+	}
+	wg.Wait()
+	close(errs)
+
+	var accepted, rejected int
+	for err := range errs {
+		switch err {
+		case nil:
+			accepted++
+		case auth.ErrInvalidCode:
+			rejected++
+		default:
+			t.Fatalf("CompleteSecondFactor() error = %v", err)
+		}
+	}
+	if accepted != 1 || rejected != 1 {
+		t.Fatalf("CompleteSecondFactor() accepted %d, rejected %d; want the code accepted once", accepted, rejected)
+	}
+}
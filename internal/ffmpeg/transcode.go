@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 // TranscodeOptions defines the parameters for transcoding
 type TranscodeOptions struct {
 	InputPath          string
+	InputFormat        string // demuxer, e.g. "concat" for a WriteConcatList list
 	OutputPath         string
 	VideoCodec         string
 	AudioCodec         string
@@ -80,6 +82,7 @@ func buildTranscodeArgs(opts TranscodeOptions) []string {
 	if opts.StartTime > 0 {
 		args = append(args, "-ss", fmt.Sprintf("%.2f", opts.StartTime))
 	}
+	args = appendInputFormatArgs(args, opts)
 	args = append(args, "-i", opts.InputPath)
 
 	// Duration
@@ -192,6 +195,7 @@ func buildHLSArgs(opts TranscodeOptions, segmentDuration int) []string {
 	if opts.StartTime > 0 {
 		args = append(args, "-ss", fmt.Sprintf("%.2f", opts.StartTime))
 	}
+	args = appendInputFormatArgs(args, opts)
 	args = append(args, "-i", opts.InputPath)
 
 	// Duration
@@ -272,6 +276,30 @@ func buildHLSArgs(opts TranscodeOptions, segmentDuration int) []string {
 	return args
 }
 
+func appendInputFormatArgs(args []string, opts TranscodeOptions) []string {
+	if opts.InputFormat == "" {
+		return args
+	}
+	args = append(args, "-f", opts.InputFormat)
+	if opts.InputFormat == "concat" {
+		// Concat lists hold absolute paths, which the demuxer rejects as unsafe
+		args = append(args, "-safe", "0")
+	}
+	return args
+}
+
+// WriteConcatList writes a list for the concat demuxer, which reads the files one
+// after another as a single input, e.g. the parts of a movie split into cd1 and cd2
+func WriteConcatList(listPath string, paths []string) error {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for _, path := range paths {
+		// Single quotes are closed, escaped and reopened
+		b.WriteString("file '" + strings.ReplaceAll(path, "'", `'\''`) + "'\n")
+	}
+	return os.WriteFile(listPath, []byte(b.String()), 0o644)
+}
+
 func appendMapArgs(args []string, opts TranscodeOptions) []string {
 	if opts.DisableVideo && opts.DisableAudio {
 		return args
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// libraryAccess restricts a request to the media below the library roots granted to
+// the user and within the user's parental controls. A nil *libraryAccess allows
+// everything; all methods accept a nil receiver.
+type libraryAccess struct {
+	lib      *Library
+	allRoots bool
+	roots    []string
+	// Parental controls; ratings holds the effective rating of every rated item
+	limitAge     bool
+	maxAge       int
+	blockUnrated bool
+	ratings      map[string]string
+	// ids caches the accessible media IDs, built on first use
+	ids map[string]bool
+}
+
+// libraryAccess returns the access restriction of the request user. Requests without
+// a session (authentication disabled), admins and users without restrictions get nil.
+func (s *Server) libraryAccess(r *http.Request) (*libraryAccess, error) {
+	session, ok := sessionFromContext(r.Context())
+	if !ok || session.IsAdmin || s.lib.store == nil {
+		return nil, nil
+	}
+
+	access, found, err := s.lib.store.GetUserRootAccess(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	controls, _, err := s.lib.store.GetUserParentalControls(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, limitAge := contentRatingAge(controls.MaxRating)
+	blockUnrated := controls.UnratedPolicy == UnratedBlock
+	if access.AllRoots && !limitAge && !blockUnrated {
+		return nil, nil
+	}
+
+	restricted := &libraryAccess{
+		lib:          s.lib,
+		allRoots:     access.AllRoots,
+		roots:        []string{},
+		limitAge:     limitAge,
+		maxAge:       maxAge,
+		blockUnrated: blockUnrated,
+	}
+
+	if !access.AllRoots {
+		roots, err := s.lib.store.ListRoots()
+		if err != nil {
+			return nil, err
+		}
+		granted := make(map[string]bool, len(access.RootIDs))
+		for _, id := range access.RootIDs {
+			granted[id] = true
+		}
+		for _, root := range roots {
+			if !granted[root.ID] {
+				continue
+			}
+			if rootAbs, err := filepath.Abs(root.Path); err == nil {
+				restricted.roots = append(restricted.roots, rootAbs)
+			}
+		}
+	}
+
+	if limitAge || blockUnrated {
+		if restricted.ratings, err = s.lib.store.GetContentRatings(); err != nil {
+			return nil, err
+		}
+	}
+
+	return restricted, nil
+}
+
+// restricted reports whether results need to be filtered
+func (a *libraryAccess) restricted() bool {
+	return a != nil
+}
+
+func (a *libraryAccess) allowsPath(path string) bool {
+	if a == nil || a.allRoots {
+		return true
+	}
+	pathAbs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range a.roots {
+		if pathWithin(root, pathAbs) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsRating checks the effective rating of a media item against the parental controls
+func (a *libraryAccess) allowsRating(id string) bool {
+	if a == nil || (!a.limitAge && !a.blockUnrated) {
+		return true
+	}
+	age, rated := contentRatingAge(a.ratings[id])
+	if !rated {
+		return !a.blockUnrated
+	}
+	return !a.limitAge || age <= a.maxAge
+}
+
+func (a *libraryAccess) allowsItem(item MediaItem) bool {
+	return a.allowsPath(item.VideoPath) && a.allowsRating(item.ID)
+}
+
+func (a *libraryAccess) allowsRoot(root LibraryRoot) bool {
+	return a.allowsPath(root.Path)
+}
+
+// allowsScanRun checks the root a scan ran on; runs of removed roots are hidden
+func (a *libraryAccess) allowsScanRun(run ScanRun) bool {
+	if a == nil || a.allRoots {
+		return true
+	}
+	root, ok := a.lib.Root(run.RootID)
+	return ok && a.allowsRoot(root)
+}
+
+// allowsID checks a media ID for results that do not carry the item path
+func (a *libraryAccess) allowsID(id string) bool {
+	if a == nil {
+		return true
+	}
+	if a.ids == nil {
+		a.ids = map[string]bool{}
+		for _, item := range a.lib.All() {
+			if a.allowsItem(item) {
+				a.ids[item.ID] = true
+			}
+		}
+	}
+	return a.ids[id]
+}
+
+// allowsAnyID reports whether at least one of the media IDs is accessible
+func (a *libraryAccess) allowsAnyID(ids []string) bool {
+	if a == nil {
+		return true
+	}
+	for _, id := range ids {
+		if a.allowsID(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *libraryAccess) filterItems(items []MediaItem) []MediaItem {
+	if a == nil {
+		return items
+	}
+	out := make([]MediaItem, 0, len(items))
+	for _, item := range items {
+		if a.allowsItem(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (a *libraryAccess) filterEpisodes(episodes []Episode) []Episode {
+	if a == nil {
+		return episodes
+	}
+	out := make([]Episode, 0, len(episodes))
+	for _, episode := range episodes {
+		if a.allowsID(episode.MediaID) {
+			out = append(out, episode)
+		}
+	}
+	return out
+}
+
+// filterShowGroups drops grouped shows without accessible episodes and recounts the rest
+func (a *libraryAccess) filterShowGroups(shows []TVShowGroup) []TVShowGroup {
+	if a == nil {
+		return shows
+	}
+	out := make([]TVShowGroup, 0, len(shows))
+	for _, show := range shows {
+		visible := make([]string, 0, len(show.EpisodeIDs))
+		for _, id := range show.EpisodeIDs {
+			if a.allowsID(id) {
+				visible = append(visible, id)
+			}
+		}
+		if len(visible) == 0 {
+			continue
+		}
+		show.EpisodeIDs = visible
+		show.EpisodeCount = len(visible)
+		show.FirstEpisodeID = visible[0]
+		out = append(out, show)
+	}
+	return out
+}
+
+// pageLimit returns the limit and offset to query with. Restricted requests fetch
+// everything and paginate after filtering, so pages stay complete.
+func (a *libraryAccess) pageLimit(limit, offset int) (int, int) {
+	if a == nil {
+		return limit, offset
+	}
+	return 0, 0
+}
+
+// paginate applies limit and offset to results filtered by a restricted request
+func paginate[T any](a *libraryAccess, items []T, limit, offset int) []T {
+	if a == nil {
+		return items
+	}
+	if offset > 0 {
+		if offset >= len(items) {
+			return []T{}
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// knownRootIDs returns the IDs that belong to existing roots and the first unknown ID, if any
+func (s *Server) knownRootIDs(ids []string) ([]string, string, error) {
+	roots, err := s.lib.store.ListRoots()
+	if err != nil {
+		return nil, "", err
+	}
+	known := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		known[root.ID] = true
+	}
+	rootIDs := []string{}
+	unknown := ""
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if !known[id] {
+			if unknown == "" {
+				unknown = id
+			}
+			continue
+		}
+		rootIDs = append(rootIDs, id)
+	}
+	return rootIDs, unknown, nil
+}
+
+// handleAuthUserRoots shows and replaces the library roots granted to a user (admin only)
+func (s *Server) handleAuthUserRoots(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, PUT, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	userID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/users/"), "/roots")
+	if userID == "" || strings.Contains(userID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+	if _, err := s.authManager.GetUser(userID); err != nil {
+		if err == auth.ErrUserNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		access, _, err := s.lib.store.GetUserRootAccess(userID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, access)
+
+	case http.MethodPut:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload RootAccess
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		rootIDs, unknown, err := s.knownRootIDs(payload.RootIDs)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if unknown != "" {
+			s.writeError(w, "unknown root: "+unknown, http.StatusBadRequest)
+			return
+		}
+		payload.RootIDs = rootIDs
+
+		err = s.lib.store.SetUserRootAccess(userID, payload)
+		s.audit(r, "auth.user.roots", userID, auditOutcome(err))
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+
+		access, _, err := s.lib.store.GetUserRootAccess(userID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, access)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
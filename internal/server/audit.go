@@ -0,0 +1,162 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+const (
+	auditPruneInterval = time.Hour
+	auditDefaultLimit  = 100
+)
+
+// audit records an action of the request user
+func (s *Server) audit(r *http.Request, action, target, outcome string) {
+	actorID, actor := "", ""
+	if session, ok := sessionFromContext(r.Context()); ok {
+		actorID, actor = session.UserID, session.Username
+	}
+	s.auditAs(r, actorID, actor, action, target, outcome)
+}
+
+// auditAs records an action for an explicit actor, e.g. the username of a login attempt.
+// Writing is best effort: a broken audit log must not fail the audited request.
+func (s *Server) auditAs(r *http.Request, actorID, actor, action, target, outcome string) {
+	if s.lib.store == nil || s.readOnly {
+		return
+	}
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		ActorID:   actorID,
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		IP:        clientIP(r),
+		Outcome:   outcome,
+	}
+	if err := s.lib.store.AddAuditEntry(entry); err != nil {
+		log.Printf("level=warn msg=\"failed to write audit entry\" action=%s err=%v", action, err)
+	}
+}
+
+// auditOutcome maps the error of an audited operation to its outcome
+func auditOutcome(err error) string {
+	if err != nil {
+		return AuditFailure
+	}
+	return AuditSuccess
+}
+
+// runAuditPruner deletes audit entries older than the retention period
+func (s *Server) runAuditPruner() {
+	defer s.auditWg.Done()
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		s.pruneAuditLog()
+		select {
+		case <-ticker.C:
+		case <-s.auditStop:
+			return
+		}
+	}
+}
+
+func (s *Server) pruneAuditLog() {
+	removed, err := s.lib.store.PruneAuditEntries(time.Now().Add(-s.auditRetention))
+	if err != nil {
+		log.Printf("level=warn msg=\"failed to prune audit log\" err=%v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("level=info msg=\"pruned audit log\" removed=%d retention=%s", removed, s.auditRetention)
+	}
+}
+
+func (s *Server) stopAuditPruner() {
+	if s.auditStop == nil {
+		return
+	}
+	close(s.auditStop)
+	s.auditWg.Wait()
+	s.auditStop = nil
+}
+
+// handleAudit lists audit entries, newest first (admin only)
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w)
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	limit, offset, ok := parseLimitOffset(r)
+	if !ok {
+		s.writeError(w, errBadRequest, http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = auditDefaultLimit
+	}
+
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:   strings.TrimSpace(query.Get("actor")),
+		Action:  strings.TrimSpace(query.Get("action")),
+		Target:  strings.TrimSpace(query.Get("target")),
+		Outcome: strings.TrimSpace(query.Get("outcome")),
+	}
+	if filter.Since, ok = parseAuditTime(query.Get("since")); !ok {
+		s.writeError(w, "invalid since", http.StatusBadRequest)
+		return
+	}
+	if filter.Until, ok = parseAuditTime(query.Get("until")); !ok {
+		s.writeError(w, "invalid until", http.StatusBadRequest)
+		return
+	}
+
+	entries, total, err := s.lib.store.ListAuditEntries(filter, limit, offset)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]any{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// parseAuditTime accepts RFC 3339 timestamps and Unix seconds; empty means unset
+func parseAuditTime(raw string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, true
+	}
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed, true
+}
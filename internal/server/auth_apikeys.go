@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// handleAuthAPIKeys lists and creates API keys (admin only)
+func (s *Server) handleAuthAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.authManager.ListAPIKeys(strings.TrimSpace(r.URL.Query().Get("userId")))
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, keys)
+
+	case http.MethodPost:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			Name      string    `json:"name"`
+			UserID    string    `json:"userId"`
+			Scopes    []string  `json:"scopes"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		name := strings.TrimSpace(payload.Name)
+		if name == "" {
+			s.writeError(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		if !payload.ExpiresAt.IsZero() && !payload.ExpiresAt.After(time.Now()) {
+			s.writeError(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		userID := strings.TrimSpace(payload.UserID)
+		if userID == "" {
+			userID = session.UserID
+		}
+
+		key, plain, err := s.authManager.CreateAPIKey(userID, name, payload.Scopes, payload.ExpiresAt)
+		if err != nil {
+			s.audit(r, "auth.apikey.create", userID, AuditFailure)
+			switch err {
+			case auth.ErrUserNotFound:
+				s.writeError(w, "user not found", http.StatusNotFound)
+			case auth.ErrInvalidScope:
+				s.writeError(w, "invalid scope", http.StatusBadRequest)
+			default:
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		s.audit(r, "auth.apikey.create", key.ID, AuditSuccess)
+
+		// The plain key is only shown once
+		writeJSON(w, r, struct {
+			*auth.APIKey
+			Key string `json:"key"`
+		}{key, plain})
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
+// handleAuthAPIKeyDetail revokes an API key (admin only)
+func (s *Server) handleAuthAPIKeyDetail(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "DELETE, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	if s.readOnly {
+		s.writeError(w, "read-only mode", http.StatusForbidden)
+		return
+	}
+
+	keyID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/apikeys/"), "/")
+	if keyID == "" {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	err := s.authManager.RevokeAPIKey(keyID)
+	s.audit(r, "auth.apikey.revoke", keyID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrAPIKeyNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{"status": "ok"})
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/treefix50/primetime/internal/auth"
 )
@@ -25,8 +28,9 @@ func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var payload struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		ClientName string `json:"clientName"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -39,19 +43,66 @@ func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := s.authManager.Login(payload.Username, payload.Password)
+	client := auth.ClientInfo{Name: strings.TrimSpace(payload.ClientName), IP: clientIP(r)}
+	if client.Name == "" {
+		client.Name = r.UserAgent()
+	}
+
+	session, err := s.authManager.Login(payload.Username, payload.Password, client)
 	if err != nil {
-		if err == auth.ErrInvalidCredentials {
-			s.writeError(w, "invalid credentials", http.StatusUnauthorized)
+		var challenge *auth.SecondFactorChallenge
+		if errors.As(err, &challenge) {
+			// The client completes the login with the challenge at /auth/login/totp
+			writeJSON(w, r, map[string]any{
+				"secondFactorRequired": true,
+				"challenge":            challenge.Token,
+				"expiresAt":            challenge.ExpiresAt,
+			})
 			return
 		}
-		s.writeError(w, errInternal, http.StatusInternalServerError)
+		s.auditAs(r, "", payload.Username, "auth.login", "", loginAuditOutcome(err))
+		s.writeLoginError(w, err)
 		return
 	}
 
+	s.auditAs(r, session.UserID, session.Username, "auth.login", "", AuditSuccess)
 	writeJSON(w, r, session)
 }
 
+// loginAuditOutcome records throttled logins as denied and everything else as failed
+func loginAuditOutcome(err error) string {
+	var throttled *auth.ThrottleError
+	if errors.As(err, &throttled) {
+		return AuditDenied
+	}
+	return AuditFailure
+}
+
+// writeLoginError maps login errors to responses
+func (s *Server) writeLoginError(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottleError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(throttled.RetryAfter)))
+		if errors.Is(err, auth.ErrAccountLocked) {
+			s.writeError(w, "account temporarily locked", http.StatusLocked)
+			return
+		}
+		s.writeError(w, "too many login attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	switch err {
+	case auth.ErrInvalidCredentials:
+		s.writeError(w, "invalid credentials", http.StatusUnauthorized)
+	case auth.ErrInvalidChallenge:
+		s.writeError(w, "invalid or expired challenge", http.StatusUnauthorized)
+	case auth.ErrInvalidCode:
+		s.writeError(w, "invalid code", http.StatusUnauthorized)
+	default:
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+	}
+}
+
 // handleAuthLogout handles user logout
 func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
 	if s.handleOptions(w, r, "POST, OPTIONS") {
@@ -74,7 +125,9 @@ func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.authManager.Logout(token); err != nil {
+	err := s.authManager.Logout(token)
+	s.audit(r, "auth.logout", "", auditOutcome(err))
+	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
@@ -128,21 +181,8 @@ func (s *Server) handleAuthUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Require authentication
-	session, err := s.requireAuth(r)
-	if err != nil {
-		s.writeError(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	switch r.Method {
 	case http.MethodGet:
-		// Admin only
-		if !session.IsAdmin {
-			s.writeError(w, "admin access required", http.StatusForbidden)
-			return
-		}
-
 		users, err := s.authManager.ListUsers()
 		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
@@ -164,12 +204,6 @@ func (s *Server) handleAuthUsers(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, r, safeUsers)
 
 	case http.MethodPost:
-		// Admin only
-		if !session.IsAdmin {
-			s.writeError(w, "admin access required", http.StatusForbidden)
-			return
-		}
-
 		var payload struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
@@ -188,13 +222,19 @@ func (s *Server) handleAuthUsers(w http.ResponseWriter, r *http.Request) {
 
 		user, err := s.authManager.CreateUser(payload.Username, payload.Password, payload.IsAdmin)
 		if err != nil {
+			s.audit(r, "auth.user.create", payload.Username, AuditFailure)
 			if err == auth.ErrUserExists {
 				s.writeError(w, "user already exists", http.StatusConflict)
 				return
 			}
+			if errors.Is(err, auth.ErrWeakPassword) {
+				s.writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
+		s.audit(r, "auth.user.create", user.ID, AuditSuccess)
 
 		// Return safe user data
 		writeJSON(w, r, map[string]interface{}{
@@ -264,7 +304,13 @@ func (s *Server) handleAuthUserPassword(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := s.authManager.ResetPassword(userID, payload.NewPassword); err != nil {
+		err := s.authManager.ResetPassword(userID, payload.NewPassword)
+		s.audit(r, "auth.user.password.reset", userID, auditOutcome(err))
+		if err != nil {
+			if errors.Is(err, auth.ErrWeakPassword) {
+				s.writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -275,11 +321,17 @@ func (s *Server) handleAuthUserPassword(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := s.authManager.ChangePassword(userID, payload.OldPassword, payload.NewPassword); err != nil {
+		err := s.authManager.ChangePassword(userID, payload.OldPassword, payload.NewPassword)
+		s.audit(r, "auth.user.password.change", userID, auditOutcome(err))
+		if err != nil {
 			if err == auth.ErrInvalidCredentials {
 				s.writeError(w, "invalid old password", http.StatusUnauthorized)
 				return
 			}
+			if errors.Is(err, auth.ErrWeakPassword) {
+				s.writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -311,12 +363,6 @@ func (s *Server) handleAuthUserDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Admin only
-	if !session.IsAdmin {
-		s.writeError(w, "admin access required", http.StatusForbidden)
-		return
-	}
-
 	path := strings.TrimPrefix(r.URL.Path, "/auth/users/")
 	userID := strings.TrimSuffix(path, "/")
 
@@ -331,7 +377,50 @@ func (s *Server) handleAuthUserDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.authManager.DeleteUser(userID); err != nil {
+	err = s.authManager.DeleteUser(userID)
+	s.audit(r, "auth.user.delete", userID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrLastAdmin {
+			s.writeError(w, "cannot delete the last admin", http.StatusConflict)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{"status": "ok"})
+}
+
+// handleAuthUserUnlock clears the login lockout of a user (admin only)
+func (s *Server) handleAuthUserUnlock(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "POST, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/auth/users/")
+	userID := strings.TrimSuffix(path, "/unlock")
+	if userID == "" || strings.Contains(userID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	err := s.authManager.UnlockUser(userID)
+	s.audit(r, "auth.user.unlock", userID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrUserNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
@@ -341,6 +430,10 @@ func (s *Server) handleAuthUserDelete(w http.ResponseWriter, r *http.Request) {
 
 // requireAuth validates the session and returns it
 func (s *Server) requireAuth(r *http.Request) (*auth.Session, error) {
+	if session, ok := sessionFromContext(r.Context()); ok {
+		return session, nil
+	}
+
 	if s.authManager == nil {
 		return nil, auth.ErrInvalidToken
 	}
@@ -353,11 +446,21 @@ func (s *Server) requireAuth(r *http.Request) (*auth.Session, error) {
 	return s.authManager.ValidateSession(token)
 }
 
-// extractToken extracts the bearer token from the Authorization header
+// retryAfterSeconds converts a wait duration into a Retry-After value
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// extractToken extracts the bearer token from the Authorization header.
+// API keys may alternatively be sent in the X-API-Key header.
 func extractToken(r *http.Request) string {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return ""
+		return strings.TrimSpace(r.Header.Get("X-API-Key"))
 	}
 
 	parts := strings.SplitN(authHeader, " ", 2)
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// handleAuthInvites lists and creates registration invites (admin only)
+func (s *Server) handleAuthInvites(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		invites, err := s.authManager.ListInvites()
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, invites)
+
+	case http.MethodPost:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			TTLSeconds int64    `json:"ttlSeconds"`
+			MaxUses    int      `json:"maxUses"`
+			IsAdmin    bool     `json:"isAdmin"`
+			AllRoots   *bool    `json:"allRoots"`
+			RootIDs    []string `json:"rootIds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if payload.TTLSeconds < 0 || payload.MaxUses < 0 {
+			s.writeError(w, "ttlSeconds and maxUses must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		// Without an explicit allRoots, listing roots restricts the account to them
+		options := auth.InviteOptions{
+			TTL:      time.Duration(payload.TTLSeconds) * time.Second,
+			MaxUses:  payload.MaxUses,
+			IsAdmin:  payload.IsAdmin,
+			AllRoots: len(payload.RootIDs) == 0,
+		}
+		if payload.AllRoots != nil {
+			options.AllRoots = *payload.AllRoots
+		}
+		if !options.AllRoots {
+			if s.lib.store == nil {
+				s.writeError(w, "not available without database", http.StatusNotImplemented)
+				return
+			}
+			rootIDs, unknown, err := s.knownRootIDs(payload.RootIDs)
+			if err != nil {
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+				return
+			}
+			if unknown != "" {
+				s.writeError(w, "unknown root: "+unknown, http.StatusBadRequest)
+				return
+			}
+			options.RootIDs = rootIDs
+		}
+
+		invite, code, err := s.authManager.CreateInvite(session.UserID, options)
+		if err != nil {
+			s.audit(r, "auth.invite.create", "", AuditFailure)
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, "auth.invite.create", invite.ID, AuditSuccess)
+
+		// The plain code is only shown once
+		writeJSON(w, r, struct {
+			*auth.Invite
+			Code string `json:"code"`
+		}{invite, code})
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
+// handleAuthInviteDetail revokes an invite (admin only)
+func (s *Server) handleAuthInviteDetail(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "DELETE, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	if s.readOnly {
+		s.writeError(w, "read-only mode", http.StatusForbidden)
+		return
+	}
+
+	inviteID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/invites/"), "/")
+	if inviteID == "" || strings.Contains(inviteID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	err := s.authManager.RevokeInvite(inviteID)
+	s.audit(r, "auth.invite.revoke", inviteID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrInviteNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{"status": "ok"})
+}
+
+// handleAuthRegister creates an account from an invite. GET returns the password policy.
+func (s *Server) handleAuthRegister(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, r, map[string]any{"passwordPolicy": s.authManager.PasswordPolicy()})
+
+	case http.MethodPost:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			Code     string `json:"code"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		username := strings.TrimSpace(payload.Username)
+		if strings.TrimSpace(payload.Code) == "" || username == "" || payload.Password == "" {
+			s.writeError(w, "code, username and password are required", http.StatusBadRequest)
+			return
+		}
+
+		user, invite, err := s.authManager.Register(payload.Code, username, payload.Password)
+		if err != nil {
+			s.auditAs(r, "", username, "auth.register", "", AuditFailure)
+			switch {
+			case err == auth.ErrInvalidInvite:
+				s.writeError(w, "invalid or expired invite", http.StatusForbidden)
+			case err == auth.ErrUserExists:
+				s.writeError(w, "user already exists", http.StatusConflict)
+			case errors.Is(err, auth.ErrWeakPassword):
+				s.writeError(w, err.Error(), http.StatusBadRequest)
+			default:
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		s.auditAs(r, user.ID, user.Username, "auth.register", invite.ID, AuditSuccess)
+
+		writeJSON(w, r, map[string]interface{}{
+			"id":        user.ID,
+			"username":  user.Username,
+			"isAdmin":   user.IsAdmin,
+			"createdAt": user.CreatedAt,
+		})
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// routePolicy describes which credentials a route requires.
+type routePolicy int
+
+const (
+	policySession routePolicy = iota
+	policyPublic
+	policyAdmin
+)
+
+type routeRule struct {
+	path   string
+	prefix bool
+	// suffix additionally limits a prefix rule to paths ending with it
+	suffix string
+	// methods limits the rule to these methods; empty means all methods
+	methods []string
+	policy  routePolicy
+}
+
+// routePolicies lists every route that deviates from the default session policy.
+// Public routes form an explicit allow-list; everything not listed here requires a session.
+// The first matching rule wins, so method-specific rules come before broader ones.
+var routePolicies = []routeRule{
+	{path: "/health", policy: policyPublic},
+	{path: "/version", policy: policyPublic},
+	{path: "/auth/login", policy: policyPublic},
+	{path: "/auth/login/totp", policy: policyPublic},
+	{path: "/auth/register", policy: policyPublic},
+	{path: "/users", policy: policyAdmin},
+	{path: "/users/", prefix: true, policy: policyAdmin},
+	{path: "/auth/users", policy: policyAdmin},
+	// Users change their own password, the handler checks the account
+	{path: "/auth/users/", prefix: true, suffix: "/password", policy: policySession},
+	{path: "/auth/users/", prefix: true, policy: policyAdmin},
+	{path: "/auth/apikeys", policy: policyAdmin},
+	{path: "/auth/apikeys/", prefix: true, policy: policyAdmin},
+	{path: "/audit", policy: policyAdmin},
+	{path: "/auth/invites", policy: policyAdmin},
+	{path: "/auth/invites/", prefix: true, policy: policyAdmin},
+	// Changes to the shared library, reads stay at session level
+	{path: "/library", methods: []string{http.MethodPost}, policy: policyAdmin},
+	{path: "/library/scan", policy: policyAdmin},
+	{path: "/library/roots", methods: []string{http.MethodPost, http.MethodDelete}, policy: policyAdmin},
+	{path: "/library/roots/", prefix: true, methods: []string{http.MethodPost, http.MethodPatch, http.MethodDelete}, policy: policyAdmin},
+	{path: "/library/scans/", prefix: true, methods: []string{http.MethodDelete}, policy: policyAdmin},
+	{path: "/library/duplicates/hash", methods: []string{http.MethodPost}, policy: policyAdmin},
+	{path: "/items/", prefix: true, suffix: "/rating", policy: policyAdmin},
+	{path: "/shows", methods: []string{http.MethodPost}, policy: policyAdmin},
+	{path: "/shows/", prefix: true, methods: []string{http.MethodDelete}, policy: policyAdmin},
+	{path: "/transcoding/profiles", methods: []string{http.MethodPost}, policy: policyAdmin},
+	{path: "/transcoding/profiles/", prefix: true, methods: []string{http.MethodDelete}, policy: policyAdmin},
+	{path: "/collections", methods: []string{http.MethodPost}, policy: policyAdmin},
+	{path: "/collections/", prefix: true, methods: []string{http.MethodPost, http.MethodPut, http.MethodDelete}, policy: policyAdmin},
+}
+
+type contextKey string
+
+const sessionContextKey contextKey = "session"
+
+// withSession stores the authenticated session in the request context.
+func withSession(ctx context.Context, session *auth.Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey, session)
+}
+
+// sessionFromContext returns the session stored by the auth middleware.
+func sessionFromContext(ctx context.Context) (*auth.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(*auth.Session)
+	return session, ok && session != nil
+}
+
+// sessionUserID returns the ID of the authenticated user, or "" when authentication is unavailable.
+func sessionUserID(r *http.Request) string {
+	if session, ok := sessionFromContext(r.Context()); ok {
+		return session.UserID
+	}
+	return ""
+}
+
+// scopesAllow reports whether an API key restricted to scopes may perform the request.
+// read-only permits reads, playback additionally permits progress and watched updates
+// and minting signed stream URLs.
+func scopesAllow(scopes []string, r *http.Request) bool {
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+	for _, scope := range scopes {
+		switch scope {
+		case auth.ScopeAdmin:
+			return true
+		case auth.ScopeReadOnly:
+			if isRead {
+				return true
+			}
+		case auth.ScopePlayback:
+			if isRead || isPlaybackWrite(r.URL.Path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isPlaybackWrite(requestPath string) bool {
+	if !strings.HasPrefix(requestPath, "/items/") {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(requestPath, "/items/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return false
+	}
+	return parts[1] == "playback" || parts[1] == "watched" || parts[1] == "stream-url"
+}
+
+// clientIP returns the IP address of the client. Forwarding headers are only trusted
+// when the peer is a loopback address, i.e. a reverse proxy on the same host.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first := strings.TrimSpace(strings.Split(forwarded, ",")[0])
+			if net.ParseIP(first) != nil {
+				return first
+			}
+		}
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+	}
+	return host
+}
+
+func policyForRequest(method, requestPath string) routePolicy {
+	for _, rule := range routePolicies {
+		if len(rule.methods) > 0 && !slices.Contains(rule.methods, method) {
+			continue
+		}
+		if rule.prefix {
+			if strings.HasPrefix(requestPath, rule.path) && strings.HasSuffix(requestPath, rule.suffix) {
+				return rule.policy
+			}
+			continue
+		}
+		if requestPath == rule.path {
+			return rule.policy
+		}
+	}
+	return policySession
+}
+
+// authMiddleware enforces the route policies and attaches the session to the request context.
+// Without an auth manager (no database) authentication is not available and requests pass through.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authManager == nil || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := policyForRequest(r.Method, r.URL.Path)
+		if policy == policyPublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := extractToken(r)
+		if token == "" {
+			// Native players fetch streams without headers, using signed URLs instead
+			if session, ok := s.sessionFromStreamSignature(r); ok {
+				next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		session, err := s.authManager.ValidateSession(token)
+		if err != nil {
+			if err == auth.ErrInvalidToken || err == auth.ErrTokenExpired {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				s.writeError(w, "invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+
+		if policy == policyAdmin && !session.IsAdmin {
+			s.auditAs(r, session.UserID, session.Username, "auth.admin", r.Method+" "+r.URL.Path, AuditDenied)
+			s.writeError(w, "admin access required", http.StatusForbidden)
+			return
+		}
+
+		if len(session.Scopes) > 0 && !scopesAllow(session.Scopes, r) {
+			s.writeError(w, "insufficient scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func TestScopesAllow(t *testing.T) {
+	readOnly := []string{auth.ScopeReadOnly}
+	playback := []string{auth.ScopePlayback}
+	admin := []string{auth.ScopeAdmin}
+
+	tests := []struct {
+		scopes []string
+		method string
+		target string
+		want   bool
+	}{
+		{readOnly, http.MethodGet, "/library", true},
+		{readOnly, http.MethodHead, "/items/abc/stream", true},
+		{readOnly, http.MethodPost, "/items/abc/playback", false},
+		{readOnly, http.MethodDelete, "/favorites", false},
+		{playback, http.MethodGet, "/library", true},
+		{playback, http.MethodPost, "/items/abc/playback", true},
+		{playback, http.MethodPut, "/items/abc/watched", true},
+		{playback, http.MethodDelete, "/items/abc/watched", true},
+		{playback, http.MethodPost, "/items/abc/stream-url", true},
+		{playback, http.MethodPost, "/items/abc/rating", false},
+		{playback, http.MethodPost, "/items//playback", false},
+		{playback, http.MethodPost, "/items/abc/playback/extra", false},
+		{playback, http.MethodPost, "/library/scan", false},
+		{playback, http.MethodPost, "/auth/apikeys", false},
+		{admin, http.MethodPost, "/auth/apikeys", true},
+		{admin, http.MethodDelete, "/library/roots", true},
+		{[]string{auth.ScopeReadOnly, auth.ScopePlayback}, http.MethodPost, "/items/abc/watched", true},
+		{[]string{"unknown"}, http.MethodGet, "/library", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := scopesAllow(tt.scopes, r); got != tt.want {
+			t.Errorf("scopesAllow(%v, %s %s) = %v; want %v", tt.scopes, tt.method, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestRoutePoliciesPerMethod(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{"health is public", http.MethodGet, "/health", "", http.StatusOK},
+		{"version is public", http.MethodGet, "/version", "", http.StatusOK},
+		{"login is public", http.MethodPost, "/auth/login", "", http.StatusBadRequest},
+		{"library needs a session", http.MethodGet, "/library", "", http.StatusUnauthorized},
+		{"user lists roots", http.MethodGet, "/library/roots", ts.userToken, http.StatusOK},
+		{"user lists shows", http.MethodGet, "/shows", ts.userToken, http.StatusOK},
+		{"user lists profiles", http.MethodGet, "/transcoding/profiles", ts.userToken, http.StatusOK},
+		{"user rescans library", http.MethodPost, "/library", ts.userToken, http.StatusForbidden},
+		{"user scans path", http.MethodPost, "/library/scan", ts.userToken, http.StatusForbidden},
+		{"user adds root", http.MethodPost, "/library/roots", ts.userToken, http.StatusForbidden},
+		{"user removes root", http.MethodDelete, "/library/roots?id=x", ts.userToken, http.StatusForbidden},
+		{"user edits root", http.MethodPatch, "/library/roots/x", ts.userToken, http.StatusForbidden},
+		{"user scans root", http.MethodPost, "/library/roots/x/scan", ts.userToken, http.StatusForbidden},
+		{"user cancels scan", http.MethodDelete, "/library/scans/x", ts.userToken, http.StatusForbidden},
+		{"user groups shows", http.MethodPost, "/shows", ts.userToken, http.StatusForbidden},
+		{"user deletes show", http.MethodDelete, "/shows/x", ts.userToken, http.StatusForbidden},
+		{"user adds profile", http.MethodPost, "/transcoding/profiles", ts.userToken, http.StatusForbidden},
+		{"user deletes profile", http.MethodDelete, "/transcoding/profiles/x", ts.userToken, http.StatusForbidden},
+		{"user lists collections", http.MethodGet, "/collections", ts.userToken, http.StatusOK},
+		{"user creates collection", http.MethodPost, "/collections", ts.userToken, http.StatusForbidden},
+		{"user renames collection", http.MethodPut, "/collections/x", ts.userToken, http.StatusForbidden},
+		{"user deletes collection", http.MethodDelete, "/collections/x", ts.userToken, http.StatusForbidden},
+		{"user adds collection item", http.MethodPost, "/collections/x/items", ts.userToken, http.StatusForbidden},
+		{"user removes collection item", http.MethodDelete, "/collections/x/items/y", ts.userToken, http.StatusForbidden},
+		{"user lists users", http.MethodGet, "/users", ts.userToken, http.StatusForbidden},
+		{"user lists accounts", http.MethodGet, "/auth/users", ts.userToken, http.StatusForbidden},
+		{"user deletes account", http.MethodDelete, "/auth/users/x", ts.userToken, http.StatusForbidden},
+		{"user unlocks account", http.MethodPost, "/auth/users/x/unlock", ts.userToken, http.StatusForbidden},
+		{"user resets totp", http.MethodDelete, "/auth/users/x/totp", ts.userToken, http.StatusForbidden},
+		{"user reads root access", http.MethodGet, "/auth/users/x/roots", ts.userToken, http.StatusForbidden},
+		{"user reads parental controls", http.MethodGet, "/auth/users/x/parental", ts.userToken, http.StatusForbidden},
+		{"user changes own password", http.MethodPost, "/auth/users/" + ts.userID + "/password", ts.userToken, http.StatusBadRequest},
+		{"user reads audit log", http.MethodGet, "/audit", ts.userToken, http.StatusForbidden},
+		{"user reads item rating", http.MethodGet, "/items/x/rating", ts.userToken, http.StatusForbidden},
+		{"user starts hash job", http.MethodPost, "/library/duplicates/hash", ts.userToken, http.StatusForbidden},
+		{"user lists api keys", http.MethodGet, "/auth/apikeys", ts.userToken, http.StatusForbidden},
+		{"user lists invites", http.MethodGet, "/auth/invites", ts.userToken, http.StatusForbidden},
+		{"admin scans path", http.MethodPost, "/library/scan", ts.adminToken, http.StatusBadRequest},
+		{"admin adds root", http.MethodPost, "/library/roots", ts.adminToken, http.StatusBadRequest},
+		{"admin edits root", http.MethodPatch, "/library/roots/x", ts.adminToken, http.StatusNotFound},
+		{"admin deletes profile", http.MethodDelete, "/transcoding/profiles/x", ts.adminToken, http.StatusOK},
+		{"admin creates collection", http.MethodPost, "/collections", ts.adminToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := ts.do(tt.method, tt.target, tt.token, ""); w.Code != tt.want {
+				t.Fatalf("%s %s = %d %s; want %d", tt.method, tt.target, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeysThroughMiddleware(t *testing.T) {
+	ts := newTestServer(t)
+	manager := auth.NewManager(ts.store, time.Hour)
+	create := func(scopes []string, expiresAt time.Time) string {
+		t.Helper()
+		_, plain, err := manager.CreateAPIKey(ts.userID, "client", scopes, expiresAt)
+		if err != nil {
+			t.Fatalf("CreateAPIKey() error = %v", err)
+		}
+		return plain
+	}
+	readOnly := create([]string{auth.ScopeReadOnly}, time.Time{})
+	playback := create([]string{auth.ScopePlayback}, time.Time{})
+	expired := create(nil, time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{"read-only reads", http.MethodGet, "/library", readOnly, http.StatusOK},
+		{"read-only writes", http.MethodPost, "/favorites", readOnly, http.StatusForbidden},
+		{"playback records progress", http.MethodPost, "/items/missing/playback", playback, http.StatusNotFound},
+		{"playback scans", http.MethodPost, "/library/scan", playback, http.StatusForbidden},
+		{"expired key", http.MethodGet, "/library", expired, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := ts.do(tt.method, tt.target, tt.token, ""); w.Code != tt.want {
+				t.Fatalf("%s %s = %d %s; want %d", tt.method, tt.target, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDenialsAreAudited(t *testing.T) {
+	ts := newTestServer(t)
+
+	if w := ts.do(http.MethodDelete, "/auth/users/x", ts.userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE /auth/users/x as user = %d; want 403", w.Code)
+	}
+
+	// The failed login that reaches the threshold locks the account
+	earlier := auth.LoginFailure{Key: "user:alice", Failures: 9, LastFailure: time.Now().Add(-10 * time.Minute)}
+	if err := ts.store.SaveLoginFailure(earlier); err != nil {
+		t.Fatalf("SaveLoginFailure() error = %v", err)
+	}
+	if w := ts.do(http.MethodPost, "/auth/login", "", `{"username":"alice","password":"wrong"}`); w.Code != http.StatusLocked {
+		t.Fatalf("POST /auth/login = %d %s; want 423", w.Code, w.Body.String())
+	}
+
+	var list struct {
+		Entries []server.AuditEntry `json:"entries"`
+	}
+	decode(t, ts.do(http.MethodGet, "/audit?outcome=denied", ts.adminToken, ""), http.StatusOK, &list)
+	want := map[string]string{"auth.admin": "DELETE /auth/users/x", "auth.login": ""}
+	for _, entry := range list.Entries {
+		if target, ok := want[entry.Action]; ok && entry.Target == target && entry.Actor == "alice" {
+			delete(want, entry.Action)
+		}
+	}
+	if len(want) != 0 {
+		t.Fatalf("denied audit entries = %+v; missing %v", list.Entries, want)
+	}
+}
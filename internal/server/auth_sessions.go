@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// sessionInfo is the public view of a session; the token is never exposed
+type sessionInfo struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	ClientName string    `json:"clientName,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// handleAuthSessions lists the active sessions of the current user.
+// Admins may list the sessions of another user with ?userId=.
+func (s *Server) handleAuthSessions(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := session.UserID
+	if requested := strings.TrimSpace(r.URL.Query().Get("userId")); requested != "" && requested != userID {
+		if !session.IsAdmin {
+			s.writeError(w, "admin access required", http.StatusForbidden)
+			return
+		}
+		userID = requested
+	}
+
+	sessions, err := s.authManager.ListSessions(userID)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]sessionInfo, 0, len(sessions))
+	for _, entry := range sessions {
+		result = append(result, sessionInfo{
+			ID:         entry.ID,
+			UserID:     entry.UserID,
+			Username:   entry.Username,
+			ClientName: entry.ClientName,
+			IP:         entry.IP,
+			CreatedAt:  entry.CreatedAt,
+			LastSeenAt: entry.LastSeenAt,
+			ExpiresAt:  entry.ExpiresAt,
+			Current:    entry.ID != "" && entry.ID == session.ID,
+		})
+	}
+
+	writeJSON(w, r, result)
+}
+
+// handleAuthSessionDetail revokes a session. Users may revoke their own sessions, admins any session.
+func (s *Server) handleAuthSessionDetail(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "DELETE, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/sessions/"), "/")
+	if sessionID == "" || strings.Contains(sessionID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	target, err := s.authManager.GetSessionByID(sessionID)
+	if err != nil {
+		if err == auth.ErrSessionNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	// Do not reveal foreign sessions to regular users
+	if target.UserID != session.UserID && !session.IsAdmin {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	err = s.authManager.RevokeSession(sessionID)
+	s.audit(r, "auth.session.revoke", sessionID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrSessionNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// handleAuthLoginTOTP completes a login that requires a second factor
+func (s *Server) handleAuthLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "POST, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	var payload struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(payload.Challenge) == "" || strings.TrimSpace(payload.Code) == "" {
+		s.writeError(w, "challenge and code are required", http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.authManager.CompleteSecondFactor(payload.Challenge, payload.Code)
+	if err != nil {
+		s.auditAs(r, "", "", "auth.login.totp", "", loginAuditOutcome(err))
+		s.writeLoginError(w, err)
+		return
+	}
+	s.auditAs(r, session.UserID, session.Username, "auth.login.totp", "", AuditSuccess)
+
+	writeJSON(w, r, session)
+}
+
+// handleAuthTOTP shows, starts and disables two-factor authentication for the current user
+func (s *Server) handleAuthTOTP(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, POST, DELETE, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status, err := s.authManager.TOTPStatus(session.UserID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, status)
+
+	case http.MethodPost:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		enrollment, err := s.authManager.BeginTOTPEnrollment(session.UserID)
+		if err != nil {
+			if err == auth.ErrTOTPAlreadyEnabled {
+				s.writeError(w, "two-factor authentication already enabled", http.StatusConflict)
+				return
+			}
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, enrollment)
+
+	case http.MethodDelete:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		err := s.authManager.DisableTOTP(session.UserID, payload.Code)
+		s.audit(r, "auth.totp.disable", session.UserID, auditOutcome(err))
+		if err != nil {
+			switch err {
+			case auth.ErrTOTPNotEnabled:
+				s.writeError(w, "two-factor authentication not enabled", http.StatusConflict)
+			case auth.ErrInvalidCode:
+				s.writeError(w, "invalid code", http.StatusBadRequest)
+			default:
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, r, map[string]string{"status": "ok"})
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
+// handleAuthTOTPConfirm enables two-factor authentication with a first code and returns the recovery codes
+func (s *Server) handleAuthTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "POST, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	session, err := s.requireAuth(r)
+	if err != nil {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if s.readOnly {
+		s.writeError(w, "read-only mode", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := s.authManager.ConfirmTOTPEnrollment(session.UserID, payload.Code)
+	s.audit(r, "auth.totp.enable", session.UserID, auditOutcome(err))
+	if err != nil {
+		switch err {
+		case auth.ErrTOTPAlreadyEnabled:
+			s.writeError(w, "two-factor authentication already enabled", http.StatusConflict)
+		case auth.ErrTOTPNotEnrolled:
+			s.writeError(w, "two-factor enrollment not started", http.StatusConflict)
+		case auth.ErrInvalidCode:
+			s.writeError(w, "invalid code", http.StatusBadRequest)
+		default:
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The recovery codes are only shown once
+	writeJSON(w, r, map[string]any{"recoveryCodes": codes})
+}
+
+// handleAuthUserTOTPReset turns off two-factor authentication for a user (admin only)
+func (s *Server) handleAuthUserTOTPReset(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "DELETE, OPTIONS") {
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	if s.readOnly {
+		s.writeError(w, "read-only mode", http.StatusForbidden)
+		return
+	}
+
+	userID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/users/"), "/totp")
+	if userID == "" || strings.Contains(userID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	err := s.authManager.ResetTOTP(userID)
+	s.audit(r, "auth.user.totp.reset", userID, auditOutcome(err))
+	if err != nil {
+		if err == auth.ErrUserNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]string{"status": "ok"})
+}
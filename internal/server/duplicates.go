@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Kinds of duplicate groups
+const (
+	DuplicateContent = "content"
+	DuplicateIMDb    = "imdb"
+)
+
+// ErrHashJobRunning is returned when a hash job is started while one is running
+var ErrHashJobRunning = errors.New("hash job already running")
+
+// NewDuplicateGroup summarizes a group of duplicates
+func NewDuplicateGroup(kind, key string, items []MediaItem) DuplicateGroup {
+	group := DuplicateGroup{Kind: kind, Key: key, Items: items, Count: len(items)}
+	var largest int64
+	for _, item := range items {
+		group.TotalSize += item.Size
+		largest = max(largest, item.Size)
+	}
+	if len(items) > 1 {
+		group.ReclaimableSize = group.TotalSize - largest
+	}
+	if kind == DuplicateContent && len(items) > 1 {
+		group.Verified = true
+		for _, item := range items {
+			if item.ContentHash == "" || item.ContentHash != items[0].ContentHash {
+				group.Verified = false
+				break
+			}
+		}
+	}
+	return group
+}
+
+// HashJob is the state of the background job computing the full SHA-256 of videos
+// that share their fingerprint with another video
+type HashJob struct {
+	Running     bool      `json:"running"`
+	Total       int       `json:"total"`
+	Hashed      int       `json:"hashed"`
+	Failed      int       `json:"failed"`
+	CurrentPath string    `json:"currentPath,omitempty"`
+	StartedAt   time.Time `json:"startedAt,omitzero"`
+	FinishedAt  time.Time `json:"finishedAt,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// StartHashJob hashes the duplicate candidates in the background. Videos are read
+// one after another, reading several at once would only slow a disk down.
+func (l *Library) StartHashJob() (HashJob, error) {
+	if l.store == nil || storeReadOnly(l.store) {
+		return HashJob{}, errors.New("hash job requires a writable database")
+	}
+
+	l.hashMu.Lock()
+	defer l.hashMu.Unlock()
+	if l.hashJob.Running {
+		return l.hashJob, ErrHashJobRunning
+	}
+	candidates, err := l.store.ListHashCandidates()
+	if err != nil {
+		return HashJob{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.hashJob = HashJob{Running: true, Total: len(candidates), StartedAt: time.Now()}
+	l.hashCancel = cancel
+	l.hashDone = make(chan struct{})
+	go l.runHashJob(ctx, candidates, l.hashDone)
+	return l.hashJob, nil
+}
+
+func (l *Library) runHashJob(ctx context.Context, candidates []MediaItem, done chan struct{}) {
+	defer close(done)
+
+	var jobErr error
+	for _, item := range candidates {
+		if jobErr = ctx.Err(); jobErr != nil {
+			break
+		}
+		l.updateHashJob(func(job *HashJob) { job.CurrentPath = item.VideoPath })
+
+		hash, err := fileSHA256(ctx, item.VideoPath)
+		if err == nil {
+			// A file changed since the scan keeps no hash; the next job picks it up
+			_, err = l.store.SetContentHash(item.ID, item.Fingerprint, hash)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("level=warn msg=\"content hash failed\" path=%s err=%v", item.VideoPath, err)
+		}
+		l.updateHashJob(func(job *HashJob) {
+			if err != nil {
+				job.Failed++
+			} else {
+				job.Hashed++
+			}
+		})
+	}
+
+	l.updateHashJob(func(job *HashJob) {
+		job.Running = false
+		job.CurrentPath = ""
+		job.FinishedAt = time.Now()
+		if jobErr != nil {
+			job.Error = jobErr.Error()
+		}
+	})
+}
+
+func (l *Library) updateHashJob(fn func(job *HashJob)) {
+	l.hashMu.Lock()
+	fn(&l.hashJob)
+	l.hashMu.Unlock()
+}
+
+// HashJob returns the state of the running or last hash job
+func (l *Library) HashJob() HashJob {
+	l.hashMu.Lock()
+	defer l.hashMu.Unlock()
+	return l.hashJob
+}
+
+// stopHashJob cancels a running hash job and waits for it, e.g. on shutdown
+func (l *Library) stopHashJob() {
+	l.hashMu.Lock()
+	cancel, done := l.hashCancel, l.hashDone
+	l.hashMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// fileSHA256 hashes a whole file and stops early when ctx is canceled
+func fileSHA256(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	buf := make([]byte, fingerprintChunk)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := file.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// handleLibraryDuplicatesHash returns the state of the hash job (GET) or starts it
+// (POST)
+func (s *Server) handleLibraryDuplicatesHash(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, POST, OPTIONS") {
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, r, s.lib.HashJob())
+
+	case http.MethodPost:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+		// Hashing reads every candidate completely, so only admins start it
+		job, err := s.lib.StartHashJob()
+		if err != nil {
+			if errors.Is(err, ErrHashJobRunning) {
+				s.writeError(w, "hash job already running", http.StatusConflict)
+				return
+			}
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		s.audit(r, "library.duplicates.hash", "", AuditSuccess)
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(job)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
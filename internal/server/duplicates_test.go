@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestDuplicatesByContentAndIMDb(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("A/Movie.mkv", "movie content")
+	write("B/Movie Copy.mkv", "movie content")
+	nfo := "<movie><title>Film</title><imdbid>tt0123456</imdbid></movie>"
+	write("Film/Film.mkv", "film in high resolution")
+	write("Film/Film.nfo", nfo)
+	write("Film 720p/Film.mkv", "film small")
+	write("Film 720p/Film.nfo", nfo)
+	write("Other.mkv", "other content")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	groups, err := store.GetDuplicates()
+	if err != nil {
+		t.Fatalf("GetDuplicates() error = %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("GetDuplicates() = %+v; want 2 groups", groups)
+	}
+	content, imdb := groups[0], groups[1]
+	if content.Kind != server.DuplicateContent || content.Count != 2 || content.TotalSize != 26 || content.ReclaimableSize != 13 || content.Verified {
+		t.Fatalf("content group = %+v", content)
+	}
+	if imdb.Kind != server.DuplicateIMDb || imdb.Key != "tt0123456" || imdb.Count != 2 || imdb.TotalSize != 33 || imdb.ReclaimableSize != 10 {
+		t.Fatalf("imdb group = %+v", imdb)
+	}
+
+	job, err := lib.StartHashJob()
+	if err != nil {
+		t.Fatalf("StartHashJob() error = %v", err)
+	}
+	if job.Total != 2 {
+		t.Fatalf("hash job total = %d; want 2", job.Total)
+	}
+	for deadline := time.Now().Add(5 * time.Second); lib.HashJob().Running; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("hash job did not finish")
+		}
+	}
+	if job := lib.HashJob(); job.Hashed != 2 || job.Failed != 0 {
+		t.Fatalf("hash job = %+v; want 2 hashed", job)
+	}
+	groups, err = store.GetDuplicates()
+	if err != nil {
+		t.Fatalf("GetDuplicates() error = %v", err)
+	}
+	if !groups[0].Verified {
+		t.Fatalf("content group = %+v; want verified", groups[0])
+	}
+
+	// Changing a copy drops its stale hash
+	write("B/Movie Copy.mkv", "changed content")
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if candidates, err := store.ListHashCandidates(); err != nil || len(candidates) != 0 {
+		t.Fatalf("ListHashCandidates() = %v, %v; want none", candidates, err)
+	}
+	groups, err = store.GetDuplicates()
+	if err != nil || len(groups) != 1 || groups[0].Kind != server.DuplicateIMDb {
+		t.Fatalf("GetDuplicates() = %+v, %v; want only the imdb group", groups, err)
+	}
+}
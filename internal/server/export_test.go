@@ -0,0 +1,8 @@
+package server
+
+import "net/http"
+
+// Handler exposes the middleware chain and routes to the external tests
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
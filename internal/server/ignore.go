@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreFileName is the name of the gitignore-style files honoured by scans. Rules
+// apply to the directory of the file and everything below it.
+const IgnoreFileName = ".primetimeignore"
+
+// Sources of ignore rules besides .primetimeignore files
+const (
+	IgnoreSourceDefault = "default"
+	IgnoreSourceRoot    = "root"
+)
+
+// DefaultIgnorePatterns skips NAS metadata, trash folders, samples and extras
+var DefaultIgnorePatterns = []string{
+	"@eaDir/",
+	"#recycle/",
+	"#snapshot/",
+	"@Recycle/",
+	".@__thumb/",
+	".Trash-*/",
+	".AppleDouble/",
+	"$RECYCLE.BIN/",
+	"System Volume Information/",
+	"lost+found/",
+	"[Ss]ample/",
+	"[Ss]ample.*",
+	"*[-._ ][Ss]ample.*",
+	"[Ee]xtras/",
+}
+
+// IgnoredPath is an entry a scan skipped, with the rule that matched it
+type IgnoredPath struct {
+	Path       string    `json:"path"`
+	IsDir      bool      `json:"isDir"`
+	Pattern    string    `json:"pattern"`
+	Source     string    `json:"source"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// ignoreRule is one compiled gitignore pattern. Anchored patterns match the path
+// relative to base, all others match at any depth below it.
+type ignoreRule struct {
+	pattern string
+	source  string
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// parseIgnoreLine compiles a line of an ignore file; blank lines and comments
+// yield no rule
+func parseIgnoreLine(line, base, source string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{pattern: line, source: source, base: base}
+	glob := line
+	switch {
+	case strings.HasPrefix(glob, "!"):
+		rule.negate = true
+		glob = glob[1:]
+	case strings.HasPrefix(glob, `\!`), strings.HasPrefix(glob, `\#`):
+		glob = glob[1:]
+	}
+	if strings.HasSuffix(glob, "/") {
+		rule.dirOnly = true
+		glob = strings.TrimRight(glob, "/")
+	}
+	if glob == "" {
+		return ignoreRule{}, false
+	}
+
+	// A slash anywhere but at the end anchors the pattern to its base directory
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	expr, ok := globToRegexp(glob)
+	if !ok {
+		return ignoreRule{}, false
+	}
+	if anchored || strings.HasPrefix(glob, "**/") {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates gitignore wildcards: * and ? stay within a path segment,
+// ** spans segments and [...] is a character class
+func globToRegexp(glob string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				switch {
+				case i+1 < len(glob) && glob[i+1] == '/':
+					// "**/" matches zero or more directories
+					i++
+					b.WriteString("(?:.*/)?")
+				case i+1 == len(glob):
+					b.WriteString(".*")
+				default:
+					b.WriteString("[^/]*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", false
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), true
+}
+
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return r.re.MatchString(filepath.ToSlash(rel))
+}
+
+// ignoreMatcher decides which paths a scan skips. Rules are applied in the order
+// defaults, root excludes, then .primetimeignore files from the top directory down
+// to the entry; as in git, the last matching rule wins.
+type ignoreMatcher struct {
+	top      string
+	cwd      string
+	defaults []ignoreRule
+	roots    map[string][]ignoreRule
+	mu       sync.Mutex
+	chains   map[string][]ignoreRule
+}
+
+// newIgnoreMatcher builds a matcher for paths below top. rootExcludes maps root
+// paths to their exclude patterns.
+func newIgnoreMatcher(top string, rootExcludes map[string][]string) *ignoreMatcher {
+	cwd, _ := os.Getwd()
+	m := &ignoreMatcher{
+		cwd:    cwd,
+		roots:  map[string][]ignoreRule{},
+		chains: map[string][]ignoreRule{},
+	}
+	m.top = m.abs(top)
+	for _, pattern := range DefaultIgnorePatterns {
+		if rule, ok := parseIgnoreLine(pattern, m.top, IgnoreSourceDefault); ok {
+			m.defaults = append(m.defaults, rule)
+		}
+	}
+	for rootPath, patterns := range rootExcludes {
+		rootPath = m.abs(rootPath)
+		for _, pattern := range patterns {
+			if rule, ok := parseIgnoreLine(pattern, rootPath, IgnoreSourceRoot); ok {
+				m.roots[rootPath] = append(m.roots[rootPath], rule)
+			}
+		}
+	}
+	return m
+}
+
+// abs makes walk paths comparable; relative paths are resolved once against the
+// working directory instead of calling filepath.Abs for every entry
+func (m *ignoreMatcher) abs(path string) string {
+	if filepath.IsAbs(path) || m.cwd == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(m.cwd, path)
+}
+
+// match returns the rule that excludes path, if any
+func (m *ignoreMatcher) match(path string, isDir bool) (ignoreRule, bool) {
+	path = m.abs(path)
+	if path == m.top || !pathWithin(m.top, path) {
+		return ignoreRule{}, false
+	}
+
+	var last ignoreRule
+	matched := false
+	check := func(rules []ignoreRule) {
+		for _, rule := range rules {
+			if rule.matches(path, isDir) {
+				last, matched = rule, true
+			}
+		}
+	}
+	check(m.defaults)
+	check(m.chain(filepath.Dir(path)))
+	if !matched || last.negate {
+		return ignoreRule{}, false
+	}
+	return last, true
+}
+
+// excludedAncestor reports the rule of an ignored directory containing path, e.g.
+// when a partial scan targets a directory inside @eaDir
+func (m *ignoreMatcher) excludedAncestor(path string) (ignoreRule, bool) {
+	for dir := filepath.Dir(m.abs(path)); dir != m.top && pathWithin(m.top, dir); dir = filepath.Dir(dir) {
+		if rule, ok := m.match(dir, true); ok {
+			return rule, true
+		}
+	}
+	return ignoreRule{}, false
+}
+
+// chain returns the root excludes and ignore files that apply inside dir, outermost
+// first. Chains are cached, a scan reads every ignore file once.
+func (m *ignoreMatcher) chain(dir string) []ignoreRule {
+	m.mu.Lock()
+	rules, ok := m.chains[dir]
+	m.mu.Unlock()
+	if ok {
+		return rules
+	}
+
+	var parent []ignoreRule
+	if dir != m.top {
+		if up := filepath.Dir(dir); up != dir && pathWithin(m.top, up) {
+			parent = m.chain(up)
+		}
+	}
+	own := append(append([]ignoreRule{}, m.roots[dir]...), readIgnoreFile(dir)...)
+	if len(own) == 0 {
+		rules = parent
+	} else {
+		rules = append(append(make([]ignoreRule, 0, len(parent)+len(own)), parent...), own...)
+	}
+
+	m.mu.Lock()
+	m.chains[dir] = rules
+	m.mu.Unlock()
+	return rules
+}
+
+func readIgnoreFile(dir string) []ignoreRule {
+	path := filepath.Join(dir, IgnoreFileName)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), dir, path); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// ignoreMatcherFor builds the matcher of a scan below top with the exclude patterns
+// of all roots
+func (l *Library) ignoreMatcherFor(top string) *ignoreMatcher {
+	excludes := map[string][]string{}
+	for _, root := range l.Roots() {
+		if len(root.Exclude) > 0 {
+			excludes[root.Path] = append(excludes[root.Path], root.Exclude...)
+		}
+	}
+	return newIgnoreMatcher(top, excludes)
+}
+
+// cleanList trims the entries of a root setting and drops blank ones, as the store does
+func cleanList(entries []string) []string {
+	cleaned := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			cleaned = append(cleaned, entry)
+		}
+	}
+	return cleaned
+}
+
+// validIgnorePatterns reports whether every non-blank pattern compiles to a rule
+func validIgnorePatterns(patterns []string) bool {
+	for _, pattern := range cleanList(patterns) {
+		if _, ok := parseIgnoreLine(pattern, "", ""); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestScanHonoursIgnoreRules(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("Movie.mkv", "x")
+	write("Movie-sample.mkv", "x")
+	write("@eaDir/Movie.mkv", "x")
+	write("Extras/Bonus.mkv", "x")
+	write("Keep/.primetimeignore", "# local rules\n*.mp4\n!keep.mp4\n")
+	write("Keep/a.mp4", "x")
+	write("Keep/keep.mp4", "x")
+	write("Keep/b.mkv", "x")
+	write("Skip/Other.mkv", "x")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	roots, err := store.ListRoots()
+	if err != nil || len(roots) != 1 {
+		t.Fatalf("ListRoots() = %+v, %v", roots, err)
+	}
+	roots[0].Exclude = []string{"/Skip/", " "}
+	if _, err := lib.UpdateRoot(roots[0]); err != nil {
+		t.Fatalf("UpdateRoot() error = %v", err)
+	}
+	if roots, _ = store.ListRoots(); len(roots[0].Exclude) != 1 || roots[0].Exclude[0] != "/Skip/" {
+		t.Fatalf("ListRoots() exclude = %v; want [/Skip/]", roots[0].Exclude)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	items, err := store.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	var paths []string
+	for _, item := range items {
+		rel, _ := filepath.Rel(root, item.VideoPath)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	sort.Strings(paths)
+	if strings.Join(paths, ",") != "Keep/b.mkv,Keep/keep.mp4,Movie.mkv" {
+		t.Fatalf("indexed = %v; want Keep/b.mkv, Keep/keep.mp4, Movie.mkv", paths)
+	}
+
+	ignored, err := store.ListIgnoredPaths(root)
+	if err != nil {
+		t.Fatalf("ListIgnoredPaths() error = %v", err)
+	}
+	want := map[string]string{
+		"@eaDir":           "default @eaDir/",
+		"Extras":           "default [Ee]xtras/",
+		"Keep/a.mp4":       filepath.Join(root, "Keep", ".primetimeignore") + " *.mp4",
+		"Movie-sample.mkv": "default *[-._ ][Ss]ample.*",
+		"Skip":             "root /Skip/",
+	}
+	if len(ignored) != len(want) {
+		t.Fatalf("ListIgnoredPaths() = %+v; want %d entries", ignored, len(want))
+	}
+	for _, entry := range ignored {
+		rel, _ := filepath.Rel(root, entry.Path)
+		if got := entry.Source + " " + entry.Pattern; want[filepath.ToSlash(rel)] != got {
+			t.Fatalf("ignored %s = %q; want %q", rel, got, want[filepath.ToSlash(rel)])
+		}
+	}
+
+	// A partial scan only replaces the entries within its path
+	if err := os.Remove(filepath.Join(root, "Keep", "a.mp4")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := lib.ScanPath("Keep"); err != nil {
+		t.Fatalf("ScanPath() error = %v", err)
+	}
+	if ignored, _ = store.ListIgnoredPaths(root); len(ignored) != len(want)-1 {
+		t.Fatalf("ListIgnoredPaths() after partial scan = %+v; want %d entries", ignored, len(want)-1)
+	}
+}
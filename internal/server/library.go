@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
@@ -27,17 +28,77 @@ type MediaItem struct {
 	Modified   time.Time `json:"modified"`
 	StableKey  string    `json:"-"`
 	PosterPath string    `json:"posterPath,omitempty"`
+	// FileID (device and inode) and Fingerprint identify the video across renames
+	FileID      string `json:"-"`
+	Fingerprint string `json:"-"`
+	// ContentHash is the full SHA-256, only computed by the hash job
+	ContentHash string `json:"-"`
+	// OfflineSince is set while the root of the item is unavailable
+	OfflineSince time.Time `json:"offlineSince,omitzero"`
+	// Parts lists the files of a stacked item (Movie.cd1.avi, Movie.cd2.avi) in
+	// playback order; VideoPath is the first part and Size the size of all parts
+	Parts []MediaPart `json:"parts,omitempty"`
+	// Versions lists the files of an item kept in several resolutions or editions
+	// (Movie.mkv, Movie - 4K.mkv); VideoPath, Size and Modified are version 1
+	Versions []MediaVersion `json:"versions,omitempty"`
+}
+
+// MediaPart is one file of a stacked item
+type MediaPart struct {
+	Part      int       `json:"part"`
+	VideoPath string    `json:"videoPath"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+}
+
+// MediaVersion is one file of an item with versions. Resolution is the class of
+// the video like 2160p, from the NFO of the version or its file name.
+type MediaVersion struct {
+	Version    int       `json:"version"`
+	VideoPath  string    `json:"videoPath"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Resolution string    `json:"resolution,omitempty"`
+	Edition    string    `json:"edition,omitempty"`
 }
 
+// Library manages the media of all roots: the primary root from -root and the roots
+// added through the API, each with its own scan schedule, extensions and type.
 type Library struct {
+	// root and rootID are the primary root
 	root              string
 	rootID            string
 	mu                sync.RWMutex
 	items             map[string]MediaItem
 	store             MediaStore
 	allowedExtensions map[string]bool
+	// roots holds every root by ID
+	rootsMu sync.RWMutex
+	roots   map[string]*libraryRoot
+	// scanInterval is the schedule of roots without their own interval
+	scanInterval time.Duration
+	scheduling   bool
+	scheduleWg   sync.WaitGroup
+	// offlineGrace is how long items of an unavailable root are kept
+	offlineGrace time.Duration
 	// lastScan tracks the time the library last completed a scan.
 	lastScan time.Time
+	// jobs holds the running scans, finishedJobs the most recent finished ones
+	jobsMu       sync.Mutex
+	jobs         map[string]*scanJob
+	finishedJobs []ScanRun
+	// scanWorkers bounds the parallel metadata reads of a scan
+	scanWorkers int
+	// hashJob is the state of the running or last content hash job
+	hashMu     sync.Mutex
+	hashJob    HashJob
+	hashCancel context.CancelFunc
+	hashDone   chan struct{}
+	// versionNFOs caches the IDs and resolution read from the NFOs of versions
+	versionMu   sync.Mutex
+	versionNFOs map[string]versionNFO
 }
 
 var (
@@ -95,13 +156,19 @@ func NewLibrary(root string, store MediaStore, extensions []string) (*Library, e
 		return nil, err
 	}
 	items := map[string]MediaItem{}
-	var rootID string
+	primary := LibraryRoot{Path: root, Type: "library", CreatedAt: time.Now()}
 	if store != nil && !storeReadOnly(store) {
 		rootEntry, err := store.AddRoot(root, "library")
 		if err != nil {
 			return nil, err
 		}
-		rootID = rootEntry.ID
+		primary = rootEntry
+		// Runs still marked as running were cut off by a restart
+		if interrupted, err := store.InterruptScanRuns(time.Now()); err != nil {
+			return nil, err
+		} else if interrupted > 0 {
+			log.Printf("level=warn msg=\"marked interrupted scan runs as failed\" runs=%d", interrupted)
+		}
 	}
 	if store != nil {
 		storedItems, err := store.GetAll()
@@ -112,100 +179,213 @@ func NewLibrary(root string, store MediaStore, extensions []string) (*Library, e
 			items[item.ID] = item
 		}
 	}
-	return &Library{
+	lib := &Library{
 		root:              root,
-		rootID:            rootID,
+		rootID:            primary.ID,
 		items:             items,
 		store:             store,
 		allowedExtensions: buildAllowedExtensions(extensions),
-	}, nil
+		roots:             map[string]*libraryRoot{},
+		jobs:              map[string]*scanJob{},
+		scanWorkers:       DefaultScanWorkers,
+	}
+	if err := lib.loadRoots(primary); err != nil {
+		return nil, err
+	}
+	return lib, nil
 }
 
-// performScan is the core scanning logic used by both Scan() and ScanPath()
-func (l *Library) performScan(targetPath string, isFullScan bool) error {
+// performScan is the core scanning logic used by every scan job. Metadata is only
+// re-read for items whose video or sidecar files changed since the last scan. A
+// canceled walk leaves the library untouched.
+func (l *Library) performScan(job *scanJob) error {
+	ctx := job.ctx
+	scope := job.scope
+	targetPath := job.run.Path
+	// A part of a stack or a version is scanned with its siblings, alone it would
+	// become an item
+	if info, err := os.Stat(targetPath); err == nil && !info.IsDir() && (isStackPart(targetPath) || l.isVersion(targetPath)) {
+		targetPath = filepath.Dir(targetPath)
+	}
 	found := map[string]MediaItem{}
+	files := map[string][]MediaFile{}
+	index := newDirIndex()
+	ignore := l.ignoreMatcherFor(scope.path)
+	var ignored []IgnoredPath
 	var scanErrs []error
-	var scanRunID string
 	canWrite := l.store != nil && !storeReadOnly(l.store)
 
-	if canWrite && l.rootID != "" {
-		run, err := l.store.StartScanRun(l.rootID, time.Now())
-		if err != nil {
-			scanErrs = append(scanErrs, err)
-		} else {
-			scanRunID = run.ID
-		}
+	if job.startErr != nil {
+		scanErrs = append(scanErrs, job.startErr)
 	}
 
-	// Scan files
-	err := filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			scanErrs = append(scanErrs, err)
-			return nil
-		}
-		if d.IsDir() {
-			return nil
+	// An unmounted disk must not wipe the library
+	if err := l.checkRootAvailable(job); err != nil {
+		if errors.Is(err, ErrRootUnavailable) {
+			return errors.Join(append(scanErrs, err)...)
 		}
+		scanErrs = append(scanErrs, err)
+	}
 
-		ext := strings.ToLower(filepath.Ext(d.Name()))
-		if !l.allowedExtensions[ext] {
-			return nil
-		}
+	// Known items resolve their ID without a query per file. Items stored with a path
+	// relative to the working directory keep their ID and get the absolute path.
+	l.mu.RLock()
+	known := make(map[string]MediaItem, len(l.items))
+	for _, item := range l.items {
+		known[ignore.abs(item.VideoPath)] = item
+	}
+	l.mu.RUnlock()
+	// appeared holds the items of files with a path unknown so far
+	appeared := map[string]bool{}
+
+	skip := func(path string, isDir bool, rule ignoreRule) {
+		ignored = append(ignored, IgnoredPath{
+			Path:       ignore.abs(path),
+			IsDir:      isDir,
+			Pattern:    rule.pattern,
+			Source:     rule.source,
+			DetectedAt: time.Now(),
+		})
+	}
 
-		info, err := d.Info()
-		if err != nil {
-			scanErrs = append(scanErrs, err)
-			return nil
-		}
+	// Scan files; a target inside an ignored directory has nothing to index
+	var err error
+	if _, excluded := ignore.excludedAncestor(targetPath); !excluded {
+		err = filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				scanErrs = append(scanErrs, err)
+				return nil
+			}
+			if d.IsDir() {
+				if path != targetPath && scope.isNested(path) {
+					return filepath.SkipDir
+				}
+				if rule, ok := ignore.match(path, true); ok {
+					skip(path, true, rule)
+					return filepath.SkipDir
+				}
+				job.update(func(progress *ScanProgress) {
+					progress.DirsVisited++
+					progress.CurrentPath = path
+				})
+				return nil
+			}
 
-		rawTitle := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
-		title := rawTitle
-		if parsedTitle, _, _, ok := parseEpisodeInfo(rawTitle); ok {
-			title = parsedTitle
-		}
-		nfo, _ := findNFOPaths(path)
+			ext := strings.ToLower(filepath.Ext(d.Name()))
+			if !scope.allowed[ext] {
+				return nil
+			}
+			if rule, ok := ignore.match(path, false); ok {
+				skip(path, false, rule)
+				return nil
+			}
 
-		stableKey := stableID(path, info)
-		id := stableKey
-		if l.store != nil {
-			if existingID, ok, err := l.store.GetIDByPath(path); err != nil {
+			info, err := d.Info()
+			if err != nil {
 				scanErrs = append(scanErrs, err)
-			} else if ok {
-				id = existingID
-			} else if !storeReadOnly(l.store) {
-				id = newUUID()
+				return nil
 			}
-		}
 
-		found[id] = MediaItem{
-			ID:        id,
-			Title:     title,
-			VideoPath: path,
-			NFOPath:   nfo,
-			Size:      info.Size(),
-			Modified:  info.ModTime(),
-			StableKey: stableKey,
-		}
-		return nil
-	})
+			rawTitle := strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+			title := rawTitle
+			if parsedTitle, _, _, ok := parseEpisodeInfo(rawTitle); ok {
+				title = parsedTitle
+			}
+			itemFiles := collectMediaFiles(path, info, index)
+
+			stableKey := stableID(path, info)
+			fileID, _ := fileIdentity(info)
+			id := stableKey
+			fingerprint := ""
+			if prev, ok := known[path]; ok {
+				id = prev.ID
+				// The fingerprint of an unchanged video is not read again
+				if size, modified := firstPartState(prev); size == info.Size() && modified.Unix() == info.ModTime().Unix() {
+					fingerprint = prev.Fingerprint
+				}
+			} else if l.store != nil {
+				if existingID, ok, err := l.store.GetIDByPath(path); err != nil {
+					scanErrs = append(scanErrs, err)
+				} else if ok {
+					id = existingID
+				} else {
+					if !storeReadOnly(l.store) {
+						id = newUUID()
+					}
+					appeared[id] = true
+				}
+			} else {
+				appeared[id] = true
+			}
+
+			found[id] = MediaItem{
+				ID:          id,
+				Title:       title,
+				VideoPath:   path,
+				NFOPath:     mediaFilePath(itemFiles, MediaFileNFO),
+				Size:        info.Size(),
+				Modified:    info.ModTime(),
+				StableKey:   stableKey,
+				PosterPath:  mediaFilePath(itemFiles, MediaFilePoster),
+				FileID:      fileID,
+				Fingerprint: fingerprint,
+			}
+			files[id] = itemFiles
+			job.update(func(progress *ScanProgress) {
+				progress.FilesFound++
+			})
+			return nil
+		})
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		scanErrs = append(scanErrs, err)
 	}
+	if canWrite {
+		if err := l.store.ReplaceIgnoredPaths(ignore.abs(targetPath), ignored); err != nil {
+			scanErrs = append(scanErrs, err)
+		}
+	}
+
+	// Parts of stacked videos become one item before moves are matched
+	stackItems(found, files, appeared, index)
+	// Versions of a movie in the same directory become one item as well
+	l.versionItems(found, files, appeared, index)
 
-	// Update library items with proper locking and deep copy to avoid race conditions
-	l.mu.Lock()
-	previousCopy := make(map[string]MediaItem, len(l.items))
-	for k, v := range l.items {
-		previousCopy[k] = v
+	// Renamed and moved files keep the ID of their item
+	l.fingerprintItems(ctx, found)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
 	}
-	lastScan := l.lastScan
+	moved := l.detectMoves(scope, targetPath, found, files, appeared)
 
-	if isFullScan {
-		l.items = found
-	} else {
+	// Replace the items of the scanned subtree; items of other roots and of nested
+	// roots stay as they are
+	owned := func(item MediaItem) bool {
+		path := ignore.abs(item.VideoPath)
+		return pathWithin(targetPath, path) && scope.owns(path)
+	}
+	l.mu.RLock()
+	previousForComparison := make(map[string]MediaItem)
+	for id, item := range l.items {
+		if owned(item) {
+			previousForComparison[id] = item
+		}
+	}
+	l.mu.RUnlock()
+	// The found items replace the subtree only once the store holds them, otherwise
+	// memory and database would disagree until the next scan
+	commit := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
 		updated := make(map[string]MediaItem, len(l.items)+len(found))
 		for id, item := range l.items {
-			if !pathWithin(targetPath, item.VideoPath) {
+			if !owned(item) {
 				updated[id] = item
 			}
 		}
@@ -213,121 +393,154 @@ func (l *Library) performScan(targetPath string, isFullScan bool) error {
 			updated[id] = item
 		}
 		l.items = updated
+		l.lastScan = time.Now()
 	}
-	l.lastScan = time.Now()
-	l.mu.Unlock()
 
-	// Determine which items to compare against
-	var previousForComparison map[string]MediaItem
-	if isFullScan {
-		previousForComparison = previousCopy
-	} else {
-		previousForComparison = make(map[string]MediaItem)
-		for id, item := range previousCopy {
-			if pathWithin(targetPath, item.VideoPath) {
-				previousForComparison[id] = item
-			}
+	idsToDelete := removedIDs(previousForComparison, found)
+	added := 0
+	for id := range found {
+		if _, ok := previousForComparison[id]; !ok {
+			added++
 		}
 	}
 
 	// Persist changes to store
-	if l.store != nil {
-		idsToDelete := removedIDs(previousForComparison, found)
-		if canWrite && len(idsToDelete) > 0 {
-			if err := l.store.DeleteItems(idsToDelete); err != nil {
-				scanErrs = append(scanErrs, err)
+	var changedIDs []string
+	if canWrite {
+		var errs []error
+		changedIDs, errs = l.persistScan(ctx, found, files, previousForComparison, idsToDelete, commit)
+		scanErrs = append(scanErrs, errs...)
+	} else {
+		commit()
+		for _, item := range diffItems(found, previousForComparison) {
+			changedIDs = append(changedIDs, item.ID)
+		}
+	}
+	job.update(func(progress *ScanProgress) {
+		progress.Added = added
+		progress.Removed = len(idsToDelete)
+		progress.Moved = moved
+		progress.Updated = 0
+		for _, id := range changedIDs {
+			if _, ok := previousForComparison[id]; ok {
+				progress.Updated++
 			}
 		}
-		itemsToSave := diffItems(found, previousForComparison, lastScan)
-		if canWrite && len(itemsToSave) > 0 {
-			if err := l.store.SaveItems(itemsToSave); err != nil {
-				scanErrs = append(scanErrs, err)
-			}
+	})
+
+	if len(scanErrs) > 0 {
+		return errors.Join(scanErrs...)
+	}
+	return nil
+}
+
+// persistScan writes the scan result to the store. Items and metadata of unchanged
+// files are left alone, and only shows with changed episodes are regrouped. It
+// returns the IDs of the items whose files changed. commit runs once the items are
+// saved, before their metadata is read; it is skipped when saving fails.
+func (l *Library) persistScan(ctx context.Context, found map[string]MediaItem, files map[string][]MediaFile, previous map[string]MediaItem, idsToDelete []string, commit func()) ([]string, []error) {
+	var errs []error
+
+	// Without the tracked files every item counts as changed
+	stored, err := l.store.GetMediaFiles()
+	if err != nil {
+		errs = append(errs, err)
+		stored = nil
+	}
+	changed := make([]string, 0)
+	for id := range found {
+		if storedFiles, ok := stored[id]; !ok || !mediaFilesEqual(storedFiles, files[id]) {
+			changed = append(changed, id)
 		}
-		for _, item := range found {
-			if !canWrite {
-				break
-			}
-			itemNFO, showNFO := findNFOPaths(item.VideoPath)
-			if itemNFO == "" {
-				if fallback, ok := fallbackNFOFromFilename(item.VideoPath); ok {
-					merged := fallback
-					if showNFO != "" {
-						if show, err := ParseNFOFile(showNFO); err == nil {
-							merged = mergeEpisodeWithShow(merged, show)
-						}
-					}
-					// Use extended NFO save for complete metadata support
-					if err := l.store.SaveNFOExtended(item.ID, merged); err != nil {
-						scanErrs = append(scanErrs, err)
-					}
-					continue
-				}
-				if err := l.store.DeleteNFOExtended(item.ID); err != nil {
-					scanErrs = append(scanErrs, err)
-				}
-				continue
-			}
-			nfo, err := ParseNFOFile(itemNFO)
-			if err != nil {
-				log.Printf("level=warn msg=\"nfo parse failed\" path=%s err=%v", itemNFO, err)
-				continue
-			}
-			if nfo.Type == "episode" && showNFO != "" {
-				if show, err := ParseNFOFile(showNFO); err == nil {
-					nfo = mergeEpisodeWithShow(nfo, show)
-				}
-			}
-			// Use extended NFO save for complete metadata support (actors, stream details, etc.)
-			if err := l.store.SaveNFOExtended(item.ID, nfo); err != nil {
-				scanErrs = append(scanErrs, err)
-			}
+	}
+	sort.Strings(changed)
+
+	// Shows of removed and changed episodes are looked up before their NFOs change
+	affectedShows := map[string]bool{}
+	if len(changed) > 0 || len(idsToDelete) > 0 {
+		titles, err := l.store.ShowTitlesForItems(append(append([]string{}, idsToDelete...), changed...))
+		if err != nil {
+			errs = append(errs, err)
 		}
-		if canWrite {
-			if err := l.store.AutoGroupEpisodes(); err != nil {
-				scanErrs = append(scanErrs, err)
-			}
+		for _, title := range titles {
+			affectedShows[title] = true
 		}
 	}
 
-	// Finalize scan run
-	var scanErr error
-	if len(scanErrs) > 0 {
-		scanErr = errors.Join(scanErrs...)
+	if len(idsToDelete) > 0 {
+		if err := l.store.DeleteItems(idsToDelete); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	if scanRunID != "" {
-		finishedAt := time.Now()
-		if scanErr != nil {
-			if err := l.store.FailScanRun(scanRunID, finishedAt, scanErr.Error()); err != nil {
-				scanErrs = append(scanErrs, err)
-				scanErr = errors.Join(scanErrs...)
-			}
-		} else {
-			if err := l.store.FinishScanRun(scanRunID, finishedAt); err != nil {
-				scanErrs = append(scanErrs, err)
-				scanErr = errors.Join(scanErrs...)
-			}
+	if itemsToSave := diffItems(found, previous); len(itemsToSave) > 0 {
+		if err := l.store.SaveItems(itemsToSave); err != nil {
+			// Without the items, neither metadata nor file states can be stored
+			return changed, append(errs, err)
 		}
 	}
-	if scanErr != nil {
-		return scanErr
+	commit()
+
+	updates := l.readMetadata(ctx, changed, files)
+	if len(updates) < len(changed) {
+		// Items left over by a cancel are picked up by the next scan
+		errs = append(errs, ctx.Err())
 	}
-	return nil
+	for _, update := range updates {
+		if update.NFO != nil && update.NFO.ShowTitle != "" {
+			affectedShows[update.NFO.ShowTitle] = true
+		}
+	}
+	if err := l.store.SaveMetadataBatch(updates); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(affectedShows) > 0 {
+		titles := make([]string, 0, len(affectedShows))
+		for title := range affectedShows {
+			titles = append(titles, title)
+		}
+		sort.Strings(titles)
+		if err := l.store.AutoGroupShows(titles); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return changed, errs
 }
 
+// ScanPath scans a directory or file inside one of the roots
 func (l *Library) ScanPath(path string) error {
-	targetPath, _, err := l.resolveScanPath(path)
+	targetPath, root, err := l.resolveScanPath(path)
 	if err != nil {
 		return err
 	}
-	return l.performScan(targetPath, false)
+	return l.runScan(l.beginScan(l.scope(root), targetPath, targetPath == root.abs))
 }
 
+// Scan scans all roots one after another
 func (l *Library) Scan() error {
-	return l.performScan(l.root, true)
+	var errs []error
+	for _, root := range l.Roots() {
+		if err := l.ScanRoot(root.ID); err != nil && !errors.Is(err, ErrRootNotFound) {
+			errs = append(errs, fmt.Errorf("root %s: %w", root.Path, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (l *Library) resolveScanPath(path string) (string, fs.FileInfo, error) {
+// ScanRoot scans a whole root
+func (l *Library) ScanRoot(id string) error {
+	l.rootsMu.RLock()
+	root, ok := l.roots[id]
+	l.rootsMu.RUnlock()
+	if !ok {
+		return ErrRootNotFound
+	}
+	return l.runScan(l.beginScan(l.scope(root), root.abs, true))
+}
+
+// resolveScanPath resolves a scan path and the innermost root containing it. Relative
+// paths are relative to the primary root.
+func (l *Library) resolveScanPath(path string) (string, *libraryRoot, error) {
 	cleanPath := strings.TrimSpace(path)
 	if cleanPath == "" {
 		return "", nil, ErrInvalidScanPath
@@ -336,25 +549,21 @@ func (l *Library) resolveScanPath(path string) (string, fs.FileInfo, error) {
 	if !filepath.IsAbs(cleanPath) {
 		cleanPath = filepath.Join(l.root, cleanPath)
 	}
-	rootAbs, err := filepath.Abs(l.root)
-	if err != nil {
-		return "", nil, err
-	}
 	targetAbs, err := filepath.Abs(cleanPath)
 	if err != nil {
 		return "", nil, err
 	}
-	if !pathWithin(rootAbs, targetAbs) {
+	root, ok := l.rootFor(targetAbs)
+	if !ok {
 		return "", nil, ErrInvalidScanPath
 	}
-	info, err := os.Stat(targetAbs)
-	if err != nil {
+	if _, err := os.Stat(targetAbs); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return "", nil, ErrScanPathNotFound
 		}
 		return "", nil, err
 	}
-	return targetAbs, info, nil
+	return targetAbs, root, nil
 }
 
 func pathWithin(basePath, targetPath string) bool {
@@ -482,15 +691,7 @@ func sortItems(items []MediaItem, sortBy string) {
 	}
 }
 
-func diffItems(found, previous map[string]MediaItem, lastScan time.Time) []MediaItem {
-	if lastScan.IsZero() {
-		out := make([]MediaItem, 0, len(found))
-		for _, item := range found {
-			out = append(out, item)
-		}
-		return out
-	}
-
+func diffItems(found, previous map[string]MediaItem) []MediaItem {
 	out := make([]MediaItem, 0, len(found))
 	for id, item := range found {
 		prev, ok := previous[id]
@@ -520,11 +721,18 @@ func mediaItemEqual(a, b MediaItem) bool {
 		a.VideoPath == b.VideoPath &&
 		a.NFOPath == b.NFOPath &&
 		a.Size == b.Size &&
-		a.Modified.Equal(b.Modified) &&
-		a.StableKey == b.StableKey
-}
-
-func findNFOPaths(videoPath string) (string, string) {
+		a.Modified.Unix() == b.Modified.Unix() &&
+		a.StableKey == b.StableKey &&
+		a.PosterPath == b.PosterPath &&
+		a.FileID == b.FileID &&
+		a.Fingerprint == b.Fingerprint &&
+		mediaPartsEqual(a.Parts, b.Parts) &&
+		mediaVersionsEqual(a.Versions, b.Versions)
+}
+
+// findNFOPaths returns the item and show NFO of a video; exists reports whether a
+// candidate file is present
+func findNFOPaths(videoPath string, exists func(string) bool) (string, string) {
 	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
 	dir := filepath.Dir(videoPath)
 	filename := filepath.Base(base)
@@ -535,7 +743,7 @@ func findNFOPaths(videoPath string) (string, string) {
 		filepath.Join(dir, "index.nfo"),
 		filepath.Join(dir, filepath.Base(dir)+".nfo"),
 	}
-	itemNFO := firstExistingPath(itemCandidates)
+	itemNFO := firstExistingPath(itemCandidates, exists)
 	showNFO := ""
 
 	if title, _, _, ok := parseEpisodeInfo(filename); ok {
@@ -543,18 +751,18 @@ func findNFOPaths(videoPath string) (string, string) {
 			filepath.Join(dir, "tvshow.nfo"),
 			filepath.Join(dir, title+".nfo"),
 		}
-		showNFO = firstExistingPath(showCandidates)
+		showNFO = firstExistingPath(showCandidates, exists)
 	}
 
 	return itemNFO, showNFO
 }
 
-func firstExistingPath(paths []string) string {
+func firstExistingPath(paths []string, exists func(string) bool) string {
 	for _, candidate := range paths {
 		if candidate == "" {
 			continue
 		}
-		if _, err := os.Stat(candidate); err == nil {
+		if exists(candidate) {
 			return candidate
 		}
 	}
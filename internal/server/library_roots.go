@@ -0,0 +1,410 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRootNotFound = errors.New("root not found")
+	ErrRootExists   = errors.New("root already exists")
+	// ErrPrimaryRoot is returned when removing the -root directory, it would be
+	// added again on the next start
+	ErrPrimaryRoot = errors.New("primary root cannot be removed")
+)
+
+// libraryRoot is a root managed by the library. Its settings are guarded by the
+// library's rootsMu; scans work on a rootScope snapshot.
+type libraryRoot struct {
+	LibraryRoot
+	abs     string
+	allowed map[string]bool
+	// scanMu runs the scans of a root one after another
+	scanMu sync.Mutex
+	// stop ends the periodic scans of the root
+	stop chan struct{}
+}
+
+// rootScope is what a scan needs to know about its root
+type rootScope struct {
+	id      string
+	path    string
+	allowed map[string]bool
+	// nested are other roots inside this one; they are scanned on their own
+	nested []string
+	mu     *sync.Mutex
+}
+
+// owns reports whether an item path belongs to the root and not to a nested root
+func (r rootScope) owns(path string) bool {
+	if !pathWithin(r.path, path) {
+		return false
+	}
+	for _, nested := range r.nested {
+		if pathWithin(nested, path) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r rootScope) isNested(dir string) bool {
+	for _, nested := range r.nested {
+		if dir == nested {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath resolves a root or item path; stored paths may be relative to the
+// working directory
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (l *Library) newLibraryRoot(root LibraryRoot) *libraryRoot {
+	root = normalizeRoot(root)
+	allowed := l.allowedExtensions
+	if len(root.Extensions) > 0 {
+		allowed = buildAllowedExtensions(root.Extensions)
+	}
+	return &libraryRoot{LibraryRoot: root, abs: absPath(root.Path), allowed: allowed}
+}
+
+// loadRoots registers the primary root and every root stored in the database. A
+// read-only store could not add the primary root; its stored entry is used if any.
+func (l *Library) loadRoots(primary LibraryRoot) error {
+	roots := []LibraryRoot{primary}
+	if l.store != nil {
+		stored, err := l.store.ListRoots()
+		if err != nil {
+			return err
+		}
+		primaryAbs := absPath(primary.Path)
+		for _, root := range stored {
+			if root.ID == primary.ID || (primary.ID == "" && absPath(root.Path) == primaryAbs) {
+				roots[0] = root
+				l.rootID = root.ID
+			} else {
+				roots = append(roots, root)
+			}
+		}
+	}
+
+	l.rootsMu.Lock()
+	defer l.rootsMu.Unlock()
+	for _, root := range roots {
+		l.roots[root.ID] = l.newLibraryRoot(root)
+	}
+	return nil
+}
+
+// Roots returns all roots, oldest first
+func (l *Library) Roots() []LibraryRoot {
+	l.rootsMu.RLock()
+	defer l.rootsMu.RUnlock()
+	roots := make([]LibraryRoot, 0, len(l.roots))
+	for _, root := range l.roots {
+		roots = append(roots, root.LibraryRoot)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if roots[i].CreatedAt.Equal(roots[j].CreatedAt) {
+			return roots[i].ID < roots[j].ID
+		}
+		return roots[i].CreatedAt.Before(roots[j].CreatedAt)
+	})
+	return roots
+}
+
+// Root returns a root by ID
+func (l *Library) Root(id string) (LibraryRoot, bool) {
+	l.rootsMu.RLock()
+	defer l.rootsMu.RUnlock()
+	root, ok := l.roots[id]
+	if !ok {
+		return LibraryRoot{}, false
+	}
+	return root.LibraryRoot, true
+}
+
+// rootFor returns the innermost root containing path
+func (l *Library) rootFor(path string) (*libraryRoot, bool) {
+	l.rootsMu.RLock()
+	defer l.rootsMu.RUnlock()
+	return l.rootForLocked(path)
+}
+
+func (l *Library) rootForLocked(path string) (*libraryRoot, bool) {
+	var best *libraryRoot
+	for _, root := range l.roots {
+		if pathWithin(root.abs, path) && (best == nil || len(root.abs) > len(best.abs)) {
+			best = root
+		}
+	}
+	return best, best != nil
+}
+
+// scope snapshots the settings of a root for a scan
+func (l *Library) scope(root *libraryRoot) rootScope {
+	l.rootsMu.RLock()
+	defer l.rootsMu.RUnlock()
+	scope := rootScope{id: root.ID, path: root.abs, allowed: root.allowed, mu: &root.scanMu}
+	for _, other := range l.roots {
+		if other != root && other.abs != root.abs && pathWithin(root.abs, other.abs) {
+			scope.nested = append(scope.nested, other.abs)
+		}
+	}
+	return scope
+}
+
+// AddRoot adds a root at runtime. The path must be an existing directory that is not
+// a root yet; roots inside other roots are allowed and scanned on their own.
+func (l *Library) AddRoot(root LibraryRoot) (LibraryRoot, error) {
+	if l.store == nil || storeReadOnly(l.store) {
+		return LibraryRoot{}, fmt.Errorf("adding roots requires a writable database")
+	}
+	path := strings.TrimSpace(root.Path)
+	if path == "" {
+		return LibraryRoot{}, ErrInvalidScanPath
+	}
+	abs := absPath(path)
+	info, err := os.Stat(abs)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return LibraryRoot{}, ErrScanPathNotFound
+		}
+		return LibraryRoot{}, err
+	}
+	if !info.IsDir() {
+		return LibraryRoot{}, ErrInvalidScanPath
+	}
+
+	l.rootsMu.Lock()
+	defer l.rootsMu.Unlock()
+	for _, existing := range l.roots {
+		if existing.abs == abs {
+			return LibraryRoot{}, ErrRootExists
+		}
+	}
+
+	stored, err := l.store.AddRoot(abs, root.Type)
+	if err != nil {
+		return LibraryRoot{}, err
+	}
+	root.ID, root.Path, root.Type, root.CreatedAt = stored.ID, stored.Path, stored.Type, stored.CreatedAt
+	root.UnavailableSince = time.Time{}
+	if _, err := l.store.UpdateRoot(root); err != nil {
+		return LibraryRoot{}, err
+	}
+
+	entry := l.newLibraryRoot(root)
+	l.roots[root.ID] = entry
+	l.scheduleLocked(entry)
+	return entry.LibraryRoot, nil
+}
+
+// UpdateRoot changes type, exclude patterns, scan interval and extensions of a root
+func (l *Library) UpdateRoot(root LibraryRoot) (LibraryRoot, error) {
+	l.rootsMu.Lock()
+	defer l.rootsMu.Unlock()
+	entry, ok := l.roots[root.ID]
+	if !ok {
+		return LibraryRoot{}, ErrRootNotFound
+	}
+	root.Path, root.CreatedAt, root.UnavailableSince = entry.Path, entry.CreatedAt, entry.UnavailableSince
+	if l.store != nil {
+		if found, err := l.store.UpdateRoot(root); err != nil {
+			return LibraryRoot{}, err
+		} else if !found {
+			return LibraryRoot{}, ErrRootNotFound
+		}
+	}
+
+	updated := l.newLibraryRoot(root)
+	entry.LibraryRoot = updated.LibraryRoot
+	entry.allowed = updated.allowed
+	l.unscheduleLocked(entry)
+	l.scheduleLocked(entry)
+	return entry.LibraryRoot, nil
+}
+
+// normalizeRoot applies the defaults the store applies
+func normalizeRoot(root LibraryRoot) LibraryRoot {
+	if strings.TrimSpace(root.Type) == "" {
+		root.Type = "library"
+	}
+	root.Exclude = cleanList(root.Exclude)
+	root.Extensions = cleanList(root.Extensions)
+	root.Available = root.UnavailableSince.IsZero()
+	return root
+}
+
+// RemoveRoot cancels the scans of a root, stops its schedule and drops its items
+// with their metadata. Items inside another root are kept for that root's scans.
+func (l *Library) RemoveRoot(id string) error {
+	if id == l.rootID {
+		return ErrPrimaryRoot
+	}
+
+	l.rootsMu.Lock()
+	entry, ok := l.roots[id]
+	if !ok {
+		l.rootsMu.Unlock()
+		return ErrRootNotFound
+	}
+	l.unscheduleLocked(entry)
+	delete(l.roots, id)
+	l.rootsMu.Unlock()
+
+	l.cancelRootScans(id)
+	// Wait for a scan that was about to start
+	entry.scanMu.Lock()
+	defer entry.scanMu.Unlock()
+
+	_, covered := l.rootFor(entry.abs)
+	var errs []error
+	if !covered {
+		var ids []string
+		l.mu.RLock()
+		for itemID, item := range l.items {
+			if pathWithin(entry.abs, absPath(item.VideoPath)) {
+				ids = append(ids, itemID)
+			}
+		}
+		l.mu.RUnlock()
+		if err := l.purgeItems(ids); err != nil {
+			return err
+		}
+		if l.store != nil && !storeReadOnly(l.store) {
+			if err := l.store.ReplaceIgnoredPaths(entry.abs, nil); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if l.store != nil {
+		if err := l.store.RemoveRoot(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeItems deletes items with their metadata and regroups the affected shows
+func (l *Library) purgeItems(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var errs []error
+	if l.store != nil && !storeReadOnly(l.store) {
+		titles, err := l.store.ShowTitlesForItems(ids)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if err := l.store.DeleteItems(ids); err != nil {
+			return err
+		}
+		if err := l.store.AutoGroupShows(titles); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	l.mu.Lock()
+	for _, id := range ids {
+		delete(l.items, id)
+	}
+	l.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// cancelRootScans stops the running scans of a root
+func (l *Library) cancelRootScans(rootID string) {
+	l.jobsMu.Lock()
+	var jobs []*scanJob
+	for _, job := range l.jobs {
+		if job.run.RootID == rootID {
+			jobs = append(jobs, job)
+		}
+	}
+	l.jobsMu.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+		<-job.done
+	}
+}
+
+// StartSchedules starts the periodic scans of every root. Roots without their own
+// interval use defaultInterval; zero disables their periodic scans.
+func (l *Library) StartSchedules(defaultInterval time.Duration) {
+	l.rootsMu.Lock()
+	defer l.rootsMu.Unlock()
+	l.scheduling = true
+	l.scanInterval = defaultInterval
+	for _, root := range l.roots {
+		l.scheduleLocked(root)
+	}
+}
+
+// StopSchedules stops all periodic scans and waits for the schedulers to exit
+func (l *Library) StopSchedules() {
+	l.rootsMu.Lock()
+	l.scheduling = false
+	for _, root := range l.roots {
+		l.unscheduleLocked(root)
+	}
+	l.rootsMu.Unlock()
+	l.scheduleWg.Wait()
+}
+
+func (l *Library) scheduleLocked(root *libraryRoot) {
+	if !l.scheduling || root.stop != nil {
+		return
+	}
+	interval := l.scanInterval
+	if root.ScanIntervalSeconds != 0 {
+		interval = time.Duration(root.ScanIntervalSeconds) * time.Second
+	}
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	root.stop = stop
+	id := root.ID
+	l.scheduleWg.Add(1)
+	go func() {
+		defer l.scheduleWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Unavailable roots are logged once when they go offline
+				if err := l.ScanRoot(id); err != nil && !errors.Is(err, ErrRootNotFound) && !errors.Is(err, ErrRootUnavailable) {
+					log.Printf("scan failed (periodic) root=%s: %v", id, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (l *Library) unscheduleLocked(root *libraryRoot) {
+	if root.stop != nil {
+		close(root.stop)
+		root.stop = nil
+	}
+}
@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestLibraryManagesMultipleRoots(t *testing.T) {
+	store := newTestStore(t)
+	primary := t.TempDir()
+	other := t.TempDir()
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	write(filepath.Join(primary, "Movie.mkv"))
+	write(filepath.Join(other, "Clip.webm"))
+	write(filepath.Join(other, "Clip.nfo"))
+	write(filepath.Join(other, "Film.mkv"))
+
+	lib, err := server.NewLibrary(primary, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	root, err := lib.AddRoot(server.LibraryRoot{Path: other, Type: "clips", Extensions: []string{"webm"}, ScanIntervalSeconds: -1})
+	if err != nil {
+		t.Fatalf("AddRoot() error = %v", err)
+	}
+	if _, err := lib.AddRoot(server.LibraryRoot{Path: other}); !errors.Is(err, server.ErrRootExists) {
+		t.Fatalf("AddRoot() duplicate error = %v; want ErrRootExists", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	items, err := store.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	var names []string
+	var clipID string
+	for _, item := range items {
+		names = append(names, filepath.Base(item.VideoPath))
+		if filepath.Base(item.VideoPath) == "Clip.webm" {
+			clipID = item.ID
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "Clip.webm,Movie.mkv" {
+		t.Fatalf("indexed = %v; want Clip.webm and Movie.mkv", names)
+	}
+	stored, err := store.ListRoots()
+	if err != nil || len(stored) != 2 || stored[1].ScanIntervalSeconds != -1 || strings.Join(stored[1].Extensions, ",") != "webm" {
+		t.Fatalf("ListRoots() = %+v, %v", stored, err)
+	}
+
+	// A scan of one root leaves the items of the others alone
+	if err := lib.ScanRoot(root.ID); err != nil {
+		t.Fatalf("ScanRoot() error = %v", err)
+	}
+	if _, ok := lib.Get(clipID); !ok || len(lib.All()) != 2 {
+		t.Fatalf("All() after ScanRoot = %d items; want 2", len(lib.All()))
+	}
+
+	if err := lib.RemoveRoot(stored[0].ID); !errors.Is(err, server.ErrPrimaryRoot) {
+		t.Fatalf("RemoveRoot(primary) error = %v; want ErrPrimaryRoot", err)
+	}
+	if err := lib.RemoveRoot(root.ID); err != nil {
+		t.Fatalf("RemoveRoot() error = %v", err)
+	}
+	if _, ok, err := store.GetByID(clipID); err != nil || ok {
+		t.Fatalf("GetByID() after RemoveRoot = %v, %v; want removed", ok, err)
+	}
+	if _, ok, err := store.GetNFO(clipID); err != nil || ok {
+		t.Fatalf("GetNFO() after RemoveRoot = %v, %v; want removed", ok, err)
+	}
+	if len(lib.All()) != 1 || len(lib.Roots()) != 1 {
+		t.Fatalf("after RemoveRoot: %d items, %d roots; want 1 and 1", len(lib.All()), len(lib.Roots()))
+	}
+	if _, err := lib.StartRootScan(root.ID); !errors.Is(err, server.ErrRootNotFound) {
+		t.Fatalf("StartRootScan() error = %v; want ErrRootNotFound", err)
+	}
+}
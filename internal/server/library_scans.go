@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const scanListDefaultLimit = 50
+
+// writeScanStarted answers a scan request with the run of the started job
+func writeScanStarted(w http.ResponseWriter, run ScanRun) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(run)
+}
+
+// handleLibraryScans lists scan runs, newest first. Running scans carry live progress.
+// Restricted users only see the runs of their roots.
+func (s *Server) handleLibraryScans(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w)
+		return
+	}
+
+	limit, offset, ok := parseLimitOffset(r)
+	if !ok {
+		s.writeError(w, errBadRequest, http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = scanListDefaultLimit
+	}
+
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	var runs []ScanRun
+	var total int
+	if s.lib.store == nil || s.readOnly {
+		// Runs are not persisted, only the ones in memory are known
+		runs = filterScanRuns(access, s.lib.ScanJobs())
+		total = len(runs)
+		runs = applyLimitOffset(runs, limit, offset)
+	} else {
+		queryLimit, queryOffset := access.pageLimit(limit, offset)
+		runs, total, err = s.lib.store.ListScanRuns(queryLimit, queryOffset)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		for i, run := range runs {
+			if live, ok := s.lib.ScanJob(run.ID); ok {
+				runs[i] = live
+			}
+		}
+		if access.restricted() {
+			runs = filterScanRuns(access, runs)
+			total = len(runs)
+			runs = paginate(access, runs, limit, offset)
+		}
+	}
+
+	writeJSON(w, r, map[string]any{
+		"scans":  runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// handleLibraryScanDetail returns a scan run (GET) or cancels a running scan (DELETE)
+func (s *Server) handleLibraryScanDetail(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, DELETE, OPTIONS") {
+		return
+	}
+
+	scanID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/library/scans/"), "/")
+	if scanID == "" || strings.Contains(scanID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		access, err := s.libraryAccess(r)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		run, ok, err := s.scanRun(scanID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if !ok || !access.allowsScanRun(run) {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, run)
+
+	case http.MethodDelete:
+		if s.lib.CancelScan(scanID) {
+			s.audit(r, "library.scan.cancel", scanID, AuditSuccess)
+			run, _, err := s.scanRun(scanID)
+			if err != nil {
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, r, run)
+			return
+		}
+
+		_, ok, err := s.scanRun(scanID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, "scan is not running", http.StatusConflict)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
+func filterScanRuns(access *libraryAccess, runs []ScanRun) []ScanRun {
+	if !access.restricted() {
+		return runs
+	}
+	out := make([]ScanRun, 0, len(runs))
+	for _, run := range runs {
+		if access.allowsScanRun(run) {
+			out = append(out, run)
+		}
+	}
+	return out
+}
+
+// scanRun looks a scan up in memory first, so running scans report live progress
+func (s *Server) scanRun(id string) (ScanRun, bool, error) {
+	if run, ok := s.lib.ScanJob(id); ok {
+		return run, true, nil
+	}
+	if s.lib.store == nil {
+		return ScanRun{}, false, nil
+	}
+	return s.lib.store.GetScanRun(id)
+}
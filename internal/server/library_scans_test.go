@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestScanRunsFollowRootAccess(t *testing.T) {
+	ts := newTestServer(t)
+	other := t.TempDir()
+	for _, path := range []string{filepath.Join(ts.root, "Movie.mkv"), filepath.Join(other, "Clip.mkv")} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	var added server.LibraryRoot
+	decode(t, ts.do(http.MethodPost, "/library/roots", ts.adminToken, `{"path":"`+other+`"}`), http.StatusOK, &added)
+	ts.waitForScans(t)
+	var primary server.ScanRun
+	var roots []server.LibraryRoot
+	decode(t, ts.do(http.MethodGet, "/library/roots", ts.adminToken, ""), http.StatusOK, &roots)
+	for _, root := range roots {
+		if root.ID != added.ID {
+			decode(t, ts.do(http.MethodPost, "/library/roots/"+root.ID+"/scan", ts.adminToken, ""), http.StatusAccepted, &primary)
+		}
+	}
+	ts.waitForScans(t)
+	if err := ts.store.SetUserRootAccess(ts.userID, server.RootAccess{RootIDs: []string{primary.RootID}}); err != nil {
+		t.Fatalf("SetUserRootAccess() error = %v", err)
+	}
+
+	var list struct {
+		Scans []server.ScanRun `json:"scans"`
+		Total int              `json:"total"`
+	}
+	decode(t, ts.do(http.MethodGet, "/library/scans", ts.adminToken, ""), http.StatusOK, &list)
+	if list.Total != 2 {
+		t.Fatalf("GET /library/scans as admin = %+v; want both runs", list)
+	}
+	var hidden string
+	for _, run := range list.Scans {
+		if run.RootID == added.ID {
+			hidden = run.ID
+		}
+	}
+
+	decode(t, ts.do(http.MethodGet, "/library/scans?limit=1", ts.userToken, ""), http.StatusOK, &list)
+	if list.Total != 1 || len(list.Scans) != 1 || list.Scans[0].ID != primary.ID {
+		t.Fatalf("GET /library/scans as restricted user = %+v; want only %s", list, primary.ID)
+	}
+	if w := ts.do(http.MethodGet, "/library/scans/"+hidden, ts.userToken, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET /library/scans/%s as restricted user = %d; want 404", hidden, w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/library/scans/"+hidden, ts.adminToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /library/scans/%s as admin = %d; want 200", hidden, w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dirIndex caches directory listings during a scan. Sidecar lookups probe many
+// candidate names per video; with the listing only existing files need a stat.
+type dirIndex struct {
+	mu   sync.Mutex
+	dirs map[string]map[string]fs.DirEntry
+}
+
+func newDirIndex() *dirIndex {
+	return &dirIndex{dirs: map[string]map[string]fs.DirEntry{}}
+}
+
+// stat returns the info of a regular file, following symlinks like os.Stat
+func (d *dirIndex) stat(path string) (fs.FileInfo, bool) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+
+	d.mu.Lock()
+	entries, ok := d.dirs[dir]
+	if !ok {
+		list, err := os.ReadDir(dir)
+		entries = make(map[string]fs.DirEntry, len(list))
+		if err == nil {
+			for _, entry := range list {
+				entries[entry.Name()] = entry
+			}
+		}
+		d.dirs[dir] = entries
+	}
+	d.mu.Unlock()
+
+	entry, ok := entries[name]
+	if !ok || entry.IsDir() {
+		return nil, false
+	}
+	var info fs.FileInfo
+	var err error
+	if entry.Type()&fs.ModeSymlink != 0 {
+		info, err = os.Stat(path)
+	} else {
+		info, err = entry.Info()
+	}
+	if err != nil || info.IsDir() {
+		return nil, false
+	}
+	return info, true
+}
+
+func (d *dirIndex) exists(path string) bool {
+	_, ok := d.stat(path)
+	return ok
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// collectMediaFiles records the video and its NFO, show NFO, poster and subtitle
+func collectMediaFiles(videoPath string, video fs.FileInfo, index *dirIndex) []MediaFile {
+	files := []MediaFile{{
+		Kind:     MediaFileVideo,
+		Path:     videoPath,
+		Size:     video.Size(),
+		Modified: video.ModTime(),
+	}}
+	return append(files, sidecarFiles(videoPath, index)...)
+}
+
+// sidecarFiles returns the NFO, show NFO, poster and subtitle named after a video
+func sidecarFiles(videoPath string, index *dirIndex) []MediaFile {
+	var files []MediaFile
+	add := func(kind, path string) {
+		if path == "" {
+			return
+		}
+		if info, ok := index.stat(path); ok {
+			files = append(files, MediaFile{Kind: kind, Path: path, Size: info.Size(), Modified: info.ModTime()})
+		}
+	}
+	itemNFO, showNFO := findNFOPaths(videoPath, index.exists)
+	add(MediaFileNFO, itemNFO)
+	add(MediaFileShowNFO, showNFO)
+	poster, _ := findPoster(videoPath, index.exists)
+	add(MediaFilePoster, poster)
+	subtitle, _ := findSubtitle(videoPath, index.exists)
+	add(MediaFileSubtitle, subtitle)
+	return files
+}
+
+// mediaFilePath returns the path of the file of the given kind, if tracked
+func mediaFilePath(files []MediaFile, kind string) string {
+	for _, file := range files {
+		if file.Kind == kind {
+			return file.Path
+		}
+	}
+	return ""
+}
+
+// mediaFilesEqual compares at the precision the store keeps: paths, sizes and
+// modification times in seconds
+func mediaFilesEqual(a, b []MediaFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byKind := make(map[string]MediaFile, len(b))
+	for _, file := range b {
+		byKind[file.Kind] = file
+	}
+	for _, file := range a {
+		other, ok := byKind[file.Kind]
+		if !ok || other.Path != file.Path || other.Size != file.Size ||
+			other.Modified.Unix() != file.Modified.Unix() {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"path/filepath"
 	"strings"
 )
 
@@ -19,11 +22,21 @@ func (s *Server) handleLibraryRoots(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		// List all library roots
-		roots, err := s.lib.store.ListRoots()
+		roots := s.lib.Roots()
+		access, err := s.libraryAccess(r)
 		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
+		if access.restricted() {
+			visible := make([]LibraryRoot, 0, len(roots))
+			for _, root := range roots {
+				if access.allowsRoot(root) {
+					visible = append(visible, root)
+				}
+			}
+			roots = visible
+		}
 		writeJSON(w, r, roots)
 
 	case http.MethodPost:
@@ -33,8 +46,11 @@ func (s *Server) handleLibraryRoots(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var payload struct {
-			Path string `json:"path"`
-			Type string `json:"type"` // "movies", "tv", "music", "photos", etc.
+			Path                string   `json:"path"`
+			Type                string   `json:"type"` // "movies", "tv", "music", "photos", etc.
+			Exclude             []string `json:"exclude"`
+			ScanIntervalSeconds int64    `json:"scanIntervalSeconds"`
+			Extensions          []string `json:"extensions"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			s.writeError(w, "bad request", http.StatusBadRequest)
@@ -45,24 +61,45 @@ func (s *Server) handleLibraryRoots(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, "path is required", http.StatusBadRequest)
 			return
 		}
+		if !validIgnorePatterns(payload.Exclude) {
+			s.writeError(w, "invalid exclude pattern", http.StatusBadRequest)
+			return
+		}
 		if strings.TrimSpace(payload.Type) == "" {
 			payload.Type = "library" // Default type
 		}
 
-		// Add the root
-		root, err := s.lib.store.AddRoot(payload.Path, payload.Type)
+		// Add the root; it gets its own schedule right away
+		root, err := s.lib.AddRoot(LibraryRoot{
+			Path:                payload.Path,
+			Type:                payload.Type,
+			Exclude:             payload.Exclude,
+			ScanIntervalSeconds: payload.ScanIntervalSeconds,
+			Extensions:          payload.Extensions,
+		})
 		if err != nil {
-			s.writeError(w, errInternal, http.StatusInternalServerError)
+			s.audit(r, "library.root.add", payload.Path, AuditFailure)
+			switch {
+			case errors.Is(err, ErrRootExists):
+				s.writeError(w, "root already exists", http.StatusConflict)
+			case errors.Is(err, ErrInvalidScanPath), errors.Is(err, ErrScanPathNotFound):
+				s.writeError(w, "path must be an existing directory", http.StatusBadRequest)
+			default:
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+			}
 			return
 		}
 
-		// Trigger scan for the new root
-		go func() {
-			if err := s.lib.ScanPath(payload.Path); err != nil {
-				// Log error but don't fail the request
-				// The root was added successfully
-			}
-		}()
+		s.audit(r, "library.root.add", root.ID, AuditSuccess)
+
+		if s.watcher != nil {
+			s.watcher.addRoot(root.Path)
+		}
+
+		// Scan the new root in the background; failures are recorded in the scan run
+		if _, err := s.lib.StartRootScan(root.ID); err != nil {
+			log.Printf("level=warn msg=\"failed to start scan of new root\" root=%s err=%v", root.ID, err)
+		}
 
 		writeJSON(w, r, root)
 
@@ -85,11 +122,26 @@ func (s *Server) handleLibraryRoots(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := s.lib.store.RemoveRoot(payload.ID); err != nil {
-			s.writeError(w, errInternal, http.StatusInternalServerError)
+		// Running scans of the root are canceled, its items and metadata dropped
+		root, _ := s.lib.Root(payload.ID)
+		err := s.lib.RemoveRoot(payload.ID)
+		s.audit(r, "library.root.remove", payload.ID, auditOutcome(err))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRootNotFound):
+				s.writeError(w, "root not found", http.StatusNotFound)
+			case errors.Is(err, ErrPrimaryRoot):
+				s.writeError(w, "primary root cannot be removed", http.StatusConflict)
+			default:
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+			}
 			return
 		}
 
+		if s.watcher != nil {
+			s.watcher.removeRoot(root.Path)
+		}
+
 		writeJSON(w, r, map[string]string{"status": "ok"})
 
 	default:
@@ -97,6 +149,218 @@ func (s *Server) handleLibraryRoots(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleLibraryRootRoutes dispatches the routes below /library/roots/{id}
+func (s *Server) handleLibraryRootRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/library/roots/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		s.handleLibraryRoot(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "ignored":
+		s.handleLibraryRootIgnored(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "offline":
+		s.handleLibraryRootOffline(w, r, parts[0])
+	default:
+		s.handleLibraryRootScan(w, r)
+	}
+}
+
+// handleLibraryRoot returns a root (GET) or updates its type, exclude patterns, scan
+// interval and extensions (PATCH)
+func (s *Server) handleLibraryRoot(w http.ResponseWriter, r *http.Request, rootID string) {
+	if s.handleOptions(w, r, "GET, PATCH, OPTIONS") {
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	root, ok := s.lib.Root(rootID)
+	if !ok {
+		s.writeError(w, "root not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		access, err := s.libraryAccess(r)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if !access.allowsRoot(root) {
+			s.writeError(w, "root not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, root)
+
+	case http.MethodPatch:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			Type                *string   `json:"type"`
+			Exclude             *[]string `json:"exclude"`
+			ScanIntervalSeconds *int64    `json:"scanIntervalSeconds"`
+			Extensions          *[]string `json:"extensions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if payload.Type != nil {
+			root.Type = *payload.Type
+		}
+		if payload.Exclude != nil {
+			if !validIgnorePatterns(*payload.Exclude) {
+				s.writeError(w, "invalid exclude pattern", http.StatusBadRequest)
+				return
+			}
+			root.Exclude = *payload.Exclude
+		}
+		if payload.ScanIntervalSeconds != nil {
+			root.ScanIntervalSeconds = *payload.ScanIntervalSeconds
+		}
+		if payload.Extensions != nil {
+			root.Extensions = *payload.Extensions
+		}
+
+		updated, err := s.lib.UpdateRoot(root)
+		s.audit(r, "library.root.update", rootID, auditOutcome(err))
+		if err != nil {
+			if errors.Is(err, ErrRootNotFound) {
+				s.writeError(w, "root not found", http.StatusNotFound)
+				return
+			}
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, updated)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
+// handleLibraryRootIgnored lists the paths the last scans of a root skipped and the
+// rule that matched each of them
+func (s *Server) handleLibraryRootIgnored(w http.ResponseWriter, r *http.Request, rootID string) {
+	if s.handleOptions(w, r, "GET, OPTIONS") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.methodNotAllowed(w)
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	limit, offset, ok := parseLimitOffset(r)
+	if !ok {
+		s.writeError(w, errBadRequest, http.StatusBadRequest)
+		return
+	}
+
+	root, ok := s.lib.Root(rootID)
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	if !ok || !access.allowsRoot(root) {
+		s.writeError(w, "root not found", http.StatusNotFound)
+		return
+	}
+
+	rootPath, err := filepath.Abs(root.Path)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	entries, err := s.lib.store.ListIgnoredPaths(rootPath)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, map[string]any{
+		"rootId":  root.ID,
+		"path":    root.Path,
+		"exclude": root.Exclude,
+		"total":   len(entries),
+		"ignored": applyLimitOffset(entries, limit, offset),
+	})
+}
+
+// handleLibraryRootOffline lists the items of an unavailable root that are flagged
+// offline (GET) or deletes them once an admin confirms the root is gone (DELETE)
+func (s *Server) handleLibraryRootOffline(w http.ResponseWriter, r *http.Request, rootID string) {
+	if s.handleOptions(w, r, "GET, DELETE, OPTIONS") {
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit, offset, ok := parseLimitOffset(r)
+		if !ok {
+			s.writeError(w, errBadRequest, http.StatusBadRequest)
+			return
+		}
+		root, ok := s.lib.Root(rootID)
+		access, err := s.libraryAccess(r)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if !ok || !access.allowsRoot(root) {
+			s.writeError(w, "root not found", http.StatusNotFound)
+			return
+		}
+		items, err := s.lib.OfflineItems(rootID)
+		if err != nil {
+			s.writeError(w, "root not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, r, map[string]any{
+			"rootId":           root.ID,
+			"path":             root.Path,
+			"available":        root.Available,
+			"unavailableSince": root.UnavailableSince,
+			"total":            len(items),
+			"items":            applyLimitOffset(items, limit, offset),
+		})
+
+	case http.MethodDelete:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		removed, err := s.lib.PurgeOfflineItems(rootID)
+		s.audit(r, "library.root.offline.purge", rootID, auditOutcome(err))
+		if err != nil {
+			if errors.Is(err, ErrRootNotFound) {
+				s.writeError(w, "root not found", http.StatusNotFound)
+				return
+			}
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, map[string]int{"removed": removed})
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
 // handleLibraryRootScan triggers a scan for a specific root
 func (s *Server) handleLibraryRootScan(w http.ResponseWriter, r *http.Request) {
 	if s.handleOptions(w, r, "POST, OPTIONS") {
@@ -124,22 +388,7 @@ func (s *Server) handleLibraryRootScan(w http.ResponseWriter, r *http.Request) {
 
 	rootID := parts[0]
 
-	// Get the root to find its path
-	roots, err := s.lib.store.ListRoots()
-	if err != nil {
-		s.writeError(w, errInternal, http.StatusInternalServerError)
-		return
-	}
-
-	var rootPath string
-	for _, root := range roots {
-		if root.ID == rootID {
-			rootPath = root.Path
-			break
-		}
-	}
-
-	if rootPath == "" {
+	if _, ok := s.lib.Root(rootID); !ok {
 		s.writeError(w, "root not found", http.StatusNotFound)
 		return
 	}
@@ -151,12 +400,18 @@ func (s *Server) handleLibraryRootScan(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger scan
-	if err := s.lib.ScanPath(rootPath); err != nil {
+	run, err := s.lib.StartRootScan(rootID)
+	s.audit(r, "library.root.scan", rootID, auditOutcome(err))
+	if err != nil {
+		if errors.Is(err, ErrRootNotFound) {
+			s.writeError(w, "root not found", http.StatusNotFound)
+			return
+		}
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
 
-	writeJSON(w, r, map[string]string{"status": "ok"})
+	writeScanStarted(w, run)
 }
 
 // handleLibraryByType returns items filtered by NFO type (movie, tvshow) or grouped TV shows
@@ -188,6 +443,13 @@ func (s *Server) handleLibraryByType(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	queryLimit, queryOffset := access.pageLimit(limit, offset)
+
 	// Map client library types to NFO types
 	var nfoType string
 	switch strings.ToLower(libraryType) {
@@ -195,12 +457,12 @@ func (s *Server) handleLibraryByType(w http.ResponseWriter, r *http.Request) {
 		nfoType = "movie"
 	case "tvshow", "tvshows", "tv", "series", "serien":
 		// For TV shows, return grouped shows instead of individual episodes
-		shows, err := s.lib.store.GetTVShowsGrouped(limit, offset, sortBy, query)
+		shows, err := s.lib.store.GetTVShowsGrouped(queryLimit, queryOffset, sortBy, query)
 		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
-		writeJSON(w, r, shows)
+		writeJSON(w, r, paginate(access, access.filterShowGroups(shows), limit, offset))
 		return
 	case "episode", "episodes":
 		nfoType = "episode"
@@ -210,11 +472,11 @@ func (s *Server) handleLibraryByType(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get items filtered by NFO type
-	items, err := s.lib.store.GetItemsByNFOType(nfoType, limit, offset, sortBy, query)
+	items, err := s.lib.store.GetItemsByNFOType(nfoType, queryLimit, queryOffset, sortBy, query)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
 
-	writeJSON(w, r, items)
+	writeJSON(w, r, paginate(access, access.filterItems(items), limit, offset))
 }
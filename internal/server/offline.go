@@ -0,0 +1,200 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// ErrRootUnavailable is returned by scans of a root whose path is missing or empty
+// while the library still holds items of it, e.g. an unmounted disk or share. The
+// items are flagged offline instead of being deleted.
+var ErrRootUnavailable = errors.New("root unavailable")
+
+// SetOfflineGrace sets how long the offline items of an unavailable root are kept.
+// Zero keeps them until PurgeOfflineItems is called.
+func (l *Library) SetOfflineGrace(grace time.Duration) {
+	l.mu.Lock()
+	l.offlineGrace = max(grace, 0)
+	l.mu.Unlock()
+}
+
+// rootReachable reports whether a root directory exists and has at least one entry.
+// A mount point of an unmounted disk exists but is empty.
+func rootReachable(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	return err == nil
+}
+
+// scopeItems returns the IDs of the items belonging to a root and the subset of them
+// flagged offline
+func (l *Library) scopeItems(scope rootScope) (ids, offline []string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for id, item := range l.items {
+		if scope.owns(absPath(item.VideoPath)) {
+			ids = append(ids, id)
+			if !item.OfflineSince.IsZero() {
+				offline = append(offline, id)
+			}
+		}
+	}
+	return ids, offline
+}
+
+// checkRootAvailable runs before every scan. A missing or empty root with items is
+// marked unavailable and its items are flagged offline; they are only deleted once
+// the grace period has passed. A reachable root is marked available again.
+func (l *Library) checkRootAvailable(job *scanJob) error {
+	scope := job.scope
+	ids, offline := l.scopeItems(scope)
+	canWrite := l.store != nil && !storeReadOnly(l.store)
+
+	if len(ids) == 0 || rootReachable(scope.path) {
+		var errs []error
+		if l.setRootUnavailableSince(scope.id, time.Time{}) {
+			log.Printf("level=info msg=\"root available again\" root=%s path=%s", scope.id, scope.path)
+		}
+		if len(offline) > 0 {
+			if canWrite {
+				if err := l.store.ClearOfflineItems(offline); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			l.setItemsOffline(offline, time.Time{})
+		}
+		return errors.Join(errs...)
+	}
+
+	since := l.rootUnavailableSince(scope.id)
+	if since.IsZero() {
+		since = time.Now()
+		l.setRootUnavailableSince(scope.id, since)
+		log.Printf("level=warn msg=\"root unavailable, items flagged offline\" root=%s path=%s items=%d", scope.id, scope.path, len(ids))
+	}
+	errs := []error{fmt.Errorf("%w: %s", ErrRootUnavailable, scope.path)}
+	if canWrite {
+		if err := l.store.MarkItemsOffline(ids, since); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	l.setItemsOffline(ids, since)
+
+	l.mu.RLock()
+	grace := l.offlineGrace
+	l.mu.RUnlock()
+	if grace > 0 && time.Since(since) >= grace {
+		log.Printf("level=warn msg=\"removing offline items after grace period\" root=%s path=%s items=%d", scope.id, scope.path, len(ids))
+		if err := l.purgeItems(ids); err != nil {
+			errs = append(errs, err)
+		}
+		job.update(func(progress *ScanProgress) {
+			progress.Removed = len(ids)
+		})
+	}
+	return errors.Join(errs...)
+}
+
+func (l *Library) rootUnavailableSince(id string) time.Time {
+	l.rootsMu.RLock()
+	defer l.rootsMu.RUnlock()
+	if root, ok := l.roots[id]; ok {
+		return root.UnavailableSince
+	}
+	return time.Time{}
+}
+
+// setRootUnavailableSince updates the availability of a root and reports whether it
+// changed
+func (l *Library) setRootUnavailableSince(id string, since time.Time) bool {
+	l.rootsMu.Lock()
+	root, ok := l.roots[id]
+	if !ok || root.UnavailableSince.Equal(since) {
+		l.rootsMu.Unlock()
+		return false
+	}
+	root.UnavailableSince = since
+	root.Available = since.IsZero()
+	l.rootsMu.Unlock()
+
+	if id != "" && l.store != nil && !storeReadOnly(l.store) {
+		if err := l.store.SetRootUnavailableSince(id, since); err != nil {
+			log.Printf("level=warn msg=\"failed to store root availability\" root=%s err=%v", id, err)
+		}
+	}
+	return true
+}
+
+// setItemsOffline flags items in memory; items flagged before keep their time
+func (l *Library) setItemsOffline(ids []string, since time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		item, ok := l.items[id]
+		if !ok || (!since.IsZero() && !item.OfflineSince.IsZero()) {
+			continue
+		}
+		item.OfflineSince = since
+		l.items[id] = item
+	}
+}
+
+// OfflineItems returns the items of a root that are flagged offline, sorted by path
+func (l *Library) OfflineItems(rootID string) ([]MediaItem, error) {
+	l.rootsMu.RLock()
+	root, ok := l.roots[rootID]
+	l.rootsMu.RUnlock()
+	if !ok {
+		return nil, ErrRootNotFound
+	}
+	scope := l.scope(root)
+
+	l.mu.RLock()
+	items := []MediaItem{}
+	for _, item := range l.items {
+		if !item.OfflineSince.IsZero() && scope.owns(absPath(item.VideoPath)) {
+			items = append(items, item)
+		}
+	}
+	l.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].VideoPath < items[j].VideoPath
+	})
+	return items, nil
+}
+
+// PurgeOfflineItems deletes the offline items of a root right away, e.g. when an
+// admin confirms that a disk was removed for good. It returns the number of
+// deleted items.
+func (l *Library) PurgeOfflineItems(rootID string) (int, error) {
+	l.rootsMu.RLock()
+	root, ok := l.roots[rootID]
+	l.rootsMu.RUnlock()
+	if !ok {
+		return 0, ErrRootNotFound
+	}
+
+	// A running scan of the root could flag or clear items meanwhile
+	root.scanMu.Lock()
+	defer root.scanMu.Unlock()
+	items, err := l.OfflineItems(rootID)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	if err := l.purgeItems(ids); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestUnavailableRootKeepsItemsOffline(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	video := filepath.Join(root, "Movie.mkv")
+	write := func() {
+		t.Helper()
+		if err := os.WriteFile(video, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "Movie.nfo"), []byte("<movie><title>Movie</title></movie>"), 0o644); err != nil {
+			t.Fatalf("write nfo: %v", err)
+		}
+	}
+	unmount := func() {
+		t.Helper()
+		entries, _ := os.ReadDir(root)
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+				t.Fatalf("remove: %v", err)
+			}
+		}
+	}
+	write()
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	id, ok, err := store.GetIDByPath(video)
+	if err != nil || !ok {
+		t.Fatalf("GetIDByPath() = %v, %v", ok, err)
+	}
+	rootID := lib.Roots()[0].ID
+
+	// An empty mount point keeps the items and their metadata
+	unmount()
+	if err := lib.Scan(); !errors.Is(err, server.ErrRootUnavailable) {
+		t.Fatalf("Scan() of empty root error = %v; want ErrRootUnavailable", err)
+	}
+	item, ok, err := store.GetByID(id)
+	if err != nil || !ok || item.OfflineSince.IsZero() {
+		t.Fatalf("GetByID() = %+v, %v, %v; want offline item", item, ok, err)
+	}
+	if _, ok, err := store.GetNFO(id); err != nil || !ok {
+		t.Fatalf("GetNFO() = %v, %v; want kept", ok, err)
+	}
+	if roots, err := store.ListRoots(); err != nil || roots[0].Available || roots[0].UnavailableSince.IsZero() {
+		t.Fatalf("ListRoots() = %+v, %v; want unavailable root", roots, err)
+	}
+	if offline, err := lib.OfflineItems(rootID); err != nil || len(offline) != 1 {
+		t.Fatalf("OfflineItems() = %+v, %v; want 1 item", offline, err)
+	}
+
+	// Remounting clears the flags and keeps the ID
+	write()
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() after remount error = %v", err)
+	}
+	if item, ok, _ := store.GetByID(id); !ok || !item.OfflineSince.IsZero() {
+		t.Fatalf("GetByID() after remount = %+v, %v; want online item", item, ok)
+	}
+	if root, _ := lib.Root(rootID); !root.Available {
+		t.Fatalf("Root() after remount = %+v; want available", root)
+	}
+
+	// Offline items are deleted on confirmation or after the grace period
+	unmount()
+	_ = lib.Scan()
+	if removed, err := lib.PurgeOfflineItems(rootID); err != nil || removed != 1 {
+		t.Fatalf("PurgeOfflineItems() = %d, %v; want 1", removed, err)
+	}
+	if _, ok, _ := store.GetByID(id); ok {
+		t.Fatalf("GetByID() after purge: item still stored")
+	}
+
+	write()
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	unmount()
+	lib.SetOfflineGrace(time.Nanosecond)
+	if err := lib.Scan(); !errors.Is(err, server.ErrRootUnavailable) {
+		t.Fatalf("Scan() error = %v; want ErrRootUnavailable", err)
+	}
+	if items, err := store.GetAll(); err != nil || len(items) != 0 {
+		t.Fatalf("GetAll() after grace period = %d items, %v; want none", len(items), err)
+	}
+}
@@ -9,6 +9,10 @@ type PlaybackState struct {
 	LastPlayedAt    int64    `json:"lastPlayedAt"`
 	PercentComplete *float64 `json:"percentComplete,omitempty"`
 	ClientID        string   `json:"clientId,omitempty"`
+	// Part is set when the position is within that part of a stacked item, e.g.
+	// while playing /items/{id}/stream?part=2; otherwise it is the position in the
+	// whole stack
+	Part int `json:"part,omitempty"`
 }
 
 // PlaybackEvent represents a client playback progress payload.
@@ -19,4 +23,5 @@ type PlaybackEvent struct {
 	LastPlayedAt    int64    `json:"lastPlayedAt"`
 	PercentComplete *float64 `json:"percentComplete,omitempty"`
 	ClientID        string   `json:"clientId,omitempty"`
+	Part            int      `json:"part,omitempty"`
 }
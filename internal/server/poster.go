@@ -1,13 +1,16 @@
 package server
 
 import (
-	"os"
 	"path/filepath"
 	"strings"
 )
 
 // FindPosterForVideo searches for poster images next to the video file
 func FindPosterForVideo(videoPath string) (string, bool) {
+	return findPoster(videoPath, fileExists)
+}
+
+func findPoster(videoPath string, exists func(string) bool) (string, bool) {
 	if videoPath == "" {
 		return "", false
 	}
@@ -29,7 +32,7 @@ func FindPosterForVideo(videoPath string) (string, bool) {
 	}
 
 	for _, candidate := range candidates {
-		if _, err := os.Stat(candidate); err == nil {
+		if exists(candidate) {
 			return candidate, true
 		}
 	}
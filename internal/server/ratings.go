@@ -0,0 +1,228 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// Policies for items without a content rating
+const (
+	UnratedAllow = "allow"
+	UnratedBlock = "block"
+)
+
+// Minimum ages of the supported certification scales. MPAA and US TV ratings are
+// mapped to the age they are commonly considered suitable from.
+var (
+	mpaaRatingAges = map[string]int{
+		"G": 0, "PG": 10, "PG-13": 13, "R": 17, "NC-17": 18,
+		"TV-Y": 0, "TV-Y7": 7, "TV-G": 0, "TV-PG": 10, "TV-14": 14, "TV-MA": 17,
+	}
+	bbfcRatingAges = map[string]int{
+		"U": 0, "UC": 0, "PG": 8, "12": 12, "12A": 12, "15": 15, "18": 18, "R18": 18,
+	}
+	fskRatingAges = map[string]int{
+		"0": 0, "6": 6, "12": 12, "16": 16, "18": 18,
+	}
+)
+
+// contentRatingAge maps a certification as found in the NFO <mpaa> field to a
+// minimum age. It understands the MPAA, FSK and BBFC scales and the usual
+// notations such as "Rated PG-13", "FSK 12", "Germany:12" or "UK:15". The second
+// result is false for empty or unknown ratings.
+func contentRatingAge(rating string) (int, bool) {
+	value := strings.ToUpper(strings.TrimSpace(rating))
+	value = strings.TrimPrefix(value, "RATED ")
+
+	scale := ""
+	if idx := strings.LastIndex(value, ":"); idx >= 0 {
+		switch strings.TrimSpace(value[:idx]) {
+		case "DE", "DEU", "GER", "GERMANY", "DEUTSCHLAND":
+			scale = "FSK"
+		case "UK", "GB", "GBR", "UNITED KINGDOM":
+			scale = "BBFC"
+		case "US", "USA", "UNITED STATES":
+			scale = "MPAA"
+		}
+		value = strings.TrimSpace(value[idx+1:])
+	}
+	for _, prefix := range []string{"FSK", "BBFC", "MPAA"} {
+		if strings.HasPrefix(value, prefix) {
+			scale = prefix
+			value = strings.TrimLeft(strings.TrimPrefix(value, prefix), " -:")
+			break
+		}
+	}
+	if value == "" {
+		return 0, false
+	}
+
+	switch scale {
+	case "FSK":
+		age, ok := fskRatingAges[strings.TrimPrefix(value, "AB ")]
+		return age, ok
+	case "BBFC":
+		age, ok := bbfcRatingAges[value]
+		return age, ok
+	case "MPAA":
+		age, ok := mpaaRatingAges[value]
+		return age, ok
+	}
+
+	// Without a scale hint "PG" is read as MPAA, the stricter interpretation
+	if age, ok := mpaaRatingAges[value]; ok {
+		return age, true
+	}
+	if age, ok := bbfcRatingAges[value]; ok {
+		return age, true
+	}
+	if age, err := strconv.Atoi(value); err == nil && age >= 0 && age <= 21 {
+		return age, true
+	}
+	return 0, false
+}
+
+// handleItemRating shows and overrides the effective content rating of an item (admin only)
+func (s *Server) handleItemRating(w http.ResponseWriter, r *http.Request, item MediaItem) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		s.methodNotAllowed(w)
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload struct {
+			Rating string `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		rating := strings.TrimSpace(payload.Rating)
+		if _, ok := contentRatingAge(rating); !ok {
+			s.writeError(w, "unknown rating", http.StatusBadRequest)
+			return
+		}
+		err := s.lib.store.SetContentRatingOverride(item.ID, rating, time.Now())
+		s.audit(r, "item.rating.set", item.ID, auditOutcome(err))
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		err := s.lib.store.SetContentRatingOverride(item.ID, "", time.Now())
+		s.audit(r, "item.rating.reset", item.ID, auditOutcome(err))
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rating, err := s.lib.store.GetContentRating(item.ID)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	if age, ok := contentRatingAge(rating.Effective); ok {
+		rating.MinimumAge = &age
+	}
+	writeJSON(w, r, rating)
+}
+
+// handleAuthUserParental shows and sets the parental controls of a user (admin only)
+func (s *Server) handleAuthUserParental(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, PUT, OPTIONS") {
+		return
+	}
+
+	if s.authManager == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	userID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/users/"), "/parental")
+	if userID == "" || strings.Contains(userID, "/") {
+		s.writeError(w, errNotFound, http.StatusNotFound)
+		return
+	}
+	if _, err := s.authManager.GetUser(userID); err != nil {
+		if err == auth.ErrUserNotFound {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		controls, _, err := s.lib.store.GetUserParentalControls(userID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, controls)
+
+	case http.MethodPut:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload ParentalControls
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		payload.MaxRating = strings.TrimSpace(payload.MaxRating)
+		if payload.MaxRating != "" {
+			if _, ok := contentRatingAge(payload.MaxRating); !ok {
+				s.writeError(w, "unknown rating", http.StatusBadRequest)
+				return
+			}
+		}
+		switch payload.UnratedPolicy {
+		case "":
+			payload.UnratedPolicy = UnratedAllow
+		case UnratedAllow, UnratedBlock:
+		default:
+			s.writeError(w, "unratedPolicy must be allow or block", http.StatusBadRequest)
+			return
+		}
+
+		err := s.lib.store.SetUserParentalControls(userID, payload)
+		s.audit(r, "auth.user.parental", userID, auditOutcome(err))
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, payload)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentScanJobs is the number of finished jobs kept in memory, e.g. for libraries
+// without a database
+const recentScanJobs = 20
+
+// scanJob tracks one scan from start to finish. Its run is persisted to scan_runs
+// when the library has a writable store.
+type scanJob struct {
+	mu        sync.Mutex
+	run       ScanRun
+	persisted bool
+	startErr  error
+	scope     rootScope
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func (j *scanJob) snapshot() ScanRun {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.run
+}
+
+func (j *scanJob) update(fn func(progress *ScanProgress)) {
+	j.mu.Lock()
+	fn(&j.run.Progress)
+	j.mu.Unlock()
+}
+
+// beginScan registers a scan job for an already resolved path of a root
+func (l *Library) beginScan(scope rootScope, targetPath string, isFullScan bool) *scanJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scanJob{
+		run: ScanRun{
+			RootID:    scope.id,
+			Path:      targetPath,
+			FullScan:  isFullScan,
+			StartedAt: time.Now(),
+			Status:    ScanRunning,
+		},
+		scope:  scope,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if l.store != nil && !storeReadOnly(l.store) && scope.id != "" {
+		run, err := l.store.StartScanRun(scope.id, targetPath, isFullScan, job.run.StartedAt)
+		if err != nil {
+			job.startErr = err
+		} else {
+			job.run.ID = run.ID
+			job.persisted = true
+		}
+	}
+	if job.run.ID == "" {
+		job.run.ID = newUUID()
+	}
+
+	l.jobsMu.Lock()
+	l.jobs[job.run.ID] = job
+	l.jobsMu.Unlock()
+	return job
+}
+
+// runScan performs the scan of a job and records its outcome. Scans of the same root
+// run one after another.
+func (l *Library) runScan(job *scanJob) error {
+	defer close(job.done)
+	defer job.cancel()
+
+	job.scope.mu.Lock()
+	var scanErr error
+	if _, ok := l.Root(job.scope.id); !ok {
+		scanErr = ErrRootNotFound
+	} else if scanErr = job.ctx.Err(); scanErr == nil {
+		scanErr = l.performScan(job)
+	}
+	job.scope.mu.Unlock()
+
+	job.mu.Lock()
+	job.run.FinishedAt = time.Now()
+	job.run.Progress.CurrentPath = ""
+	switch {
+	case scanErr == nil:
+		job.run.Status = ScanSuccess
+	case errors.Is(scanErr, context.Canceled):
+		job.run.Status = ScanCanceled
+	default:
+		job.run.Status = ScanFailed
+		job.run.Error = scanErr.Error()
+	}
+	run := job.run
+	job.mu.Unlock()
+
+	if job.persisted {
+		var errs []error
+		if err := l.store.UpdateScanRunProgress(run.ID, run.Progress); err != nil {
+			errs = append(errs, err)
+		}
+		var err error
+		switch run.Status {
+		case ScanSuccess:
+			err = l.store.FinishScanRun(run.ID, run.FinishedAt)
+		case ScanCanceled:
+			err = l.store.CancelScanRun(run.ID, run.FinishedAt)
+		default:
+			err = l.store.FailScanRun(run.ID, run.FinishedAt, run.Error)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			scanErr = errors.Join(append([]error{scanErr}, errs...)...)
+		}
+	}
+
+	l.jobsMu.Lock()
+	delete(l.jobs, run.ID)
+	l.finishedJobs = append([]ScanRun{run}, l.finishedJobs...)
+	if len(l.finishedJobs) > recentScanJobs {
+		l.finishedJobs = l.finishedJobs[:recentScanJobs]
+	}
+	l.jobsMu.Unlock()
+
+	return scanErr
+}
+
+// StartScan starts a scan of a directory or file in the background and returns its
+// run right away. An empty path scans all roots.
+func (l *Library) StartScan(path string) (ScanRun, error) {
+	if strings.TrimSpace(path) == "" {
+		runs := l.StartFullScan()
+		if len(runs) == 0 {
+			return ScanRun{}, ErrRootNotFound
+		}
+		return runs[0], nil
+	}
+	targetPath, root, err := l.resolveScanPath(path)
+	if err != nil {
+		return ScanRun{}, err
+	}
+	return l.startJob(l.beginScan(l.scope(root), targetPath, targetPath == root.abs)), nil
+}
+
+// StartRootScan starts a full scan of a root in the background
+func (l *Library) StartRootScan(id string) (ScanRun, error) {
+	l.rootsMu.RLock()
+	root, ok := l.roots[id]
+	l.rootsMu.RUnlock()
+	if !ok {
+		return ScanRun{}, ErrRootNotFound
+	}
+	return l.startJob(l.beginScan(l.scope(root), root.abs, true)), nil
+}
+
+// StartFullScan starts a full scan of every root, oldest root first
+func (l *Library) StartFullScan() []ScanRun {
+	var runs []ScanRun
+	for _, root := range l.Roots() {
+		if run, err := l.StartRootScan(root.ID); err == nil {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+func (l *Library) startJob(job *scanJob) ScanRun {
+	go func() {
+		if err := l.runScan(job); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("scan failed (job %s): %v", job.run.ID, err)
+		}
+	}()
+	return job.snapshot()
+}
+
+// ScanJob returns a running or recently finished scan
+func (l *Library) ScanJob(id string) (ScanRun, bool) {
+	l.jobsMu.Lock()
+	defer l.jobsMu.Unlock()
+	if job, ok := l.jobs[id]; ok {
+		return job.snapshot(), true
+	}
+	for _, run := range l.finishedJobs {
+		if run.ID == id {
+			return run, true
+		}
+	}
+	return ScanRun{}, false
+}
+
+// ScanJobs returns the running and recently finished scans, newest first
+func (l *Library) ScanJobs() []ScanRun {
+	l.jobsMu.Lock()
+	defer l.jobsMu.Unlock()
+	runs := make([]ScanRun, 0, len(l.jobs)+len(l.finishedJobs))
+	for _, job := range l.jobs {
+		runs = append(runs, job.snapshot())
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return append(runs, l.finishedJobs...)
+}
+
+// CancelScan cancels a running scan and waits until it stopped. It reports false
+// when no scan with this ID is running.
+func (l *Library) CancelScan(id string) bool {
+	l.jobsMu.Lock()
+	job, ok := l.jobs[id]
+	l.jobsMu.Unlock()
+	if !ok {
+		return false
+	}
+	job.cancel()
+	<-job.done
+	return true
+}
+
+// cancelScans stops all running scans, e.g. on shutdown
+func (l *Library) cancelScans() {
+	l.jobsMu.Lock()
+	jobs := make([]*scanJob, 0, len(l.jobs))
+	for _, job := range l.jobs {
+		jobs = append(jobs, job)
+	}
+	l.jobsMu.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+		<-job.done
+	}
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+	"github.com/treefix50/primetime/internal/storage"
+)
+
+// failingItemsStore fails to save items while fail is set
+type failingItemsStore struct {
+	*storage.Store
+	fail bool
+}
+
+func (s *failingItemsStore) SaveItems(items []server.MediaItem) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Store.SaveItems(items)
+}
+
+func TestScanJobsRecordProgress(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	for _, name := range []string{"Movie One.mkv", "Movie Two.mp4", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	run, err := lib.StartScan("")
+	if err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	if run.ID == "" || !run.FullScan {
+		t.Fatalf("StartScan() = %+v; want a full scan with ID", run)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, ok := lib.ScanJob(run.ID)
+		if !ok {
+			t.Fatalf("ScanJob(%s) not found", run.ID)
+		}
+		if current.Status != server.ScanRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scan %s still running", run.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lib.CancelScan(run.ID) {
+		t.Fatalf("CancelScan() of a finished scan = true; want false")
+	}
+
+	stored, ok, err := store.GetScanRun(run.ID)
+	if err != nil || !ok {
+		t.Fatalf("GetScanRun() = %v, %v", ok, err)
+	}
+	if stored.Status != server.ScanSuccess || stored.Progress.FilesFound != 2 || stored.Progress.Added != 2 || stored.Progress.DirsVisited != 1 {
+		t.Fatalf("GetScanRun() = %+v; want success with 2 files added in 1 directory", stored)
+	}
+	runs, total, err := store.ListScanRuns(10, 0)
+	if err != nil || total != 1 || len(runs) != 1 || runs[0].ID != run.ID {
+		t.Fatalf("ListScanRuns() = %+v, %d, %v; want the one run", runs, total, err)
+	}
+
+	// Runs left running by a crash are marked failed on the next start
+	if _, err := store.StartScanRun(run.RootID, root, true, time.Now()); err != nil {
+		t.Fatalf("StartScanRun() error = %v", err)
+	}
+	if interrupted, err := store.InterruptScanRuns(time.Now()); err != nil || interrupted != 1 {
+		t.Fatalf("InterruptScanRuns() = %d, %v; want 1", interrupted, err)
+	}
+}
+
+func TestFailedSaveKeepsItemsInMemory(t *testing.T) {
+	store := &failingItemsStore{Store: newTestStore(t)}
+	root := t.TempDir()
+	write := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("Movie One.mkv")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if items := lib.All(); len(items) != 1 {
+		t.Fatalf("All() = %+v; want one item", items)
+	}
+
+	// Memory keeps matching the store when the new items cannot be saved
+	write("Movie Two.mkv")
+	store.fail = true
+	if err := lib.Scan(); err == nil {
+		t.Fatalf("Scan() error = nil; want the save error")
+	}
+	if items := lib.All(); len(items) != 1 || items[0].Title != "Movie One" {
+		t.Fatalf("All() after failed save = %+v; want only Movie One", items)
+	}
+
+	store.fail = false
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if items := lib.All(); len(items) != 2 {
+		t.Fatalf("All() = %+v; want both movies", items)
+	}
+}
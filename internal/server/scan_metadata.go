@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// DefaultScanWorkers is the number of items whose metadata is read in parallel during
+// a scan. Reading NFOs is dominated by I/O latency, especially on network shares.
+const DefaultScanWorkers = 4
+
+// showNFOCache parses every show NFO once per scan. All episodes of a show share the
+// same tvshow.nfo, so without the cache it would be parsed for each of them.
+type showNFOCache struct {
+	mu      sync.Mutex
+	entries map[string]*showNFOEntry
+}
+
+type showNFOEntry struct {
+	once sync.Once
+	nfo  *NFO
+	err  error
+}
+
+func newShowNFOCache() *showNFOCache {
+	return &showNFOCache{entries: map[string]*showNFOEntry{}}
+}
+
+// parse returns the parsed show NFO. The result is shared between episodes and must
+// not be modified.
+func (c *showNFOCache) parse(path string) (*NFO, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	if !ok {
+		entry = &showNFOEntry{}
+		c.entries[path] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.nfo, entry.err = ParseNFOFile(path)
+	})
+	return entry.nfo, entry.err
+}
+
+// SetScanWorkers sets the number of parallel metadata workers for subsequent scans
+func (l *Library) SetScanWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	l.mu.Lock()
+	l.scanWorkers = workers
+	l.mu.Unlock()
+}
+
+func (l *Library) metadataWorkers() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.scanWorkers < 1 {
+		return DefaultScanWorkers
+	}
+	return l.scanWorkers
+}
+
+// readMetadata reads the metadata of the given items with a bounded worker pool. The
+// updates keep the order of ids, so the writes do not depend on worker scheduling.
+// After a cancel only the items read so far are returned.
+func (l *Library) readMetadata(ctx context.Context, ids []string, files map[string][]MediaFile) []MetadataUpdate {
+	updates := make([]MetadataUpdate, len(ids))
+	done := make([]bool, len(ids))
+	shows := newShowNFOCache()
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(l.metadataWorkers(), len(ids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				updates[i] = readItemMetadata(ids[i], files[ids[i]], shows)
+				done[i] = true
+			}
+		}()
+	}
+
+feed:
+	for i := range ids {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	out := updates[:0]
+	for i, update := range updates {
+		if done[i] {
+			out = append(out, update)
+		}
+	}
+	return out
+}
+
+// readItemMetadata parses the NFO of an item, or falls back to the filename, and
+// merges the show NFO into episodes
+func readItemMetadata(id string, itemFiles []MediaFile, shows *showNFOCache) MetadataUpdate {
+	update := MetadataUpdate{MediaID: id, Files: itemFiles}
+	videoPath := mediaFilePath(itemFiles, MediaFileVideo)
+	itemNFO := mediaFilePath(itemFiles, MediaFileNFO)
+	showNFO := mediaFilePath(itemFiles, MediaFileShowNFO)
+
+	if itemNFO == "" {
+		if fallback, ok := fallbackNFOFromFilename(videoPath); ok {
+			merged := fallback
+			if showNFO != "" {
+				if show, err := shows.parse(showNFO); err == nil {
+					merged = mergeEpisodeWithShow(merged, show)
+				}
+			}
+			update.NFO = merged
+		}
+		return update
+	}
+
+	nfo, err := ParseNFOFile(itemNFO)
+	if err != nil {
+		// The broken NFO is tracked as well and parsed again once it changes
+		log.Printf("level=warn msg=\"nfo parse failed\" path=%s err=%v", itemNFO, err)
+		update.KeepNFO = true
+		return update
+	}
+	if nfo.Type == "episode" && showNFO != "" {
+		if show, err := shows.parse(showNFO); err == nil {
+			nfo = mergeEpisodeWithShow(nfo, show)
+		}
+	}
+	update.NFO = nfo
+	return update
+}
@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestIncrementalScanSkipsUnchangedItems(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	write := func(name, content string, modified time.Time) string {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatalf("chtimes %s: %v", name, err)
+		}
+		return path
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := write("Show.S01E01.mkv", "one", past)
+	second := write("Show.S01E02.mkv", "two", past)
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	firstID, _, _ := store.GetIDByPath(first)
+	secondID, _, _ := store.GetIDByPath(second)
+
+	files, err := store.GetMediaFiles()
+	if err != nil {
+		t.Fatalf("GetMediaFiles() error = %v", err)
+	}
+	if len(files[firstID]) != 1 || files[firstID][0].Kind != server.MediaFileVideo || files[firstID][0].Size != 3 {
+		t.Fatalf("GetMediaFiles()[first] = %+v; want the video only", files[firstID])
+	}
+	shows, err := store.GetTVShowMediaIDs()
+	if err != nil {
+		t.Fatalf("GetTVShowMediaIDs() error = %v", err)
+	}
+	if len(shows) != 1 {
+		t.Fatalf("GetTVShowMediaIDs() = %v; want one show", shows)
+	}
+
+	// Unchanged items keep their stored metadata, the item with a new NFO is refreshed
+	for i, id := range []string{firstID, secondID} {
+		untouched := &server.NFO{Type: "episode", Title: "untouched", ShowTitle: "Show", Season: "1", Episode: fmt.Sprint(i + 1)}
+		if err := store.SaveNFOExtended(id, untouched); err != nil {
+			t.Fatalf("SaveNFOExtended() error = %v", err)
+		}
+	}
+	write("Show.S01E01.nfo", "<episodedetails><title>Pilot</title><showtitle>Show</showtitle><season>1</season><episode>1</episode></episodedetails>", past)
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() after NFO error = %v", err)
+	}
+
+	nfo, ok, err := store.GetNFOExtended(firstID)
+	if err != nil || !ok || nfo.Title != "Pilot" {
+		t.Fatalf("GetNFOExtended(first) = %+v, %v, %v; want Pilot", nfo, ok, err)
+	}
+	nfo, ok, err = store.GetNFOExtended(secondID)
+	if err != nil || !ok || nfo.Title != "untouched" {
+		t.Fatalf("GetNFOExtended(second) = %+v, %v, %v; want untouched", nfo, ok, err)
+	}
+	if files, _ = store.GetMediaFiles(); len(files[firstID]) != 2 {
+		t.Fatalf("GetMediaFiles()[first] = %+v; want video and NFO", files[firstID])
+	}
+
+	// A removed file drops its tracked files with the item
+	if err := os.Remove(second); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() after remove error = %v", err)
+	}
+	if files, _ = store.GetMediaFiles(); len(files[secondID]) != 0 {
+		t.Fatalf("GetMediaFiles()[second] = %+v; want none", files[secondID])
+	}
+}
+
+func TestParallelScanMergesShowNFO(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	showDir := filepath.Join(root, "Show")
+	if err := os.MkdirAll(showDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(showDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+	write("tvshow.nfo", "<tvshow><title>Show</title><genre>Drama</genre><mpaa>TV-14</mpaa></tvshow>")
+	var episodes []string
+	for i := 1; i <= 12; i++ {
+		episodes = append(episodes, write(fmt.Sprintf("Show.S01E%02d.mkv", i), "x"))
+	}
+	write("Show.S01E03.nfo", "<episodedetails><title>Third</title><season>1</season><episode>3</episode></episodedetails>")
+	write("Show.S01E04.nfo", "<episodedetails><title>broken")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	lib.SetScanWorkers(3)
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	for i, path := range episodes {
+		id, ok, err := store.GetIDByPath(path)
+		if err != nil || !ok {
+			t.Fatalf("GetIDByPath(%s) = %v, %v", path, ok, err)
+		}
+		nfo, ok, err := store.GetNFOExtended(id)
+		if i == 3 {
+			// The broken NFO is skipped, but its files are tracked
+			if err != nil || ok {
+				t.Fatalf("GetNFOExtended(broken) = %+v, %v, %v; want none", nfo, ok, err)
+			}
+			if files, _ := store.GetMediaFiles(); len(files[id]) != 3 {
+				t.Fatalf("GetMediaFiles()[broken] = %+v; want video, NFO and show NFO", files[id])
+			}
+			continue
+		}
+		if err != nil || !ok {
+			t.Fatalf("GetNFOExtended(%s) = %v, %v", path, ok, err)
+		}
+		if nfo.ShowTitle != "Show" || nfo.MPAA != "TV-14" || len(nfo.Genres) != 1 || nfo.Genres[0] != "Drama" {
+			t.Fatalf("GetNFOExtended(%s) = %+v; want show data merged", path, nfo)
+		}
+		if i == 2 && nfo.Title != "Third" {
+			t.Fatalf("GetNFOExtended(%s).Title = %q; want Third", path, nfo.Title)
+		}
+	}
+}
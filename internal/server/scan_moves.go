@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// fingerprintChunk is the size of the head and tail of a video that partial
+// fingerprints hash. Reading all of a large video would take far too long on a NAS.
+const fingerprintChunk = 1 << 20
+
+// partialFingerprint identifies the content of a file by its size and a hash of its
+// first and last MiB. It survives renames, moves and copies to another filesystem.
+func partialFingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, file, min(size, fingerprintChunk)); err != nil {
+		return "", err
+	}
+	if tail := size - fingerprintChunk; tail > 0 {
+		if _, err := file.Seek(max(tail, fingerprintChunk), io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, file); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%d:%s", size, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// fingerprintItems computes the fingerprints the found items are missing, i.e. of
+// new and changed videos, with the metadata worker pool
+func (l *Library) fingerprintItems(ctx context.Context, found map[string]MediaItem) {
+	var ids []string
+	for id, item := range found {
+		if item.Fingerprint == "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	results := make([]string, len(ids))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(l.metadataWorkers(), len(ids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				path := found[ids[i]].VideoPath
+				fingerprint, err := partialFingerprint(path)
+				if err != nil {
+					log.Printf("level=warn msg=\"fingerprint failed\" path=%s err=%v", path, err)
+					continue
+				}
+				results[i] = fingerprint
+			}
+		}()
+	}
+feed:
+	for i := range ids {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for i, id := range ids {
+		if results[i] != "" {
+			item := found[id]
+			item.Fingerprint = results[i]
+			found[id] = item
+		}
+	}
+}
+
+// detectMoves gives files that appeared in this scan the ID of a file that
+// disappeared, so watched state, favorites, collections and playback positions stay
+// with the video. Files are matched by device and inode, or by fingerprint when the
+// inode changed, e.g. after a move to another filesystem. Besides the items missing
+// in the scanned subtree, items of the root outside of it whose video is gone are
+// candidates as well, so moves between directories survive partial scans. It returns
+// the number of moved items.
+func (l *Library) detectMoves(scope rootScope, targetPath string, found map[string]MediaItem, files map[string][]MediaFile, appeared map[string]bool) int {
+	if len(appeared) == 0 {
+		return 0
+	}
+
+	type candidate struct {
+		item   MediaItem
+		inside bool
+		used   bool
+	}
+	var candidates []*candidate
+	l.mu.RLock()
+	for id, item := range l.items {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		path := absPath(item.VideoPath)
+		if scope.owns(path) {
+			candidates = append(candidates, &candidate{item: item, inside: pathWithin(targetPath, path)})
+		}
+	}
+	l.mu.RUnlock()
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	byFileID := map[string][]*candidate{}
+	byFingerprint := map[string][]*candidate{}
+	for _, c := range candidates {
+		if c.item.FileID != "" {
+			byFileID[c.item.FileID] = append(byFileID[c.item.FileID], c)
+		}
+		if c.item.Fingerprint != "" {
+			byFingerprint[c.item.Fingerprint] = append(byFingerprint[c.item.Fingerprint], c)
+		}
+	}
+
+	// A candidate outside the scanned subtree only moved if its video is gone
+	available := func(c *candidate) bool {
+		if c.used {
+			return false
+		}
+		if c.inside {
+			return true
+		}
+		_, err := os.Stat(c.item.VideoPath)
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	match := func(item MediaItem) *candidate {
+		if item.FileID != "" {
+			// Inodes are reused after a delete; the size guards against that
+			for _, c := range byFileID[item.FileID] {
+				if c.item.Size == item.Size && available(c) {
+					return c
+				}
+			}
+		}
+		if item.Fingerprint != "" {
+			for _, c := range byFingerprint[item.Fingerprint] {
+				if available(c) {
+					return c
+				}
+			}
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(appeared))
+	for id := range appeared {
+		if _, ok := found[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return found[ids[i]].VideoPath < found[ids[j]].VideoPath
+	})
+
+	moved := 0
+	for _, id := range ids {
+		item := found[id]
+		c := match(item)
+		if c == nil {
+			continue
+		}
+		c.used = true
+		log.Printf("level=info msg=\"detected moved file\" id=%s from=%s to=%s", c.item.ID, c.item.VideoPath, item.VideoPath)
+
+		delete(found, id)
+		item.ID = c.item.ID
+		found[item.ID] = item
+		files[item.ID] = files[id]
+		delete(files, id)
+		moved++
+	}
+	return moved
+}
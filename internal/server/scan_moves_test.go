@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestScanKeepsIDOfMovedFiles(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	path := func(name string) string { return filepath.Join(root, name) }
+	if err := os.WriteFile(path("Movie.mkv"), []byte("movie content"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	id, ok, err := store.GetIDByPath(path("Movie.mkv"))
+	if err != nil || !ok {
+		t.Fatalf("GetIDByPath() = %v, %v", ok, err)
+	}
+	if err := store.UpsertPlaybackState("", id, 60, 100, time.Now().Unix(), nil, "", 0); err != nil {
+		t.Fatalf("UpsertPlaybackState() error = %v", err)
+	}
+	expectID := func(name string) {
+		t.Helper()
+		got, ok, err := store.GetIDByPath(path(name))
+		if err != nil || !ok || got != id {
+			t.Fatalf("GetIDByPath(%s) = %q, %v, %v; want %q", name, got, ok, err, id)
+		}
+	}
+
+	// Rename within a directory: same inode
+	if err := os.Rename(path("Movie.mkv"), path("Movie (2019).mkv")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	expectID("Movie (2019).mkv")
+
+	// Move into another directory, picked up by a partial scan of the target only
+	if err := os.Mkdir(path("Movie (2019)"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Rename(path("Movie (2019).mkv"), path("Movie (2019)/Movie (2019).mkv")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if err := lib.ScanPath("Movie (2019)"); err != nil {
+		t.Fatalf("ScanPath() error = %v", err)
+	}
+	expectID("Movie (2019)/Movie (2019).mkv")
+
+	// A copy to another filesystem gets a new inode; the fingerprint still matches
+	if err := os.WriteFile(path("Copy.mkv"), []byte("movie content"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.RemoveAll(path("Movie (2019)")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	run, err := lib.StartScan("")
+	if err != nil {
+		t.Fatalf("StartScan() error = %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if current, _ := lib.ScanJob(run.ID); current.Status != server.ScanRunning {
+			run = current
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scan did not finish")
+		}
+	}
+	if run.Progress.Moved != 1 || run.Progress.Removed != 0 {
+		t.Fatalf("scan progress = %+v; want 1 moved, 0 removed", run.Progress)
+	}
+	expectID("Copy.mkv")
+	if _, ok, err := store.GetPlaybackState("", id, ""); err != nil || !ok {
+		t.Fatalf("GetPlaybackState() = %v, %v; want kept", ok, err)
+	}
+
+	// Different content is a new item
+	if err := os.WriteFile(path("Other.mkv"), []byte("other content"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if other, ok, _ := store.GetIDByPath(path("Other.mkv")); !ok || other == id {
+		t.Fatalf("GetIDByPath(Other.mkv) = %q, %v; want a new ID", other, ok)
+	}
+}
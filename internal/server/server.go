@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -20,6 +19,7 @@ import (
 
 const (
 	errInternal         = "internal server error"
+	errBadRequest       = "bad request"
 	errNotFound         = "not found"
 	errMethodNotAllowed = "method not allowed"
 	manualScanRateLimit = 30 * time.Second
@@ -39,14 +39,15 @@ type Server struct {
 	startedAt         time.Time
 	version           VersionInfo
 	scanInterval      time.Duration
-	scanTicker        *time.Ticker
-	scanStop          chan struct{}
-	scanStopOnce      sync.Once
-	scanWg            sync.WaitGroup
+	watcher           *libraryWatcher
 	manualScanLimiter *RateLimiter
 	playbackLimiter   *RateLimiter
 	transcodingMgr    *TranscodingManager
 	authManager       *auth.Manager
+	streamSigner      *streamSigner
+	auditRetention    time.Duration
+	auditStop         chan struct{}
+	auditWg           sync.WaitGroup
 }
 
 func (s *Server) methodNotAllowed(w http.ResponseWriter) {
@@ -59,26 +60,64 @@ type VersionInfo struct {
 	BuildDate string `json:"buildDate"`
 }
 
-func New(root, addr string, store MediaStore, scanInterval time.Duration, noInitialScan bool, cors bool, jsonErrors bool, version VersionInfo, ffmpegReady bool, allowReadOnlyScan bool, extensions []string, ffmpegPath string) (*Server, error) {
-	lib, err := NewLibrary(root, store, extensions)
+// Options configures a Server. Root is the primary media root; without a Store the
+// library is kept in memory only and authentication is not available.
+type Options struct {
+	Root              string
+	Addr              string
+	Store             MediaStore
+	ScanInterval      time.Duration
+	NoInitialScan     bool
+	CORS              bool
+	JSONErrors        bool
+	Version           VersionInfo
+	FFmpegReady       bool
+	FFmpegPath        string
+	AllowReadOnlyScan bool
+	Extensions        []string
+	// ScanWorkers below 1 reads metadata with one worker; an OfflineGrace of zero
+	// keeps offline items until an admin confirms their removal
+	ScanWorkers    int
+	OfflineGrace   time.Duration
+	Watch          bool
+	AuditRetention time.Duration
+	// PasswordPolicy is enforced as given; a nil PasswordHasher uses the auth default
+	PasswordPolicy auth.PasswordPolicy
+	PasswordHasher auth.PasswordHasher
+}
+
+func New(opts Options) (*Server, error) {
+	root, store := opts.Root, opts.Store
+	lib, err := NewLibrary(root, store, opts.Extensions)
 	if err != nil {
 		return nil, err
 	}
+	lib.SetScanWorkers(opts.ScanWorkers)
+	lib.SetOfflineGrace(opts.OfflineGrace)
 	readOnly := storeReadOnly(store)
-	allowScan := !readOnly || allowReadOnlyScan
-	if allowScan && !noInitialScan {
-		// initial scan
-		if err := lib.Scan(); err != nil {
+	allowScan := !readOnly || opts.AllowReadOnlyScan
+	if allowScan && !opts.NoInitialScan {
+		// initial scan; only the primary root is required, the other roots are
+		// scanned in the background. An unmounted primary root keeps its items offline.
+		if err := lib.ScanRoot(lib.rootID); err != nil && !errors.Is(err, ErrRootUnavailable) {
 			log.Printf("scan failed (initial): %v", err)
 			return nil, err
 		}
+		for _, root := range lib.Roots() {
+			if root.ID == lib.rootID {
+				continue
+			}
+			if _, err := lib.StartRootScan(root.ID); err != nil {
+				log.Printf("level=warn msg=\"failed to start initial scan\" root=%s err=%v", root.ID, err)
+			}
+		}
 	}
 
 	mux := http.NewServeMux()
 
 	// Initialize transcoding manager
 	cacheDir := filepath.Join(root, "..", "cache", "transcoding")
-	transcodingMgr := NewTranscodingManager(ffmpegPath, cacheDir, store)
+	transcodingMgr := NewTranscodingManager(opts.FFmpegPath, cacheDir, store)
 
 	// Initialize auth manager
 	var authMgr *auth.Manager
@@ -86,32 +125,51 @@ func New(root, addr string, store MediaStore, scanInterval time.Duration, noInit
 		// Type assert to auth.Store - the storage.Store implements all required methods
 		if authStore, ok := store.(auth.Store); ok {
 			authMgr = auth.NewManager(authStore, 24*time.Hour) // 24 hour sessions
+			authMgr.SetPasswordPolicy(opts.PasswordPolicy)
+			authMgr.SetPasswordHasher(opts.PasswordHasher)
+		}
+	}
+
+	var signer *streamSigner
+	if authMgr != nil {
+		if signer, err = newStreamSigner(); err != nil {
+			return nil, err
 		}
 	}
 
 	s := &Server{
-		addr:              addr,
+		addr:              opts.Addr,
 		lib:               lib,
-		cors:              cors,
-		jsonErrors:        jsonErrors,
+		cors:              opts.CORS,
+		jsonErrors:        opts.JSONErrors,
 		readOnly:          readOnly,
-		allowReadOnlyScan: allowReadOnlyScan,
-		ffmpegReady:       ffmpegReady,
-		ffmpegPath:        ffmpegPath,
+		allowReadOnlyScan: opts.AllowReadOnlyScan,
+		ffmpegReady:       opts.FFmpegReady,
+		ffmpegPath:        opts.FFmpegPath,
 		startedAt:         time.Now(),
-		version:           version,
-		scanInterval:      scanInterval,
+		version:           opts.Version,
+		scanInterval:      opts.ScanInterval,
 		manualScanLimiter: NewRateLimiter(manualScanRateLimit),
 		playbackLimiter:   NewRateLimiter(playbackProgressMin),
 		transcodingMgr:    transcodingMgr,
 		authManager:       authMgr,
+		streamSigner:      signer,
+		auditRetention:    opts.AuditRetention,
 	}
 
-	if s.scanInterval > 0 && (!s.readOnly || s.allowReadOnlyScan) {
-		s.scanTicker = time.NewTicker(s.scanInterval)
-		s.scanStop = make(chan struct{})
-		s.scanWg.Add(1)
-		go s.runScanTicker()
+	if !s.readOnly || s.allowReadOnlyScan {
+		// Every root is scanned on its own schedule, -scan-interval is the default
+		lib.StartSchedules(s.scanInterval)
+	}
+
+	if opts.Watch && (!s.readOnly || s.allowReadOnlyScan) {
+		s.startWatcher()
+	}
+
+	if s.auditRetention > 0 && store != nil && !s.readOnly {
+		s.auditStop = make(chan struct{})
+		s.auditWg.Add(1)
+		go s.runAuditPruner()
 	}
 
 	mux.HandleFunc("/health", s.handleHealth)
@@ -119,10 +177,13 @@ func New(root, addr string, store MediaStore, scanInterval time.Duration, noInit
 	mux.HandleFunc("/version", s.handleVersion)
 	mux.HandleFunc("/library", s.handleLibrary)
 	mux.HandleFunc("/library/scan", s.handleLibraryScan)
+	mux.HandleFunc("/library/scans", s.handleLibraryScans)
+	mux.HandleFunc("/library/scans/", s.handleLibraryScanDetail)
 	mux.HandleFunc("/library/duplicates", s.handleLibraryDuplicates)
+	mux.HandleFunc("/library/duplicates/hash", s.handleLibraryDuplicatesHash)
 	mux.HandleFunc("/library/recent", s.handleLibraryRecent)
 	mux.HandleFunc("/library/roots", s.handleLibraryRoots)
-	mux.HandleFunc("/library/roots/", s.handleLibraryRootScan)
+	mux.HandleFunc("/library/roots/", s.handleLibraryRootRoutes)
 	mux.HandleFunc("/library/type/", s.handleLibraryByType)
 	mux.HandleFunc("/playback", s.handlePlayback)
 	mux.HandleFunc("/favorites", s.handleFavorites)
@@ -134,6 +195,7 @@ func New(root, addr string, store MediaStore, scanInterval time.Duration, noInit
 	// Verbesserung 1: Multi-User-Support
 	mux.HandleFunc("/users", s.handleUsers)
 	mux.HandleFunc("/users/", s.handleUserDetail)
+	mux.HandleFunc("/preferences", s.handlePreferences)
 
 	// Verbesserung 2: Transkodierungs-Profile
 	mux.HandleFunc("/transcoding/profiles", s.handleTranscodingProfiles)
@@ -146,20 +208,39 @@ func New(root, addr string, store MediaStore, scanInterval time.Duration, noInit
 
 	// Authentication endpoints
 	mux.HandleFunc("/auth/login", s.handleAuthLogin)
+	mux.HandleFunc("/auth/login/totp", s.handleAuthLoginTOTP)
 	mux.HandleFunc("/auth/logout", s.handleAuthLogout)
 	mux.HandleFunc("/auth/session", s.handleAuthSession)
+	mux.HandleFunc("/auth/sessions", s.handleAuthSessions)
+	mux.HandleFunc("/auth/sessions/", s.handleAuthSessionDetail)
+	mux.HandleFunc("/auth/totp", s.handleAuthTOTP)
+	mux.HandleFunc("/auth/totp/confirm", s.handleAuthTOTPConfirm)
+	mux.HandleFunc("/auth/register", s.handleAuthRegister)
+	mux.HandleFunc("/auth/invites", s.handleAuthInvites)
+	mux.HandleFunc("/auth/invites/", s.handleAuthInviteDetail)
+	mux.HandleFunc("/auth/apikeys", s.handleAuthAPIKeys)
+	mux.HandleFunc("/auth/apikeys/", s.handleAuthAPIKeyDetail)
+	mux.HandleFunc("/audit", s.handleAudit)
 	mux.HandleFunc("/auth/users", s.handleAuthUsers)
 	mux.HandleFunc("/auth/users/", func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/password") {
 			s.handleAuthUserPassword(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/unlock") {
+			s.handleAuthUserUnlock(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/totp") {
+			s.handleAuthUserTOTPReset(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/roots") {
+			s.handleAuthUserRoots(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/parental") {
+			s.handleAuthUserParental(w, r)
 		} else {
 			s.handleAuthUserDelete(w, r)
 		}
 	})
 
 	s.http = &http.Server{
-		Addr:              addr,
-		Handler:           logMiddleware(mux, s.cors),
+		Addr:              opts.Addr,
+		Handler:           logMiddleware(s.authMiddleware(mux), s.cors),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 	return s, nil
@@ -168,36 +249,38 @@ func New(root, addr string, store MediaStore, scanInterval time.Duration, noInit
 func (s *Server) Start() error { return s.http.ListenAndServe() }
 
 func (s *Server) Close() error {
-	s.stopScanTicker()
+	s.lib.StopSchedules()
+	s.stopWatcher()
+	s.lib.cancelScans()
+	s.lib.stopHashJob()
+	s.stopAuditPruner()
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	return s.http.Shutdown(ctx)
 }
 
-func (s *Server) runScanTicker() {
-	defer s.scanWg.Done()
-	for {
-		select {
-		case <-s.scanTicker.C:
-			if err := s.lib.Scan(); err != nil {
-				log.Printf("scan failed (periodic): %v", err)
-			}
-		case <-s.scanStop:
-			s.scanTicker.Stop()
-			return
-		}
+// startWatcher watches the library roots for changes. Without a watcher, e.g. when
+// inotify is unavailable, the library is only updated by the periodic scans.
+func (s *Server) startWatcher() {
+	var roots []string
+	for _, root := range s.lib.Roots() {
+		roots = append(roots, root.Path)
 	}
+
+	watcher, err := startLibraryWatcher(s.lib, roots)
+	if err != nil {
+		log.Printf("level=warn msg=\"filesystem watcher unavailable, using periodic scans only\" err=%v", err)
+		return
+	}
+	s.watcher = watcher
 }
 
-func (s *Server) stopScanTicker() {
-	if s.scanStop == nil {
+func (s *Server) stopWatcher() {
+	if s.watcher == nil {
 		return
 	}
-	s.scanStopOnce.Do(func() {
-		close(s.scanStop)
-	})
-	s.scanWg.Wait()
-	s.scanStop = nil
+	s.watcher.close()
+	s.watcher = nil
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -246,6 +329,25 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	if access.restricted() {
+		// Library-wide statistics would reveal roots the user cannot see
+		_, lastScan, err := s.lib.Stats()
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, map[string]any{
+			"totalItems": len(access.filterItems(s.lib.All())),
+			"lastScan":   lastScan,
+		})
+		return
+	}
+
 	// Verbesserung 5: Erweiterte Statistiken
 	detailed := strings.TrimSpace(r.URL.Query().Get("detailed"))
 	if detailed != "" && s.lib.store != nil {
@@ -290,6 +392,12 @@ func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 		itemType := strings.TrimSpace(r.URL.Query().Get("type"))
 		rating := strings.TrimSpace(r.URL.Query().Get("rating"))
 
+		access, err := s.libraryAccess(r)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+
 		if s.lib.store == nil {
 			items := s.lib.All()
 			if query != "" {
@@ -301,12 +409,13 @@ func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		items, err := s.lib.store.GetAllLimitedWithFilters(limit, offset, sortBy, query, genre, year, itemType, rating)
+		queryLimit, queryOffset := access.pageLimit(limit, offset)
+		items, err := s.lib.store.GetAllLimitedWithFilters(queryLimit, queryOffset, sortBy, query, genre, year, itemType, rating)
 		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
-		writeJSON(w, r, items)
+		writeJSON(w, r, paginate(access, access.filterItems(items), limit, offset))
 	case http.MethodPost:
 		if s.readOnly && !s.allowReadOnlyScan {
 			s.writeError(w, "read-only mode", http.StatusForbidden)
@@ -316,11 +425,14 @@ func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, manualScanError(wait), http.StatusTooManyRequests)
 			return
 		}
-		if err := s.lib.Scan(); err != nil {
-			s.writeError(w, errInternal, http.StatusInternalServerError)
-			return
+		// One scan per root; the roots are scanned in parallel
+		runs := s.lib.StartFullScan()
+		for _, run := range runs {
+			s.audit(r, "library.scan", run.ID, AuditSuccess)
 		}
-		writeJSON(w, r, map[string]string{"status": "ok"})
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{"scans": runs})
 	default:
 		s.methodNotAllowed(w)
 	}
@@ -355,7 +467,8 @@ func (s *Server) handleLibraryScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.lib.ScanPath(payload.Path); err != nil {
+	run, err := s.lib.StartScan(payload.Path)
+	if err != nil {
 		if errors.Is(err, ErrInvalidScanPath) || errors.Is(err, ErrScanPathNotFound) {
 			s.writeError(w, "bad request", http.StatusBadRequest)
 			return
@@ -363,7 +476,8 @@ func (s *Server) handleLibraryScan(w http.ResponseWriter, r *http.Request) {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, r, map[string]string{"status": "ok"})
+	s.audit(r, "library.scan", run.ID, AuditSuccess)
+	writeScanStarted(w, run)
 }
 
 func (s *Server) allowManualScan() (bool, time.Duration) {
@@ -400,11 +514,27 @@ func (s *Server) handleLibraryDuplicates(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
 	duplicates, err := s.lib.store.GetDuplicates()
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
+	if access.restricted() {
+		visible := make([]DuplicateGroup, 0, len(duplicates))
+		for _, group := range duplicates {
+			group = NewDuplicateGroup(group.Kind, group.Key, access.filterItems(group.Items))
+			if group.Count > 1 {
+				visible = append(visible, group)
+			}
+		}
+		duplicates = visible
+	}
 	writeJSON(w, r, duplicates)
 }
 
@@ -425,27 +555,42 @@ func (s *Server) handlePlayback(w http.ResponseWriter, r *http.Request) {
 	clientID := strings.TrimSpace(r.URL.Query().Get("clientId"))
 	onlyUnfinished := strings.TrimSpace(r.URL.Query().Get("unfinished")) != ""
 
-	states, err := s.lib.store.GetAllPlaybackStates(clientID, onlyUnfinished)
+	access, err := s.libraryAccess(r)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
+
+	states, err := s.lib.store.GetAllPlaybackStates(sessionUserID(r), clientID, onlyUnfinished)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	if access.restricted() {
+		visible := make([]PlaybackState, 0, len(states))
+		for _, state := range states {
+			if access.allowsID(state.MediaID) {
+				visible = append(visible, state)
+			}
+		}
+		states = visible
+	}
 	writeJSON(w, r, states)
 }
 
 // Routes under /items/{id}[/{action}...]
 func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
-	if s.handleOptions(w, r, "GET, POST, DELETE, OPTIONS") {
+	if s.handleOptions(w, r, "GET, POST, PUT, DELETE, OPTIONS") {
 		return
 	}
 
-	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
 		s.methodNotAllowed(w)
 		return
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/items/")
-	parts := strings.Split(path, "/")
+	itemPath := strings.TrimPrefix(r.URL.Path, "/items/")
+	parts := strings.Split(itemPath, "/")
 	if len(parts) < 1 || parts[0] == "" {
 		s.writeError(w, errNotFound, http.StatusNotFound)
 		return
@@ -457,23 +602,31 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 		action = parts[1]
 	}
 
+	// Items outside the roots granted to the user are reported as missing
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
 	if action == "exists" {
 		if r.Method != http.MethodGet {
 			s.methodNotAllowed(w)
 			return
 		}
+		var item MediaItem
 		var ok bool
 		if s.lib.store == nil {
-			_, ok = s.lib.Get(id)
+			item, ok = s.lib.Get(id)
 		} else {
 			var err error
-			_, ok, err = s.lib.store.GetByID(id)
+			item, ok, err = s.lib.store.GetByID(id)
 			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
 		}
-		if ok {
+		if ok && access.allowsItem(item) {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -493,7 +646,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if !ok {
+	if !ok || !access.allowsItem(item) {
 		s.writeError(w, errNotFound, http.StatusNotFound)
 		return
 	}
@@ -537,9 +690,13 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				s.writeError(w, errBadRequest, http.StatusBadRequest)
 				return
 			}
-			hlsPath := filepath.Join(s.transcodingMgr.cacheDir, "hls", item.ID, profile.ID, asset)
+			_, version, ok := s.requestedVersion(w, r, item, profile)
+			if !ok {
+				return
+			}
+			hlsPath := filepath.Join(s.transcodingMgr.cacheDir, "hls", versionKey(item.ID, version), profile.ID, asset)
 			if strings.HasSuffix(asset, ".m3u8") {
-				s.transcodingMgr.ServeHLSPlaylist(w, r, hlsPath)
+				s.transcodingMgr.ServeHLSPlaylist(w, r, hlsPath, s.hlsSegmentQuery(r, item.ID))
 				return
 			}
 			s.transcodingMgr.ServeHLSSegment(w, r, hlsPath)
@@ -563,8 +720,21 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Serve original file
-		ServeVideoFile(w, r, item.VideoPath)
+		// Serve original file: of an item with versions version 1 unless another
+		// is selected, of a stack one part, the first by default
+		version, _, ok := s.requestedVersion(w, r, item, nil)
+		if !ok {
+			return
+		}
+		part, ok := s.requestedPart(w, r, version)
+		if !ok {
+			return
+		}
+		ServeVideoFile(w, r, part.VideoPath)
+
+	case "stream-url":
+		// /items/{id}/stream-url
+		s.handleStreamURL(w, r, item)
 
 	case "stream.m3u8":
 		if r.Method != http.MethodGet {
@@ -634,7 +804,11 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
 		}
-		subtitlePath, contentType := subtitlePathForVideo(item.VideoPath)
+		part, ok := s.requestedPart(w, r, item)
+		if !ok {
+			return
+		}
+		subtitlePath, contentType := subtitlePathForVideo(part.VideoPath)
 		if subtitlePath == "" {
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
@@ -650,7 +824,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			clientID := strings.TrimSpace(r.URL.Query().Get("clientId"))
-			state, ok, err := s.lib.store.GetPlaybackState(item.ID, clientID)
+			state, ok, err := s.lib.store.GetPlaybackState(sessionUserID(r), item.ID, clientID)
 			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
@@ -688,9 +862,25 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				lastPlayedAt = time.Now().Unix()
 			}
 
-			shouldDelete := position <= 0 || duration <= 0 || (event == "stop" && position >= duration)
+			// Positions within a part only differ from the position in the item for
+			// stacked items
+			part := payload.Part
+			if _, ok := partOf(item, part); part != 0 && !ok {
+				s.writeError(w, "invalid part", http.StatusBadRequest)
+				return
+			}
+			if len(item.Parts) == 0 {
+				part = 0
+			}
+			// Finishing a part that is not the last one resumes at the next part
+			if part > 0 && part < len(item.Parts) && event == "stop" && duration > 0 && position >= duration {
+				part++
+				position = 0
+			}
+
+			shouldDelete := (position <= 0 && part <= 1) || duration <= 0 || (event == "stop" && position >= duration)
 			if shouldDelete {
-				if err := s.lib.store.DeletePlaybackState(item.ID, clientID); err != nil {
+				if err := s.lib.store.DeletePlaybackState(sessionUserID(r), item.ID, clientID); err != nil {
 					s.writeError(w, errInternal, http.StatusInternalServerError)
 					return
 				}
@@ -699,14 +889,14 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if event == "progress" {
-				key := item.ID + "|" + clientID
+				key := sessionUserID(r) + "|" + item.ID + "|" + clientID
 				if ok, wait := s.playbackLimiter.Allow(key); !ok {
 					s.writeError(w, playbackRateLimitError(wait), http.StatusTooManyRequests)
 					return
 				}
 			}
 
-			if err := s.lib.store.UpsertPlaybackState(item.ID, position, duration, lastPlayedAt, payload.PercentComplete, clientID); err != nil {
+			if err := s.lib.store.UpsertPlaybackState(sessionUserID(r), item.ID, position, duration, lastPlayedAt, payload.PercentComplete, clientID, part); err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -724,7 +914,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 
 		switch r.Method {
 		case http.MethodGet:
-			watched, err := s.lib.store.IsWatched(item.ID)
+			watched, err := s.lib.store.IsWatched(sessionUserID(r), item.ID)
 			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
@@ -735,7 +925,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				s.writeError(w, "read-only mode", http.StatusForbidden)
 				return
 			}
-			if err := s.lib.store.MarkWatched(item.ID, time.Now()); err != nil {
+			if err := s.lib.store.MarkWatched(sessionUserID(r), item.ID, time.Now()); err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -745,7 +935,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				s.writeError(w, "read-only mode", http.StatusForbidden)
 				return
 			}
-			if err := s.lib.store.UnmarkWatched(item.ID); err != nil {
+			if err := s.lib.store.UnmarkWatched(sessionUserID(r), item.ID); err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -763,7 +953,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 
 		switch r.Method {
 		case http.MethodGet:
-			favorite, err := s.lib.store.IsFavorite(item.ID)
+			favorite, err := s.lib.store.IsFavorite(sessionUserID(r), item.ID)
 			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
@@ -774,7 +964,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				s.writeError(w, "read-only mode", http.StatusForbidden)
 				return
 			}
-			if err := s.lib.store.AddFavorite(item.ID, time.Now()); err != nil {
+			if err := s.lib.store.AddFavorite(sessionUserID(r), item.ID, time.Now()); err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -784,7 +974,7 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 				s.writeError(w, "read-only mode", http.StatusForbidden)
 				return
 			}
-			if err := s.lib.store.RemoveFavorite(item.ID); err != nil {
+			if err := s.lib.store.RemoveFavorite(sessionUserID(r), item.ID); err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -793,6 +983,10 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 			s.methodNotAllowed(w)
 		}
 
+	case "rating":
+		// /items/{id}/rating
+		s.handleItemRating(w, r, item)
+
 	case "poster":
 		// /items/{id}/poster  OR  /items/{id}/poster/exists
 		if r.Method != http.MethodGet {
@@ -859,6 +1053,10 @@ func (s *Server) handleItems(w http.ResponseWriter, r *http.Request) {
 }
 
 func subtitlePathForVideo(videoPath string) (string, string) {
+	return findSubtitle(videoPath, fileExists)
+}
+
+func findSubtitle(videoPath string, exists func(string) bool) (string, string) {
 	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
 	if base == "" {
 		return "", ""
@@ -872,7 +1070,7 @@ func subtitlePathForVideo(videoPath string) (string, string) {
 	}
 	for _, candidate := range candidates {
 		path := base + candidate.ext
-		if _, err := os.Stat(path); err == nil {
+		if exists(path) {
 			return path, candidate.contentType
 		}
 	}
@@ -900,7 +1098,7 @@ func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
 func (s *Server) writePreflightHeaders(w http.ResponseWriter, methods string) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", methods)
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 }
 
 func (s *Server) writeError(w http.ResponseWriter, message string, code int) {
@@ -940,10 +1138,10 @@ func filterItems(items []MediaItem, query string) []MediaItem {
 	return filtered
 }
 
-func applyLimitOffset(items []MediaItem, limit, offset int) []MediaItem {
+func applyLimitOffset[T any](items []T, limit, offset int) []T {
 	if offset > 0 {
 		if offset >= len(items) {
-			return []MediaItem{}
+			return []T{}
 		}
 		items = items[offset:]
 	}
@@ -1007,12 +1205,19 @@ func (s *Server) handleLibraryRecent(w http.ResponseWriter, r *http.Request) {
 
 	itemType := strings.TrimSpace(r.URL.Query().Get("type"))
 
-	items, err := s.lib.store.GetRecentlyAdded(limit, days, itemType)
+	access, err := s.libraryAccess(r)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, r, items)
+
+	queryLimit, _ := access.pageLimit(limit, 0)
+	items, err := s.lib.store.GetRecentlyAdded(queryLimit, days, itemType)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, paginate(access, access.filterItems(items), limit, 0))
 }
 
 // Erweiterung 2: Favorites
@@ -1035,12 +1240,19 @@ func (s *Server) handleFavorites(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := s.lib.store.GetFavorites(limit, offset)
+	access, err := s.libraryAccess(r)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, r, items)
+
+	queryLimit, queryOffset := access.pageLimit(limit, offset)
+	items, err := s.lib.store.GetFavorites(sessionUserID(r), queryLimit, queryOffset)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, paginate(access, access.filterItems(items), limit, offset))
 }
 
 // Erweiterung 1: Watched
@@ -1063,12 +1275,19 @@ func (s *Server) handleWatched(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := s.lib.store.GetWatchedItems(limit, offset)
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
+	queryLimit, queryOffset := access.pageLimit(limit, offset)
+	items, err := s.lib.store.GetWatchedItems(sessionUserID(r), queryLimit, queryOffset)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, r, items)
+	writeJSON(w, r, paginate(access, access.filterItems(items), limit, offset))
 }
 
 // Erweiterung 4: Collections
@@ -1094,6 +1313,17 @@ func (s *Server) handleCollections(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
+		access, err := s.libraryAccess(r)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		for i := range collections {
+			if err := s.countAccessibleCollectionItems(access, &collections[i]); err != nil {
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+				return
+			}
+		}
 		writeJSON(w, r, collections)
 
 	case http.MethodPost:
@@ -1117,7 +1347,9 @@ func (s *Server) handleCollections(w http.ResponseWriter, r *http.Request) {
 		}
 
 		id := newCollectionID()
-		if err := s.lib.store.CreateCollection(id, payload.Name, payload.Description, time.Now()); err != nil {
+		err := s.lib.store.CreateCollection(id, payload.Name, payload.Description, time.Now())
+		s.audit(r, "collection.create", id, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -1160,6 +1392,12 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 		action = parts[1]
 	}
 
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
 	if action == "items" {
 		// /collections/{id}/items
 		switch r.Method {
@@ -1169,7 +1407,7 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
-			writeJSON(w, r, items)
+			writeJSON(w, r, access.filterItems(items))
 
 		case http.MethodPost:
 			if s.readOnly {
@@ -1190,8 +1428,21 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 				s.writeError(w, "mediaId is required", http.StatusBadRequest)
 				return
 			}
+			if access.restricted() {
+				item, ok, err := s.lib.store.GetByID(payload.MediaID)
+				if err != nil {
+					s.writeError(w, errInternal, http.StatusInternalServerError)
+					return
+				}
+				if !ok || !access.allowsItem(item) {
+					s.writeError(w, errNotFound, http.StatusNotFound)
+					return
+				}
+			}
 
-			if err := s.lib.store.AddItemToCollection(collectionID, payload.MediaID, payload.Position, time.Now()); err != nil {
+			err := s.lib.store.AddItemToCollection(collectionID, payload.MediaID, payload.Position, time.Now())
+			s.audit(r, "collection.item.add", collectionID+"/"+payload.MediaID, auditOutcome(err))
+			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -1209,7 +1460,9 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 			}
 
 			mediaID := parts[2]
-			if err := s.lib.store.RemoveItemFromCollection(collectionID, mediaID); err != nil {
+			err := s.lib.store.RemoveItemFromCollection(collectionID, mediaID)
+			s.audit(r, "collection.item.remove", collectionID+"/"+mediaID, auditOutcome(err))
+			if err != nil {
 				s.writeError(w, errInternal, http.StatusInternalServerError)
 				return
 			}
@@ -1233,6 +1486,10 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
 		}
+		if err := s.countAccessibleCollectionItems(access, collection); err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
 		writeJSON(w, r, collection)
 
 	case http.MethodPut:
@@ -1255,7 +1512,9 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := s.lib.store.UpdateCollection(collectionID, payload.Name, payload.Description, time.Now()); err != nil {
+		err := s.lib.store.UpdateCollection(collectionID, payload.Name, payload.Description, time.Now())
+		s.audit(r, "collection.update", collectionID, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -1269,6 +1528,10 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
 		}
+		if err := s.countAccessibleCollectionItems(access, collection); err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
 		writeJSON(w, r, collection)
 
 	case http.MethodDelete:
@@ -1277,7 +1540,9 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := s.lib.store.DeleteCollection(collectionID); err != nil {
+		err := s.lib.store.DeleteCollection(collectionID)
+		s.audit(r, "collection.delete", collectionID, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -1288,6 +1553,19 @@ func (s *Server) handleCollectionDetail(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// countAccessibleCollectionItems sets the item count of a collection to the items the request may see
+func (s *Server) countAccessibleCollectionItems(access *libraryAccess, collection *Collection) error {
+	if !access.restricted() {
+		return nil
+	}
+	items, err := s.lib.store.GetCollectionItems(collection.ID)
+	if err != nil {
+		return err
+	}
+	collection.ItemCount = len(access.filterItems(items))
+	return nil
+}
+
 func newCollectionID() string {
 	return fmt.Sprintf("col_%d", time.Now().UnixNano())
 }
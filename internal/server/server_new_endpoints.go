@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
 )
 
 // ============================================================================
@@ -63,7 +65,9 @@ func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
 		}
 
 		id := generateUserID(payload.Name)
-		if err := s.lib.store.CreateMediaUser(id, payload.Name, time.Now()); err != nil {
+		err = s.lib.store.CreateMediaUser(id, payload.Name, time.Now())
+		s.audit(r, "user.create", id, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -126,8 +130,24 @@ func (s *Server) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, "read-only mode", http.StatusForbidden)
 			return
 		}
+		if userID == sessionUserID(r) {
+			s.writeError(w, "cannot delete your own account", http.StatusBadRequest)
+			return
+		}
 
-		if err := s.lib.store.DeleteMediaUser(userID); err != nil {
+		// /users is an alias for the accounts in /auth/users; the auth manager also drops their sessions.
+		var err error
+		if s.authManager != nil {
+			err = s.authManager.DeleteUser(userID)
+		} else {
+			err = s.lib.store.DeleteMediaUser(userID)
+		}
+		s.audit(r, "user.delete", userID, auditOutcome(err))
+		if err != nil {
+			if err == auth.ErrLastAdmin {
+				s.writeError(w, "cannot delete the last admin", http.StatusConflict)
+				return
+			}
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -138,6 +158,66 @@ func (s *Server) handleUserDetail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handlePreferences reads and updates the preferences of the logged-in user.
+func (s *Server) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	if s.handleOptions(w, r, "GET, PUT, OPTIONS") {
+		return
+	}
+	if s.lib.store == nil {
+		s.writeError(w, "not available without database", http.StatusNotImplemented)
+		return
+	}
+
+	userID := sessionUserID(r)
+	if userID == "" {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		prefs, err := s.lib.store.GetAllUserPreferences(userID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, prefs)
+
+	case http.MethodPut:
+		if s.readOnly {
+			s.writeError(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		for key, value := range payload {
+			if strings.TrimSpace(key) == "" {
+				s.writeError(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			if err := s.lib.store.SetUserPreference(userID, key, value); err != nil {
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		prefs, err := s.lib.store.GetAllUserPreferences(userID)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, prefs)
+
+	default:
+		s.methodNotAllowed(w)
+	}
+}
+
 // ============================================================================
 // Verbesserung 2: Transkodierungs-Profile Endpoints
 // ============================================================================
@@ -217,7 +297,9 @@ func (s *Server) handleTranscodingProfiles(w http.ResponseWriter, r *http.Reques
 			CreatedAt:            time.Now(),
 		}
 
-		if err := s.lib.store.CreateTranscodingProfile(profile); err != nil {
+		err := s.lib.store.CreateTranscodingProfile(profile)
+		s.audit(r, "transcoding.profile.create", id, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -275,7 +357,9 @@ func (s *Server) handleTranscodingProfileDetail(w http.ResponseWriter, r *http.R
 			return
 		}
 
-		if err := s.lib.store.DeleteTranscodingProfile(profileID); err != nil {
+		err := s.lib.store.DeleteTranscodingProfile(profileID)
+		s.audit(r, "transcoding.profile.delete", profileID, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -307,11 +391,32 @@ func (s *Server) handleTVShows(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		shows, err := s.lib.store.GetAllTVShows(limit, offset)
+		access, err := s.libraryAccess(r)
 		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
+
+		queryLimit, queryOffset := access.pageLimit(limit, offset)
+		shows, err := s.lib.store.GetAllTVShows(queryLimit, queryOffset)
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if access.restricted() {
+			mediaIDs, err := s.lib.store.GetTVShowMediaIDs()
+			if err != nil {
+				s.writeError(w, errInternal, http.StatusInternalServerError)
+				return
+			}
+			visible := make([]TVShow, 0, len(shows))
+			for _, show := range shows {
+				if access.allowsAnyID(mediaIDs[show.ID]) {
+					visible = append(visible, show)
+				}
+			}
+			shows = paginate(access, visible, limit, offset)
+		}
 		writeJSON(w, r, shows)
 
 	case http.MethodPost:
@@ -321,7 +426,9 @@ func (s *Server) handleTVShows(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Trigger auto-grouping of episodes
-		if err := s.lib.store.AutoGroupEpisodes(); err != nil {
+		err := s.lib.store.AutoGroupEpisodes()
+		s.audit(r, "show.group", "", auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -354,10 +461,28 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 		action = parts[1]
 	}
 
+	// Shows without an episode in the roots granted to the user are reported as missing
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+	if access.restricted() {
+		mediaIDs, err := s.lib.store.GetTVShowMediaIDs()
+		if err != nil {
+			s.writeError(w, errInternal, http.StatusInternalServerError)
+			return
+		}
+		if !access.allowsAnyID(mediaIDs[showID]) {
+			s.writeError(w, errNotFound, http.StatusNotFound)
+			return
+		}
+	}
+
 	if action == "seasons" {
 		// Check if it's /shows/{id}/seasons/{season}/episodes
 		if len(parts) >= 4 && parts[2] != "" && parts[3] == "episodes" {
-			s.handleSeasonEpisodes(w, r, showID, parts[2])
+			s.handleSeasonEpisodes(w, r, access, showID, parts[2])
 			return
 		}
 
@@ -372,6 +497,21 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
+		if access.restricted() {
+			visible := make([]Season, 0, len(seasons))
+			for _, season := range seasons {
+				episodes, err := s.lib.store.GetEpisodesBySeason(season.ID)
+				if err != nil {
+					s.writeError(w, errInternal, http.StatusInternalServerError)
+					return
+				}
+				season.EpisodeCount = len(access.filterEpisodes(episodes))
+				if season.EpisodeCount > 0 {
+					visible = append(visible, season)
+				}
+			}
+			seasons = visible
+		}
 		writeJSON(w, r, seasons)
 		return
 	}
@@ -383,9 +523,15 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		userID := strings.TrimSpace(r.URL.Query().Get("userId"))
-		if userID == "" {
-			userID = "" // Default user
+		// Admins may look up the progress of another account via ?userId=
+		userID := sessionUserID(r)
+		if override := strings.TrimSpace(r.URL.Query().Get("userId")); override != "" && override != userID {
+			session, ok := sessionFromContext(r.Context())
+			if ok && !session.IsAdmin {
+				s.writeError(w, "admin access required", http.StatusForbidden)
+				return
+			}
+			userID = override
 		}
 
 		episode, ok, err := s.lib.store.GetNextUnwatchedEpisode(showID, userID)
@@ -393,7 +539,7 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
-		if !ok {
+		if !ok || !access.allowsID(episode.MediaID) {
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
 		}
@@ -421,7 +567,9 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := s.lib.store.DeleteTVShow(showID); err != nil {
+		err := s.lib.store.DeleteTVShow(showID)
+		s.audit(r, "show.delete", showID, auditOutcome(err))
+		if err != nil {
 			s.writeError(w, errInternal, http.StatusInternalServerError)
 			return
 		}
@@ -432,7 +580,7 @@ func (s *Server) handleTVShowDetail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleSeasonEpisodes(w http.ResponseWriter, r *http.Request, showID, seasonNumStr string) {
+func (s *Server) handleSeasonEpisodes(w http.ResponseWriter, r *http.Request, access *libraryAccess, showID, seasonNumStr string) {
 	if r.Method != http.MethodGet {
 		s.methodNotAllowed(w)
 		return
@@ -469,7 +617,7 @@ func (s *Server) handleSeasonEpisodes(w http.ResponseWriter, r *http.Request, sh
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, r, episodes)
+	writeJSON(w, r, access.filterEpisodes(episodes))
 }
 
 // Helper functions
@@ -505,8 +653,14 @@ func (s *Server) handleTranscodedStream(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// Of an item with versions the one selected or fitting the profile is transcoded
+	item, version, ok := s.requestedVersion(w, r, item, profile)
+	if !ok {
+		return
+	}
+
 	// Check if already cached
-	cached, ok, err := s.lib.store.GetTranscodingCache(item.ID, profile.ID)
+	cached, ok, err := s.lib.store.GetTranscodingCache(item.ID, profile.ID, version)
 	if err == nil && ok {
 		// Serve from cache
 		s.transcodingMgr.ServeTranscodedFile(w, r, cached.CachePath)
@@ -515,7 +669,7 @@ func (s *Server) handleTranscodedStream(w http.ResponseWriter, r *http.Request,
 
 	// Start transcoding job
 	selection := selectAudioSelection(*profile, item, s.lib.store)
-	job, err := s.transcodingMgr.StartTranscoding(item.ID, profile.ID, item, *profile, selection)
+	job, err := s.transcodingMgr.StartTranscoding(item.ID, profile.ID, version, item, *profile, selection)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
@@ -555,9 +709,14 @@ func (s *Server) handleHLSStream(w http.ResponseWriter, r *http.Request, item Me
 		return
 	}
 
+	item, version, ok := s.requestedVersion(w, r, item, profile)
+	if !ok {
+		return
+	}
+
 	// Start HLS transcoding job
 	selection := selectAudioSelection(*profile, item, s.lib.store)
-	job, err := s.transcodingMgr.StartHLSTranscoding(item.ID, profile.ID, item, *profile, selection)
+	job, err := s.transcodingMgr.StartHLSTranscoding(item.ID, profile.ID, version, item, *profile, selection)
 	if err != nil {
 		s.writeError(w, errInternal, http.StatusInternalServerError)
 		return
@@ -565,7 +724,7 @@ func (s *Server) handleHLSStream(w http.ResponseWriter, r *http.Request, item Me
 
 	// If job is completed, serve the playlist
 	if job.Status == "completed" && job.OutputPath != "" {
-		s.transcodingMgr.ServeHLSPlaylist(w, r, job.OutputPath)
+		s.transcodingMgr.ServeHLSPlaylist(w, r, job.OutputPath, s.hlsSegmentQuery(r, item.ID))
 		return
 	}
 
@@ -594,10 +753,17 @@ func (s *Server) handleTranscodingJobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Restricted users only see the jobs of items they may access
+	access, err := s.libraryAccess(r)
+	if err != nil {
+		s.writeError(w, errInternal, http.StatusInternalServerError)
+		return
+	}
+
 	jobID := strings.TrimSpace(r.URL.Query().Get("id"))
 	if jobID != "" {
 		job, ok := s.transcodingMgr.GetJob(jobID)
-		if !ok {
+		if !ok || !access.allowsID(job.MediaID) {
 			s.writeError(w, errNotFound, http.StatusNotFound)
 			return
 		}
@@ -605,5 +771,15 @@ func (s *Server) handleTranscodingJobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, r, s.transcodingMgr.ListJobs())
+	jobs := s.transcodingMgr.ListJobs()
+	if access.restricted() {
+		visible := make([]TranscodingJobSummary, 0, len(jobs))
+		for _, job := range jobs {
+			if access.allowsID(job.MediaID) {
+				visible = append(visible, job)
+			}
+		}
+		jobs = visible
+	}
+	writeJSON(w, r, jobs)
 }
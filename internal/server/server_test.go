@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+	"github.com/treefix50/primetime/internal/server"
+	"github.com/treefix50/primetime/internal/storage"
+)
+
+// newTestStore returns a migrated store in a temporary database file
+func newTestStore(t *testing.T) *storage.Store {
+	t.Helper()
+	store, err := storage.Open(filepath.Join(t.TempDir(), "primetime.db"), storage.Options{})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+// testServer is a server on a fresh store with a logged in admin and a logged in
+// regular user
+type testServer struct {
+	*server.Server
+	store      *storage.Store
+	root       string
+	adminToken string
+	userToken  string
+	userID     string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store := newTestStore(t)
+	root := t.TempDir()
+	manager := auth.NewManager(store, time.Hour)
+	if _, err := manager.CreateUser("root", "correct-password", true); err != nil {
+		t.Fatalf("CreateUser(root) error = %v", err)
+	}
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser(alice) error = %v", err)
+	}
+	admin, err := manager.Login("root", "correct-password", auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login(root) error = %v", err)
+	}
+	session, err := manager.Login("alice", "correct-password", auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login(alice) error = %v", err)
+	}
+
+	s, err := server.New(server.Options{Root: root, Store: store, NoInitialScan: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return &testServer{Server: s, store: store, root: root, adminToken: admin.Token, userToken: session.Token, userID: user.ID}
+}
+
+// do sends a request through the middleware chain, with token as bearer token unless empty
+func (ts *testServer) do(method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ts.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// decode reads the JSON body of a response with status want into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, want int, v any) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d %s; want %d", w.Code, w.Body.String(), want)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body.String(), err)
+	}
+}
+
+// waitForScans waits until no scan of the server is running
+func (ts *testServer) waitForScans(t *testing.T) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var list struct {
+			Scans []server.ScanRun `json:"scans"`
+		}
+		decode(t, ts.do(http.MethodGet, "/library/scans", ts.adminToken, ""), http.StatusOK, &list)
+		running := false
+		for _, run := range list.Scans {
+			running = running || run.Status == server.ScanRunning
+		}
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scans did not finish")
+		}
+	}
+}
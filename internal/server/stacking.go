@@ -0,0 +1,203 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stackPattern matches the stacking names of Kodi: cd, dvd, part, pt, disc and disk
+// followed by a number or a letter a-d, e.g. Movie.cd1.avi, Movie - part2.mkv or
+// Movie-discB.mkv. The marker must be separated from the title.
+var stackPattern = regexp.MustCompile(`(?i)^(.*?)[ _.-]+(?:cd|dvd|part|pt|dis[ck])[ _.-]*([0-9]+|[a-d])((?:[ _.-].*?)?)(\.[^.]+)$`)
+
+// parseStackPart returns the stack a video belongs to, its title and the part
+// number. The stack name is the file name without the part marker, e.g. Movie.avi
+// for Movie.cd1.avi. Episodes are never stacked.
+func parseStackPart(videoPath string) (name, title string, part int, ok bool) {
+	base := filepath.Base(videoPath)
+	if _, _, _, episode := parseEpisodeInfo(strings.TrimSuffix(base, filepath.Ext(base))); episode {
+		return "", "", 0, false
+	}
+	matches := stackPattern.FindStringSubmatch(base)
+	if matches == nil || strings.TrimSpace(matches[1]) == "" {
+		return "", "", 0, false
+	}
+	if number, err := strconv.Atoi(matches[2]); err == nil {
+		part = number
+	} else {
+		part = int(strings.ToLower(matches[2])[0]-'a') + 1
+	}
+	if part < 1 {
+		return "", "", 0, false
+	}
+	return matches[1] + matches[3] + matches[4], strings.TrimSpace(matches[1]), part, true
+}
+
+// isStackPart reports whether a video is named like a part of a stack
+func isStackPart(videoPath string) bool {
+	_, _, _, ok := parseStackPart(videoPath)
+	return ok
+}
+
+// stackItems merges the parts of stacked videos in the same directory into one
+// item. The item keeps the ID of a part known before, preferring the first part, so
+// playback states survive a rescan; the items of the other parts are dropped. Its
+// NFO, poster and subtitle are looked up under the stack name (Movie.nfo) first,
+// then under the first part. Parts with the same number make a stack ambiguous, it
+// is left alone.
+func stackItems(found map[string]MediaItem, files map[string][]MediaFile, appeared map[string]bool, index *dirIndex) {
+	type member struct {
+		id   string
+		part int
+	}
+	stacks := map[string][]member{}
+	for id, item := range found {
+		name, _, part, ok := parseStackPart(item.VideoPath)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(filepath.Join(filepath.Dir(item.VideoPath), name))
+		stacks[key] = append(stacks[key], member{id: id, part: part})
+	}
+
+	for _, members := range stacks {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].part < members[j].part })
+		distinct := true
+		for i := 1; i < len(members); i++ {
+			if members[i].part == members[i-1].part {
+				distinct = false
+			}
+		}
+		if !distinct {
+			continue
+		}
+
+		id := members[0].id
+		allAppeared := true
+		for _, m := range members {
+			if !appeared[m.id] {
+				allAppeared = false
+				id = m.id
+				break
+			}
+		}
+
+		first := found[members[0].id]
+		name, title, _, _ := parseStackPart(first.VideoPath)
+		stack := first
+		stack.ID = id
+		stack.Title = title
+		stack.Size = 0
+		stack.Parts = make([]MediaPart, 0, len(members))
+		for i, m := range members {
+			part := found[m.id]
+			stack.Parts = append(stack.Parts, MediaPart{
+				Part:      i + 1,
+				VideoPath: part.VideoPath,
+				Size:      part.Size,
+				Modified:  part.Modified,
+			})
+			stack.Size += part.Size
+			if part.Modified.After(stack.Modified) {
+				stack.Modified = part.Modified
+			}
+		}
+
+		stackFiles := []MediaFile{files[first.ID][0]}
+		sidecars := sidecarFiles(filepath.Join(filepath.Dir(first.VideoPath), name), index)
+		for _, file := range files[first.ID][1:] {
+			if mediaFilePath(sidecars, file.Kind) == "" {
+				sidecars = append(sidecars, file)
+			}
+		}
+		stackFiles = append(stackFiles, sidecars...)
+		stack.NFOPath = mediaFilePath(stackFiles, MediaFileNFO)
+		stack.PosterPath = mediaFilePath(stackFiles, MediaFilePoster)
+
+		for _, m := range members {
+			delete(found, m.id)
+			delete(files, m.id)
+			delete(appeared, m.id)
+		}
+		found[id] = stack
+		files[id] = stackFiles
+		if allAppeared {
+			appeared[id] = true
+		}
+	}
+}
+
+// partOf returns the file of part n of an item. Items that are not stacked only
+// have part 1, their video.
+func partOf(item MediaItem, n int) (MediaPart, bool) {
+	if len(item.Parts) == 0 {
+		if n != 1 {
+			return MediaPart{}, false
+		}
+		return MediaPart{Part: 1, VideoPath: item.VideoPath, Size: item.Size, Modified: item.Modified}, true
+	}
+	if n < 1 || n > len(item.Parts) {
+		return MediaPart{}, false
+	}
+	return item.Parts[n-1], true
+}
+
+// requestedPart returns the part selected by the part query parameter, the first
+// part without it. It writes the error response for invalid parts.
+func (s *Server) requestedPart(w http.ResponseWriter, r *http.Request, item MediaItem) (MediaPart, bool) {
+	n := 1
+	if value := strings.TrimSpace(r.URL.Query().Get("part")); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil {
+			s.writeError(w, "invalid part", http.StatusBadRequest)
+			return MediaPart{}, false
+		}
+	}
+	part, ok := partOf(item, n)
+	if !ok {
+		s.writeError(w, "part not found", http.StatusNotFound)
+	}
+	return part, ok
+}
+
+// videoPaths returns the files of an item in playback order
+func videoPaths(item MediaItem) []string {
+	if len(item.Parts) == 0 {
+		return []string{item.VideoPath}
+	}
+	paths := make([]string, len(item.Parts))
+	for i, part := range item.Parts {
+		paths[i] = part.VideoPath
+	}
+	return paths
+}
+
+// firstPartState returns the size and modification time of the first video file of
+// an item, which the scan compares to decide whether its fingerprint is current
+func firstPartState(item MediaItem) (int64, time.Time) {
+	if len(item.Parts) > 0 {
+		return item.Parts[0].Size, item.Parts[0].Modified
+	}
+	return item.Size, item.Modified
+}
+
+func mediaPartsEqual(a, b []MediaPart) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Part != b[i].Part || a[i].VideoPath != b[i].VideoPath || a[i].Size != b[i].Size ||
+			a[i].Modified.Unix() != b[i].Modified.Unix() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package server
+
+import "testing"
+
+func TestParseStackPart(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		title string
+		part  int
+		ok    bool
+	}{
+		{path: "/m/Movie.cd1.avi", name: "Movie.avi", title: "Movie", part: 1, ok: true},
+		{path: "/m/Movie.CD2.avi", name: "Movie.avi", title: "Movie", part: 2, ok: true},
+		{path: "/m/Movie cd12.avi", name: "Movie.avi", title: "Movie", part: 12, ok: true},
+		{path: "/m/Movie - part2.mkv", name: "Movie.mkv", title: "Movie", part: 2, ok: true},
+		{path: "/m/Movie_pt3.mkv", name: "Movie.mkv", title: "Movie", part: 3, ok: true},
+		{path: "/m/Movie.dvd1.iso", name: "Movie.iso", title: "Movie", part: 1, ok: true},
+		{path: "/m/Movie-discA.mkv", name: "Movie.mkv", title: "Movie", part: 1, ok: true},
+		{path: "/m/Movie-discB.mkv", name: "Movie.mkv", title: "Movie", part: 2, ok: true},
+		{path: "/m/Movie disk c.mkv", name: "Movie.mkv", title: "Movie", part: 3, ok: true},
+		{path: "/m/Movie.disc-D.mkv", name: "Movie.mkv", title: "Movie", part: 4, ok: true},
+		{path: "/m/Movie.cd1.Extended.mkv", name: "Movie.Extended.mkv", title: "Movie", part: 1, ok: true},
+		// Letters beyond d, part 0 and markers glued to a word are no parts
+		{path: "/m/Movie-discE.mkv"},
+		{path: "/m/Movie.cd0.avi"},
+		{path: "/m/Moviecd1.avi"},
+		{path: "/m/Movie Partagas.mkv"},
+		{path: "/m/Counterpart.mkv"},
+		// Language tags look like pt with a letter
+		{path: "/m/Movie.pt-BR.mkv"},
+		{path: "/m/Movie.pt.mkv"},
+		// Episodes and files without a title are never stacked
+		{path: "/m/Show.S01E01.cd1.mkv"},
+		{path: "/m/Show.1x02.part1.mkv"},
+		{path: "/m/cd1.avi"},
+		{path: "/m/.cd1.avi"},
+	}
+	for _, tt := range tests {
+		name, title, part, ok := parseStackPart(tt.path)
+		if name != tt.name || title != tt.title || part != tt.part || ok != tt.ok {
+			t.Errorf("parseStackPart(%q) = %q, %q, %d, %v; want %q, %q, %d, %v",
+				tt.path, name, title, part, ok, tt.name, tt.title, tt.part, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestScanStacksMultiPartMovies(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	path := func(name string) string { return filepath.Join(root, name) }
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path(name)), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path(name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("Movie/Movie.cd2.avi", "second half")
+	write("Movie/Movie.cd1.avi", "first half!")
+	write("Movie/Movie.nfo", "<movie><title>Movie</title></movie>")
+	write("Lonely.part1.mkv", "no siblings")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	items, err := store.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("GetAll() = %d items; want the stack and the lonely part", len(items))
+	}
+	id, ok, err := store.GetIDByPath(path("Movie/Movie.cd1.avi"))
+	if err != nil || !ok {
+		t.Fatalf("GetIDByPath() = %v, %v", ok, err)
+	}
+	item, ok, err := store.GetByID(id)
+	if err != nil || !ok {
+		t.Fatalf("GetByID() = %v, %v", ok, err)
+	}
+	if item.Title != "Movie" || item.Size != 22 || item.NFOPath != path("Movie/Movie.nfo") || len(item.Parts) != 2 {
+		t.Fatalf("stack = %+v", item)
+	}
+	if item.Parts[0].VideoPath != path("Movie/Movie.cd1.avi") || item.Parts[1].VideoPath != path("Movie/Movie.cd2.avi") || item.Parts[1].Part != 2 {
+		t.Fatalf("parts = %+v", item.Parts)
+	}
+
+	// The stack keeps its ID and the position within a part
+	if err := store.UpsertPlaybackState("", id, 30, 100, time.Now().Unix(), nil, "", 2); err != nil {
+		t.Fatalf("UpsertPlaybackState() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if again, _, _ := store.GetIDByPath(path("Movie/Movie.cd1.avi")); again != id {
+		t.Fatalf("stack ID = %q after rescan; want %q", again, id)
+	}
+	state, ok, err := store.GetPlaybackState("", id, "")
+	if err != nil || !ok || state.Part != 2 || state.PositionSeconds != 30 {
+		t.Fatalf("GetPlaybackState() = %+v, %v, %v; want part 2 at 30s", state, ok, err)
+	}
+
+	// A single part left is a plain item again
+	if err := os.Remove(path("Movie/Movie.cd2.avi")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	item, ok, err = store.GetByID(id)
+	if err != nil || !ok || len(item.Parts) != 0 || item.Size != 11 {
+		t.Fatalf("GetByID() = %+v, %v, %v; want an item without parts", item, ok, err)
+	}
+}
@@ -8,11 +8,31 @@ type MediaStore interface {
 	AddRoot(path, rootType string) (LibraryRoot, error)
 	ListRoots() ([]LibraryRoot, error)
 	RemoveRoot(id string) error
-	StartScanRun(rootID string, startedAt time.Time) (ScanRun, error)
+	// UpdateRoot stores type, exclude patterns, scan interval and extensions of a root
+	UpdateRoot(root LibraryRoot) (bool, error)
+	// Offline roots: a zero time marks the root available again. Offline items keep
+	// the time they were first flagged.
+	SetRootUnavailableSince(id string, since time.Time) error
+	MarkItemsOffline(ids []string, since time.Time) error
+	ClearOfflineItems(ids []string) error
+	// Ignored paths of the last scans; scope limits replacing and listing to a subtree
+	ReplaceIgnoredPaths(scope string, entries []IgnoredPath) error
+	ListIgnoredPaths(scope string) ([]IgnoredPath, error)
+	StartScanRun(rootID, path string, fullScan bool, startedAt time.Time) (ScanRun, error)
 	FinishScanRun(id string, finishedAt time.Time) error
 	FailScanRun(id string, finishedAt time.Time, errMsg string) error
+	CancelScanRun(id string, finishedAt time.Time) error
+	UpdateScanRunProgress(id string, progress ScanProgress) error
+	GetScanRun(id string) (ScanRun, bool, error)
+	ListScanRuns(limit, offset int) ([]ScanRun, int, error)
+	InterruptScanRuns(finishedAt time.Time) (int64, error)
 	SaveItems(items []MediaItem) error
 	DeleteItems(ids []string) error
+	// Incremental scans: size and mtime of videos and their sidecars
+	GetMediaFiles() (map[string][]MediaFile, error)
+	SaveMediaFiles(files map[string][]MediaFile) error
+	// SaveMetadataBatch writes the metadata and file states of a scan in batched transactions
+	SaveMetadataBatch(updates []MetadataUpdate) error
 	GetAll() ([]MediaItem, error)
 	GetAllLimited(limit, offset int, sortBy, query string) ([]MediaItem, error)
 	// Verbesserung 3: Erweiterte Suchfunktionalität
@@ -26,27 +46,31 @@ type MediaStore interface {
 	SaveNFOExtended(mediaID string, nfo *NFO) error
 	GetNFOExtended(mediaID string) (*NFO, bool, error)
 	DeleteNFOExtended(mediaID string) error
-	UpsertPlaybackState(mediaID string, positionSeconds, durationSeconds int64, lastPlayedAt int64, percentComplete *float64, clientID string) error
-	GetPlaybackState(mediaID, clientID string) (*PlaybackState, bool, error)
-	DeletePlaybackState(mediaID, clientID string) error
+	UpsertPlaybackState(userID, mediaID string, positionSeconds, durationSeconds int64, lastPlayedAt int64, percentComplete *float64, clientID string, part int) error
+	GetPlaybackState(userID, mediaID, clientID string) (*PlaybackState, bool, error)
+	DeletePlaybackState(userID, mediaID, clientID string) error
 	// Verbesserung 2: Batch-Operations für Playback-State
-	GetAllPlaybackStates(clientID string, onlyUnfinished bool) ([]PlaybackState, error)
+	GetAllPlaybackStates(userID, clientID string, onlyUnfinished bool) ([]PlaybackState, error)
 	// Verbesserung 4: Duplicate Detection
 	GetDuplicates() ([]DuplicateGroup, error)
+	// Full SHA-256 of videos sharing a fingerprint, stored only while the fingerprint
+	// still matches
+	ListHashCandidates() ([]MediaItem, error)
+	SetContentHash(id, fingerprint, hash string) (bool, error)
 	// Verbesserung 5: Erweiterte Statistiken
 	GetDetailedStats() (*DetailedStats, error)
 
 	// Erweiterung 1: Watched/Unwatched Status
-	MarkWatched(mediaID string, watchedAt time.Time) error
-	UnmarkWatched(mediaID string) error
-	IsWatched(mediaID string) (bool, error)
-	GetWatchedItems(limit, offset int) ([]MediaItem, error)
+	MarkWatched(userID, mediaID string, watchedAt time.Time) error
+	UnmarkWatched(userID, mediaID string) error
+	IsWatched(userID, mediaID string) (bool, error)
+	GetWatchedItems(userID string, limit, offset int) ([]MediaItem, error)
 
 	// Erweiterung 2: Favorites/Bookmarks
-	AddFavorite(mediaID string, addedAt time.Time) error
-	RemoveFavorite(mediaID string) error
-	IsFavorite(mediaID string) (bool, error)
-	GetFavorites(limit, offset int) ([]MediaItem, error)
+	AddFavorite(userID, mediaID string, addedAt time.Time) error
+	RemoveFavorite(userID, mediaID string) error
+	IsFavorite(userID, mediaID string) (bool, error)
+	GetFavorites(userID string, limit, offset int) ([]MediaItem, error)
 
 	// Erweiterung 3: Recently Added
 	GetRecentlyAdded(limit int, days int, itemType string) ([]MediaItem, error)
@@ -67,6 +91,15 @@ type MediaStore interface {
 
 	// Multi-Root Support
 	GetItemsByRoots(rootIDs []string, limit, offset int, sortBy, query string) ([]MediaItem, error)
+	GetUserRootAccess(userID string) (RootAccess, bool, error)
+	SetUserRootAccess(userID string, access RootAccess) error
+
+	// Parental controls
+	GetUserParentalControls(userID string) (ParentalControls, bool, error)
+	SetUserParentalControls(userID string, controls ParentalControls) error
+	GetContentRatings() (map[string]string, error)
+	GetContentRating(mediaID string) (*ContentRating, error)
+	SetContentRatingOverride(mediaID, rating string, updatedAt time.Time) error
 
 	// NFO-based filtering and TV Show grouping
 	GetItemsByNFOType(nfoType string, limit, offset int, sortBy, query string) ([]MediaItem, error)
@@ -92,7 +125,7 @@ type MediaStore interface {
 	GetAllTranscodingProfiles() ([]TranscodingProfile, error)
 	DeleteTranscodingProfile(id string) error
 	SaveTranscodingCache(cache TranscodingCache) error
-	GetTranscodingCache(mediaID, profileID string) (*TranscodingCache, bool, error)
+	GetTranscodingCache(mediaID, profileID string, version int) (*TranscodingCache, bool, error)
 	DeleteTranscodingCache(id string) error
 	CleanOldTranscodingCache(olderThan time.Time) error
 
@@ -114,7 +147,42 @@ type MediaStore interface {
 	UpdateEpisode(episode Episode) error
 	DeleteEpisode(id string) error
 	GetNextUnwatchedEpisode(showID, userID string) (*Episode, bool, error)
+	GetTVShowMediaIDs() (map[string][]string, error)
 	AutoGroupEpisodes() error
+	AutoGroupShows(showTitles []string) error
+	ShowTitlesForItems(mediaIDs []string) ([]string, error)
+
+	// Audit log
+	AddAuditEntry(entry AuditEntry) error
+	ListAuditEntries(filter AuditFilter, limit, offset int) ([]AuditEntry, int, error)
+	PruneAuditEntries(before time.Time) (int64, error)
+}
+
+// Kinds of files tracked per media item
+const (
+	MediaFileVideo    = "video"
+	MediaFileNFO      = "nfo"
+	MediaFileShowNFO  = "show_nfo"
+	MediaFilePoster   = "poster"
+	MediaFileSubtitle = "subtitle"
+)
+
+// MediaFile records the state of a video or sidecar file at the last metadata refresh.
+// Scans only re-read the metadata of items whose files changed since.
+type MediaFile struct {
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// MetadataUpdate is the refreshed metadata of one item. A nil NFO removes the stored
+// metadata, unless KeepNFO is set, e.g. because the NFO could not be parsed.
+type MetadataUpdate struct {
+	MediaID string
+	NFO     *NFO
+	KeepNFO bool
+	Files   []MediaFile
 }
 
 type LibraryRoot struct {
@@ -122,22 +190,115 @@ type LibraryRoot struct {
 	Path      string    `json:"path"`
 	Type      string    `json:"type"`
 	CreatedAt time.Time `json:"createdAt"`
+	// Exclude holds gitignore-style patterns relative to the root
+	Exclude []string `json:"exclude"`
+	// ScanIntervalSeconds overrides -scan-interval; 0 uses it, a negative value
+	// disables periodic scans of the root
+	ScanIntervalSeconds int64 `json:"scanIntervalSeconds"`
+	// Extensions overrides -extensions when not empty
+	Extensions []string `json:"extensions"`
+	// Available is false while the root path is missing or empty although the library
+	// holds items of it, e.g. an unmounted disk; those items are flagged offline
+	Available        bool      `json:"available"`
+	UnavailableSince time.Time `json:"unavailableSince,omitzero"`
+}
+
+// RootAccess lists the library roots a user may see. AllRoots grants every root,
+// including roots added later.
+type RootAccess struct {
+	AllRoots bool     `json:"allRoots"`
+	RootIDs  []string `json:"rootIds"`
+}
+
+// ParentalControls limits the content a user may see. MaxRating is a certification
+// such as "FSK 12", "PG-13" or "UK:15"; an empty MaxRating means no limit.
+// UnratedPolicy decides about items without a rating ("allow" or "block").
+type ParentalControls struct {
+	MaxRating     string `json:"maxRating"`
+	UnratedPolicy string `json:"unratedPolicy"`
 }
 
+// ContentRating is the certification of a media item. An admin override takes
+// precedence over the rating from the NFO.
+type ContentRating struct {
+	MediaID    string `json:"mediaId"`
+	NFORating  string `json:"nfoRating,omitempty"`
+	Override   string `json:"override,omitempty"`
+	Effective  string `json:"effective,omitempty"`
+	MinimumAge *int   `json:"minimumAge,omitempty"`
+}
+
+// AuditEntry records an administrative or security-relevant action. Actor is the
+// username, or the attempted username for failed logins.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	ActorID   string    `json:"actorId,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Outcome   string    `json:"outcome"`
+}
+
+// AuditFilter narrows ListAuditEntries; empty fields match everything. Action also
+// matches all actions below it, e.g. "auth.user" matches "auth.user.delete".
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+}
+
+// States of a scan run
+const (
+	ScanRunning  = "running"
+	ScanSuccess  = "success"
+	ScanFailed   = "failed"
+	ScanCanceled = "canceled"
+)
+
 type ScanRun struct {
-	ID         string    `json:"id"`
-	RootID     string    `json:"rootId"`
-	StartedAt  time.Time `json:"startedAt"`
-	FinishedAt time.Time `json:"finishedAt"`
-	Status     string    `json:"status"`
-	Error      string    `json:"error,omitempty"`
+	ID         string       `json:"id"`
+	RootID     string       `json:"rootId"`
+	Path       string       `json:"path,omitempty"`
+	FullScan   bool         `json:"fullScan"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Status     string       `json:"status"`
+	Error      string       `json:"error,omitempty"`
+	Progress   ScanProgress `json:"progress"`
+}
+
+// ScanProgress counts the work of a scan; CurrentPath is only set while it runs
+type ScanProgress struct {
+	DirsVisited int `json:"dirsVisited"`
+	FilesFound  int `json:"filesFound"`
+	Added       int `json:"added"`
+	Updated     int `json:"updated"`
+	Removed     int `json:"removed"`
+	// Moved counts items that kept their ID after a rename or move
+	Moved       int    `json:"moved"`
+	CurrentPath string `json:"currentPath,omitempty"`
 }
 
 // Verbesserung 4: Duplicate Detection
 type DuplicateGroup struct {
-	StableKey string      `json:"stableKey"`
-	Items     []MediaItem `json:"items"`
-	Count     int         `json:"count"`
+	// Kind is DuplicateContent for copies of the same file and DuplicateIMDb for
+	// different files of the same title, e.g. two encodes of a movie
+	Kind string `json:"kind"`
+	// Key is the fingerprint or the IMDb ID, with season and episode for episodes
+	Key   string      `json:"key"`
+	Items []MediaItem `json:"items"`
+	Count int         `json:"count"`
+	// TotalSize is the size of all items; ReclaimableSize what deleting all but
+	// one copy (or all but the largest file) would free
+	TotalSize       int64 `json:"totalSize"`
+	ReclaimableSize int64 `json:"reclaimableSize"`
+	// Verified reports that the full SHA-256 of all copies is known and equal
+	Verified bool `json:"verified"`
 }
 
 // Verbesserung 5: Erweiterte Statistiken
@@ -168,9 +329,11 @@ type Collection struct {
 }
 
 // Verbesserung 1: User types
+// User is the compatibility view of an account in auth_users used by /users.
 type User struct {
 	ID         string    `json:"id"`
 	Name       string    `json:"name"`
+	IsAdmin    bool      `json:"isAdmin"`
 	CreatedAt  time.Time `json:"createdAt"`
 	LastActive time.Time `json:"lastActive,omitempty"`
 }
@@ -196,6 +359,7 @@ type TranscodingCache struct {
 	ID           string    `json:"id"`
 	MediaID      string    `json:"mediaId"`
 	ProfileID    string    `json:"profileId"`
+	Version      int       `json:"version"`
 	CachePath    string    `json:"cachePath"`
 	CreatedAt    time.Time `json:"createdAt"`
 	LastAccessed time.Time `json:"lastAccessed"`
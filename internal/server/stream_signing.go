@@ -0,0 +1,224 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+const (
+	streamURLDefaultTTL = 4 * time.Hour
+	streamURLMaxTTL     = 24 * time.Hour
+
+	streamParamUser    = "uid"
+	streamParamExpires = "exp"
+	streamParamSig     = "sig"
+)
+
+// streamContentParams select what a stream delivers. Their values are signed, so a
+// signed URL cannot be switched to another profile, version or part.
+var streamContentParams = []string{"profile", "version", "part"}
+
+// streamSigner mints and verifies HMAC-signed stream URLs. A signature is bound to
+// an item, a user, an expiry and the content parameters, so it also covers the HLS
+// segments of that stream.
+// The key is generated per process; signed URLs become invalid after a restart.
+type streamSigner struct {
+	key []byte
+}
+
+func newStreamSigner() (*streamSigner, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &streamSigner{key: key}, nil
+}
+
+func (ss *streamSigner) signature(itemID, userID string, expires int64, query url.Values) string {
+	mac := hmac.New(sha256.New, ss.key)
+	mac.Write([]byte(itemID + "\n" + userID + "\n" + strconv.FormatInt(expires, 10) + "\n" + streamContent(query).Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// streamContent returns the content parameters of query
+func streamContent(query url.Values) url.Values {
+	content := url.Values{}
+	for _, name := range streamContentParams {
+		if values, ok := query[name]; ok {
+			content[name] = values
+		}
+	}
+	return content
+}
+
+// sign adds the signature parameters to query, covering its content parameters
+func (ss *streamSigner) sign(query url.Values, itemID, userID string, expiresAt time.Time) {
+	expires := expiresAt.Unix()
+	query.Set(streamParamUser, userID)
+	query.Set(streamParamExpires, strconv.FormatInt(expires, 10))
+	query.Set(streamParamSig, ss.signature(itemID, userID, expires, query))
+}
+
+// verify checks the signature parameters of query and returns the bound user ID.
+// Parameters that are neither signature nor content parameters are rejected.
+func (ss *streamSigner) verify(query url.Values, itemID string, now time.Time) (string, bool) {
+	for name := range query {
+		switch name {
+		case streamParamUser, streamParamExpires, streamParamSig:
+		default:
+			if !slices.Contains(streamContentParams, name) {
+				return "", false
+			}
+		}
+	}
+	userID := query.Get(streamParamUser)
+	sig := query.Get(streamParamSig)
+	expires, err := strconv.ParseInt(query.Get(streamParamExpires), 10, 64)
+	if userID == "" || sig == "" || err != nil {
+		return "", false
+	}
+	if now.Unix() > expires {
+		return "", false
+	}
+	expected := ss.signature(itemID, userID, expires, query)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "", false
+	}
+	return userID, true
+}
+
+// streamItemID returns the item ID for /items/{id}/stream, /items/{id}/stream.m3u8
+// and /items/{id}/stream/{asset}, the only routes that accept signed URLs.
+func streamItemID(requestPath string) (string, bool) {
+	if !strings.HasPrefix(requestPath, "/items/") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(requestPath, "/items/"), "/")
+	if len(parts) < 2 || parts[0] == "" {
+		return "", false
+	}
+	if parts[1] != "stream" && parts[1] != "stream.m3u8" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// sessionFromStreamSignature authenticates a signed stream request. The resulting
+// session is limited to reads.
+func (s *Server) sessionFromStreamSignature(r *http.Request) (*auth.Session, bool) {
+	if s.streamSigner == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return nil, false
+	}
+	itemID, ok := streamItemID(r.URL.Path)
+	if !ok {
+		return nil, false
+	}
+	userID, ok := s.streamSigner.verify(r.URL.Query(), itemID, time.Now())
+	if !ok {
+		return nil, false
+	}
+	user, err := s.authManager.GetUser(userID)
+	if err != nil {
+		return nil, false
+	}
+	return &auth.Session{
+		UserID:   user.ID,
+		Username: user.Username,
+		Scopes:   []string{auth.ScopeReadOnly},
+	}, true
+}
+
+// hlsSegmentQuery returns the query appended to URIs inside a rewritten HLS playlist.
+// When the playlist was requested with a header, the segment URIs are signed for the
+// requesting user so the rest of the HLS session works without headers; they keep
+// only the content parameters of the playlist.
+func (s *Server) hlsSegmentQuery(r *http.Request, itemID string) string {
+	if s.streamSigner == nil || r.URL.Query().Get(streamParamSig) != "" {
+		return r.URL.RawQuery
+	}
+	userID := sessionUserID(r)
+	if userID == "" {
+		return r.URL.RawQuery
+	}
+	query := streamContent(r.URL.Query())
+	s.streamSigner.sign(query, itemID, userID, time.Now().Add(streamURLDefaultTTL))
+	return query.Encode()
+}
+
+// handleStreamURL mints signed stream URLs for /items/{id}/stream-url
+func (s *Server) handleStreamURL(w http.ResponseWriter, r *http.Request, item MediaItem) {
+	if r.Method != http.MethodPost {
+		s.methodNotAllowed(w)
+		return
+	}
+	if s.streamSigner == nil {
+		s.writeError(w, "authentication not available", http.StatusNotImplemented)
+		return
+	}
+
+	userID := sessionUserID(r)
+	if userID == "" {
+		s.writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		TTLSeconds int64  `json:"ttlSeconds"`
+		Profile    string `json:"profile"`
+		Version    int    `json:"version"`
+		Part       int    `json:"part"`
+	}
+	// The body is optional
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		s.writeError(w, errBadRequest, http.StatusBadRequest)
+		return
+	}
+
+	ttl := streamURLDefaultTTL
+	if payload.TTLSeconds > 0 {
+		ttl = time.Duration(payload.TTLSeconds) * time.Second
+	}
+	if ttl > streamURLMaxTTL {
+		ttl = streamURLMaxTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	query := url.Values{}
+	if profile := strings.TrimSpace(payload.Profile); profile != "" {
+		query.Set("profile", profile)
+	}
+	if payload.Version != 0 {
+		if _, ok := versionOf(item, payload.Version); !ok {
+			s.writeError(w, "version not found", http.StatusNotFound)
+			return
+		}
+		query.Set("version", strconv.Itoa(payload.Version))
+	}
+	if payload.Part != 0 {
+		if _, ok := partOf(item, payload.Part); !ok {
+			s.writeError(w, "part not found", http.StatusNotFound)
+			return
+		}
+		query.Set("part", strconv.Itoa(payload.Part))
+	}
+	s.streamSigner.sign(query, item.ID, userID, expiresAt)
+
+	base := "/items/" + url.PathEscape(item.ID)
+	writeJSON(w, r, map[string]any{
+		"url":       base + "/stream?" + query.Encode(),
+		"hlsUrl":    base + "/stream.m3u8?" + query.Encode(),
+		"expiresAt": time.Unix(expiresAt.Unix(), 0),
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+func newTestSigner(t *testing.T) *streamSigner {
+	t.Helper()
+	signer, err := newStreamSigner()
+	if err != nil {
+		t.Fatalf("newStreamSigner() error = %v", err)
+	}
+	return signer
+}
+
+func TestStreamSignerVerify(t *testing.T) {
+	signer := newTestSigner(t)
+	now := time.Now()
+	signed := url.Values{"profile": {"720p"}}
+	signer.sign(signed, "item1", "user1", now.Add(time.Hour))
+
+	if userID, ok := signer.verify(signed, "item1", now); !ok || userID != "user1" {
+		t.Fatalf("verify() = %q, %v; want user1", userID, ok)
+	}
+
+	with := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range signed {
+			query[k] = append([]string(nil), v...)
+		}
+		if value == "" {
+			query.Del(key)
+		} else {
+			query.Set(key, value)
+		}
+		return query
+	}
+	tests := []struct {
+		name   string
+		signer *streamSigner
+		query  url.Values
+		itemID string
+		now    time.Time
+	}{
+		{"other user", signer, with(streamParamUser, "user2"), "item1", now},
+		{"other item", signer, signed, "item2", now},
+		{"extended expiry", signer, with(streamParamExpires, "99999999999"), "item1", now},
+		{"tampered signature", signer, with(streamParamSig, strings.Repeat("A", 43)), "item1", now},
+		{"missing signature", signer, with(streamParamSig, ""), "item1", now},
+		{"missing user", signer, with(streamParamUser, ""), "item1", now},
+		{"invalid expiry", signer, with(streamParamExpires, "soon"), "item1", now},
+		{"expired", signer, signed, "item1", now.Add(2 * time.Hour)},
+		{"other key", newTestSigner(t), signed, "item1", now},
+		{"other profile", signer, with("profile", "1080p"), "item1", now},
+		{"removed profile", signer, with("profile", ""), "item1", now},
+		{"added version", signer, with("version", "2"), "item1", now},
+		{"added part", signer, with("part", "2"), "item1", now},
+		{"unsigned parameter", signer, with("clientId", "tv"), "item1", now},
+	}
+	for _, tt := range tests {
+		if userID, ok := tt.signer.verify(tt.query, tt.itemID, tt.now); ok {
+			t.Errorf("verify(%s) = %q, true; want rejected", tt.name, userID)
+		}
+	}
+}
+
+func TestStreamItemID(t *testing.T) {
+	tests := []struct {
+		path string
+		id   string
+		ok   bool
+	}{
+		{"/items/abc/stream", "abc", true},
+		{"/items/abc/stream.m3u8", "abc", true},
+		{"/items/abc/stream/segment_001.ts", "abc", true},
+		{"/items/abc/playback", "", false},
+		{"/items/abc", "", false},
+		{"/items//stream", "", false},
+		{"/library/abc/stream", "", false},
+	}
+	for _, tt := range tests {
+		if id, ok := streamItemID(tt.path); id != tt.id || ok != tt.ok {
+			t.Errorf("streamItemID(%s) = %q, %v; want %q, %v", tt.path, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestHLSSegmentQuery(t *testing.T) {
+	signer := newTestSigner(t)
+	s := &Server{streamSigner: signer}
+	session := &auth.Session{UserID: "user1"}
+
+	// A playlist requested with a header gets segment URIs signed for the user
+	r := httptest.NewRequest(http.MethodGet, "/items/item1/stream.m3u8?profile=720p&version=2&cache=0", nil)
+	r = r.WithContext(withSession(r.Context(), session))
+	rawQuery := s.hlsSegmentQuery(r, "item1")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatalf("hlsSegmentQuery() = %q: %v", rawQuery, err)
+	}
+	if userID, ok := signer.verify(query, "item1", time.Now()); !ok || userID != "user1" || query.Get("profile") != "720p" || query.Get("version") != "2" {
+		t.Fatalf("hlsSegmentQuery() = %q; want profile, version and a signature for user1", rawQuery)
+	}
+	if query.Has("cache") {
+		t.Fatalf("hlsSegmentQuery() = %q; want only content parameters signed", rawQuery)
+	}
+
+	playlist := "#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",URI=\"audio_0.m3u8\"\n#EXTINF:6.0,\nsegment_000.ts\n/absolute.ts\n"
+	rewritten := string(rewriteHLSPlaylist([]byte(playlist), hlsBasePath(r.URL.Path), rawQuery))
+	for _, want := range []string{
+		`URI="/items/item1/stream/audio_0.m3u8?` + rawQuery + `"`,
+		"\n/items/item1/stream/segment_000.ts?" + rawQuery + "\n",
+		"\n/absolute.ts\n",
+	} {
+		if !strings.Contains(rewritten, want) {
+			t.Fatalf("rewriteHLSPlaylist() = %q; want %q", rewritten, want)
+		}
+	}
+
+	// Signed requests pass their signature on unchanged, anonymous ones stay unsigned
+	signed := httptest.NewRequest(http.MethodGet, "/items/item1/stream.m3u8?"+rawQuery, nil)
+	if got := s.hlsSegmentQuery(signed, "item1"); got != rawQuery {
+		t.Fatalf("hlsSegmentQuery(signed) = %q; want %q", got, rawQuery)
+	}
+	anonymous := httptest.NewRequest(http.MethodGet, "/items/item1/stream.m3u8?profile=720p", nil)
+	if got := s.hlsSegmentQuery(anonymous, "item1"); got != "profile=720p" {
+		t.Fatalf("hlsSegmentQuery(anonymous) = %q; want profile=720p", got)
+	}
+	if got := (&Server{}).hlsSegmentQuery(r, "item1"); got != r.URL.RawQuery {
+		t.Fatalf("hlsSegmentQuery(without signer) = %q; want %q", got, r.URL.RawQuery)
+	}
+}
@@ -36,6 +36,7 @@ type TranscodingJob struct {
 	ID         string
 	MediaID    string
 	ProfileID  string
+	Version    int
 	Status     string // "pending", "running", "completed", "failed"
 	Progress   float64
 	StartedAt  time.Time
@@ -51,6 +52,7 @@ type TranscodingJobSummary struct {
 	ID         string    `json:"id"`
 	MediaID    string    `json:"mediaId"`
 	ProfileID  string    `json:"profileId"`
+	Version    int       `json:"version,omitempty"`
 	Status     string    `json:"status"`
 	Progress   float64   `json:"progress"`
 	StartedAt  time.Time `json:"startedAt"`
@@ -70,13 +72,14 @@ func NewTranscodingManager(ffmpegPath, cacheDir string, store MediaStore) *Trans
 	}
 }
 
-// StartTranscoding starts a transcoding job
-func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, item MediaItem, profile TranscodingProfile, selection AudioSelection) (*TranscodingJob, error) {
+// StartTranscoding starts a transcoding job for a version of an item, whose video
+// is the one of item
+func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, version int, item MediaItem, profile TranscodingProfile, selection AudioSelection) (*TranscodingJob, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
 	// Check if job already exists
-	jobID := generateJobID(mediaID, profileID)
+	jobID := generateJobID(versionKey(mediaID, version), profileID)
 	if job, exists := tm.activeJobs[jobID]; exists {
 		if job.Status == "running" || job.Status == "pending" {
 			return job, nil
@@ -85,7 +88,7 @@ func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, item M
 
 	// Check cache first
 	if tm.store != nil {
-		cached, ok, err := tm.store.GetTranscodingCache(mediaID, profileID)
+		cached, ok, err := tm.store.GetTranscodingCache(mediaID, profileID, version)
 		if err == nil && ok {
 			// Check if cache file still exists
 			if _, err := os.Stat(cached.CachePath); err == nil {
@@ -97,6 +100,7 @@ func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, item M
 					ID:         jobID,
 					MediaID:    mediaID,
 					ProfileID:  profileID,
+					Version:    version,
 					Status:     "completed",
 					Progress:   100,
 					OutputPath: cached.CachePath,
@@ -111,7 +115,7 @@ func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, item M
 	}
 
 	// Prepare output path
-	outputPath := filepath.Join(tm.cacheDir, fmt.Sprintf("%s_%s.%s", mediaID, profileID, profile.Container))
+	outputPath := filepath.Join(tm.cacheDir, fmt.Sprintf("%s_%s.%s", versionKey(mediaID, version), profileID, profile.Container))
 
 	// Create job
 	ctx, cancel := context.WithCancel(context.Background())
@@ -119,6 +123,7 @@ func (tm *TranscodingManager) StartTranscoding(mediaID, profileID string, item M
 		ID:        jobID,
 		MediaID:   mediaID,
 		ProfileID: profileID,
+		Version:   version,
 		Status:    "pending",
 		Progress:  0,
 		StartedAt: time.Now(),
@@ -150,8 +155,11 @@ func (tm *TranscodingManager) runTranscoding(job *TranscodingJob, item MediaItem
 		audioDecision.DecisionNote,
 	)
 
+	// The parts of a stack are transcoded as one stream
+	inputPath, inputFormat, err := transcodeInput(item, filepath.Dir(outputPath))
 	opts := ffmpeg.TranscodeOptions{
-		InputPath:          item.VideoPath,
+		InputPath:          inputPath,
+		InputFormat:        inputFormat,
 		OutputPath:         outputPath,
 		VideoCodec:         profile.VideoCodec,
 		AudioCodec:         audioDecision.Codec,
@@ -166,7 +174,10 @@ func (tm *TranscodingManager) runTranscoding(job *TranscodingJob, item MediaItem
 		Container:          profile.Container,
 	}
 
-	result, err := ffmpeg.Transcode(job.ctx, tm.ffmpegPath, opts)
+	var result *ffmpeg.TranscodeResult
+	if err == nil {
+		result, err = ffmpeg.Transcode(job.ctx, tm.ffmpegPath, opts)
+	}
 
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
@@ -194,6 +205,7 @@ func (tm *TranscodingManager) runTranscoding(job *TranscodingJob, item MediaItem
 			ID:           job.ID,
 			MediaID:      job.MediaID,
 			ProfileID:    job.ProfileID,
+			Version:      job.Version,
 			CachePath:    outputPath,
 			CreatedAt:    time.Now(),
 			LastAccessed: time.Now(),
@@ -255,12 +267,13 @@ func (tm *TranscodingManager) CleanupOldCache() error {
 	return tm.store.CleanOldTranscodingCache(cutoff)
 }
 
-// StartHLSTranscoding starts HLS transcoding for adaptive streaming
-func (tm *TranscodingManager) StartHLSTranscoding(mediaID, profileID string, item MediaItem, profile TranscodingProfile, selection AudioSelection) (*TranscodingJob, error) {
+// StartHLSTranscoding starts HLS transcoding for adaptive streaming of a version of
+// an item, whose video is the one of item
+func (tm *TranscodingManager) StartHLSTranscoding(mediaID, profileID string, version int, item MediaItem, profile TranscodingProfile, selection AudioSelection) (*TranscodingJob, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	jobID := generateJobID(mediaID, profileID) + "_hls"
+	jobID := generateJobID(versionKey(mediaID, version), profileID) + "_hls"
 
 	// Check if job already exists
 	if job, exists := tm.activeJobs[jobID]; exists {
@@ -270,7 +283,7 @@ func (tm *TranscodingManager) StartHLSTranscoding(mediaID, profileID string, ite
 	}
 
 	// Create HLS directory
-	hlsDir := filepath.Join(tm.cacheDir, "hls", mediaID, profileID)
+	hlsDir := filepath.Join(tm.cacheDir, "hls", versionKey(mediaID, version), profileID)
 	if err := os.MkdirAll(hlsDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create HLS directory: %w", err)
 	}
@@ -284,6 +297,7 @@ func (tm *TranscodingManager) StartHLSTranscoding(mediaID, profileID string, ite
 		ID:         jobID,
 		MediaID:    mediaID,
 		ProfileID:  profileID,
+		Version:    version,
 		Status:     "pending",
 		Progress:   0,
 		StartedAt:  time.Now(),
@@ -309,9 +323,11 @@ func (tm *TranscodingManager) runHLSTranscoding(job *TranscodingJob, item MediaI
 	hlsDir := filepath.Dir(playlistPath)
 	videoPlaylistPath := filepath.Join(hlsDir, "video.m3u8")
 	audioVariants := tm.collectHLSAudioVariants(item, profile, selection)
+	inputPath, inputFormat, err := transcodeInput(item, hlsDir)
 
 	videoOpts := ffmpeg.TranscodeOptions{
-		InputPath:    item.VideoPath,
+		InputPath:    inputPath,
+		InputFormat:  inputFormat,
 		OutputPath:   videoPlaylistPath,
 		VideoCodec:   profile.VideoCodec,
 		Resolution:   profile.Resolution,
@@ -320,7 +336,9 @@ func (tm *TranscodingManager) runHLSTranscoding(job *TranscodingJob, item MediaI
 		DisableAudio: true,
 	}
 
-	_, err := ffmpeg.TranscodeToHLS(job.ctx, tm.ffmpegPath, videoOpts, 6)
+	if err == nil {
+		_, err = ffmpeg.TranscodeToHLS(job.ctx, tm.ffmpegPath, videoOpts, 6)
+	}
 	if err == nil {
 		for i := range audioVariants {
 			variant := audioVariants[i]
@@ -340,7 +358,8 @@ func (tm *TranscodingManager) runHLSTranscoding(job *TranscodingJob, item MediaI
 				audioDecision.DecisionNote,
 			)
 			audioOpts := ffmpeg.TranscodeOptions{
-				InputPath:          item.VideoPath,
+				InputPath:          inputPath,
+				InputFormat:        inputFormat,
 				OutputPath:         filepath.Join(hlsDir, variant.PlaylistFilename),
 				AudioCodec:         audioDecision.Codec,
 				AudioBitrateKbps:   audioDecision.BitrateKbps,
@@ -382,8 +401,8 @@ func (tm *TranscodingManager) runHLSTranscoding(job *TranscodingJob, item MediaI
 	job.FinishedAt = time.Now()
 }
 
-// ServeHLSPlaylist serves an HLS playlist file
-func (tm *TranscodingManager) ServeHLSPlaylist(w http.ResponseWriter, r *http.Request, playlistPath string) {
+// ServeHLSPlaylist serves an HLS playlist file. segmentQuery is appended to relative URIs.
+func (tm *TranscodingManager) ServeHLSPlaylist(w http.ResponseWriter, r *http.Request, playlistPath, segmentQuery string) {
 	// Check if file exists
 	content, err := os.ReadFile(playlistPath)
 	if err != nil {
@@ -392,7 +411,7 @@ func (tm *TranscodingManager) ServeHLSPlaylist(w http.ResponseWriter, r *http.Re
 	}
 
 	basePath := hlsBasePath(r.URL.Path)
-	mapped := rewriteHLSPlaylist(content, basePath, r.URL.RawQuery)
+	mapped := rewriteHLSPlaylist(content, basePath, segmentQuery)
 
 	// Set appropriate headers
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
@@ -500,6 +519,19 @@ func (tm *TranscodingManager) ServeTranscodedFile(w http.ResponseWriter, r *http
 
 // Helper functions
 
+// transcodeInput returns the ffmpeg input of an item. The parts of a stacked item
+// are read through a concat list written to dir, so they play as one stream.
+func transcodeInput(item MediaItem, dir string) (string, string, error) {
+	if len(item.Parts) < 2 {
+		return item.VideoPath, "", nil
+	}
+	listPath := filepath.Join(dir, item.ID+"_parts.ffconcat")
+	if err := ffmpeg.WriteConcatList(listPath, videoPaths(item)); err != nil {
+		return "", "", fmt.Errorf("failed to write concat list: %w", err)
+	}
+	return listPath, "concat", nil
+}
+
 func generateJobID(mediaID, profileID string) string {
 	sum := sha1.Sum([]byte(mediaID + ":" + profileID))
 	return "job_" + hex.EncodeToString(sum[:8])
@@ -514,6 +546,7 @@ func summarizeJob(job *TranscodingJob) TranscodingJobSummary {
 		ID:         job.ID,
 		MediaID:    job.MediaID,
 		ProfileID:  job.ProfileID,
+		Version:    job.Version,
 		Status:     job.Status,
 		Progress:   job.Progress,
 		StartedAt:  job.StartedAt,
@@ -11,6 +11,8 @@ type TVShowGroup struct {
 	LastModified   time.Time `json:"lastModified"`
 	Year           string    `json:"year,omitempty"`
 	FirstEpisodeID string    `json:"firstEpisodeId"` // For poster lookup
+	// EpisodeIDs lists all episodes of the show for access checks
+	EpisodeIDs []string `json:"-"`
 }
 
 // TVSeasonGroup represents a season within a TV show
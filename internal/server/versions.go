@@ -0,0 +1,484 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// editionPattern matches the edition tag of Plex, e.g. Movie {edition-Extended}.mkv
+var editionPattern = regexp.MustCompile(`(?i)\s*\{edition-([^}]*)\}`)
+
+// resolutionSuffix matches a resolution at the end of a file name, e.g. Movie.2160p
+// or Movie [4K]
+var resolutionSuffix = regexp.MustCompile(`(?i)[ ._\[(-]+([0-9]{3,4}p|4k|uhd)[\])]?$`)
+
+// editionWords mark a label after " - " as an edition, e.g. Movie - Director's Cut
+var editionWords = map[string]bool{
+	"cut":        true,
+	"edition":    true,
+	"extended":   true,
+	"imax":       true,
+	"redux":      true,
+	"remastered": true,
+	"theatrical": true,
+	"uncut":      true,
+	"unrated":    true,
+	"version":    true,
+}
+
+// qualityWords describe the encoding of a version rather than its edition
+var qualityWords = map[string]bool{
+	"dv":    true,
+	"hdr":   true,
+	"hdr10": true,
+	"remux": true,
+	"sdr":   true,
+}
+
+// resolutionClasses are the common video sizes, the largest first
+var resolutionClasses = [][2]int{
+	{7680, 4320},
+	{3840, 2160},
+	{2560, 1440},
+	{1920, 1080},
+	{1280, 720},
+	{1024, 576},
+	{720, 480},
+}
+
+// resolutionName returns the class of a video size like 1080p. Widescreen videos
+// count by their width, 1920x800 is 1080p.
+func resolutionName(width, height int) string {
+	for _, class := range resolutionClasses {
+		if width*10 >= class[0]*9 || height*10 >= class[1]*9 {
+			return strconv.Itoa(class[1]) + "p"
+		}
+	}
+	if height > 0 {
+		return strconv.Itoa(height) + "p"
+	}
+	return ""
+}
+
+// resolutionHeight returns the height of a resolution label like 1080p or 4K, 0 for
+// anything else
+func resolutionHeight(label string) int {
+	label = strings.ToLower(strings.Trim(label, "[]()"))
+	switch label {
+	case "4k", "uhd":
+		return 2160
+	case "fhd":
+		return 1080
+	case "hd":
+		return 720
+	case "sd":
+		return 480
+	}
+	if number, ok := strings.CutSuffix(label, "p"); ok {
+		if height, err := strconv.Atoi(number); err == nil && height >= 240 && height <= 4320 {
+			return height
+		}
+	}
+	return 0
+}
+
+// parseVersionName splits the file name of a video into the title and the label of
+// its version: the edition from {edition-...} or a label after " - ", and the
+// height of a resolution like 4K or 2160p. A label after " - " only counts when it
+// holds a resolution or an edition word, or when the title is the folder name;
+// Mission Impossible - Fallout.mkv keeps its title.
+func parseVersionName(videoPath string) (title, edition string, height int) {
+	base := filepath.Base(videoPath)
+	title = strings.TrimSuffix(base, filepath.Ext(base))
+	if matches := editionPattern.FindStringSubmatch(title); matches != nil {
+		edition = strings.TrimSpace(matches[1])
+		title = strings.TrimSpace(editionPattern.ReplaceAllString(title, ""))
+	}
+	if i := strings.LastIndex(title, " - "); i > 0 {
+		name := strings.TrimSpace(title[:i])
+		folder := strings.EqualFold(name, filepath.Base(filepath.Dir(videoPath)))
+		if label, labelHeight, ok := parseVersionLabel(title[i+3:], folder); ok {
+			title, height = name, labelHeight
+			if edition == "" {
+				edition = label
+			}
+		}
+	}
+	for {
+		matches := resolutionSuffix.FindStringSubmatchIndex(title)
+		if matches == nil || matches[0] == 0 {
+			break
+		}
+		if height == 0 {
+			height = resolutionHeight(title[matches[2]:matches[3]])
+		}
+		title = title[:matches[0]]
+	}
+	return strings.TrimSpace(title), edition, height
+}
+
+// parseVersionLabel returns the edition and the resolution height of a label like
+// "4K", "Director's Cut" or "Extended 2160p"
+func parseVersionLabel(label string, folder bool) (edition string, height int, ok bool) {
+	var words []string
+	quality, keyword := false, false
+	for _, word := range strings.Fields(label) {
+		lower := strings.ToLower(strings.Trim(word, "[](),"))
+		if h := resolutionHeight(lower); h > 0 {
+			height, quality = h, true
+			continue
+		}
+		if qualityWords[lower] {
+			quality = true
+			continue
+		}
+		if editionWords[lower] {
+			keyword = true
+		}
+		words = append(words, word)
+	}
+	edition = strings.Join(words, " ")
+	ok = (quality && edition == "") || keyword || (folder && strings.TrimSpace(label) != "")
+	return edition, height, ok
+}
+
+// isVersion reports whether a video is named like a version or is a version of a
+// known item
+func (l *Library) isVersion(videoPath string) bool {
+	if _, edition, height := parseVersionName(videoPath); edition != "" || height > 0 {
+		return true
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, item := range l.items {
+		for _, version := range item.Versions {
+			if version.VideoPath == videoPath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// versionNFO holds what grouping versions reads from an NFO of its own
+type versionNFO struct {
+	size     int64
+	modified time.Time
+	ids      []string
+	width    int
+	height   int
+}
+
+// readVersionNFO returns the unique IDs and the video size of an NFO. The result is
+// kept until the file changes, so rescans do not parse the NFOs again.
+func (l *Library) readVersionNFO(file MediaFile) versionNFO {
+	l.versionMu.Lock()
+	cached, ok := l.versionNFOs[file.Path]
+	l.versionMu.Unlock()
+	if ok && cached.size == file.Size && cached.modified.Equal(file.Modified) {
+		return cached
+	}
+
+	info := versionNFO{size: file.Size, modified: file.Modified}
+	if nfo, err := ParseNFOFile(file.Path); err == nil && nfo.Type != "episode" && nfo.Type != "tvshow" {
+		add := func(kind, value string) {
+			if value = strings.TrimSpace(value); value != "" {
+				info.ids = append(info.ids, strings.ToLower(kind)+":"+strings.ToLower(value))
+			}
+		}
+		add("imdb", nfo.IMDbID)
+		add("tmdb", nfo.TMDbID)
+		for _, uid := range nfo.UniqueIDs {
+			add(uid.Type, uid.Value)
+		}
+		if nfo.StreamDetails != nil && len(nfo.StreamDetails.Video) > 0 {
+			video := nfo.StreamDetails.Video[0]
+			info.width, _ = strconv.Atoi(strings.TrimSpace(video.Width))
+			info.height, _ = strconv.Atoi(strings.TrimSpace(video.Height))
+		}
+	}
+
+	l.versionMu.Lock()
+	if l.versionNFOs == nil {
+		l.versionNFOs = map[string]versionNFO{}
+	}
+	l.versionNFOs[file.Path] = info
+	l.versionMu.Unlock()
+	return info
+}
+
+// versionItems merges the versions of a movie in the same directory into one item:
+// videos with the same title apart from a resolution or edition label (Movie.mkv,
+// Movie - 4K.mkv, Movie {edition-Extended}.mkv), videos sharing an NFO like
+// movie.nfo and videos whose own NFOs have the same IMDb, TMDb or other unique ID.
+// Version 1 is the video without edition in the highest resolution. Like a stack
+// the item keeps the ID of a version known before and looks up its NFO and poster
+// under the title (Movie.nfo) first, then under version 1. Episodes and stacks
+// are left alone.
+func (l *Library) versionItems(found map[string]MediaItem, files map[string][]MediaFile, appeared map[string]bool, index *dirIndex) {
+	type candidate struct {
+		id      string
+		title   string
+		edition string
+		height  int
+		nfo     versionNFO
+	}
+	dirs := map[string][]candidate{}
+	for id, item := range found {
+		if len(item.Parts) > 0 {
+			continue
+		}
+		base := filepath.Base(item.VideoPath)
+		if _, _, _, episode := parseEpisodeInfo(strings.TrimSuffix(base, filepath.Ext(base))); episode {
+			continue
+		}
+		title, edition, height := parseVersionName(item.VideoPath)
+		dir := filepath.Dir(item.VideoPath)
+		dirs[dir] = append(dirs[dir], candidate{id: id, title: title, edition: edition, height: height})
+	}
+
+	for dir, candidates := range dirs {
+		if len(candidates) < 2 {
+			continue
+		}
+
+		parent := make([]int, len(candidates))
+		for i := range parent {
+			parent[i] = i
+		}
+		root := func(i int) int {
+			for parent[i] != i {
+				parent[i] = parent[parent[i]]
+				i = parent[i]
+			}
+			return i
+		}
+		link := func(seen map[string]int, key string, i int) {
+			if other, ok := seen[key]; ok {
+				parent[root(i)] = root(other)
+			} else {
+				seen[key] = i
+			}
+		}
+
+		nfoUsers := map[string]int{}
+		for _, c := range candidates {
+			if path := found[c.id].NFOPath; path != "" {
+				nfoUsers[path]++
+			}
+		}
+		titles, nfos, uniqueIDs := map[string]int{}, map[string]int{}, map[string]int{}
+		for i := range candidates {
+			c := &candidates[i]
+			link(titles, strings.ToLower(c.title), i)
+			path := found[c.id].NFOPath
+			if path == "" {
+				continue
+			}
+			if nfoUsers[path] > 1 {
+				link(nfos, path, i)
+				continue
+			}
+			for _, file := range files[c.id] {
+				if file.Kind == MediaFileNFO {
+					c.nfo = l.readVersionNFO(file)
+				}
+			}
+			for _, id := range c.nfo.ids {
+				link(uniqueIDs, id, i)
+			}
+		}
+
+		groups := map[int][]candidate{}
+		for i, c := range candidates {
+			groups[root(i)] = append(groups[root(i)], c)
+		}
+		for _, members := range groups {
+			if len(members) < 2 {
+				continue
+			}
+
+			versions := make([]MediaVersion, len(members))
+			for i, m := range members {
+				item := found[m.id]
+				versions[i] = MediaVersion{
+					VideoPath:  item.VideoPath,
+					Size:       item.Size,
+					Modified:   item.Modified,
+					Resolution: resolutionName(0, m.height),
+					Edition:    m.edition,
+				}
+				if m.nfo.width > 0 || m.nfo.height > 0 {
+					versions[i].Width = m.nfo.width
+					versions[i].Height = m.nfo.height
+					versions[i].Resolution = resolutionName(m.nfo.width, m.nfo.height)
+				}
+			}
+			order := make([]int, len(members))
+			for i := range order {
+				order[i] = i
+			}
+			sort.Slice(order, func(i, j int) bool {
+				a, b := versions[order[i]], versions[order[j]]
+				if (a.Edition == "") != (b.Edition == "") {
+					return a.Edition == ""
+				}
+				if a.Edition != b.Edition {
+					return a.Edition < b.Edition
+				}
+				if ha, hb := resolutionHeight(a.Resolution), resolutionHeight(b.Resolution); ha != hb {
+					return ha > hb
+				}
+				return a.VideoPath < b.VideoPath
+			})
+
+			id := members[order[0]].id
+			allAppeared := true
+			for _, i := range order {
+				if !appeared[members[i].id] {
+					allAppeared = false
+					id = members[i].id
+					break
+				}
+			}
+
+			first := found[members[order[0]].id]
+			item := first
+			item.ID = id
+			item.Title = members[order[0]].title
+			item.Versions = make([]MediaVersion, 0, len(members))
+			for n, i := range order {
+				version := versions[i]
+				version.Version = n + 1
+				item.Versions = append(item.Versions, version)
+			}
+
+			itemFiles := []MediaFile{files[first.ID][0]}
+			sidecars := sidecarFiles(filepath.Join(dir, item.Title+filepath.Ext(first.VideoPath)), index)
+			for _, file := range files[first.ID][1:] {
+				if mediaFilePath(sidecars, file.Kind) == "" {
+					sidecars = append(sidecars, file)
+				}
+			}
+			itemFiles = append(itemFiles, sidecars...)
+			item.NFOPath = mediaFilePath(itemFiles, MediaFileNFO)
+			item.PosterPath = mediaFilePath(itemFiles, MediaFilePoster)
+
+			for _, m := range members {
+				delete(found, m.id)
+				delete(files, m.id)
+				delete(appeared, m.id)
+			}
+			found[id] = item
+			files[id] = itemFiles
+			if allAppeared {
+				appeared[id] = true
+			}
+		}
+	}
+}
+
+// versionOf returns version n of an item. Items without versions only have
+// version 1, their video.
+func versionOf(item MediaItem, n int) (MediaVersion, bool) {
+	if len(item.Versions) == 0 {
+		if n != 1 {
+			return MediaVersion{}, false
+		}
+		return MediaVersion{Version: 1, VideoPath: item.VideoPath, Size: item.Size, Modified: item.Modified}, true
+	}
+	if n < 1 || n > len(item.Versions) {
+		return MediaVersion{}, false
+	}
+	return item.Versions[n-1], true
+}
+
+// defaultVersion picks the version streamed with a transcoding profile: of the
+// versions with the edition of version 1 the smallest that still fills the
+// resolution of the profile, else the largest. Without a profile, a resolution
+// limit or known resolutions it is version 1.
+func defaultVersion(item MediaItem, profile *TranscodingProfile) int {
+	if len(item.Versions) == 0 || profile == nil {
+		return 1
+	}
+	limit := 0
+	if width, height, ok := strings.Cut(strings.ToLower(profile.Resolution), "x"); ok {
+		w, _ := strconv.Atoi(strings.TrimSpace(width))
+		h, _ := strconv.Atoi(strings.TrimSpace(height))
+		limit = resolutionHeight(resolutionName(w, h))
+	}
+	if limit == 0 {
+		return 1
+	}
+
+	best, bestHeight := 0, 0
+	for _, version := range item.Versions {
+		height := resolutionHeight(version.Resolution)
+		if version.Edition != item.Versions[0].Edition || height == 0 {
+			continue
+		}
+		switch {
+		case best == 0:
+		case height >= limit && (bestHeight < limit || height < bestHeight):
+		case height < limit && bestHeight < limit && height > bestHeight:
+		default:
+			continue
+		}
+		best, bestHeight = version.Version, height
+	}
+	if best == 0 {
+		return 1
+	}
+	return best
+}
+
+// requestedVersion returns the item with the video of the version selected by the
+// version query parameter, without it the default version for the profile. It
+// writes the error response for invalid versions.
+func (s *Server) requestedVersion(w http.ResponseWriter, r *http.Request, item MediaItem, profile *TranscodingProfile) (MediaItem, int, bool) {
+	n := defaultVersion(item, profile)
+	if value := strings.TrimSpace(r.URL.Query().Get("version")); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil {
+			s.writeError(w, "invalid version", http.StatusBadRequest)
+			return MediaItem{}, 0, false
+		}
+	}
+	version, ok := versionOf(item, n)
+	if !ok {
+		s.writeError(w, "version not found", http.StatusNotFound)
+		return MediaItem{}, 0, false
+	}
+	item.VideoPath = version.VideoPath
+	item.Size = version.Size
+	item.Modified = version.Modified
+	return item, n, true
+}
+
+// versionKey names the transcodes of a version; version 1 keeps the names used for
+// items without versions
+func versionKey(id string, version int) string {
+	if version <= 1 {
+		return id
+	}
+	return id + "_v" + strconv.Itoa(version)
+}
+
+func mediaVersionsEqual(a, b []MediaVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Version != b[i].Version || a[i].VideoPath != b[i].VideoPath || a[i].Size != b[i].Size ||
+			a[i].Modified.Unix() != b[i].Modified.Unix() || a[i].Width != b[i].Width || a[i].Height != b[i].Height ||
+			a[i].Resolution != b[i].Resolution || a[i].Edition != b[i].Edition {
+			return false
+		}
+	}
+	return true
+}
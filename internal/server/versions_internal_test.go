@@ -0,0 +1,86 @@
+package server
+
+import "testing"
+
+func TestParseVersionName(t *testing.T) {
+	tests := []struct {
+		path    string
+		title   string
+		edition string
+		height  int
+	}{
+		{path: "/m/Movie (2010)/Movie (2010).mkv", title: "Movie (2010)"},
+		{path: "/m/Movie (2010)/Movie (2010) - 4K.mkv", title: "Movie (2010)", height: 2160},
+		{path: "/m/Movie (2010)/Movie (2010) - 720p.mkv", title: "Movie (2010)", height: 720},
+		{path: "/m/Movie.2160p.mkv", title: "Movie", height: 2160},
+		{path: "/m/Movie [1080p].mkv", title: "Movie", height: 1080},
+		{path: "/m/Movie (UHD).mkv", title: "Movie", height: 2160},
+		// Edition tags of Plex and edition words after " - "
+		{path: "/m/Movie {edition-Director's Cut}.mkv", title: "Movie", edition: "Director's Cut"},
+		{path: "/m/Movie {EDITION-Extended} - 4K.mkv", title: "Movie", edition: "Extended", height: 2160},
+		{path: "/m/Movie - Director's Cut.mkv", title: "Movie", edition: "Director's Cut"},
+		{path: "/m/Movie - Extended Edition 2160p.mkv", title: "Movie", edition: "Extended Edition", height: 2160},
+		{path: "/m/Movie - 1080p HDR Remux.mkv", title: "Movie", height: 1080},
+		// A subtitle stays part of the title unless the folder names the movie
+		{path: "/m/Mission Impossible - Fallout.mkv", title: "Mission Impossible - Fallout"},
+		{path: "/m/Arrival/Arrival - Screener.mkv", title: "Arrival", edition: "Screener"},
+		{path: "/m/Films/Alien - Final Version (1979).mkv", title: "Alien", edition: "Final Version (1979)"},
+		{path: "/m/Movie.Extended.480p.mkv", title: "Movie.Extended", height: 480},
+		// A bare resolution is the title
+		{path: "/m/1080p.mkv", title: "1080p"},
+	}
+	for _, tt := range tests {
+		title, edition, height := parseVersionName(tt.path)
+		if title != tt.title || edition != tt.edition || height != tt.height {
+			t.Errorf("parseVersionName(%q) = %q, %q, %d; want %q, %q, %d",
+				tt.path, title, edition, height, tt.title, tt.edition, tt.height)
+		}
+	}
+}
+
+func TestDefaultVersion(t *testing.T) {
+	versions := func(editionsAndResolutions ...string) MediaItem {
+		var item MediaItem
+		for i := 0; i+1 < len(editionsAndResolutions); i += 2 {
+			item.Versions = append(item.Versions, MediaVersion{
+				Version:    len(item.Versions) + 1,
+				Edition:    editionsAndResolutions[i],
+				Resolution: editionsAndResolutions[i+1],
+			})
+		}
+		return item
+	}
+	profile := func(resolution string) *TranscodingProfile {
+		return &TranscodingProfile{Resolution: resolution}
+	}
+	mixed := versions("", "1080p", "", "2160p", "", "720p")
+
+	tests := []struct {
+		name    string
+		item    MediaItem
+		profile *TranscodingProfile
+		want    int
+	}{
+		{name: "no profile", item: mixed, want: 1},
+		{name: "profile without resolution", item: mixed, profile: profile(""), want: 1},
+		{name: "unparsable resolution", item: mixed, profile: profile("big"), want: 1},
+		{name: "single file", item: MediaItem{}, profile: profile("1280x720"), want: 1},
+		{name: "exact fit", item: mixed, profile: profile("1280x720"), want: 3},
+		{name: "smallest that fills", item: mixed, profile: profile("1920x1080"), want: 1},
+		{name: "widescreen counts by width", item: mixed, profile: profile("1920x800"), want: 1},
+		{name: "smallest above a low limit", item: versions("", "2160p", "", "1080p"), profile: profile("640x360"), want: 2},
+		// Nothing fills the profile: the largest version below it
+		{name: "none fits", item: mixed, profile: profile("7680x4320"), want: 2},
+		{name: "none fits among smaller", item: versions("", "480p", "", "1080p", "", "720p"), profile: profile("3840x2160"), want: 2},
+		// Only versions with the edition of version 1 are candidates
+		{name: "same edition", item: versions("Theatrical", "2160p", "Extended", "1080p", "Theatrical", "1080p"), profile: profile("1920x1080"), want: 3},
+		{name: "other editions ignored", item: versions("Theatrical", "2160p", "Extended", "720p"), profile: profile("1280x720"), want: 1},
+		{name: "unknown resolutions", item: versions("", "", "", ""), profile: profile("1920x1080"), want: 1},
+		{name: "unknown first resolution", item: versions("", "", "", "1080p", "", "2160p"), profile: profile("3840x2160"), want: 3},
+	}
+	for _, tt := range tests {
+		if got := defaultVersion(tt.item, tt.profile); got != tt.want {
+			t.Errorf("defaultVersion(%s) = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
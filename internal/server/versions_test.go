@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+func TestScanGroupsVersions(t *testing.T) {
+	store := newTestStore(t)
+	root := t.TempDir()
+	path := func(name string) string { return filepath.Join(root, name) }
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path(name)), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path(name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	bladeRunner := func(width, height string) string {
+		return `<movie><title>Blade Runner</title><uniqueid type="imdb">tt0083658</uniqueid>` +
+			`<fileinfo><streamdetails><video><width>` + width + `</width><height>` + height +
+			`</height></video></streamdetails></fileinfo></movie>`
+	}
+	write("Movie (2010)/Movie (2010).mkv", "full hd")
+	write("Movie (2010)/Movie (2010) - 4K.mkv", "ultra hd video")
+	write("Movie (2010)/Movie (2010) {edition-Extended}.mkv", "extended")
+	write("Movie (2010)/Movie (2010).nfo", "<movie><title>Movie</title></movie>")
+	write("Films/Blade Runner.mkv", "theatrical")
+	write("Films/Blade Runner.nfo", bladeRunner("1920", "800"))
+	write("Films/Blade Runner Final Cut.mkv", "final cut")
+	write("Films/Blade Runner Final Cut.nfo", bladeRunner("3840", "1600"))
+	write("Films/Mission Impossible - Fallout.mkv", "fallout")
+	write("Films/Mission Impossible - Ghost Protocol.mkv", "ghost protocol")
+
+	lib, err := server.NewLibrary(root, store, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	items, err := store.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("GetAll() = %d items; want two movies with versions and two sequels", len(items))
+	}
+
+	// Grouped by folder and title; the 4K video without edition is version 1
+	id, ok, err := store.GetIDByPath(path("Movie (2010)/Movie (2010) - 4K.mkv"))
+	if err != nil || !ok {
+		t.Fatalf("GetIDByPath() = %v, %v", ok, err)
+	}
+	item, ok, err := store.GetByID(id)
+	if err != nil || !ok {
+		t.Fatalf("GetByID() = %v, %v", ok, err)
+	}
+	if item.Title != "Movie (2010)" || item.NFOPath != path("Movie (2010)/Movie (2010).nfo") || item.Size != 14 || len(item.Versions) != 3 {
+		t.Fatalf("item = %+v", item)
+	}
+	want := []server.MediaVersion{
+		{Version: 1, VideoPath: path("Movie (2010)/Movie (2010) - 4K.mkv"), Size: 14, Resolution: "2160p"},
+		{Version: 2, VideoPath: path("Movie (2010)/Movie (2010).mkv"), Size: 7},
+		{Version: 3, VideoPath: path("Movie (2010)/Movie (2010) {edition-Extended}.mkv"), Size: 8, Edition: "Extended"},
+	}
+	for i, version := range item.Versions {
+		version.Modified = time.Time{}
+		if version != want[i] {
+			t.Fatalf("version %d = %+v; want %+v", i+1, version, want[i])
+		}
+	}
+
+	// Grouped by the IMDb ID of their NFOs, with the resolution of the NFO
+	id, ok, err = store.GetIDByPath(path("Films/Blade Runner Final Cut.mkv"))
+	if err != nil || !ok {
+		t.Fatalf("GetIDByPath() = %v, %v", ok, err)
+	}
+	item, _, _ = store.GetByID(id)
+	if len(item.Versions) != 2 || item.Versions[0].Resolution != "2160p" || item.Versions[0].Width != 3840 ||
+		item.Versions[1].VideoPath != path("Films/Blade Runner.mkv") || item.Versions[1].Resolution != "1080p" {
+		t.Fatalf("versions = %+v", item.Versions)
+	}
+
+	// The item keeps its ID when a version goes away; a single video left has none
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if again, _, _ := store.GetIDByPath(path("Films/Blade Runner Final Cut.mkv")); again != id {
+		t.Fatalf("ID = %q after rescan; want %q", again, id)
+	}
+	if err := os.Remove(path("Films/Blade Runner.mkv")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := lib.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	item, ok, err = store.GetByID(id)
+	if err != nil || !ok || len(item.Versions) != 0 || item.VideoPath != path("Films/Blade Runner Final Cut.mkv") {
+		t.Fatalf("GetByID() = %+v, %v, %v; want an item without versions", item, ok, err)
+	}
+}
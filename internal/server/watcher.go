@@ -0,0 +1,289 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// watchDebounce is the quiet period before pending changes are scanned
+	watchDebounce = 2 * time.Second
+	// watchMaxDelay bounds the wait while changes keep coming in, e.g. during a copy
+	watchMaxDelay = 30 * time.Second
+)
+
+var (
+	errWatchLimit       = errors.New("filesystem watch limit reached")
+	errWatchUnsupported = errors.New("filesystem watching not supported on this platform")
+)
+
+// fsEvent is a change inside a watched directory
+type fsEvent struct {
+	Dir     string
+	Name    string
+	IsDir   bool
+	Created bool
+	// Overflow reports lost events; only a full scan is reliable afterwards
+	Overflow bool
+}
+
+// fsWatcher is the platform specific part of the library watcher
+type fsWatcher interface {
+	// Add watches a single directory and returns errWatchLimit once the limit is hit
+	Add(dir string) error
+	// Remove stops watching dir and every watched directory below it
+	Remove(dir string) error
+	Len() int
+	Events() <-chan fsEvent
+	Close() error
+}
+
+// libraryWatcher turns filesystem events into ScanPath calls on the changed
+// directories. It complements the periodic full scan, which stays the safety net
+// for network shares and for directories beyond the watch limit.
+type libraryWatcher struct {
+	lib      *Library
+	backend  fsWatcher
+	debounce time.Duration
+	maxDelay time.Duration
+	mu       sync.Mutex
+	limited  bool
+	batches  chan watchBatch
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// watchBatch holds the changes collected during one debounce period
+type watchBatch struct {
+	dirs     map[string]bool
+	fullScan bool
+}
+
+// startLibraryWatcher watches every root the library can scan
+func startLibraryWatcher(lib *Library, roots []string) (*libraryWatcher, error) {
+	backend, err := newFSWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := newLibraryWatcher(lib, backend)
+	for _, root := range roots {
+		w.addRoot(root)
+	}
+	log.Printf("level=info msg=\"watching library\" roots=%d dirs=%d", len(roots), backend.Len())
+	w.start()
+	return w, nil
+}
+
+func newLibraryWatcher(lib *Library, backend fsWatcher) *libraryWatcher {
+	return &libraryWatcher{
+		lib:      lib,
+		backend:  backend,
+		debounce: watchDebounce,
+		maxDelay: watchMaxDelay,
+		batches:  make(chan watchBatch),
+		stop:     make(chan struct{}),
+	}
+}
+
+// start runs the event loop and, separately, the scans, so that events keep being
+// read while a scan is busy
+func (w *libraryWatcher) start() {
+	w.wg.Add(2)
+	go w.run()
+	go w.scanBatches()
+}
+
+// addRoot watches a root and all directories below it. Paths outside the library
+// roots are skipped, events there could not be scanned anyway.
+func (w *libraryWatcher) addRoot(root string) {
+	rootPath, _, err := w.lib.resolveScanPath(root)
+	if err != nil {
+		log.Printf("level=warn msg=\"not watching root\" path=%s err=%v", root, err)
+		return
+	}
+	w.addTree(rootPath)
+}
+
+// removeRoot stops watching a removed root. Directories that still belong to another
+// root stay watched, and roots nested inside the removed one are watched again.
+func (w *libraryWatcher) removeRoot(root string) {
+	rootPath := absPath(root)
+	if _, ok := w.lib.rootFor(rootPath); ok {
+		return
+	}
+	if err := w.backend.Remove(rootPath); err != nil {
+		log.Printf("level=warn msg=\"failed to unwatch root\" path=%s err=%v", rootPath, err)
+	}
+	for _, other := range w.lib.Roots() {
+		if pathWithin(rootPath, absPath(other.Path)) {
+			w.addRoot(other.Path)
+		}
+	}
+}
+
+// addTree watches dir recursively. Once the watch limit is hit, no further watches
+// are added and the remaining directories rely on the periodic scan.
+func (w *libraryWatcher) addTree(dir string) {
+	root, ok := w.lib.rootFor(absPath(dir))
+	if !ok {
+		return
+	}
+	ignore := w.lib.ignoreMatcherFor(root.abs)
+	if _, excluded := ignore.excludedAncestor(dir); excluded {
+		return
+	}
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		// Ignored directories are never scanned, watching them would only cost watches
+		if _, ok := ignore.match(path, true); ok {
+			return filepath.SkipDir
+		}
+		if err := w.backend.Add(path); err != nil {
+			if errors.Is(err, errWatchLimit) {
+				w.reachedLimit()
+				return filepath.SkipAll
+			}
+			log.Printf("level=warn msg=\"failed to watch directory\" path=%s err=%v", path, err)
+		}
+		return nil
+	})
+}
+
+func (w *libraryWatcher) reachedLimit() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.limited {
+		return
+	}
+	w.limited = true
+	log.Printf("level=warn msg=\"filesystem watch limit reached, remaining directories are only covered by periodic scans\" watched=%d hint=\"raise fs.inotify.max_user_watches\"", w.backend.Len())
+}
+
+func (w *libraryWatcher) run() {
+	defer w.wg.Done()
+
+	pending := map[string]bool{}
+	fullScan := false
+	var first time.Time
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.backend.Events():
+			if !ok {
+				return
+			}
+			if event.Overflow {
+				fullScan = true
+			} else {
+				if event.IsDir && event.Created {
+					w.addTree(filepath.Join(event.Dir, event.Name))
+				}
+				pending[event.Dir] = true
+			}
+
+			now := time.Now()
+			if first.IsZero() {
+				first = now
+			}
+			timer.Reset(debounceDelay(w.debounce, w.maxDelay, now.Sub(first)))
+
+		case <-timer.C:
+			select {
+			case w.batches <- watchBatch{dirs: pending, fullScan: fullScan}:
+				pending = map[string]bool{}
+				fullScan = false
+				first = time.Time{}
+			default:
+				// A scan is still running; the changes go into the next batch
+				timer.Reset(w.debounce)
+			}
+
+		case <-w.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// debounceDelay returns how long to wait for further events when the first pending
+// one arrived elapsed ago. Bursts are coalesced, but the scan is not postponed beyond
+// maxDelay.
+func debounceDelay(debounce, maxDelay, elapsed time.Duration) time.Duration {
+	if remaining := maxDelay - elapsed; remaining < debounce {
+		return max(remaining, 0)
+	}
+	return debounce
+}
+
+// scanBatches scans the batches handed over by the event loop one after another
+func (w *libraryWatcher) scanBatches() {
+	defer w.wg.Done()
+	for {
+		select {
+		case batch := <-w.batches:
+			w.flush(batch.dirs, batch.fullScan)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// flush scans the changed directories. Nested directories are covered by the scan
+// of their parent, so only the outermost ones are scanned.
+func (w *libraryWatcher) flush(pending map[string]bool, fullScan bool) {
+	if fullScan {
+		log.Printf("level=warn msg=\"filesystem events lost, running full scan\"")
+		if err := w.lib.Scan(); err != nil {
+			log.Printf("scan failed (watcher): %v", err)
+		}
+		return
+	}
+
+	for _, dir := range coalesceDirs(pending) {
+		if err := w.lib.ScanPath(dir); err != nil {
+			// The directory was removed again; its parent has a pending event as well
+			if errors.Is(err, ErrScanPathNotFound) || errors.Is(err, ErrInvalidScanPath) {
+				continue
+			}
+			log.Printf("scan failed (watcher) path=%s: %v", dir, err)
+		}
+	}
+}
+
+// coalesceDirs returns the sorted directories without those inside another one
+func coalesceDirs(dirs map[string]bool) []string {
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+
+	result := make([]string, 0, len(sorted))
+outer:
+	for _, dir := range sorted {
+		for _, kept := range result {
+			if pathWithin(kept, dir) {
+				continue outer
+			}
+		}
+		result = append(result, dir)
+	}
+	return result
+}
+
+func (w *libraryWatcher) close() {
+	close(w.stop)
+	w.wg.Wait()
+	if err := w.backend.Close(); err != nil {
+		log.Printf("level=warn msg=\"failed to close filesystem watcher\" err=%v", err)
+	}
+}
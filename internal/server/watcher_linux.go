@@ -0,0 +1,170 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches directories with inotify. The descriptor is non-blocking, so
+// the runtime poller serves reads and Close unblocks the reader.
+type inotifyWatcher struct {
+	fd     int
+	file   *os.File
+	mu     sync.Mutex
+	closed bool
+	dirs   map[int]string
+	wds    map[string]int
+	events chan fsEvent
+	done   chan struct{}
+}
+
+func newFSWatcher() (fsWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		if errors.Is(err, syscall.EMFILE) {
+			return nil, errWatchLimit
+		}
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   map[int]string{},
+		wds:    map[string]int{},
+		events: make(chan fsEvent, 256),
+		done:   make(chan struct{}),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if _, ok := w.wds[dir]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return errWatchLimit
+		}
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.dirs[wd] = dir
+	w.wds[dir] = wd
+	return nil
+}
+
+func (w *inotifyWatcher) Remove(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	var errs []error
+	for path, wd := range w.wds {
+		if !pathWithin(dir, path) {
+			continue
+		}
+		// EINVAL means the kernel already dropped the watch of a deleted directory
+		if _, err := syscall.InotifyRmWatch(w.fd, uint32(wd)); err != nil && !errors.Is(err, syscall.EINVAL) {
+			errs = append(errs, &os.PathError{Op: "inotify_rm_watch", Path: path, Err: err})
+		}
+		delete(w.dirs, wd)
+		delete(w.wds, path)
+	}
+	return errors.Join(errs...)
+}
+
+func (w *inotifyWatcher) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.wds)
+}
+
+func (w *inotifyWatcher) Events() <-chan fsEvent {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(raw.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+			offset = nameEnd
+
+			event, ok := w.translate(raw.Wd, raw.Mask, name)
+			if !ok {
+				continue
+			}
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// translate maps a raw inotify event to the directory that changed
+func (w *inotifyWatcher) translate(wd int32, mask uint32, name string) (fsEvent, bool) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return fsEvent{Overflow: true}, true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.dirs[int(wd)]
+	if !ok {
+		return fsEvent{}, false
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		// The directory is gone or unmounted; the kernel dropped the watch
+		delete(w.dirs, int(wd))
+		delete(w.wds, dir)
+		return fsEvent{}, false
+	}
+	return fsEvent{
+		Dir:     dir,
+		Name:    name,
+		IsDir:   mask&syscall.IN_ISDIR != 0,
+		Created: mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0,
+	}, true
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestInotifyTranslate(t *testing.T) {
+	w := &inotifyWatcher{dirs: map[int]string{1: "/media/Movies"}, wds: map[string]int{"/media/Movies": 1}}
+
+	tests := []struct {
+		name   string
+		wd     int32
+		mask   uint32
+		want   fsEvent
+		wantOK bool
+	}{
+		{name: "file written", wd: 1, mask: syscall.IN_CLOSE_WRITE, want: fsEvent{Dir: "/media/Movies", Name: "a.mkv"}, wantOK: true},
+		{name: "file deleted", wd: 1, mask: syscall.IN_DELETE, want: fsEvent{Dir: "/media/Movies", Name: "a.mkv"}, wantOK: true},
+		{name: "file moved in", wd: 1, mask: syscall.IN_MOVED_TO, want: fsEvent{Dir: "/media/Movies", Name: "a.mkv", Created: true}, wantOK: true},
+		{name: "directory created", wd: 1, mask: syscall.IN_CREATE | syscall.IN_ISDIR, want: fsEvent{Dir: "/media/Movies", Name: "a.mkv", IsDir: true, Created: true}, wantOK: true},
+		{name: "directory moved out", wd: 1, mask: syscall.IN_MOVED_FROM | syscall.IN_ISDIR, want: fsEvent{Dir: "/media/Movies", Name: "a.mkv", IsDir: true}, wantOK: true},
+		{name: "overflow", wd: -1, mask: syscall.IN_Q_OVERFLOW, want: fsEvent{Overflow: true}, wantOK: true},
+		{name: "unknown watch", wd: 7, mask: syscall.IN_CREATE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := w.translate(tt.wd, tt.mask, "a.mkv")
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("translate() = %+v, %v; want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	// A dropped watch is forgotten, later events of the descriptor are ignored
+	if _, ok := w.translate(1, syscall.IN_IGNORED, ""); ok {
+		t.Fatalf("translate(IN_IGNORED) reported an event")
+	}
+	if len(w.dirs) != 0 || len(w.wds) != 0 {
+		t.Fatalf("watches after IN_IGNORED = %v, %v; want none", w.dirs, w.wds)
+	}
+	if _, ok := w.translate(1, syscall.IN_CLOSE_WRITE, "a.mkv"); ok {
+		t.Fatalf("translate() of a dropped watch reported an event")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+func newFSWatcher() (fsWatcher, error) {
+	return nil, errWatchUnsupported
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFSWatcher records the watched directories; tests send events themselves
+type fakeFSWatcher struct {
+	mu     sync.Mutex
+	dirs   map[string]bool
+	events chan fsEvent
+}
+
+func newFakeFSWatcher() *fakeFSWatcher {
+	return &fakeFSWatcher{dirs: map[string]bool{}, events: make(chan fsEvent)}
+}
+
+func (f *fakeFSWatcher) Add(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirs[dir] = true
+	return nil
+}
+
+func (f *fakeFSWatcher) Remove(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for path := range f.dirs {
+		if pathWithin(dir, path) {
+			delete(f.dirs, path)
+		}
+	}
+	return nil
+}
+
+func (f *fakeFSWatcher) watching(dir string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dirs[dir]
+}
+
+func (f *fakeFSWatcher) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.dirs)
+}
+
+func (f *fakeFSWatcher) Events() <-chan fsEvent { return f.events }
+func (f *fakeFSWatcher) Close() error           { return nil }
+
+func mkdirs(t *testing.T, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s) error = %v", dir, err)
+		}
+	}
+}
+
+func newTestWatcher(t *testing.T, root string) (*Library, *libraryWatcher, *fakeFSWatcher) {
+	t.Helper()
+	lib, err := NewLibrary(root, nil, nil)
+	if err != nil {
+		t.Fatalf("NewLibrary() error = %v", err)
+	}
+	backend := newFakeFSWatcher()
+	w := newLibraryWatcher(lib, backend)
+	w.debounce = 10 * time.Millisecond
+	w.maxDelay = 50 * time.Millisecond
+	w.addRoot(root)
+	return lib, w, backend
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCoalesceDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		dirs []string
+		want []string
+	}{
+		{name: "empty", dirs: nil, want: []string{}},
+		{name: "siblings", dirs: []string{"/m/b", "/m/a"}, want: []string{"/m/a", "/m/b"}},
+		{name: "nested", dirs: []string{"/m/a/x/y", "/m/a", "/m/a/x"}, want: []string{"/m/a"}},
+		{name: "common prefix is not nesting", dirs: []string{"/m/a", "/m/ab"}, want: []string{"/m/a", "/m/ab"}},
+		{name: "mixed", dirs: []string{"/m/a/x", "/n", "/m/b", "/n/c"}, want: []string{"/m/a/x", "/m/b", "/n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs := map[string]bool{}
+			for _, dir := range tt.dirs {
+				dirs[filepath.FromSlash(dir)] = true
+			}
+			want := make([]string, len(tt.want))
+			for i, dir := range tt.want {
+				want[i] = filepath.FromSlash(dir)
+			}
+			if got := coalesceDirs(dirs); !reflect.DeepEqual(got, want) {
+				t.Fatalf("coalesceDirs(%v) = %v; want %v", tt.dirs, got, want)
+			}
+		})
+	}
+}
+
+func TestDebounceDelay(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{elapsed: 0, want: 2 * time.Second},
+		{elapsed: 27 * time.Second, want: 2 * time.Second},
+		{elapsed: 29 * time.Second, want: time.Second},
+		{elapsed: 30 * time.Second, want: 0},
+		{elapsed: time.Minute, want: 0},
+	}
+	for _, tt := range tests {
+		if got := debounceDelay(watchDebounce, watchMaxDelay, tt.elapsed); got != tt.want {
+			t.Errorf("debounceDelay(%v) = %v; want %v", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestWatcherScansChangedDirectories(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "Movies")
+	mkdirs(t, sub)
+	lib, w, backend := newTestWatcher(t, root)
+	if !backend.watching(root) || !backend.watching(sub) {
+		t.Fatalf("watched = %v; want root and Movies", backend.dirs)
+	}
+	w.start()
+	defer w.close()
+
+	// A created directory is watched right away
+	created := filepath.Join(sub, "New")
+	mkdirs(t, created)
+	backend.events <- fsEvent{Dir: sub, Name: "New", IsDir: true, Created: true}
+	waitFor(t, "the new directory to be watched", func() bool { return backend.watching(created) })
+
+	if err := os.WriteFile(filepath.Join(created, "Movie.mkv"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	backend.events <- fsEvent{Dir: created, Name: "Movie.mkv", Created: true}
+	waitFor(t, "the movie to be scanned", func() bool { return len(lib.All()) == 1 })
+}
+
+func TestWatcherReadsEventsWhileScanning(t *testing.T) {
+	root := t.TempDir()
+	lib, w, backend := newTestWatcher(t, root)
+	w.start()
+	defer w.close()
+
+	// Scans of the root wait until the lock is released
+	entry, _ := lib.rootFor(root)
+	entry.scanMu.Lock()
+	if err := os.WriteFile(filepath.Join(root, "One.mkv"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	backend.events <- fsEvent{Dir: root, Name: "One.mkv", Created: true}
+	time.Sleep(5 * w.debounce)
+
+	// The event channel is unbuffered, every send needs the event loop to receive
+	if err := os.WriteFile(filepath.Join(root, "Two.mkv"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case backend.events <- fsEvent{Dir: root, Name: "Two.mkv", Created: true}:
+		case <-time.After(time.Second):
+			entry.scanMu.Unlock()
+			t.Fatalf("event loop blocked while a scan is running")
+		}
+	}
+	entry.scanMu.Unlock()
+	waitFor(t, "both movies to be scanned", func() bool { return len(lib.All()) == 2 })
+}
+
+func TestWatcherRemoveRoot(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "Nested")
+	other := t.TempDir()
+	inner := filepath.Join(other, "Inner")
+	mkdirs(t, filepath.Join(nested, "Season 1"), filepath.Join(other, "Films"), filepath.Join(inner, "Shows"))
+	lib, w, backend := newTestWatcher(t, root)
+
+	addRoot := func(id, path string) {
+		lib.rootsMu.Lock()
+		lib.roots[id] = lib.newLibraryRoot(LibraryRoot{ID: id, Path: path})
+		lib.rootsMu.Unlock()
+		w.addRoot(path)
+	}
+	removeRoot := func(id, path string) {
+		lib.rootsMu.Lock()
+		delete(lib.roots, id)
+		lib.rootsMu.Unlock()
+		w.removeRoot(path)
+	}
+	addRoot("nested", nested)
+	addRoot("other", other)
+	addRoot("inner", inner)
+
+	// Directories of a nested root still belong to the outer root
+	removeRoot("nested", nested)
+	if !backend.watching(filepath.Join(nested, "Season 1")) {
+		t.Fatalf("Nested/Season 1 unwatched; it belongs to the primary root")
+	}
+
+	// Roots inside a removed root keep their watches
+	removeRoot("other", other)
+	if backend.watching(other) || backend.watching(filepath.Join(other, "Films")) {
+		t.Fatalf("watched = %v; want the removed root unwatched", backend.dirs)
+	}
+	if !backend.watching(inner) || !backend.watching(filepath.Join(inner, "Shows")) {
+		t.Fatalf("watched = %v; want the inner root still watched", backend.dirs)
+	}
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "fmt"
+import (
+	"database/sql"
+	"fmt"
+)
 
 const schemaMediaItems = `
 CREATE TABLE IF NOT EXISTS media_items (
@@ -89,6 +92,8 @@ CREATE TABLE IF NOT EXISTS schema_migrations (
 type migration struct {
 	version    int
 	statements []string
+	// apply runs after the statements, within the same transaction
+	apply func(tx *sql.Tx) error
 }
 
 var migrations = []migration{
@@ -438,8 +443,14 @@ var migrations = []migration{
 	},
 	{
 		version: 13,
-		statements: []string{
-			`ALTER TABLE transcoding_profiles ADD COLUMN audio_layout TEXT;`,
+		// Migration 8 creates audio_layout on new databases, only older tables lack it
+		apply: func(tx *sql.Tx) error {
+			exists, err := columnExists(tx, "transcoding_profiles", "audio_layout")
+			if err != nil || exists {
+				return err
+			}
+			_, err = tx.Exec(`ALTER TABLE transcoding_profiles ADD COLUMN audio_layout TEXT;`)
+			return err
 		},
 	},
 	{
@@ -448,6 +459,343 @@ var migrations = []migration{
 			`ALTER TABLE transcoding_profiles ADD COLUMN audio_normalization TEXT;`,
 		},
 	},
+	{
+		version: 15,
+		statements: []string{
+			// Watched state and favorites recorded before per-user tracking belong to the admin.
+			`UPDATE OR IGNORE watched_items SET user_id = 'admin' WHERE user_id = '';`,
+			`DELETE FROM watched_items WHERE user_id = '';`,
+			`UPDATE OR IGNORE favorites SET user_id = 'admin' WHERE user_id = '';`,
+			`DELETE FROM favorites WHERE user_id = '';`,
+		},
+	},
+	{
+		version: 16,
+		statements: []string{
+			// Ein einheitliches Benutzermodell: Media-User werden in auth_users überführt.
+			// Übernommene Konten haben kein Passwort und können sich erst nach einem Reset anmelden.
+			`ALTER TABLE auth_users ADD COLUMN last_active INTEGER;`,
+			`CREATE TEMP TABLE migrate_user_ids AS
+			 SELECT u.id AS old_id, a.id AS new_id
+			 FROM users u
+			 INNER JOIN auth_users a ON LOWER(a.username) = LOWER(u.name)
+			 WHERE a.id != u.id AND NOT EXISTS (SELECT 1 FROM auth_users x WHERE x.id = u.id);`,
+			`INSERT INTO auth_users (id, username, password_hash, is_admin, created_at, last_login, last_active)
+			 SELECT u.id, u.name, '', 0, u.created_at, NULL, NULLIF(u.last_active, 0)
+			 FROM users u
+			 WHERE NOT EXISTS (SELECT 1 FROM auth_users a WHERE a.id = u.id OR LOWER(a.username) = LOWER(u.name));`,
+			`UPDATE auth_users SET last_active = (SELECT NULLIF(u.last_active, 0) FROM users u WHERE u.id = auth_users.id)
+			 WHERE last_active IS NULL AND id IN (SELECT id FROM users);`,
+			`CREATE TABLE user_preferences_new (
+				user_id TEXT NOT NULL,
+				key TEXT NOT NULL,
+				value TEXT,
+				PRIMARY KEY (user_id, key),
+				FOREIGN KEY (user_id) REFERENCES auth_users(id) ON DELETE CASCADE
+			);`,
+			`INSERT OR REPLACE INTO user_preferences_new (user_id, key, value)
+			 SELECT COALESCE(m.new_id, p.user_id), p.key, p.value
+			 FROM user_preferences p
+			 LEFT JOIN migrate_user_ids m ON m.old_id = p.user_id
+			 WHERE COALESCE(m.new_id, p.user_id) IN (SELECT id FROM auth_users);`,
+			`DROP TABLE user_preferences;`,
+			`ALTER TABLE user_preferences_new RENAME TO user_preferences;`,
+			`CREATE INDEX IF NOT EXISTS idx_user_preferences_user_id ON user_preferences(user_id);`,
+			`UPDATE OR IGNORE watched_items SET user_id = (SELECT new_id FROM migrate_user_ids WHERE old_id = watched_items.user_id)
+			 WHERE user_id IN (SELECT old_id FROM migrate_user_ids);`,
+			`UPDATE OR IGNORE favorites SET user_id = (SELECT new_id FROM migrate_user_ids WHERE old_id = favorites.user_id)
+			 WHERE user_id IN (SELECT old_id FROM migrate_user_ids);`,
+			// Playback-State wird pro Benutzer und Client gespeichert.
+			`CREATE TABLE playback_state_new (
+				media_id TEXT NOT NULL,
+				user_id TEXT NOT NULL DEFAULT '',
+				client_id TEXT NOT NULL DEFAULT '',
+				position_seconds INTEGER NOT NULL,
+				duration_seconds INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				last_played_at INTEGER NOT NULL DEFAULT 0,
+				percent_complete REAL,
+				PRIMARY KEY (media_id, user_id, client_id),
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+			`INSERT OR REPLACE INTO playback_state_new (
+				media_id, user_id, client_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete
+			 )
+			 SELECT p.media_id, COALESCE(m.new_id, NULLIF(p.user_id, ''), 'admin'), p.client_id,
+				p.position_seconds, p.duration_seconds, p.updated_at, p.last_played_at, p.percent_complete
+			 FROM playback_state p
+			 LEFT JOIN migrate_user_ids m ON m.old_id = p.user_id;`,
+			`DROP TABLE playback_state;`,
+			`ALTER TABLE playback_state_new RENAME TO playback_state;`,
+			`CREATE INDEX IF NOT EXISTS idx_playback_state_client_id ON playback_state(client_id, last_played_at DESC);`,
+			`CREATE INDEX IF NOT EXISTS idx_playback_state_last_played ON playback_state(last_played_at DESC);`,
+			`CREATE INDEX IF NOT EXISTS idx_playback_state_user_id ON playback_state(user_id, last_played_at DESC);`,
+			`DROP TABLE users;`,
+			`DROP TABLE migrate_user_ids;`,
+		},
+	},
+	{
+		version: 17,
+		statements: []string{
+			// API-Keys für nicht-interaktive Clients, nur als Hash gespeichert
+			`CREATE TABLE IF NOT EXISTS auth_api_keys (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT,
+				created_at INTEGER NOT NULL,
+				last_used_at INTEGER,
+				FOREIGN KEY (user_id) REFERENCES auth_users(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_auth_api_keys_user_id ON auth_api_keys(user_id);`,
+		},
+	},
+	{
+		version: 18,
+		statements: []string{
+			// Fehlgeschlagene Logins pro Benutzername und IP, überdauern einen Neustart
+			`CREATE TABLE IF NOT EXISTS auth_login_failures (
+				key TEXT PRIMARY KEY,
+				failures INTEGER NOT NULL DEFAULT 0,
+				last_failure INTEGER NOT NULL,
+				locked_until INTEGER
+			);`,
+		},
+	},
+	{
+		version: 19,
+		statements: []string{
+			// Sessions erhalten eine öffentliche ID und Client-Informationen für die Sitzungsverwaltung
+			`ALTER TABLE auth_sessions ADD COLUMN id TEXT;`,
+			`ALTER TABLE auth_sessions ADD COLUMN last_seen INTEGER;`,
+			`ALTER TABLE auth_sessions ADD COLUMN client_name TEXT;`,
+			`ALTER TABLE auth_sessions ADD COLUMN ip TEXT;`,
+			`UPDATE auth_sessions SET id = 'sess_' || lower(hex(randomblob(8))) WHERE id IS NULL;`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_auth_sessions_id ON auth_sessions(id);`,
+		},
+	},
+	{
+		version: 20,
+		statements: []string{
+			// TOTP-Zwei-Faktor-Authentifizierung mit gehashten Wiederherstellungscodes
+			`ALTER TABLE auth_users ADD COLUMN totp_secret TEXT;`,
+			`ALTER TABLE auth_users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE auth_users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE TABLE IF NOT EXISTS auth_recovery_codes (
+				user_id TEXT NOT NULL,
+				code_hash TEXT NOT NULL,
+				used_at INTEGER,
+				PRIMARY KEY (user_id, code_hash),
+				FOREIGN KEY (user_id) REFERENCES auth_users(id) ON DELETE CASCADE
+			);`,
+		},
+	},
+	{
+		version: 21,
+		statements: []string{
+			// Zugriff pro Benutzer auf einzelne Bibliotheks-Roots; bestehende Konten sehen weiterhin alles
+			`ALTER TABLE auth_users ADD COLUMN all_roots INTEGER NOT NULL DEFAULT 1;`,
+			`CREATE TABLE IF NOT EXISTS auth_user_roots (
+				user_id TEXT NOT NULL,
+				root_id TEXT NOT NULL,
+				PRIMARY KEY (user_id, root_id),
+				FOREIGN KEY (user_id) REFERENCES auth_users(id) ON DELETE CASCADE,
+				FOREIGN KEY (root_id) REFERENCES library_roots(id) ON DELETE CASCADE
+			);`,
+		},
+	},
+	{
+		version: 22,
+		statements: []string{
+			// Jugendschutz: maximale Altersfreigabe pro Benutzer und Admin-Overrides pro Item
+			`ALTER TABLE auth_users ADD COLUMN max_rating TEXT;`,
+			`ALTER TABLE auth_users ADD COLUMN unrated_policy TEXT NOT NULL DEFAULT 'allow';`,
+			`CREATE TABLE IF NOT EXISTS media_rating_overrides (
+				media_id TEXT PRIMARY KEY,
+				rating TEXT NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+		},
+	},
+	{
+		version: 23,
+		statements: []string{
+			// Audit-Log: nur anhängen, Einträge werden ausschließlich über die Aufbewahrungsfrist gelöscht
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				created_at INTEGER NOT NULL,
+				actor_id TEXT,
+				actor TEXT,
+				action TEXT NOT NULL,
+				target TEXT,
+				ip TEXT,
+				outcome TEXT NOT NULL
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);`,
+			`CREATE TRIGGER IF NOT EXISTS audit_log_append_only
+				BEFORE UPDATE ON audit_log
+				BEGIN
+					SELECT RAISE(ABORT, 'audit_log is append-only');
+				END;`,
+		},
+	},
+	{
+		version: 24,
+		statements: []string{
+			// Einladungen zur Registrierung, Code nur als Hash gespeichert
+			`CREATE TABLE IF NOT EXISTS auth_invites (
+				id TEXT PRIMARY KEY,
+				prefix TEXT NOT NULL,
+				code_hash TEXT NOT NULL UNIQUE,
+				created_by TEXT,
+				created_at INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL,
+				max_uses INTEGER NOT NULL DEFAULT 1,
+				uses INTEGER NOT NULL DEFAULT 0,
+				is_admin INTEGER NOT NULL DEFAULT 0,
+				all_roots INTEGER NOT NULL DEFAULT 1,
+				root_ids TEXT
+			);`,
+		},
+	},
+	{
+		version: 25,
+		statements: []string{
+			// Größe und Änderungszeit von Video und Sidecars (NFO, Poster, Untertitel)
+			// beim letzten Metadaten-Abgleich; unveränderte Items überspringt der Scan
+			`CREATE TABLE IF NOT EXISTS media_files (
+				media_id TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				path TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				modified INTEGER NOT NULL,
+				PRIMARY KEY (media_id, kind),
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+		},
+	},
+	{
+		version: 26,
+		statements: []string{
+			// Scan-Jobs: gescannter Pfad und Fortschrittszähler
+			`ALTER TABLE scan_runs ADD COLUMN path TEXT;`,
+			`ALTER TABLE scan_runs ADD COLUMN full_scan INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scan_runs ADD COLUMN dirs_visited INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scan_runs ADD COLUMN files_found INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scan_runs ADD COLUMN added INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scan_runs ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE scan_runs ADD COLUMN removed INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE INDEX IF NOT EXISTS idx_scan_runs_started_at ON scan_runs(started_at);`,
+		},
+	},
+	{
+		version: 27,
+		statements: []string{
+			// Ausschlussmuster pro Root (gitignore-Syntax, eines pro Zeile)
+			`ALTER TABLE library_roots ADD COLUMN exclude_patterns TEXT;`,
+			// Vom letzten Scan übersprungene Pfade mit der greifenden Regel
+			`CREATE TABLE IF NOT EXISTS ignored_paths (
+				path TEXT PRIMARY KEY,
+				is_dir INTEGER NOT NULL DEFAULT 0,
+				pattern TEXT NOT NULL,
+				source TEXT NOT NULL,
+				detected_at INTEGER NOT NULL
+			);`,
+		},
+	},
+	{
+		version: 28,
+		statements: []string{
+			// Eigener Scan-Zeitplan (Sekunden; 0 = -scan-interval, negativ = aus) und
+			// eigene Dateiendungen (kommagetrennt; leer = -extensions) pro Root
+			`ALTER TABLE library_roots ADD COLUMN scan_interval INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE library_roots ADD COLUMN extensions TEXT;`,
+		},
+	},
+	{
+		version: 29,
+		statements: []string{
+			// Nicht erreichbare Roots (Pfad fehlt oder ist leer) und ihre offline
+			// markierten Items, die erst nach der Karenzzeit gelöscht werden
+			`ALTER TABLE library_roots ADD COLUMN unavailable_since INTEGER;`,
+			`CREATE TABLE IF NOT EXISTS offline_items (
+				media_id TEXT PRIMARY KEY,
+				offline_since INTEGER NOT NULL,
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+		},
+	},
+	{
+		version: 30,
+		statements: []string{
+			// Identität des Videos für die Erkennung verschobener und umbenannter
+			// Dateien: Gerät/Inode und Fingerprint aus Größe, erstem und letztem MiB
+			`ALTER TABLE media_items ADD COLUMN file_id TEXT;`,
+			`ALTER TABLE media_items ADD COLUMN fingerprint TEXT;`,
+			`CREATE INDEX IF NOT EXISTS idx_media_items_fingerprint ON media_items(fingerprint);`,
+			`ALTER TABLE scan_runs ADD COLUMN moved INTEGER NOT NULL DEFAULT 0;`,
+		},
+	},
+	{
+		version: 31,
+		statements: []string{
+			// Vollständiger SHA-256 für Videos mit gleichem Fingerprint, berechnet vom
+			// optionalen Hash-Job; Index für Duplikate mit gleicher IMDb-ID
+			`ALTER TABLE media_items ADD COLUMN content_hash TEXT;`,
+			`CREATE INDEX IF NOT EXISTS idx_nfo_imdb_id ON nfo(imdb_id);`,
+		},
+	},
+	{
+		version: 32,
+		statements: []string{
+			// Teile gestapelter Videos (Movie.cd1.avi, Movie.cd2.avi) in
+			// Wiedergabereihenfolge; Playback-Positionen können sich auf einen Teil
+			// beziehen (0 = Position im gesamten Stapel)
+			`CREATE TABLE IF NOT EXISTS media_parts (
+				media_id TEXT NOT NULL,
+				part INTEGER NOT NULL,
+				path TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				modified INTEGER NOT NULL,
+				PRIMARY KEY (media_id, part),
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+			`ALTER TABLE playback_state ADD COLUMN part INTEGER NOT NULL DEFAULT 0;`,
+		},
+	},
+	{
+		version: 33,
+		statements: []string{
+			// Versionen eines Films in mehreren Auflösungen oder Editionen
+			// (Movie.mkv, Movie - 4K.mkv); Version 1 ist die Standardversion.
+			// Transkodierungen werden pro Version zwischengespeichert.
+			`CREATE TABLE IF NOT EXISTS media_versions (
+				media_id TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				path TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				modified INTEGER NOT NULL,
+				width INTEGER NOT NULL DEFAULT 0,
+				height INTEGER NOT NULL DEFAULT 0,
+				resolution TEXT,
+				edition TEXT,
+				PRIMARY KEY (media_id, version),
+				FOREIGN KEY (media_id) REFERENCES media_items(id) ON DELETE CASCADE
+			);`,
+			`ALTER TABLE transcoding_cache ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+		},
+	},
+	{
+		version: 34,
+		statements: []string{
+			// Optionales Ablaufdatum für API-Schlüssel (NULL = läuft nie ab)
+			`ALTER TABLE auth_api_keys ADD COLUMN expires_at INTEGER;`,
+		},
+	},
 }
 
 func (s *Store) EnsureSchema() error {
@@ -514,6 +862,12 @@ func (s *Store) applyMigration(migration migration) error {
 		}
 	}
 
+	if migration.apply != nil {
+		if err = migration.apply(tx); err != nil {
+			return fmt.Errorf("storage: migration %d failed: %w", migration.version, err)
+		}
+	}
+
 	if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, migration.version); err != nil {
 		return fmt.Errorf("storage: record migration %d: %w", migration.version, err)
 	}
@@ -523,3 +877,22 @@ func (s *Store) applyMigration(migration migration) error {
 	}
 	return nil
 }
+
+// columnExists reports whether table has a column with the given name
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
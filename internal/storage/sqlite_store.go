@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -37,17 +38,77 @@ func (s *Store) AddRoot(path, rootType string) (server.LibraryRoot, error) {
 		return server.LibraryRoot{}, err
 	}
 
-	var stored server.LibraryRoot
-	var createdAtStored int64
-	if err := s.db.QueryRow(`
-		SELECT id, path, type, created_at
+	return scanLibraryRoot(s.db.QueryRow(`
+		SELECT `+libraryRootColumns+`
 		FROM library_roots
 		WHERE id = ?
-	`, id).Scan(&stored.ID, &stored.Path, &stored.Type, &createdAtStored); err != nil {
+	`, id))
+}
+
+const libraryRootColumns = `id, path, type, created_at, exclude_patterns, scan_interval, extensions, unavailable_since`
+
+func scanLibraryRoot(scanner interface{ Scan(...any) error }) (server.LibraryRoot, error) {
+	var root server.LibraryRoot
+	var createdAt int64
+	var exclude, extensions sql.NullString
+	var unavailableSince sql.NullInt64
+	if err := scanner.Scan(&root.ID, &root.Path, &root.Type, &createdAt, &exclude, &root.ScanIntervalSeconds, &extensions, &unavailableSince); err != nil {
 		return server.LibraryRoot{}, err
 	}
-	stored.CreatedAt = time.Unix(createdAtStored, 0)
-	return stored, nil
+	root.CreatedAt = time.Unix(createdAt, 0)
+	root.Available = !unavailableSince.Valid
+	if unavailableSince.Valid {
+		root.UnavailableSince = time.Unix(unavailableSince.Int64, 0)
+	}
+	root.Exclude = splitList(exclude.String, "\n")
+	root.Extensions = splitList(extensions.String, ",")
+	return root, nil
+}
+
+// splitList reads the exclude patterns and extensions of a root
+func splitList(value, sep string) []string {
+	return trimList(strings.Split(value, sep))
+}
+
+// trimList drops blank entries and surrounding whitespace
+func trimList(entries []string) []string {
+	list := []string{}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// UpdateRoot stores the settings of a root. It reports false when the root does not
+// exist.
+func (s *Store) UpdateRoot(root server.LibraryRoot) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("storage: missing database connection")
+	}
+
+	rootType := strings.TrimSpace(root.Type)
+	if rootType == "" {
+		rootType = "library"
+	}
+	result, err := s.db.Exec(`
+		UPDATE library_roots
+		SET type = ?, exclude_patterns = ?, scan_interval = ?, extensions = ?
+		WHERE id = ?
+	`, rootType,
+		nullString(strings.Join(trimList(root.Exclude), "\n")),
+		root.ScanIntervalSeconds,
+		nullString(strings.Join(trimList(root.Extensions), ",")),
+		root.ID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
 }
 
 func (s *Store) ListRoots() ([]server.LibraryRoot, error) {
@@ -56,7 +117,7 @@ func (s *Store) ListRoots() ([]server.LibraryRoot, error) {
 	}
 
 	rows, err := s.db.Query(`
-		SELECT id, path, type, created_at
+		SELECT ` + libraryRootColumns + `
 		FROM library_roots
 		ORDER BY created_at
 	`)
@@ -67,12 +128,10 @@ func (s *Store) ListRoots() ([]server.LibraryRoot, error) {
 
 	var roots []server.LibraryRoot
 	for rows.Next() {
-		var root server.LibraryRoot
-		var createdAt int64
-		if err := rows.Scan(&root.ID, &root.Path, &root.Type, &createdAt); err != nil {
+		root, err := scanLibraryRoot(rows)
+		if err != nil {
 			return nil, err
 		}
-		root.CreatedAt = time.Unix(createdAt, 0)
 		roots = append(roots, root)
 	}
 	if err := rows.Err(); err != nil {
@@ -88,11 +147,18 @@ func (s *Store) RemoveRoot(id string) error {
 	if strings.TrimSpace(id) == "" {
 		return nil
 	}
+	// Foreign keys are not enabled on every pooled connection, so drop the grants explicitly
+	if _, err := s.db.Exec(`DELETE FROM auth_user_roots WHERE root_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM scan_runs WHERE root_id = ?`, id); err != nil {
+		return err
+	}
 	_, err := s.db.Exec(`DELETE FROM library_roots WHERE id = ?`, id)
 	return err
 }
 
-func (s *Store) StartScanRun(rootID string, startedAt time.Time) (server.ScanRun, error) {
+func (s *Store) StartScanRun(rootID, path string, fullScan bool, startedAt time.Time) (server.ScanRun, error) {
 	if s == nil || s.db == nil {
 		return server.ScanRun{}, fmt.Errorf("storage: missing database connection")
 	}
@@ -103,22 +169,41 @@ func (s *Store) StartScanRun(rootID string, startedAt time.Time) (server.ScanRun
 		startedAt = time.Now()
 	}
 	id := scanRunID(rootID, startedAt)
+	full := 0
+	if fullScan {
+		full = 1
+	}
 	_, err := s.db.Exec(`
-		INSERT INTO scan_runs (id, root_id, started_at, status)
-		VALUES (?, ?, ?, ?)
-	`, id, rootID, startedAt.Unix(), "running")
+		INSERT INTO scan_runs (id, root_id, path, full_scan, started_at, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, rootID, nullString(path), full, startedAt.Unix(), server.ScanRunning)
 	if err != nil {
 		return server.ScanRun{}, err
 	}
 	return server.ScanRun{
 		ID:        id,
 		RootID:    rootID,
+		Path:      path,
+		FullScan:  fullScan,
 		StartedAt: startedAt,
-		Status:    "running",
+		Status:    server.ScanRunning,
 	}, nil
 }
 
 func (s *Store) FinishScanRun(id string, finishedAt time.Time) error {
+	return s.endScanRun(id, finishedAt, server.ScanSuccess, "")
+}
+
+func (s *Store) FailScanRun(id string, finishedAt time.Time, errMsg string) error {
+	return s.endScanRun(id, finishedAt, server.ScanFailed, errMsg)
+}
+
+// CancelScanRun marks a scan run as canceled by a user
+func (s *Store) CancelScanRun(id string, finishedAt time.Time) error {
+	return s.endScanRun(id, finishedAt, server.ScanCanceled, "")
+}
+
+func (s *Store) endScanRun(id string, finishedAt time.Time, status, errMsg string) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
@@ -130,30 +215,119 @@ func (s *Store) FinishScanRun(id string, finishedAt time.Time) error {
 	}
 	_, err := s.db.Exec(`
 		UPDATE scan_runs
-		SET finished_at = ?, status = ?, error = NULL
+		SET finished_at = ?, status = ?, error = ?
 		WHERE id = ?
-	`, finishedAt.Unix(), "success", id)
+	`, finishedAt.Unix(), status, nullString(errMsg), id)
 	return err
 }
 
-func (s *Store) FailScanRun(id string, finishedAt time.Time, errMsg string) error {
+// UpdateScanRunProgress stores the counters of a scan run
+func (s *Store) UpdateScanRunProgress(id string, progress server.ScanProgress) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
-	if strings.TrimSpace(id) == "" {
-		return nil
-	}
-	if finishedAt.IsZero() {
-		finishedAt = time.Now()
-	}
 	_, err := s.db.Exec(`
 		UPDATE scan_runs
-		SET finished_at = ?, status = ?, error = ?
+		SET dirs_visited = ?, files_found = ?, added = ?, updated = ?, removed = ?, moved = ?
 		WHERE id = ?
-	`, finishedAt.Unix(), "failed", nullString(errMsg), id)
+	`, progress.DirsVisited, progress.FilesFound, progress.Added, progress.Updated, progress.Removed, progress.Moved, id)
 	return err
 }
 
+const scanRunColumns = `id, root_id, path, full_scan, started_at, finished_at, status, error,
+	dirs_visited, files_found, added, updated, removed, moved`
+
+func scanScanRun(scanner interface{ Scan(...any) error }) (server.ScanRun, error) {
+	var (
+		run        server.ScanRun
+		path       sql.NullString
+		fullScan   int
+		startedAt  int64
+		finishedAt sql.NullInt64
+		errorMsg   sql.NullString
+	)
+	if err := scanner.Scan(&run.ID, &run.RootID, &path, &fullScan, &startedAt, &finishedAt, &run.Status, &errorMsg,
+		&run.Progress.DirsVisited, &run.Progress.FilesFound, &run.Progress.Added, &run.Progress.Updated, &run.Progress.Removed, &run.Progress.Moved); err != nil {
+		return server.ScanRun{}, err
+	}
+	run.Path = path.String
+	run.FullScan = fullScan == 1
+	run.StartedAt = time.Unix(startedAt, 0)
+	if finishedAt.Valid {
+		run.FinishedAt = time.Unix(finishedAt.Int64, 0)
+	}
+	run.Error = errorMsg.String
+	return run, nil
+}
+
+// GetScanRun returns a single scan run
+func (s *Store) GetScanRun(id string) (server.ScanRun, bool, error) {
+	if s == nil || s.db == nil {
+		return server.ScanRun{}, false, fmt.Errorf("storage: missing database connection")
+	}
+	run, err := scanScanRun(s.db.QueryRow(`SELECT `+scanRunColumns+` FROM scan_runs WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return server.ScanRun{}, false, nil
+		}
+		return server.ScanRun{}, false, err
+	}
+	return run, true, nil
+}
+
+// ListScanRuns returns scan runs, newest first, and the total count
+func (s *Store) ListScanRuns(limit, offset int) ([]server.ScanRun, int, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, fmt.Errorf("storage: missing database connection")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM scan_runs`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + scanRunColumns + ` FROM scan_runs ORDER BY started_at DESC, id`
+	args := []any{}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	runs := []server.ScanRun{}
+	for rows.Next() {
+		run, err := scanScanRun(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+// InterruptScanRuns fails the runs left running by a previous process
+func (s *Store) InterruptScanRuns(finishedAt time.Time) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("storage: missing database connection")
+	}
+	result, err := s.db.Exec(`
+		UPDATE scan_runs
+		SET finished_at = ?, status = ?, error = ?
+		WHERE status = ?
+	`, finishedAt.Unix(), server.ScanFailed, "interrupted by shutdown", server.ScanRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (s *Store) SaveItems(items []server.MediaItem) (err error) {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
@@ -178,8 +352,8 @@ func (s *Store) SaveItems(items []server.MediaItem) (err error) {
 		}
 
 		stmt, err := tx.Prepare(`
-		INSERT INTO media_items (id, path, title, size, modified, nfo_path, stable_key, poster_path)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO media_items (id, path, title, size, modified, nfo_path, stable_key, poster_path, file_id, fingerprint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			path=excluded.path,
 			title=excluded.title,
@@ -187,7 +361,10 @@ func (s *Store) SaveItems(items []server.MediaItem) (err error) {
 			modified=excluded.modified,
 			nfo_path=excluded.nfo_path,
 			stable_key=excluded.stable_key,
-			poster_path=excluded.poster_path
+			poster_path=excluded.poster_path,
+			file_id=excluded.file_id,
+			fingerprint=excluded.fingerprint,
+			content_hash=CASE WHEN media_items.fingerprint IS excluded.fingerprint THEN media_items.content_hash ELSE NULL END
 	`)
 		if err != nil {
 			rollback()
@@ -204,7 +381,15 @@ func (s *Store) SaveItems(items []server.MediaItem) (err error) {
 				nullString(item.NFOPath),
 				nullString(item.StableKey),
 				nullString(item.PosterPath),
+				nullString(item.FileID),
+				nullString(item.Fingerprint),
 			)
+			if err == nil {
+				err = replaceMediaPartsTx(tx, item)
+			}
+			if err == nil {
+				err = replaceMediaVersionsTx(tx, item)
+			}
 			if err != nil {
 				stmt.Close()
 				rollback()
@@ -226,6 +411,28 @@ func (s *Store) SaveItems(items []server.MediaItem) (err error) {
 	return nil
 }
 
+// itemTables lists the tables holding per-item data that DeleteItems removes along
+// with the items. Foreign keys are not enabled on every pooled connection, so the
+// rows are deleted explicitly.
+var itemTables = []string{
+	"playback_state",
+	"media_rating_overrides",
+	"media_files",
+	"media_parts",
+	"media_versions",
+	"nfo_actors",
+	"nfo_unique_ids",
+	"nfo_stream_video",
+	"nfo_stream_audio",
+	"nfo_stream_subtitle",
+	"offline_items",
+	"nfo",
+	"episodes",
+	"media_items",
+}
+
+// DeleteItems removes media items with their playback states, metadata and episode
+// entries. Seasons and shows left without episodes are removed as well.
 func (s *Store) DeleteItems(ids []string) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
@@ -234,18 +441,6 @@ func (s *Store) DeleteItems(ids []string) error {
 		return nil
 	}
 
-	placeholders := make([]string, len(ids))
-	args := make([]any, len(ids))
-	for i, id := range ids {
-		placeholders[i] = "?"
-		args[i] = id
-	}
-
-	query := fmt.Sprintf(
-		"DELETE FROM media_items WHERE id IN (%s)",
-		strings.Join(placeholders, ","),
-	)
-
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
@@ -254,18 +449,75 @@ func (s *Store) DeleteItems(ids []string) error {
 		_ = tx.Rollback()
 	}
 
-	if _, err := tx.Exec(query, args...); err != nil {
-		rollback()
-		return err
+	seasonIDs := map[string]bool{}
+	for start := 0; start < len(ids); start += mediaFilesBatchSize {
+		batch := ids[start:min(start+mediaFilesBatchSize, len(ids))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		in := strings.Join(placeholders, ",")
+
+		rows, err := tx.Query(fmt.Sprintf(`SELECT DISTINCT season_id FROM episodes WHERE media_id IN (%s)`, in), args...)
+		if err != nil {
+			rollback()
+			return err
+		}
+		for rows.Next() {
+			var seasonID string
+			if err := rows.Scan(&seasonID); err != nil {
+				rows.Close()
+				rollback()
+				return err
+			}
+			seasonIDs[seasonID] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			rollback()
+			return err
+		}
+
+		for _, table := range itemTables {
+			column := "media_id"
+			if table == "media_items" {
+				column = "id"
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s IN (%s)`, table, column, in), args...); err != nil {
+				rollback()
+				return err
+			}
+		}
 	}
 
-	playbackQuery := fmt.Sprintf(
-		"DELETE FROM playback_state WHERE media_id IN (%s)",
-		strings.Join(placeholders, ","),
-	)
-	if _, err := tx.Exec(playbackQuery, args...); err != nil {
-		rollback()
-		return err
+	// Only seasons and shows that lost episodes here are candidates; shows created
+	// without episodes stay untouched
+	for seasonID := range seasonIDs {
+		var showID string
+		if err := tx.QueryRow(`SELECT show_id FROM seasons WHERE id = ?`, seasonID).Scan(&showID); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			rollback()
+			return err
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM seasons
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM episodes WHERE season_id = ?)
+		`, seasonID, seasonID); err != nil {
+			rollback()
+			return err
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM tv_shows
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM seasons WHERE show_id = ?)
+		`, showID, showID); err != nil {
+			rollback()
+			return err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -281,9 +533,10 @@ func (s *Store) GetAll() ([]server.MediaItem, error) {
 	}
 
 	rows, err := s.db.Query(`
-		SELECT id, path, title, size, modified, nfo_path, stable_key, poster_path
-		FROM media_items
-		ORDER BY title
+		SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path, m.file_id, m.fingerprint, o.offline_since
+		FROM media_items m
+		LEFT JOIN offline_items o ON o.media_id = m.id
+		ORDER BY m.title
 	`)
 	if err != nil {
 		return nil, err
@@ -293,33 +546,56 @@ func (s *Store) GetAll() ([]server.MediaItem, error) {
 	var items []server.MediaItem
 	for rows.Next() {
 		var (
-			id         string
-			path       string
-			title      sql.NullString
-			size       int64
-			modified   int64
-			nfoPath    sql.NullString
-			stable     sql.NullString
-			posterPath sql.NullString
+			id          string
+			path        string
+			title       sql.NullString
+			size        int64
+			modified    int64
+			nfoPath     sql.NullString
+			stable      sql.NullString
+			posterPath  sql.NullString
+			fileID      sql.NullString
+			fingerprint sql.NullString
+			offline     sql.NullInt64
 		)
-		if err := rows.Scan(&id, &path, &title, &size, &modified, &nfoPath, &stable, &posterPath); err != nil {
+		if err := rows.Scan(&id, &path, &title, &size, &modified, &nfoPath, &stable, &posterPath, &fileID, &fingerprint, &offline); err != nil {
 			return nil, err
 		}
-		items = append(items, server.MediaItem{
-			ID:         id,
-			VideoPath:  path,
-			Title:      title.String,
-			Size:       size,
-			Modified:   time.Unix(modified, 0),
-			NFOPath:    nfoPath.String,
-			StableKey:  stable.String,
-			PosterPath: posterPath.String,
-		})
+		item := server.MediaItem{
+			ID:          id,
+			VideoPath:   path,
+			Title:       title.String,
+			Size:        size,
+			Modified:    time.Unix(modified, 0),
+			NFOPath:     nfoPath.String,
+			StableKey:   stable.String,
+			PosterPath:  posterPath.String,
+			FileID:      fileID.String,
+			Fingerprint: fingerprint.String,
+		}
+		if offline.Valid {
+			item.OfflineSince = time.Unix(offline.Int64, 0)
+		}
+		items = append(items, item)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	rows.Close()
+
+	parts, err := s.getMediaParts("")
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.getMediaVersions("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Parts = parts[items[i].ID]
+		items[i].Versions = versions[items[i].ID]
+	}
 
 	return items, nil
 }
@@ -414,13 +690,15 @@ func (s *Store) GetByID(id string) (server.MediaItem, bool, error) {
 		nfoPath    sql.NullString
 		stableKey  sql.NullString
 		posterPath sql.NullString
+		offline    sql.NullInt64
 	)
 
 	err := s.db.QueryRow(`
-		SELECT id, path, title, size, modified, nfo_path, stable_key, poster_path
-		FROM media_items
-		WHERE id = ?
-	`, id).Scan(&item.ID, &item.VideoPath, &title, &item.Size, &modified, &nfoPath, &stableKey, &posterPath)
+		SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path, o.offline_since
+		FROM media_items m
+		LEFT JOIN offline_items o ON o.media_id = m.id
+		WHERE m.id = ?
+	`, id).Scan(&item.ID, &item.VideoPath, &title, &item.Size, &modified, &nfoPath, &stableKey, &posterPath, &offline)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return server.MediaItem{}, false, nil
@@ -441,6 +719,19 @@ func (s *Store) GetByID(id string) (server.MediaItem, bool, error) {
 	if posterPath.Valid {
 		item.PosterPath = posterPath.String
 	}
+	if offline.Valid {
+		item.OfflineSince = time.Unix(offline.Int64, 0)
+	}
+	parts, err := s.getMediaParts(item.ID)
+	if err != nil {
+		return server.MediaItem{}, false, err
+	}
+	item.Parts = parts[item.ID]
+	versions, err := s.getMediaVersions(item.ID)
+	if err != nil {
+		return server.MediaItem{}, false, err
+	}
+	item.Versions = versions[item.ID]
 
 	return item, true, nil
 }
@@ -657,7 +948,7 @@ func (s *Store) GetNFO(mediaID string) (*server.NFO, bool, error) {
 	return &nfo, true, nil
 }
 
-func (s *Store) UpsertPlaybackState(mediaID string, positionSeconds, durationSeconds int64, lastPlayedAt int64, percentComplete *float64, clientID string) error {
+func (s *Store) UpsertPlaybackState(userID, mediaID string, positionSeconds, durationSeconds int64, lastPlayedAt int64, percentComplete *float64, clientID string, part int) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
@@ -669,39 +960,42 @@ func (s *Store) UpsertPlaybackState(mediaID string, positionSeconds, durationSec
 	}
 	_, err := s.db.Exec(`
 		INSERT INTO playback_state (
-			media_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id
+			media_id, user_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id, part
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(media_id, client_id) DO UPDATE SET
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(media_id, user_id, client_id) DO UPDATE SET
 			position_seconds=excluded.position_seconds,
 			duration_seconds=excluded.duration_seconds,
 			updated_at=excluded.updated_at,
 			last_played_at=excluded.last_played_at,
-			percent_complete=excluded.percent_complete
+			percent_complete=excluded.percent_complete,
+			part=excluded.part
 	`,
 		mediaID,
+		userID,
 		positionSeconds,
 		durationSeconds,
 		time.Now().Unix(),
 		lastPlayedAt,
 		percentValue,
 		normalizedClientID,
+		part,
 	)
 	return err
 }
 
-func (s *Store) GetPlaybackState(mediaID, clientID string) (*server.PlaybackState, bool, error) {
+func (s *Store) GetPlaybackState(userID, mediaID, clientID string) (*server.PlaybackState, bool, error) {
 	if s == nil || s.db == nil {
 		return nil, false, fmt.Errorf("storage: missing database connection")
 	}
 
 	normalizedClientID := strings.TrimSpace(clientID)
 	query := `
-		SELECT media_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id
+		SELECT media_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id, part
 		FROM playback_state
-		WHERE media_id = ?
+		WHERE media_id = ? AND user_id = ?
 	`
-	args := []any{mediaID}
+	args := []any{mediaID, userID}
 	if normalizedClientID != "" {
 		query += " AND client_id = ?"
 		args = append(args, normalizedClientID)
@@ -719,6 +1013,7 @@ func (s *Store) GetPlaybackState(mediaID, clientID string) (*server.PlaybackStat
 		&state.LastPlayedAt,
 		&percentComplete,
 		&state.ClientID,
+		&state.Part,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -735,14 +1030,14 @@ func (s *Store) GetPlaybackState(mediaID, clientID string) (*server.PlaybackStat
 	return &state, true, nil
 }
 
-func (s *Store) DeletePlaybackState(mediaID, clientID string) error {
+func (s *Store) DeletePlaybackState(userID, mediaID, clientID string) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
 
 	normalizedClientID := strings.TrimSpace(clientID)
-	query := "DELETE FROM playback_state WHERE media_id = ?"
-	args := []any{mediaID}
+	query := "DELETE FROM playback_state WHERE media_id = ? AND user_id = ?"
+	args := []any{mediaID, userID}
 	if normalizedClientID != "" {
 		query += " AND client_id = ?"
 		args = append(args, normalizedClientID)
@@ -804,17 +1099,17 @@ func scanRunID(rootID string, startedAt time.Time) string {
 }
 
 // Verbesserung 2: Batch-Operations für Playback-State
-func (s *Store) GetAllPlaybackStates(clientID string, onlyUnfinished bool) ([]server.PlaybackState, error) {
+func (s *Store) GetAllPlaybackStates(userID, clientID string, onlyUnfinished bool) ([]server.PlaybackState, error) {
 	if s == nil || s.db == nil {
 		return nil, fmt.Errorf("storage: missing database connection")
 	}
 
 	query := `
-		SELECT media_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id
+		SELECT media_id, position_seconds, duration_seconds, updated_at, last_played_at, percent_complete, client_id, part
 		FROM playback_state
-		WHERE 1=1
+		WHERE user_id = ?
 	`
-	args := []any{}
+	args := []any{userID}
 
 	normalizedClientID := strings.TrimSpace(clientID)
 	if normalizedClientID != "" {
@@ -846,6 +1141,7 @@ func (s *Store) GetAllPlaybackStates(clientID string, onlyUnfinished bool) ([]se
 			&state.LastPlayedAt,
 			&percentComplete,
 			&state.ClientID,
+			&state.Part,
 		); err != nil {
 			return nil, err
 		}
@@ -864,61 +1160,203 @@ func (s *Store) GetAllPlaybackStates(clientID string, onlyUnfinished bool) ([]se
 }
 
 // Verbesserung 4: Duplicate Detection
+// GetDuplicates returns copies of the same video, found by their fingerprint, and
+// different files of the same title, found by the IMDb ID of their NFO. Copies come
+// first, each sorted by the space deleting them would free.
 func (s *Store) GetDuplicates() ([]server.DuplicateGroup, error) {
 	if s == nil || s.db == nil {
 		return nil, fmt.Errorf("storage: missing database connection")
 	}
 
-	query := `
-		SELECT stable_key, GROUP_CONCAT(id, ',') as ids, COUNT(*) as count
-		FROM media_items
-		WHERE stable_key IS NOT NULL AND stable_key != ''
-		GROUP BY stable_key
-		HAVING count > 1
-		ORDER BY count DESC, stable_key
-	`
-
-	rows, err := s.db.Query(query)
+	rows, err := s.db.Query(`
+		SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path,
+			m.fingerprint, m.content_hash, o.offline_since, n.imdb_id, n.type, n.season, n.episode
+		FROM media_items m
+		LEFT JOIN offline_items o ON o.media_id = m.id
+		LEFT JOIN nfo n ON n.media_id = m.id
+		WHERE m.fingerprint IN (
+			SELECT fingerprint FROM media_items
+			WHERE fingerprint IS NOT NULL AND fingerprint != ''
+			GROUP BY fingerprint HAVING COUNT(*) > 1
+		)
+		OR n.imdb_id IN (
+			SELECT imdb_id FROM nfo
+			WHERE imdb_id IS NOT NULL AND imdb_id != ''
+			GROUP BY imdb_id HAVING COUNT(*) > 1
+		)
+		ORDER BY m.path
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var groups []server.DuplicateGroup
+	byFingerprint := map[string][]server.MediaItem{}
+	byIMDb := map[string][]server.MediaItem{}
+	var fingerprints, imdbKeys []string
 	for rows.Next() {
-		var stableKey string
-		var idsStr string
-		var count int
-		if err := rows.Scan(&stableKey, &idsStr, &count); err != nil {
+		var (
+			item        server.MediaItem
+			title       sql.NullString
+			modified    int64
+			nfoPath     sql.NullString
+			stable      sql.NullString
+			posterPath  sql.NullString
+			fingerprint sql.NullString
+			contentHash sql.NullString
+			offline     sql.NullInt64
+			imdbID      sql.NullString
+			itemType    sql.NullString
+			season      sql.NullInt64
+			episode     sql.NullInt64
+		)
+		if err := rows.Scan(&item.ID, &item.VideoPath, &title, &item.Size, &modified, &nfoPath, &stable, &posterPath,
+			&fingerprint, &contentHash, &offline, &imdbID, &itemType, &season, &episode); err != nil {
 			return nil, err
 		}
+		item.Title = title.String
+		item.Modified = time.Unix(modified, 0)
+		item.NFOPath = nfoPath.String
+		item.StableKey = stable.String
+		item.PosterPath = posterPath.String
+		item.Fingerprint = fingerprint.String
+		item.ContentHash = contentHash.String
+		if offline.Valid {
+			item.OfflineSince = time.Unix(offline.Int64, 0)
+		}
 
-		ids := strings.Split(idsStr, ",")
-		items := make([]server.MediaItem, 0, len(ids))
-		for _, id := range ids {
-			item, ok, err := s.GetByID(id)
-			if err != nil {
-				return nil, err
+		if item.Fingerprint != "" {
+			if _, ok := byFingerprint[item.Fingerprint]; !ok {
+				fingerprints = append(fingerprints, item.Fingerprint)
+			}
+			byFingerprint[item.Fingerprint] = append(byFingerprint[item.Fingerprint], item)
+		}
+		if imdbID.String != "" {
+			// Episodes inherit the IMDb ID of their show
+			key := imdbID.String
+			if itemType.String == "episodedetails" || (season.Valid && episode.Valid) {
+				key = fmt.Sprintf("%s/S%02dE%02d", key, season.Int64, episode.Int64)
 			}
-			if ok {
-				items = append(items, item)
+			if _, ok := byIMDb[key]; !ok {
+				imdbKeys = append(imdbKeys, key)
 			}
+			byIMDb[key] = append(byIMDb[key], item)
 		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		if len(items) > 1 {
-			groups = append(groups, server.DuplicateGroup{
-				StableKey: stableKey,
-				Items:     items,
-				Count:     len(items),
-			})
+	var content, imdb []server.DuplicateGroup
+	for _, fingerprint := range fingerprints {
+		for key, items := range splitByContentHash(fingerprint, byFingerprint[fingerprint]) {
+			if len(items) > 1 {
+				content = append(content, server.NewDuplicateGroup(server.DuplicateContent, key, items))
+			}
+		}
+	}
+	for _, key := range imdbKeys {
+		items := byIMDb[key]
+		// Copies of one file are reported as content duplicates already
+		distinct := map[string]bool{}
+		for _, item := range items {
+			if item.Fingerprint == "" {
+				distinct[item.ID] = true
+			} else {
+				distinct[item.Fingerprint] = true
+			}
+		}
+		if len(distinct) > 1 {
+			imdb = append(imdb, server.NewDuplicateGroup(server.DuplicateIMDb, key, items))
 		}
 	}
 
-	if err := rows.Err(); err != nil {
+	bySize := func(groups []server.DuplicateGroup) {
+		sort.SliceStable(groups, func(i, j int) bool {
+			if groups[i].ReclaimableSize != groups[j].ReclaimableSize {
+				return groups[i].ReclaimableSize > groups[j].ReclaimableSize
+			}
+			return groups[i].Key < groups[j].Key
+		})
+	}
+	bySize(content)
+	bySize(imdb)
+	return append(content, imdb...), nil
+}
+
+// splitByContentHash keeps copies sharing a fingerprint together unless the hash
+// job found that their content differs. The groups are then keyed by content hash,
+// copies not hashed yet stay under the fingerprint.
+func splitByContentHash(fingerprint string, items []server.MediaItem) map[string][]server.MediaItem {
+	hashes := map[string]bool{}
+	for _, item := range items {
+		if item.ContentHash != "" {
+			hashes[item.ContentHash] = true
+		}
+	}
+	if len(hashes) <= 1 {
+		return map[string][]server.MediaItem{fingerprint: items}
+	}
+	groups := map[string][]server.MediaItem{}
+	for _, item := range items {
+		key := item.ContentHash
+		if key == "" {
+			key = fingerprint
+		}
+		groups[key] = append(groups[key], item)
+	}
+	return groups
+}
+
+// ListHashCandidates returns the items sharing their fingerprint with another item
+// that have no content hash yet
+func (s *Store) ListHashCandidates() ([]server.MediaItem, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, path, size, fingerprint
+		FROM media_items
+		WHERE content_hash IS NULL AND fingerprint IN (
+			SELECT fingerprint FROM media_items
+			WHERE fingerprint IS NOT NULL AND fingerprint != ''
+			GROUP BY fingerprint HAVING COUNT(*) > 1
+		)
+		ORDER BY path
+	`)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var items []server.MediaItem
+	for rows.Next() {
+		var item server.MediaItem
+		if err := rows.Scan(&item.ID, &item.VideoPath, &item.Size, &item.Fingerprint); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
 
-	return groups, nil
+// SetContentHash stores the full SHA-256 of an item. It reports false when the
+// fingerprint changed meanwhile, i.e. the hash belongs to an outdated file.
+func (s *Store) SetContentHash(id, fingerprint, hash string) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`UPDATE media_items SET content_hash = ? WHERE id = ? AND fingerprint = ?`, hash, id, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
 }
 
 // Verbesserung 5: Erweiterte Statistiken
@@ -992,7 +1430,7 @@ func (s *Store) GetDetailedStats() (*server.DetailedStats, error) {
 
 	// Recent scan runs
 	rows, err = s.db.Query(`
-		SELECT id, root_id, started_at, finished_at, status, error
+		SELECT ` + scanRunColumns + `
 		FROM scan_runs
 		ORDER BY started_at DESC
 		LIMIT 10
@@ -1001,19 +1439,11 @@ func (s *Store) GetDetailedStats() (*server.DetailedStats, error) {
 		return nil, err
 	}
 	for rows.Next() {
-		var run server.ScanRun
-		var finishedAt sql.NullInt64
-		var errorMsg sql.NullString
-		var startedAtUnix int64
-		if err := rows.Scan(&run.ID, &run.RootID, &startedAtUnix, &finishedAt, &run.Status, &errorMsg); err != nil {
+		run, err := scanScanRun(rows)
+		if err != nil {
 			rows.Close()
 			return nil, err
 		}
-		run.StartedAt = time.Unix(startedAtUnix, 0)
-		if finishedAt.Valid {
-			run.FinishedAt = time.Unix(finishedAt.Int64, 0)
-		}
-		run.Error = errorMsg.String
 		stats.RecentScans = append(stats.RecentScans, run)
 	}
 	rows.Close()
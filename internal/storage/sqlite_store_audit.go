@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// AddAuditEntry appends an entry to the audit log
+func (s *Store) AddAuditEntry(entry server.AuditEntry) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	timestamp := entry.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (created_at, actor_id, actor, action, target, ip, outcome)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, timestamp.Unix(), nullString(entry.ActorID), nullString(entry.Actor), entry.Action,
+		nullString(entry.Target), nullString(entry.IP), entry.Outcome)
+	return err
+}
+
+// ListAuditEntries returns matching entries, newest first, and the total number of matches
+func (s *Store) ListAuditEntries(filter server.AuditFilter, limit, offset int) ([]server.AuditEntry, int, error) {
+	if s == nil || s.db == nil {
+		return nil, 0, fmt.Errorf("storage: missing database connection")
+	}
+	if limit < 0 || offset < 0 {
+		return nil, 0, fmt.Errorf("storage: invalid limit or offset")
+	}
+
+	conditions := []string{}
+	args := []any{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "(actor = ? OR actor_id = ?)")
+		args = append(args, filter.Actor, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "(action = ? OR action LIKE ? ESCAPE '\\')")
+		args = append(args, filter.Action, escapeLike(filter.Action)+".%")
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, created_at, actor_id, actor, action, target, ip, outcome
+		FROM audit_log
+		` + where + `
+		ORDER BY created_at DESC, id DESC`
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	} else if offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		args = append(args, offset)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []server.AuditEntry{}
+	for rows.Next() {
+		var (
+			entry                      server.AuditEntry
+			createdAt                  int64
+			actorID, actor, target, ip sql.NullString
+		)
+		if err := rows.Scan(&entry.ID, &createdAt, &actorID, &actor, &entry.Action, &target, &ip, &entry.Outcome); err != nil {
+			return nil, 0, err
+		}
+		entry.Timestamp = time.Unix(createdAt, 0)
+		entry.ActorID = actorID.String
+		entry.Actor = actor.String
+		entry.Target = target.String
+		entry.IP = ip.String
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// PruneAuditEntries deletes entries older than before and returns how many were removed
+func (s *Store) PruneAuditEntries(before time.Time) (int64, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`DELETE FROM audit_log WHERE created_at < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// escapeLike escapes the LIKE wildcards in value for use with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
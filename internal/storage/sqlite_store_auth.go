@@ -21,26 +21,15 @@ func (s *Store) CreateUser(user auth.User) error {
 	return err
 }
 
-// GetUser retrieves a user by ID
-func (s *Store) GetUser(id string) (*auth.User, error) {
-	if s == nil || s.db == nil {
-		return nil, fmt.Errorf("storage: missing database connection")
-	}
+const authUserColumns = `id, username, password_hash, is_admin, created_at, last_login, totp_secret, totp_enabled, totp_last_step`
 
+func scanAuthUser(scanner interface{ Scan(...any) error }) (*auth.User, error) {
 	var user auth.User
-	var createdAt, lastLogin sql.NullInt64
-	var isAdmin int
+	var createdAt, lastLogin, totpLastStep sql.NullInt64
+	var totpSecret sql.NullString
+	var isAdmin, totpEnabled int
 
-	err := s.db.QueryRow(`
-		SELECT id, username, password_hash, is_admin, created_at, last_login
-		FROM auth_users
-		WHERE id = ?
-	`, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &isAdmin, &createdAt, &lastLogin)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, auth.ErrUserNotFound
-		}
+	if err := scanner.Scan(&user.ID, &user.Username, &user.PasswordHash, &isAdmin, &createdAt, &lastLogin, &totpSecret, &totpEnabled, &totpLastStep); err != nil {
 		return nil, err
 	}
 
@@ -51,26 +40,24 @@ func (s *Store) GetUser(id string) (*auth.User, error) {
 	if lastLogin.Valid {
 		user.LastLogin = time.Unix(lastLogin.Int64, 0)
 	}
+	user.TOTPSecret = totpSecret.String
+	user.TOTPEnabled = totpEnabled == 1
+	user.TOTPLastStep = totpLastStep.Int64
 
 	return &user, nil
 }
 
-// GetUserByUsername retrieves a user by username
-func (s *Store) GetUserByUsername(username string) (*auth.User, error) {
+// GetUser retrieves a user by ID
+func (s *Store) GetUser(id string) (*auth.User, error) {
 	if s == nil || s.db == nil {
 		return nil, fmt.Errorf("storage: missing database connection")
 	}
 
-	var user auth.User
-	var createdAt, lastLogin sql.NullInt64
-	var isAdmin int
-
-	err := s.db.QueryRow(`
-		SELECT id, username, password_hash, is_admin, created_at, last_login
+	user, err := scanAuthUser(s.db.QueryRow(`
+		SELECT `+authUserColumns+`
 		FROM auth_users
-		WHERE username = ?
-	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &isAdmin, &createdAt, &lastLogin)
-
+		WHERE id = ?
+	`, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, auth.ErrUserNotFound
@@ -78,15 +65,28 @@ func (s *Store) GetUserByUsername(username string) (*auth.User, error) {
 		return nil, err
 	}
 
-	user.IsAdmin = isAdmin == 1
-	if createdAt.Valid {
-		user.CreatedAt = time.Unix(createdAt.Int64, 0)
+	return user, nil
+}
+
+// GetUserByUsername retrieves a user by username
+func (s *Store) GetUserByUsername(username string) (*auth.User, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
 	}
-	if lastLogin.Valid {
-		user.LastLogin = time.Unix(lastLogin.Int64, 0)
+
+	user, err := scanAuthUser(s.db.QueryRow(`
+		SELECT `+authUserColumns+`
+		FROM auth_users
+		WHERE username = ?
+	`, username))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, auth.ErrUserNotFound
+		}
+		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // UpdateUser updates a user
@@ -97,9 +97,11 @@ func (s *Store) UpdateUser(user auth.User) error {
 
 	_, err := s.db.Exec(`
 		UPDATE auth_users
-		SET username = ?, password_hash = ?, is_admin = ?, last_login = ?
+		SET username = ?, password_hash = ?, is_admin = ?, last_login = ?,
+			totp_secret = ?, totp_enabled = ?, totp_last_step = ?
 		WHERE id = ?
-	`, user.Username, user.PasswordHash, user.IsAdmin, nullInt64FromTime(user.LastLogin), user.ID)
+	`, user.Username, user.PasswordHash, user.IsAdmin, nullInt64FromTime(user.LastLogin),
+		nullString(user.TOTPSecret), user.TOTPEnabled, user.TOTPLastStep, user.ID)
 	return err
 }
 
@@ -120,7 +122,7 @@ func (s *Store) ListUsers() ([]auth.User, error) {
 	}
 
 	rows, err := s.db.Query(`
-		SELECT id, username, password_hash, is_admin, created_at, last_login
+		SELECT ` + authUserColumns + `
 		FROM auth_users
 		ORDER BY username
 	`)
@@ -131,23 +133,11 @@ func (s *Store) ListUsers() ([]auth.User, error) {
 
 	var users []auth.User
 	for rows.Next() {
-		var user auth.User
-		var createdAt, lastLogin sql.NullInt64
-		var isAdmin int
-
-		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &isAdmin, &createdAt, &lastLogin); err != nil {
+		user, err := scanAuthUser(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		user.IsAdmin = isAdmin == 1
-		if createdAt.Valid {
-			user.CreatedAt = time.Unix(createdAt.Int64, 0)
-		}
-		if lastLogin.Valid {
-			user.LastLogin = time.Unix(lastLogin.Int64, 0)
-		}
-
-		users = append(users, user)
+		users = append(users, *user)
 	}
 
 	return users, rows.Err()
@@ -171,28 +161,50 @@ func (s *Store) CreateSession(session auth.Session) error {
 	}
 
 	_, err := s.db.Exec(`
-		INSERT INTO auth_sessions (token, user_id, username, is_admin, created_at, expires_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, session.Token, session.UserID, session.Username, session.IsAdmin, session.CreatedAt.Unix(), session.ExpiresAt.Unix())
+		INSERT INTO auth_sessions (token, id, user_id, username, is_admin, created_at, expires_at, last_seen, client_name, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.Token, session.ID, session.UserID, session.Username, session.IsAdmin, session.CreatedAt.Unix(), session.ExpiresAt.Unix(),
+		nullInt64FromTime(session.LastSeenAt), nullString(session.ClientName), nullString(session.IP))
 	return err
 }
 
+const sessionColumns = `token, id, user_id, username, is_admin, created_at, expires_at, last_seen, client_name, ip`
+
+func scanSession(scanner interface{ Scan(...any) error }) (*auth.Session, error) {
+	var session auth.Session
+	var id, clientName, ip sql.NullString
+	var createdAt, expiresAt int64
+	var lastSeen sql.NullInt64
+	var isAdmin int
+
+	if err := scanner.Scan(&session.Token, &id, &session.UserID, &session.Username, &isAdmin, &createdAt, &expiresAt, &lastSeen, &clientName, &ip); err != nil {
+		return nil, err
+	}
+
+	session.ID = id.String
+	session.IsAdmin = isAdmin == 1
+	session.CreatedAt = time.Unix(createdAt, 0)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	if lastSeen.Valid {
+		session.LastSeenAt = time.Unix(lastSeen.Int64, 0)
+	}
+	session.ClientName = clientName.String
+	session.IP = ip.String
+
+	return &session, nil
+}
+
 // GetSession retrieves a session by token
 func (s *Store) GetSession(token string) (*auth.Session, error) {
 	if s == nil || s.db == nil {
 		return nil, fmt.Errorf("storage: missing database connection")
 	}
 
-	var session auth.Session
-	var createdAt, expiresAt int64
-	var isAdmin int
-
-	err := s.db.QueryRow(`
-		SELECT token, user_id, username, is_admin, created_at, expires_at
+	session, err := scanSession(s.db.QueryRow(`
+		SELECT `+sessionColumns+`
 		FROM auth_sessions
 		WHERE token = ?
-	`, token).Scan(&session.Token, &session.UserID, &session.Username, &isAdmin, &createdAt, &expiresAt)
-
+	`, token))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, auth.ErrInvalidToken
@@ -200,11 +212,71 @@ func (s *Store) GetSession(token string) (*auth.Session, error) {
 		return nil, err
 	}
 
-	session.IsAdmin = isAdmin == 1
-	session.CreatedAt = time.Unix(createdAt, 0)
-	session.ExpiresAt = time.Unix(expiresAt, 0)
+	return session, nil
+}
 
-	return &session, nil
+// GetSessionByID retrieves a session by its public ID
+func (s *Store) GetSessionByID(id string) (*auth.Session, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	session, err := scanSession(s.db.QueryRow(`
+		SELECT `+sessionColumns+`
+		FROM auth_sessions
+		WHERE id = ?
+	`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, auth.ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ListUserSessions lists the unexpired sessions of a user, most recently used first
+func (s *Store) ListUserSessions(userID string) ([]auth.Session, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+`
+		FROM auth_sessions
+		WHERE user_id = ? AND expires_at >= ?
+		ORDER BY COALESCE(last_seen, created_at) DESC
+	`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []auth.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchSession records activity on a session and stores its new expiry
+func (s *Store) TouchSession(token string, lastSeenAt, expiresAt time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE auth_sessions
+		SET last_seen = ?, expires_at = ?
+		WHERE token = ?
+	`, lastSeenAt.Unix(), expiresAt.Unix(), token)
+	return err
 }
 
 // DeleteSession deletes a session
@@ -237,6 +309,317 @@ func (s *Store) CleanExpiredSessions() error {
 	return err
 }
 
+// CreateAPIKey stores a new API key
+func (s *Store) CreateAPIKey(key auth.APIKey) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO auth_api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash, nullString(joinStringList(key.Scopes)), key.CreatedAt.Unix(), nullInt64FromTime(key.LastUsedAt), nullInt64FromTime(key.ExpiresAt))
+	return err
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its plain value
+func (s *Store) GetAPIKeyByHash(hash string) (*auth.APIKey, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	key, err := scanAPIKey(s.db.QueryRow(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at
+		FROM auth_api_keys
+		WHERE key_hash = ?
+	`, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, auth.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys lists the API keys of a user, or of all users when userID is empty
+func (s *Store) ListAPIKeys(userID string) ([]auth.APIKey, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at
+		FROM auth_api_keys
+	`
+	args := []any{}
+	if userID != "" {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []auth.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes an API key
+func (s *Store) DeleteAPIKey(id string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`DELETE FROM auth_api_keys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return auth.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (s *Store) TouchAPIKey(id string, usedAt time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`UPDATE auth_api_keys SET last_used_at = ? WHERE id = ?`, usedAt.Unix(), id)
+	return err
+}
+
+func scanAPIKey(scanner interface{ Scan(...any) error }) (auth.APIKey, error) {
+	var key auth.APIKey
+	var scopes sql.NullString
+	var createdAt int64
+	var lastUsedAt, expiresAt sql.NullInt64
+
+	if err := scanner.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+		return auth.APIKey{}, err
+	}
+
+	key.Scopes = splitStringList(scopes)
+	key.CreatedAt = time.Unix(createdAt, 0)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = time.Unix(lastUsedAt.Int64, 0)
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = time.Unix(expiresAt.Int64, 0)
+	}
+
+	return key, nil
+}
+
+// GetLoginFailure retrieves the failed login record for a key; a missing record has no failures
+func (s *Store) GetLoginFailure(key string) (auth.LoginFailure, error) {
+	if s == nil || s.db == nil {
+		return auth.LoginFailure{}, fmt.Errorf("storage: missing database connection")
+	}
+
+	failure := auth.LoginFailure{Key: key}
+	var lastFailure int64
+	var lockedUntil sql.NullInt64
+
+	err := s.db.QueryRow(`
+		SELECT failures, last_failure, locked_until
+		FROM auth_login_failures
+		WHERE key = ?
+	`, key).Scan(&failure.Failures, &lastFailure, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return failure, nil
+		}
+		return auth.LoginFailure{}, err
+	}
+
+	failure.LastFailure = time.Unix(lastFailure, 0)
+	if lockedUntil.Valid {
+		failure.LockedUntil = time.Unix(lockedUntil.Int64, 0)
+	}
+
+	return failure, nil
+}
+
+// SaveLoginFailure stores the failed login record for a key
+func (s *Store) SaveLoginFailure(failure auth.LoginFailure) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO auth_login_failures (key, failures, last_failure, locked_until)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure = excluded.last_failure,
+			locked_until = excluded.locked_until
+	`, failure.Key, failure.Failures, failure.LastFailure.Unix(), nullInt64FromTime(failure.LockedUntil))
+	return err
+}
+
+// RecordLoginFailure counts a failed login for a key in one statement, so concurrent
+// failures are never lost. Failures last seen before staleBefore start over unless the
+// key is still locked.
+func (s *Store) RecordLoginFailure(key string, at, staleBefore time.Time) (auth.LoginFailure, error) {
+	if s == nil || s.db == nil {
+		return auth.LoginFailure{}, fmt.Errorf("storage: missing database connection")
+	}
+
+	failure := auth.LoginFailure{Key: key, LastFailure: time.Unix(at.Unix(), 0)}
+	var lockedUntil sql.NullInt64
+
+	err := s.db.QueryRow(`
+		INSERT INTO auth_login_failures (key, failures, last_failure, locked_until)
+		VALUES (?, 1, ?, NULL)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE
+				WHEN auth_login_failures.last_failure < ? AND COALESCE(auth_login_failures.locked_until, 0) < excluded.last_failure THEN 1
+				ELSE auth_login_failures.failures + 1
+			END,
+			last_failure = excluded.last_failure
+		RETURNING failures, locked_until
+	`, key, at.Unix(), staleBefore.Unix()).Scan(&failure.Failures, &lockedUntil)
+	if err != nil {
+		return auth.LoginFailure{}, err
+	}
+
+	if lockedUntil.Valid {
+		failure.LockedUntil = time.Unix(lockedUntil.Int64, 0)
+	}
+
+	return failure, nil
+}
+
+// LockLogin locks a key until the given time
+func (s *Store) LockLogin(key string, until time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE auth_login_failures
+		SET locked_until = MAX(COALESCE(locked_until, 0), ?)
+		WHERE key = ?
+	`, until.Unix(), key)
+	return err
+}
+
+// DeleteLoginFailure clears the failed login record for a key
+func (s *Store) DeleteLoginFailure(key string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`DELETE FROM auth_login_failures WHERE key = ?`, key)
+	return err
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of a user with the given hashes
+func (s *Store) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM auth_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`
+			INSERT INTO auth_recovery_codes (user_id, code_hash)
+			VALUES (?, ?)
+		`, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false when
+// the code does not exist or was already used.
+func (s *Store) UseRecoveryCode(userID, hash string, usedAt time.Time) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE auth_recovery_codes
+		SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, usedAt.Unix(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code without touching the
+// other user columns. It reports false when this or a later step was already used,
+// so concurrent logins cannot accept the same code twice.
+func (s *Store) UseTOTPStep(userID string, step int64) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE auth_users
+		SET totp_last_step = ?
+		WHERE id = ? AND COALESCE(totp_last_step, 0) < ?
+	`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of a user
+func (s *Store) CountRecoveryCodes(userID string) (int, error) {
+	if s == nil || s.db == nil {
+		return 0, fmt.Errorf("storage: missing database connection")
+	}
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM auth_recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
 // Helper function for nullable int64 from time
 func nullInt64FromTime(t time.Time) sql.NullInt64 {
 	if t.IsZero() {
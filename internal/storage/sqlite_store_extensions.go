@@ -34,55 +34,53 @@ func (s *Store) queryMediaItemsWithFallback(queryWithPoster, queryWithoutPoster
 
 	items := []server.MediaItem{}
 	for rows.Next() {
-		var item server.MediaItem
+		var id, path string
+		var title, nfoPath, stable, posterPath sql.NullString
+		var size, modified int64
 		if hasPosterPath {
-			var posterPath sql.NullString
-			if err := rows.Scan(&item.ID, &item.VideoPath, &item.Title, &item.Size, &item.Modified, &item.NFOPath, &item.StableKey, &posterPath); err != nil {
+			if err := rows.Scan(&id, &path, &title, &size, &modified, &nfoPath, &stable, &posterPath); err != nil {
 				return nil, err
 			}
-			if posterPath.Valid {
-				item.PosterPath = posterPath.String
-			}
 		} else {
-			if err := rows.Scan(&item.ID, &item.VideoPath, &item.Title, &item.Size, &item.Modified, &item.NFOPath, &item.StableKey); err != nil {
+			if err := rows.Scan(&id, &path, &title, &size, &modified, &nfoPath, &stable); err != nil {
 				return nil, err
 			}
 		}
-		items = append(items, item)
+		items = append(items, server.MediaItem{ID: id, VideoPath: path, Title: title.String, Size: size, Modified: time.Unix(modified, 0), NFOPath: nfoPath.String, StableKey: stable.String, PosterPath: posterPath.String})
 	}
 	return items, rows.Err()
 }
 
 // Watched/Unwatched Status
-func (s *Store) MarkWatched(mediaID string, watchedAt time.Time) error {
+func (s *Store) MarkWatched(userID, mediaID string, watchedAt time.Time) error {
 	if s.readOnly {
 		return fmt.Errorf("storage: read-only mode")
 	}
-	_, err := s.db.Exec(`INSERT INTO watched_items (media_id, watched_at, user_id) VALUES (?, ?, '') ON CONFLICT(media_id, user_id) DO UPDATE SET watched_at = excluded.watched_at`, mediaID, watchedAt.Unix())
+	_, err := s.db.Exec(`INSERT INTO watched_items (media_id, watched_at, user_id) VALUES (?, ?, ?) ON CONFLICT(media_id, user_id) DO UPDATE SET watched_at = excluded.watched_at`, mediaID, watchedAt.Unix(), userID)
 	return err
 }
 
-func (s *Store) UnmarkWatched(mediaID string) error {
+func (s *Store) UnmarkWatched(userID, mediaID string) error {
 	if s.readOnly {
 		return fmt.Errorf("storage: read-only mode")
 	}
-	_, err := s.db.Exec(`DELETE FROM watched_items WHERE media_id = ? AND user_id = ''`, mediaID)
+	_, err := s.db.Exec(`DELETE FROM watched_items WHERE media_id = ? AND user_id = ?`, mediaID, userID)
 	return err
 }
 
-func (s *Store) IsWatched(mediaID string) (bool, error) {
+func (s *Store) IsWatched(userID, mediaID string) (bool, error) {
 	var exists int
-	err := s.db.QueryRow(`SELECT 1 FROM watched_items WHERE media_id = ? AND user_id = '' LIMIT 1`, mediaID).Scan(&exists)
+	err := s.db.QueryRow(`SELECT 1 FROM watched_items WHERE media_id = ? AND user_id = ? LIMIT 1`, mediaID, userID).Scan(&exists)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
 	return err == nil, err
 }
 
-func (s *Store) GetWatchedItems(limit, offset int) ([]server.MediaItem, error) {
-	queryWithPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path FROM media_items m INNER JOIN watched_items w ON m.id = w.media_id WHERE w.user_id = '' ORDER BY w.watched_at DESC`
-	queryWithoutPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key FROM media_items m INNER JOIN watched_items w ON m.id = w.media_id WHERE w.user_id = '' ORDER BY w.watched_at DESC`
-	args := []interface{}{}
+func (s *Store) GetWatchedItems(userID string, limit, offset int) ([]server.MediaItem, error) {
+	queryWithPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path FROM media_items m INNER JOIN watched_items w ON m.id = w.media_id WHERE w.user_id = ? ORDER BY w.watched_at DESC`
+	queryWithoutPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key FROM media_items m INNER JOIN watched_items w ON m.id = w.media_id WHERE w.user_id = ? ORDER BY w.watched_at DESC`
+	args := []interface{}{userID}
 	if limit > 0 {
 		queryWithPoster += " LIMIT ?"
 		queryWithoutPoster += " LIMIT ?"
@@ -97,35 +95,35 @@ func (s *Store) GetWatchedItems(limit, offset int) ([]server.MediaItem, error) {
 }
 
 // Favorites/Bookmarks
-func (s *Store) AddFavorite(mediaID string, addedAt time.Time) error {
+func (s *Store) AddFavorite(userID, mediaID string, addedAt time.Time) error {
 	if s.readOnly {
 		return fmt.Errorf("storage: read-only mode")
 	}
-	_, err := s.db.Exec(`INSERT INTO favorites (media_id, added_at, user_id) VALUES (?, ?, '') ON CONFLICT(media_id, user_id) DO UPDATE SET added_at = excluded.added_at`, mediaID, addedAt.Unix())
+	_, err := s.db.Exec(`INSERT INTO favorites (media_id, added_at, user_id) VALUES (?, ?, ?) ON CONFLICT(media_id, user_id) DO UPDATE SET added_at = excluded.added_at`, mediaID, addedAt.Unix(), userID)
 	return err
 }
 
-func (s *Store) RemoveFavorite(mediaID string) error {
+func (s *Store) RemoveFavorite(userID, mediaID string) error {
 	if s.readOnly {
 		return fmt.Errorf("storage: read-only mode")
 	}
-	_, err := s.db.Exec(`DELETE FROM favorites WHERE media_id = ? AND user_id = ''`, mediaID)
+	_, err := s.db.Exec(`DELETE FROM favorites WHERE media_id = ? AND user_id = ?`, mediaID, userID)
 	return err
 }
 
-func (s *Store) IsFavorite(mediaID string) (bool, error) {
+func (s *Store) IsFavorite(userID, mediaID string) (bool, error) {
 	var exists int
-	err := s.db.QueryRow(`SELECT 1 FROM favorites WHERE media_id = ? AND user_id = '' LIMIT 1`, mediaID).Scan(&exists)
+	err := s.db.QueryRow(`SELECT 1 FROM favorites WHERE media_id = ? AND user_id = ? LIMIT 1`, mediaID, userID).Scan(&exists)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
 	return err == nil, err
 }
 
-func (s *Store) GetFavorites(limit, offset int) ([]server.MediaItem, error) {
-	queryWithPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path FROM media_items m INNER JOIN favorites f ON m.id = f.media_id WHERE f.user_id = '' ORDER BY f.added_at DESC`
-	queryWithoutPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key FROM media_items m INNER JOIN favorites f ON m.id = f.media_id WHERE f.user_id = '' ORDER BY f.added_at DESC`
-	args := []interface{}{}
+func (s *Store) GetFavorites(userID string, limit, offset int) ([]server.MediaItem, error) {
+	queryWithPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key, m.poster_path FROM media_items m INNER JOIN favorites f ON m.id = f.media_id WHERE f.user_id = ? ORDER BY f.added_at DESC`
+	queryWithoutPoster := `SELECT m.id, m.path, m.title, m.size, m.modified, m.nfo_path, m.stable_key FROM media_items m INNER JOIN favorites f ON m.id = f.media_id WHERE f.user_id = ? ORDER BY f.added_at DESC`
+	args := []interface{}{userID}
 	if limit > 0 {
 		queryWithPoster += " LIMIT ?"
 		queryWithoutPoster += " LIMIT ?"
@@ -362,7 +360,7 @@ func (s *Store) GetTVShowsGrouped(limit, offset int, sortBy, query string) ([]se
 		if len(episodeIDs) > 0 {
 			firstEpisodeID = episodeIDs[0]
 		}
-		shows = append(shows, server.TVShowGroup{ShowTitle: showTitle, EpisodeCount: episodeCount, SeasonCount: seasonCount, FirstSeason: firstSeason, LastModified: time.Unix(lastModified, 0), Year: year.String, FirstEpisodeID: firstEpisodeID})
+		shows = append(shows, server.TVShowGroup{ShowTitle: showTitle, EpisodeCount: episodeCount, SeasonCount: seasonCount, FirstSeason: firstSeason, LastModified: time.Unix(lastModified, 0), Year: year.String, FirstEpisodeID: firstEpisodeID, EpisodeIDs: episodeIDs})
 	}
 	return shows, rows.Err()
 }
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// scopeRange returns the bounds of the paths strictly below scope. Paths are compared
+// bytewise, and the separator is followed by the next byte value.
+func scopeRange(scope string) (string, string) {
+	prefix := strings.TrimSuffix(filepath.Clean(scope), string(filepath.Separator)) + string(filepath.Separator)
+	upper := prefix[:len(prefix)-1] + string(rune(filepath.Separator+1))
+	return prefix, upper
+}
+
+// ReplaceIgnoredPaths drops the ignored paths recorded within scope and stores the
+// entries of the latest scan of it
+func (s *Store) ReplaceIgnoredPaths(scope string, entries []server.IgnoredPath) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lower, upper := scopeRange(scope)
+	if _, err := tx.Exec(`
+		DELETE FROM ignored_paths
+		WHERE path = ? OR (path >= ? AND path < ?)
+	`, filepath.Clean(scope), lower, upper); err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		stmt, err := tx.Prepare(`
+			INSERT OR REPLACE INTO ignored_paths (path, is_dir, pattern, source, detected_at)
+			VALUES (?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, entry := range entries {
+			isDir := 0
+			if entry.IsDir {
+				isDir = 1
+			}
+			if _, err := stmt.Exec(entry.Path, isDir, entry.Pattern, entry.Source, entry.DetectedAt.Unix()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListIgnoredPaths returns the ignored paths within scope, sorted by path
+func (s *Store) ListIgnoredPaths(scope string) ([]server.IgnoredPath, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	lower, upper := scopeRange(scope)
+	rows, err := s.db.Query(`
+		SELECT path, is_dir, pattern, source, detected_at
+		FROM ignored_paths
+		WHERE path = ? OR (path >= ? AND path < ?)
+		ORDER BY path
+	`, filepath.Clean(scope), lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []server.IgnoredPath{}
+	for rows.Next() {
+		var (
+			entry      server.IgnoredPath
+			isDir      int
+			detectedAt int64
+		)
+		if err := rows.Scan(&entry.Path, &isDir, &entry.Pattern, &entry.Source, &detectedAt); err != nil {
+			return nil, err
+		}
+		entry.IsDir = isDir == 1
+		entry.DetectedAt = time.Unix(detectedAt, 0)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+const inviteColumns = `id, prefix, code_hash, created_by, created_at, expires_at, max_uses, uses, is_admin, all_roots, root_ids`
+
+// CreateInvite stores a new invite
+func (s *Store) CreateInvite(invite auth.Invite) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO auth_invites (`+inviteColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, invite.ID, invite.Prefix, invite.CodeHash, nullString(invite.CreatedBy), invite.CreatedAt.Unix(), invite.ExpiresAt.Unix(),
+		invite.MaxUses, invite.Uses, invite.IsAdmin, invite.AllRoots, nullString(joinStringList(invite.RootIDs)))
+	return err
+}
+
+// GetInviteByHash retrieves an invite by the hash of its code
+func (s *Store) GetInviteByHash(hash string) (*auth.Invite, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	invite, err := scanInvite(s.db.QueryRow(`SELECT `+inviteColumns+` FROM auth_invites WHERE code_hash = ?`, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, auth.ErrInviteNotFound
+		}
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+// ListInvites lists all invites, newest first
+func (s *Store) ListInvites() ([]auth.Invite, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`SELECT ` + inviteColumns + ` FROM auth_invites ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []auth.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// DeleteInvite revokes an invite
+func (s *Store) DeleteInvite(id string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`DELETE FROM auth_invites WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return auth.ErrInviteNotFound
+	}
+	return nil
+}
+
+// UseInvite spends one use of an invite. It reports false when the invite is gone,
+// expired or used up, so concurrent registrations cannot exceed the limit.
+func (s *Store) UseInvite(id string, usedAt time.Time) (bool, error) {
+	if s == nil || s.db == nil {
+		return false, fmt.Errorf("storage: missing database connection")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE auth_invites
+		SET uses = uses + 1
+		WHERE id = ? AND uses < max_uses AND expires_at > ?
+	`, id, usedAt.Unix())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ReleaseInvite returns a use spent by a registration that failed afterwards
+func (s *Store) ReleaseInvite(id string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	_, err := s.db.Exec(`UPDATE auth_invites SET uses = uses - 1 WHERE id = ? AND uses > 0`, id)
+	return err
+}
+
+// CreateInvitedUser stores a user registered with an invite together with the library
+// roots the invite grants, in one transaction. Roots removed since the invite was
+// created are skipped.
+func (s *Store) CreateInvitedUser(user auth.User, invite auth.Invite) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO auth_users (id, username, password_hash, is_admin, created_at, last_login, all_roots)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, user.ID, user.Username, user.PasswordHash, user.IsAdmin, user.CreatedAt.Unix(), nullInt64FromTime(user.LastLogin), invite.AllRoots); err != nil {
+		return err
+	}
+	if !invite.AllRoots {
+		for _, rootID := range invite.RootIDs {
+			if _, err := tx.Exec(`
+				INSERT OR IGNORE INTO auth_user_roots (user_id, root_id)
+				SELECT ?, id FROM library_roots WHERE id = ?
+			`, user.ID, rootID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanInvite(scanner interface{ Scan(...any) error }) (auth.Invite, error) {
+	var invite auth.Invite
+	var createdBy, rootIDs sql.NullString
+	var createdAt, expiresAt int64
+	var isAdmin, allRoots int
+
+	if err := scanner.Scan(&invite.ID, &invite.Prefix, &invite.CodeHash, &createdBy, &createdAt, &expiresAt,
+		&invite.MaxUses, &invite.Uses, &isAdmin, &allRoots, &rootIDs); err != nil {
+		return auth.Invite{}, err
+	}
+
+	invite.IsAdmin = isAdmin == 1
+	invite.AllRoots = allRoots == 1
+	invite.CreatedBy = createdBy.String
+	invite.CreatedAt = time.Unix(createdAt, 0)
+	invite.ExpiresAt = time.Unix(expiresAt, 0)
+	invite.RootIDs = splitStringList(rootIDs)
+
+	return invite, nil
+}
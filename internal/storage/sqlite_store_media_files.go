@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// mediaFilesBatchSize keeps IN lists and transactions of the media file queries small
+const mediaFilesBatchSize = 500
+
+// GetMediaFiles returns the tracked video and sidecar files of every media item
+func (s *Store) GetMediaFiles() (map[string][]server.MediaFile, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT media_id, kind, path, size, modified
+		FROM media_files
+		ORDER BY media_id, kind
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := map[string][]server.MediaFile{}
+	for rows.Next() {
+		var (
+			mediaID  string
+			file     server.MediaFile
+			modified int64
+		)
+		if err := rows.Scan(&mediaID, &file.Kind, &file.Path, &file.Size, &modified); err != nil {
+			return nil, err
+		}
+		file.Modified = time.Unix(modified, 0)
+		files[mediaID] = append(files[mediaID], file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SaveMediaFiles replaces the tracked files of the given media items
+func (s *Store) SaveMediaFiles(files map[string][]server.MediaFile) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	mediaIDs := make([]string, 0, len(files))
+	for mediaID := range files {
+		mediaIDs = append(mediaIDs, mediaID)
+	}
+	sort.Strings(mediaIDs)
+
+	for start := 0; start < len(mediaIDs); start += mediaFilesBatchSize {
+		end := min(start+mediaFilesBatchSize, len(mediaIDs))
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		rollback := func() {
+			_ = tx.Rollback()
+		}
+
+		for _, mediaID := range mediaIDs[start:end] {
+			if err := replaceMediaFilesTx(tx, mediaID, files[mediaID]); err != nil {
+				rollback()
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveMetadataBatch stores the NFOs and file states of refreshed items. Each batch
+// is one transaction, so an item's metadata and file states are written together.
+func (s *Store) SaveMetadataBatch(updates []server.MetadataUpdate) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	for start := 0; start < len(updates); start += mediaFilesBatchSize {
+		end := min(start+mediaFilesBatchSize, len(updates))
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		rollback := func() {
+			_ = tx.Rollback()
+		}
+
+		for _, update := range updates[start:end] {
+			var err error
+			switch {
+			case update.NFO != nil:
+				err = saveNFOExtendedTx(tx, update.MediaID, update.NFO)
+			case !update.KeepNFO:
+				err = deleteNFOExtendedTx(tx, update.MediaID)
+			}
+			if err == nil {
+				err = replaceMediaFilesTx(tx, update.MediaID, update.Files)
+			}
+			if err != nil {
+				rollback()
+				return err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func replaceMediaFilesTx(tx *sql.Tx, mediaID string, files []server.MediaFile) error {
+	if _, err := tx.Exec(`DELETE FROM media_files WHERE media_id = ?`, mediaID); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := tx.Exec(`
+			INSERT INTO media_files (media_id, kind, path, size, modified)
+			VALUES (?, ?, ?, ?, ?)
+		`, mediaID, file.Kind, file.Path, file.Size, file.Modified.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShowTitlesForItems returns the distinct show titles from the NFOs of the given items
+func (s *Store) ShowTitlesForItems(mediaIDs []string) ([]string, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	seen := map[string]bool{}
+	for start := 0; start < len(mediaIDs); start += mediaFilesBatchSize {
+		batch := mediaIDs[start:min(start+mediaFilesBatchSize, len(mediaIDs))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+
+		rows, err := s.db.Query(fmt.Sprintf(`
+			SELECT DISTINCT show_title
+			FROM nfo
+			WHERE media_id IN (%s) AND show_title IS NOT NULL AND show_title != ''
+		`, strings.Join(placeholders, ",")), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var title string
+			if err := rows.Scan(&title); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			seen[title] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	titles := make([]string, 0, len(seen))
+	for title := range seen {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
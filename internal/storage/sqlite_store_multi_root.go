@@ -128,3 +128,98 @@ func (s *Store) GetItemsByRoots(rootIDs []string, limit, offset int, sortBy, que
 
 	return items, rows.Err()
 }
+
+// GetUserRootAccess returns the library roots a user may see
+func (s *Store) GetUserRootAccess(userID string) (server.RootAccess, bool, error) {
+	if s == nil || s.db == nil {
+		return server.RootAccess{}, false, fmt.Errorf("storage: missing database connection")
+	}
+
+	var allRoots int
+	err := s.db.QueryRow(`SELECT all_roots FROM auth_users WHERE id = ?`, userID).Scan(&allRoots)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return server.RootAccess{}, false, nil
+		}
+		return server.RootAccess{}, false, err
+	}
+
+	access := server.RootAccess{AllRoots: allRoots == 1, RootIDs: []string{}}
+	rows, err := s.db.Query(`
+		SELECT root_id FROM auth_user_roots
+		WHERE user_id = ?
+		ORDER BY root_id
+	`, userID)
+	if err != nil {
+		return server.RootAccess{}, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rootID string
+		if err := rows.Scan(&rootID); err != nil {
+			return server.RootAccess{}, false, err
+		}
+		access.RootIDs = append(access.RootIDs, rootID)
+	}
+
+	return access, true, rows.Err()
+}
+
+// SetUserRootAccess replaces the root grants of a user
+func (s *Store) SetUserRootAccess(userID string, access server.RootAccess) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE auth_users SET all_roots = ? WHERE id = ?`, access.AllRoots, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM auth_user_roots WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, rootID := range access.RootIDs {
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO auth_user_roots (user_id, root_id)
+			VALUES (?, ?)
+		`, userID, rootID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTVShowMediaIDs maps every TV show to the media IDs of its episodes
+func (s *Store) GetTVShowMediaIDs() (map[string][]string, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT se.show_id, e.media_id
+		FROM episodes e
+		INNER JOIN seasons se ON se.id = e.season_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mediaIDs := map[string][]string{}
+	for rows.Next() {
+		var showID, mediaID string
+		if err := rows.Scan(&showID, &mediaID); err != nil {
+			return nil, err
+		}
+		mediaIDs[showID] = append(mediaIDs[showID], mediaID)
+	}
+
+	return mediaIDs, rows.Err()
+}
@@ -15,40 +15,52 @@ import (
 // Verbesserung 1: Multi-User-Support
 // ============================================================================
 
+// CreateMediaUser creates an account without a password. It can log in once
+// an administrator has set a password through /auth/users/{id}/password.
 func (s *Store) CreateMediaUser(id, name string, createdAt time.Time) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
 	_, err := s.db.Exec(`
-		INSERT INTO users (id, name, created_at, last_active)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO auth_users (id, username, password_hash, is_admin, created_at, last_active)
+		VALUES (?, ?, '', 0, ?, ?)
 	`, id, name, createdAt.Unix(), createdAt.Unix())
 	return err
 }
 
+const mediaUserColumns = `id, username, is_admin, created_at, COALESCE(last_active, last_login, 0)`
+
+func scanMediaUser(scanner interface{ Scan(...any) error }) (server.User, error) {
+	var user server.User
+	var isAdmin int
+	var createdAt, lastActive int64
+	if err := scanner.Scan(&user.ID, &user.Name, &isAdmin, &createdAt, &lastActive); err != nil {
+		return server.User{}, err
+	}
+	user.IsAdmin = isAdmin == 1
+	user.CreatedAt = time.Unix(createdAt, 0)
+	if lastActive > 0 {
+		user.LastActive = time.Unix(lastActive, 0)
+	}
+	return user, nil
+}
+
 func (s *Store) GetMediaUser(id string) (*server.User, bool, error) {
 	if s == nil || s.db == nil {
 		return nil, false, fmt.Errorf("storage: missing database connection")
 	}
 
-	var user server.User
-	var createdAt, lastActive int64
-	err := s.db.QueryRow(`
-		SELECT id, name, created_at, last_active
-		FROM users
+	user, err := scanMediaUser(s.db.QueryRow(`
+		SELECT `+mediaUserColumns+`
+		FROM auth_users
 		WHERE id = ?
-	`, id).Scan(&user.ID, &user.Name, &createdAt, &lastActive)
+	`, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
-
-	user.CreatedAt = time.Unix(createdAt, 0)
-	if lastActive > 0 {
-		user.LastActive = time.Unix(lastActive, 0)
-	}
 	return &user, true, nil
 }
 
@@ -57,24 +69,17 @@ func (s *Store) GetMediaUserByName(name string) (*server.User, bool, error) {
 		return nil, false, fmt.Errorf("storage: missing database connection")
 	}
 
-	var user server.User
-	var createdAt, lastActive int64
-	err := s.db.QueryRow(`
-		SELECT id, name, created_at, last_active
-		FROM users
-		WHERE name = ?
-	`, name).Scan(&user.ID, &user.Name, &createdAt, &lastActive)
+	user, err := scanMediaUser(s.db.QueryRow(`
+		SELECT `+mediaUserColumns+`
+		FROM auth_users
+		WHERE LOWER(username) = LOWER(?)
+	`, name))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, false, nil
 		}
 		return nil, false, err
 	}
-
-	user.CreatedAt = time.Unix(createdAt, 0)
-	if lastActive > 0 {
-		user.LastActive = time.Unix(lastActive, 0)
-	}
 	return &user, true, nil
 }
 
@@ -84,9 +89,9 @@ func (s *Store) GetAllUsers() ([]server.User, error) {
 	}
 
 	rows, err := s.db.Query(`
-		SELECT id, name, created_at, last_active
-		FROM users
-		ORDER BY name
+		SELECT ` + mediaUserColumns + `
+		FROM auth_users
+		ORDER BY username
 	`)
 	if err != nil {
 		return nil, err
@@ -95,15 +100,10 @@ func (s *Store) GetAllUsers() ([]server.User, error) {
 
 	var users []server.User
 	for rows.Next() {
-		var user server.User
-		var createdAt, lastActive int64
-		if err := rows.Scan(&user.ID, &user.Name, &createdAt, &lastActive); err != nil {
+		user, err := scanMediaUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		user.CreatedAt = time.Unix(createdAt, 0)
-		if lastActive > 0 {
-			user.LastActive = time.Unix(lastActive, 0)
-		}
 		users = append(users, user)
 	}
 
@@ -115,7 +115,7 @@ func (s *Store) UpdateUserLastActive(id string, lastActive time.Time) error {
 		return fmt.Errorf("storage: missing database connection")
 	}
 	_, err := s.db.Exec(`
-		UPDATE users
+		UPDATE auth_users
 		SET last_active = ?
 		WHERE id = ?
 	`, lastActive.Unix(), id)
@@ -126,7 +126,7 @@ func (s *Store) DeleteMediaUser(id string) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
-	_, err := s.db.Exec(`DELETE FROM users WHERE id = ?`, id)
+	_, err := s.db.Exec(`DELETE FROM auth_users WHERE id = ?`, id)
 	return err
 }
 
@@ -393,17 +393,17 @@ func (s *Store) SaveTranscodingCache(cache server.TranscodingCache) error {
 		return fmt.Errorf("storage: missing database connection")
 	}
 	_, err := s.db.Exec(`
-		INSERT INTO transcoding_cache (id, media_id, profile_id, cache_path, created_at, last_accessed, size_bytes)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO transcoding_cache (id, media_id, profile_id, version, cache_path, created_at, last_accessed, size_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			last_accessed = excluded.last_accessed,
 			size_bytes = excluded.size_bytes
-	`, cache.ID, cache.MediaID, cache.ProfileID, cache.CachePath,
+	`, cache.ID, cache.MediaID, cache.ProfileID, max(cache.Version, 1), cache.CachePath,
 		cache.CreatedAt.Unix(), cache.LastAccessed.Unix(), cache.SizeBytes)
 	return err
 }
 
-func (s *Store) GetTranscodingCache(mediaID, profileID string) (*server.TranscodingCache, bool, error) {
+func (s *Store) GetTranscodingCache(mediaID, profileID string, version int) (*server.TranscodingCache, bool, error) {
 	if s == nil || s.db == nil {
 		return nil, false, fmt.Errorf("storage: missing database connection")
 	}
@@ -412,10 +412,10 @@ func (s *Store) GetTranscodingCache(mediaID, profileID string) (*server.Transcod
 	var createdAt, lastAccessed int64
 
 	err := s.db.QueryRow(`
-		SELECT id, media_id, profile_id, cache_path, created_at, last_accessed, size_bytes
+		SELECT id, media_id, profile_id, version, cache_path, created_at, last_accessed, size_bytes
 		FROM transcoding_cache
-		WHERE media_id = ? AND profile_id = ?
-	`, mediaID, profileID).Scan(&cache.ID, &cache.MediaID, &cache.ProfileID, &cache.CachePath,
+		WHERE media_id = ? AND profile_id = ? AND version = ?
+	`, mediaID, profileID, max(version, 1)).Scan(&cache.ID, &cache.MediaID, &cache.ProfileID, &cache.Version, &cache.CachePath,
 		&createdAt, &lastAccessed, &cache.SizeBytes)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -766,19 +766,44 @@ func (s *Store) GetEpisode(id string) (*server.Episode, bool, error) {
 }
 
 func (s *Store) AutoGroupEpisodes() error {
+	return s.autoGroupEpisodes(nil)
+}
+
+// AutoGroupShows groups the episodes of the given shows only, e.g. after a scan
+// changed some of their episodes
+func (s *Store) AutoGroupShows(showTitles []string) error {
+	if len(showTitles) == 0 {
+		return nil
+	}
+	return s.autoGroupEpisodes(showTitles)
+}
+
+// autoGroupEpisodes groups episodes into shows and seasons; nil groups every show
+func (s *Store) autoGroupEpisodes(showTitles []string) error {
 	if s == nil || s.db == nil {
 		return fmt.Errorf("storage: missing database connection")
 	}
 
 	// Get all media items with NFO that have show_title
-	rows, err := s.db.Query(`
+	filter := ""
+	args := make([]any, 0, len(showTitles))
+	if showTitles != nil {
+		placeholders := make([]string, len(showTitles))
+		for i, title := range showTitles {
+			placeholders[i] = "?"
+			args = append(args, title)
+		}
+		filter = fmt.Sprintf("AND n.show_title IN (%s)", strings.Join(placeholders, ","))
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`
 		SELECT m.id, n.show_title, n.season, n.episode, n.title, n.plot
 		FROM media_items m
 		INNER JOIN nfo n ON m.id = n.media_id
 		WHERE n.show_title IS NOT NULL AND n.show_title != ''
 			AND n.season IS NOT NULL AND n.episode IS NOT NULL
+			%s
 		ORDER BY n.show_title, n.season, n.episode
-	`)
+	`, filter), args...)
 	if err != nil {
 		return err
 	}
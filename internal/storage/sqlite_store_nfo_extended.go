@@ -23,12 +23,18 @@ func (s *Store) SaveNFOExtended(mediaID string, nfo *server.NFO) error {
 	if err != nil {
 		return fmt.Errorf("storage: begin transaction: %w", err)
 	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
+	if err := saveNFOExtendedTx(tx, mediaID, nfo); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("storage: commit transaction: %w", err)
+	}
+	return nil
+}
 
+// saveNFOExtendedTx writes the NFO and its related rows within tx
+func saveNFOExtendedTx(tx *sql.Tx, mediaID string, nfo *server.NFO) (err error) {
 	// Save main NFO data
 	genres := strings.Join(nfo.Genres, ",")
 	directors := strings.Join(nfo.Directors, ",")
@@ -265,10 +271,6 @@ func (s *Store) SaveNFOExtended(mediaID string, nfo *server.NFO) error {
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("storage: commit transaction: %w", err)
-	}
-
 	return nil
 }
 
@@ -514,12 +516,15 @@ func (s *Store) DeleteNFOExtended(mediaID string) error {
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
+	if err := deleteNFOExtendedTx(tx, mediaID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
+// deleteNFOExtendedTx removes the NFO and its related rows within tx
+func deleteNFOExtendedTx(tx *sql.Tx, mediaID string) error {
 	// Delete from all related tables (CASCADE should handle this, but being explicit)
 	tables := []string{
 		"nfo_actors",
@@ -531,10 +536,9 @@ func (s *Store) DeleteNFOExtended(mediaID string) error {
 	}
 
 	for _, table := range tables {
-		if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE media_id = ?`, table), mediaID); err != nil {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE media_id = ?`, table), mediaID); err != nil {
 			return err
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SetRootUnavailableSince marks a root unavailable since the given time; a zero time
+// marks it available again
+func (s *Store) SetRootUnavailableSince(id string, since time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	var value any
+	if !since.IsZero() {
+		value = since.Unix()
+	}
+	_, err := s.db.Exec(`UPDATE library_roots SET unavailable_since = ? WHERE id = ?`, value, id)
+	return err
+}
+
+// MarkItemsOffline flags items as offline. Items flagged before keep their time, so
+// the grace period counts from the first scan that missed them.
+func (s *Store) MarkItemsOffline(ids []string, since time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO offline_items (media_id, offline_since)
+		SELECT id, ? FROM media_items WHERE id = ?
+		ON CONFLICT(media_id) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		if _, err := stmt.Exec(since.Unix(), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearOfflineItems removes the offline flag of items
+func (s *Store) ClearOfflineItems(ids []string) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	for start := 0; start < len(ids); start += mediaFilesBatchSize {
+		batch := ids[start:min(start+mediaFilesBatchSize, len(ids))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		query := fmt.Sprintf(`DELETE FROM offline_items WHERE media_id IN (%s)`, strings.Join(placeholders, ","))
+		if _, err := s.db.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// GetUserParentalControls returns the content limits of a user
+func (s *Store) GetUserParentalControls(userID string) (server.ParentalControls, bool, error) {
+	if s == nil || s.db == nil {
+		return server.ParentalControls{}, false, fmt.Errorf("storage: missing database connection")
+	}
+
+	var maxRating sql.NullString
+	var unratedPolicy string
+	err := s.db.QueryRow(`
+		SELECT max_rating, unrated_policy
+		FROM auth_users
+		WHERE id = ?
+	`, userID).Scan(&maxRating, &unratedPolicy)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return server.ParentalControls{UnratedPolicy: server.UnratedAllow}, false, nil
+		}
+		return server.ParentalControls{}, false, err
+	}
+
+	return server.ParentalControls{MaxRating: maxRating.String, UnratedPolicy: unratedPolicy}, true, nil
+}
+
+// SetUserParentalControls stores the content limits of a user
+func (s *Store) SetUserParentalControls(userID string, controls server.ParentalControls) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	policy := controls.UnratedPolicy
+	if policy == "" {
+		policy = server.UnratedAllow
+	}
+	_, err := s.db.Exec(`
+		UPDATE auth_users
+		SET max_rating = ?, unrated_policy = ?
+		WHERE id = ?
+	`, nullString(controls.MaxRating), policy, userID)
+	return err
+}
+
+// GetContentRatings returns the effective rating of every rated media item
+func (s *Store) GetContentRatings() (map[string]string, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT m.id, COALESCE(o.rating, n.mpaa)
+		FROM media_items m
+		LEFT JOIN nfo n ON n.media_id = m.id
+		LEFT JOIN media_rating_overrides o ON o.media_id = m.id
+		WHERE COALESCE(o.rating, n.mpaa, '') != ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := map[string]string{}
+	for rows.Next() {
+		var id, rating string
+		if err := rows.Scan(&id, &rating); err != nil {
+			return nil, err
+		}
+		ratings[id] = rating
+	}
+
+	return ratings, rows.Err()
+}
+
+// GetContentRating returns the NFO rating, the override and the effective rating of an item
+func (s *Store) GetContentRating(mediaID string) (*server.ContentRating, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("storage: missing database connection")
+	}
+
+	var nfoRating, override sql.NullString
+	err := s.db.QueryRow(`
+		SELECT
+			(SELECT mpaa FROM nfo WHERE media_id = ?),
+			(SELECT rating FROM media_rating_overrides WHERE media_id = ?)
+	`, mediaID, mediaID).Scan(&nfoRating, &override)
+	if err != nil {
+		return nil, err
+	}
+
+	rating := &server.ContentRating{
+		MediaID:   mediaID,
+		NFORating: strings.TrimSpace(nfoRating.String),
+		Override:  override.String,
+	}
+	rating.Effective = rating.NFORating
+	if rating.Override != "" {
+		rating.Effective = rating.Override
+	}
+
+	return rating, nil
+}
+
+// SetContentRatingOverride overrides the rating of an item; an empty rating removes the override
+func (s *Store) SetContentRatingOverride(mediaID, rating string, updatedAt time.Time) error {
+	if s == nil || s.db == nil {
+		return fmt.Errorf("storage: missing database connection")
+	}
+
+	if strings.TrimSpace(rating) == "" {
+		_, err := s.db.Exec(`DELETE FROM media_rating_overrides WHERE media_id = ?`, mediaID)
+		return err
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO media_rating_overrides (media_id, rating, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(media_id) DO UPDATE SET
+			rating = excluded.rating,
+			updated_at = excluded.updated_at
+	`, mediaID, strings.TrimSpace(rating), updatedAt.Unix())
+	return err
+}
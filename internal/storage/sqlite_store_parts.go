@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// replaceMediaPartsTx stores the parts of a stacked item; items that are not
+// stacked lose parts stored before
+func replaceMediaPartsTx(tx *sql.Tx, item server.MediaItem) error {
+	if _, err := tx.Exec(`DELETE FROM media_parts WHERE media_id = ?`, item.ID); err != nil {
+		return err
+	}
+	for _, part := range item.Parts {
+		if _, err := tx.Exec(`
+			INSERT INTO media_parts (media_id, part, path, size, modified)
+			VALUES (?, ?, ?, ?, ?)
+		`, item.ID, part.Part, part.VideoPath, part.Size, part.Modified.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMediaParts returns the parts of stacked items by media ID; with an ID only the
+// parts of that item are read
+func (s *Store) getMediaParts(id string) (map[string][]server.MediaPart, error) {
+	query := `SELECT media_id, part, path, size, modified FROM media_parts`
+	var args []any
+	if id != "" {
+		query += ` WHERE media_id = ?`
+		args = append(args, id)
+	}
+	query += ` ORDER BY media_id, part`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := map[string][]server.MediaPart{}
+	for rows.Next() {
+		var (
+			mediaID  string
+			part     server.MediaPart
+			modified int64
+		)
+		if err := rows.Scan(&mediaID, &part.Part, &part.VideoPath, &part.Size, &modified); err != nil {
+			return nil, err
+		}
+		part.Modified = time.Unix(modified, 0)
+		parts[mediaID] = append(parts[mediaID], part)
+	}
+	return parts, rows.Err()
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/treefix50/primetime/internal/server"
+)
+
+// replaceMediaVersionsTx stores the versions of an item; items without versions
+// lose versions stored before
+func replaceMediaVersionsTx(tx *sql.Tx, item server.MediaItem) error {
+	if _, err := tx.Exec(`DELETE FROM media_versions WHERE media_id = ?`, item.ID); err != nil {
+		return err
+	}
+	for _, version := range item.Versions {
+		if _, err := tx.Exec(`
+			INSERT INTO media_versions (media_id, version, path, size, modified, width, height, resolution, edition)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, item.ID, version.Version, version.VideoPath, version.Size, version.Modified.Unix(),
+			version.Width, version.Height, nullString(version.Resolution), nullString(version.Edition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMediaVersions returns the versions of items by media ID; with an ID only the
+// versions of that item are read
+func (s *Store) getMediaVersions(id string) (map[string][]server.MediaVersion, error) {
+	query := `SELECT media_id, version, path, size, modified, width, height, resolution, edition FROM media_versions`
+	var args []any
+	if id != "" {
+		query += ` WHERE media_id = ?`
+		args = append(args, id)
+	}
+	query += ` ORDER BY media_id, version`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[string][]server.MediaVersion{}
+	for rows.Next() {
+		var (
+			mediaID    string
+			version    server.MediaVersion
+			modified   int64
+			resolution sql.NullString
+			edition    sql.NullString
+		)
+		if err := rows.Scan(&mediaID, &version.Version, &version.VideoPath, &version.Size, &modified,
+			&version.Width, &version.Height, &resolution, &edition); err != nil {
+			return nil, err
+		}
+		version.Modified = time.Unix(modified, 0)
+		version.Resolution = resolution.String
+		version.Edition = edition.String
+		versions[mediaID] = append(versions[mediaID], version)
+	}
+	return versions, rows.Err()
+}
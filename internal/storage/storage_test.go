@@ -2,9 +2,12 @@ package storage
 
 import (
 	"database/sql"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/treefix50/primetime/internal/auth"
 	"github.com/treefix50/primetime/internal/server"
 )
 
@@ -309,3 +312,356 @@ func TestDeleteItems(t *testing.T) {
 		t.Fatalf("expected item-2 to remain")
 	}
 }
+
+func TestWatchedAndFavoritesPerUser(t *testing.T) {
+	store := newTestStore(t, true)
+
+	items := []server.MediaItem{
+		{
+			ID:        "item-1",
+			Title:     "First",
+			VideoPath: "/tmp/first.mkv",
+			Size:      100,
+			Modified:  time.Unix(1700000000, 0),
+		},
+	}
+	if err := store.SaveItems(items); err != nil {
+		t.Fatalf("SaveItems() error = %v", err)
+	}
+
+	if err := store.MarkWatched("alice", "item-1", time.Unix(1700000200, 0)); err != nil {
+		t.Fatalf("MarkWatched() error = %v", err)
+	}
+	if err := store.AddFavorite("alice", "item-1", time.Unix(1700000200, 0)); err != nil {
+		t.Fatalf("AddFavorite() error = %v", err)
+	}
+
+	watched, err := store.IsWatched("alice", "item-1")
+	if err != nil || !watched {
+		t.Fatalf("IsWatched(alice) = %v, %v; want true", watched, err)
+	}
+	watched, err = store.IsWatched("bob", "item-1")
+	if err != nil || watched {
+		t.Fatalf("IsWatched(bob) = %v, %v; want false", watched, err)
+	}
+
+	favorites, err := store.GetFavorites("bob", 10, 0)
+	if err != nil {
+		t.Fatalf("GetFavorites(bob) error = %v", err)
+	}
+	if len(favorites) != 0 {
+		t.Fatalf("GetFavorites(bob) = %d items; want 0", len(favorites))
+	}
+	favorites, err = store.GetFavorites("alice", 10, 0)
+	if err != nil {
+		t.Fatalf("GetFavorites(alice) error = %v", err)
+	}
+	if len(favorites) != 1 || favorites[0].ID != "item-1" {
+		t.Fatalf("GetFavorites(alice) = %#v; want item-1", favorites)
+	}
+
+	if err := store.UnmarkWatched("bob", "item-1"); err != nil {
+		t.Fatalf("UnmarkWatched(bob) error = %v", err)
+	}
+	watched, err = store.IsWatched("alice", "item-1")
+	if err != nil || !watched {
+		t.Fatalf("IsWatched(alice) after bob unmark = %v, %v; want true", watched, err)
+	}
+}
+
+func TestMigrateMediaUsersIntoAuthUsers(t *testing.T) {
+	store := newTestStore(t, false)
+
+	if _, err := store.db.Exec(schemaMigrations); err != nil {
+		t.Fatalf("create schema_migrations: %v", err)
+	}
+	for _, m := range migrations {
+		if m.version > 15 {
+			break
+		}
+		if err := store.applyMigration(m); err != nil {
+			t.Fatalf("applyMigration(%d) error = %v", m.version, err)
+		}
+	}
+
+	statements := []string{
+		`INSERT INTO media_items (id, path, title, size, modified) VALUES ('item-1', '/tmp/first.mkv', 'First', 100, 1700000000)`,
+		`INSERT INTO auth_users (id, username, password_hash, is_admin, created_at) VALUES ('admin', 'admin', 'hash', 1, 1700000000)`,
+		`INSERT INTO auth_users (id, username, password_hash, is_admin, created_at) VALUES ('user_bob', 'Bob', 'hash', 0, 1700000000)`,
+		`INSERT INTO users (id, name, created_at, last_active) VALUES ('media_alice', 'alice', 1700000000, 1700000500)`,
+		`INSERT INTO users (id, name, created_at, last_active) VALUES ('media_bob', 'bob', 1700000000, 0)`,
+		`INSERT INTO user_preferences (user_id, key, value) VALUES ('media_alice', 'lang', 'de')`,
+		`INSERT INTO user_preferences (user_id, key, value) VALUES ('media_bob', 'lang', 'en')`,
+		`INSERT INTO playback_state (media_id, position_seconds, duration_seconds, updated_at, client_id, user_id) VALUES ('item-1', 10, 100, 1700000000, 'tv', '')`,
+		`INSERT INTO playback_state (media_id, position_seconds, duration_seconds, updated_at, client_id, user_id) VALUES ('item-1', 20, 100, 1700000000, 'phone', 'media_bob')`,
+	}
+	for _, statement := range statements {
+		if _, err := store.db.Exec(statement); err != nil {
+			t.Fatalf("seed %q: %v", statement, err)
+		}
+	}
+
+	if err := store.MigrateSchema(); err != nil {
+		t.Fatalf("MigrateSchema() error = %v", err)
+	}
+
+	alice, ok, err := store.GetMediaUser("media_alice")
+	if err != nil || !ok {
+		t.Fatalf("GetMediaUser(media_alice) = %v, %v", ok, err)
+	}
+	if alice.Name != "alice" || alice.LastActive.Unix() != 1700000500 {
+		t.Fatalf("unexpected migrated user: %#v", alice)
+	}
+
+	if value, ok, err := store.GetUserPreference("user_bob", "lang"); err != nil || !ok || value != "en" {
+		t.Fatalf("GetUserPreference(user_bob) = %q, %v, %v; want en", value, ok, err)
+	}
+
+	if _, ok, err := store.GetPlaybackState("admin", "item-1", "tv"); err != nil || !ok {
+		t.Fatalf("GetPlaybackState(admin) = %v, %v; want state", ok, err)
+	}
+	state, ok, err := store.GetPlaybackState("user_bob", "item-1", "")
+	if err != nil || !ok || state.PositionSeconds != 20 {
+		t.Fatalf("GetPlaybackState(user_bob) = %#v, %v, %v; want position 20", state, ok, err)
+	}
+}
+
+func TestUserRootAccess(t *testing.T) {
+	store := newTestStore(t, true)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("kid", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	kids, err := store.AddRoot("/library/Kinder", "movies")
+	if err != nil {
+		t.Fatalf("AddRoot(Kinder) error = %v", err)
+	}
+	if _, err := store.AddRoot("/library/Filme", "movies"); err != nil {
+		t.Fatalf("AddRoot(Filme) error = %v", err)
+	}
+
+	access, ok, err := store.GetUserRootAccess(user.ID)
+	if err != nil || !ok || !access.AllRoots {
+		t.Fatalf("GetUserRootAccess() = %#v, %v, %v; want all roots by default", access, ok, err)
+	}
+	if _, ok, err := store.GetUserRootAccess("missing"); err != nil || ok {
+		t.Fatalf("GetUserRootAccess(missing) = %v, %v; want not found", ok, err)
+	}
+
+	if err := store.SetUserRootAccess(user.ID, server.RootAccess{RootIDs: []string{kids.ID}}); err != nil {
+		t.Fatalf("SetUserRootAccess() error = %v", err)
+	}
+	access, _, err = store.GetUserRootAccess(user.ID)
+	if err != nil || access.AllRoots || len(access.RootIDs) != 1 || access.RootIDs[0] != kids.ID {
+		t.Fatalf("GetUserRootAccess() = %#v, %v; want only %s", access, err, kids.ID)
+	}
+
+	// Removing a root drops its grants.
+	if err := store.RemoveRoot(kids.ID); err != nil {
+		t.Fatalf("RemoveRoot() error = %v", err)
+	}
+	access, _, err = store.GetUserRootAccess(user.ID)
+	if err != nil || access.AllRoots || len(access.RootIDs) != 0 {
+		t.Fatalf("GetUserRootAccess() after RemoveRoot = %#v, %v; want no roots", access, err)
+	}
+}
+
+func TestParentalControlsAndRatings(t *testing.T) {
+	store := newTestStore(t, true)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("kid", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	controls, ok, err := store.GetUserParentalControls(user.ID)
+	if err != nil || !ok || controls.MaxRating != "" || controls.UnratedPolicy != server.UnratedAllow {
+		t.Fatalf("GetUserParentalControls() = %#v, %v, %v; want no limit by default", controls, ok, err)
+	}
+	want := server.ParentalControls{MaxRating: "FSK 12", UnratedPolicy: server.UnratedBlock}
+	if err := store.SetUserParentalControls(user.ID, want); err != nil {
+		t.Fatalf("SetUserParentalControls() error = %v", err)
+	}
+	if controls, _, err = store.GetUserParentalControls(user.ID); err != nil || controls != want {
+		t.Fatalf("GetUserParentalControls() = %#v, %v; want %#v", controls, err, want)
+	}
+
+	modified := time.Unix(1700000000, 0)
+	items := []server.MediaItem{
+		{ID: "rated", Title: "Rated", VideoPath: "/tmp/rated.mkv", Modified: modified},
+		{ID: "unrated", Title: "Unrated", VideoPath: "/tmp/unrated.mkv", Modified: modified},
+	}
+	if err := store.SaveItems(items); err != nil {
+		t.Fatalf("SaveItems() error = %v", err)
+	}
+	if err := store.SaveNFOExtended("rated", &server.NFO{Type: "movie", Title: "Rated", MPAA: "Rated PG-13"}); err != nil {
+		t.Fatalf("SaveNFOExtended() error = %v", err)
+	}
+
+	ratings, err := store.GetContentRatings()
+	if err != nil || len(ratings) != 1 || ratings["rated"] != "Rated PG-13" {
+		t.Fatalf("GetContentRatings() = %v, %v; want only the NFO rating", ratings, err)
+	}
+
+	if err := store.SetContentRatingOverride("rated", "FSK 16", time.Now()); err != nil {
+		t.Fatalf("SetContentRatingOverride() error = %v", err)
+	}
+	rating, err := store.GetContentRating("rated")
+	if err != nil || rating.NFORating != "Rated PG-13" || rating.Override != "FSK 16" || rating.Effective != "FSK 16" {
+		t.Fatalf("GetContentRating() = %#v, %v; want override to win", rating, err)
+	}
+	if ratings, _ = store.GetContentRatings(); ratings["rated"] != "FSK 16" {
+		t.Fatalf("GetContentRatings()[rated] = %q; want override", ratings["rated"])
+	}
+
+	if err := store.SetContentRatingOverride("rated", "", time.Now()); err != nil {
+		t.Fatalf("SetContentRatingOverride(empty) error = %v", err)
+	}
+	if rating, err = store.GetContentRating("rated"); err != nil || rating.Override != "" || rating.Effective != "Rated PG-13" {
+		t.Fatalf("GetContentRating() after removal = %#v, %v; want NFO rating", rating, err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	store := newTestStore(t, true)
+
+	now := time.Unix(1700000000, 0)
+	entries := []server.AuditEntry{
+		{Timestamp: now.Add(-48 * time.Hour), Actor: "admin", Action: "auth.login", IP: "10.0.0.1", Outcome: server.AuditSuccess},
+		{Timestamp: now.Add(-time.Hour), Actor: "mallory", Action: "auth.login", IP: "10.0.0.9", Outcome: server.AuditFailure},
+		{Timestamp: now, ActorID: "u1", Actor: "admin", Action: "auth.user.delete", Target: "u2", IP: "10.0.0.1", Outcome: server.AuditSuccess},
+		{Timestamp: now, ActorID: "u1", Actor: "admin", Action: "library.root.remove", Target: "r1", IP: "10.0.0.1", Outcome: server.AuditSuccess},
+	}
+	for _, entry := range entries {
+		if err := store.AddAuditEntry(entry); err != nil {
+			t.Fatalf("AddAuditEntry() error = %v", err)
+		}
+	}
+
+	got, total, err := store.ListAuditEntries(server.AuditFilter{}, 2, 0)
+	if err != nil || total != 4 || len(got) != 2 {
+		t.Fatalf("ListAuditEntries() = %d entries, total %d, %v; want 2 of 4", len(got), total, err)
+	}
+	if got[0].Action != "library.root.remove" || !got[0].Timestamp.Equal(now) {
+		t.Fatalf("ListAuditEntries()[0] = %#v; want newest first", got[0])
+	}
+
+	// An action filter matches the action and everything below it
+	got, total, err = store.ListAuditEntries(server.AuditFilter{Action: "auth"}, 0, 0)
+	if err != nil || total != 3 {
+		t.Fatalf("ListAuditEntries(auth) total = %d, %v; want 3", total, err)
+	}
+	got, total, err = store.ListAuditEntries(server.AuditFilter{Action: "auth.login", Outcome: server.AuditFailure}, 0, 0)
+	if err != nil || total != 1 || got[0].Actor != "mallory" {
+		t.Fatalf("ListAuditEntries(failed logins) = %#v, %v; want mallory", got, err)
+	}
+	got, _, err = store.ListAuditEntries(server.AuditFilter{Actor: "u1", Since: now.Add(-time.Minute)}, 0, 0)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("ListAuditEntries(actor u1) = %#v, %v; want 2 entries", got, err)
+	}
+
+	// Entries cannot be changed after the fact
+	if _, err := store.db.Exec(`UPDATE audit_log SET outcome = 'success'`); err == nil {
+		t.Fatalf("UPDATE audit_log succeeded; want append-only table")
+	}
+
+	removed, err := store.PruneAuditEntries(now.Add(-24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("PruneAuditEntries() = %d, %v; want 1", removed, err)
+	}
+	if _, total, _ = store.ListAuditEntries(server.AuditFilter{}, 0, 0); total != 3 {
+		t.Fatalf("ListAuditEntries() total after prune = %d; want 3", total)
+	}
+}
+
+func TestRecordLoginFailureConcurrent(t *testing.T) {
+	// Concurrent writers need a file database, every connection of ":memory:" is its own database
+	store, err := Open(filepath.Join(t.TempDir(), "primetime.db"), Options{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	const attempts, lockAfter = 20, 10
+	now := time.Unix(1700000000, 0)
+	lockedUntil := now.Add(15 * time.Minute)
+
+	var wg sync.WaitGroup
+	counts := make(chan int, attempts)
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failure, err := store.RecordLoginFailure("user:alice", now, now.Add(-time.Hour))
+			if err == nil && failure.Failures >= lockAfter {
+				err = store.LockLogin(failure.Key, lockedUntil)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			counts <- failure.Failures
+		}()
+	}
+	wg.Wait()
+	close(counts)
+	close(errs)
+	for err := range errs {
+		t.Fatalf("RecordLoginFailure() error = %v", err)
+	}
+
+	// Every attempt saw its own count, none was lost
+	seen := map[int]bool{}
+	for count := range counts {
+		seen[count] = true
+	}
+	if len(seen) != attempts {
+		t.Fatalf("RecordLoginFailure() counts = %v; want 1 to %d", seen, attempts)
+	}
+	failure, err := store.GetLoginFailure("user:alice")
+	if err != nil || failure.Failures != attempts || !failure.LockedUntil.Equal(lockedUntil) {
+		t.Fatalf("GetLoginFailure() = %#v, %v; want %d failures locked until %v", failure, err, attempts, lockedUntil)
+	}
+
+	// Stale failures start over once the lock expired
+	later := lockedUntil.Add(2 * time.Hour)
+	if failure, err = store.RecordLoginFailure("user:alice", later, later.Add(-time.Hour)); err != nil || failure.Failures != 1 {
+		t.Fatalf("RecordLoginFailure() after the window = %#v, %v; want 1 failure", failure, err)
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	store := newTestStore(t, true)
+	manager := auth.NewManager(store, time.Hour)
+
+	user, err := manager.CreateUser("alice", "correct-password", false)
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	if used, err := store.UseTOTPStep(user.ID, 100); err != nil || !used {
+		t.Fatalf("UseTOTPStep(100) = %v, %v; want true", used, err)
+	}
+	for _, step := range []int64{100, 99} {
+		if used, err := store.UseTOTPStep(user.ID, step); err != nil || used {
+			t.Fatalf("UseTOTPStep(%d) after 100 = %v, %v; want false", step, used, err)
+		}
+	}
+
+	// Only the step changes, the rest of the user stays as stored
+	got, err := store.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	want := *user
+	want.TOTPLastStep = 100
+	if got.TOTPLastStep != want.TOTPLastStep || got.Username != want.Username || got.PasswordHash != want.PasswordHash || got.IsAdmin != want.IsAdmin {
+		t.Fatalf("GetUser() = %#v; want %#v", got, want)
+	}
+}
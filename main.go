@@ -13,7 +13,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/treefix50/primetime/internal/auth"
 	"github.com/treefix50/primetime/internal/ffmpeg"
 	"github.com/treefix50/primetime/internal/server"
 	"github.com/treefix50/primetime/internal/storage"
@@ -26,6 +25,13 @@ var (
 )
 
 func main() {
+	if adminCommand(os.Args[1:]) {
+		if err := runAdminCommand(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "primetime: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := run(); err != nil {
 		os.Exit(1)
 	}
@@ -43,6 +49,9 @@ func run() error {
 		dbCacheSize    = flag.Int("db-cache-size", -65536, "sqlite cache size (negative values are KiB)")
 		scan           = flag.String("scan-interval", "10m", "media scan interval (e.g. 10m, 0 to disable)")
 		noInitialScan  = flag.Bool("no-initial-scan", false, "skip the initial media scan on startup")
+		watch          = flag.Bool("watch", true, "watch the library roots for changes (inotify) in addition to the periodic scans")
+		scanWorkers    = flag.Int("scan-workers", server.DefaultScanWorkers, "number of items whose metadata is read in parallel during a scan")
+		offlineGrace   = flag.Duration("offline-grace", 0, "how long items of an unavailable root are kept before they are deleted (0 keeps them until an admin confirms)")
 		cors           = flag.Bool("cors", false, "enable CORS headers for API responses")
 		jsonErrors     = flag.Bool("json-errors", false, "render API errors as JSON responses")
 		integrityCheck = flag.Bool("sqlite-integrity-check", false, "run PRAGMA integrity_check and exit")
@@ -50,7 +59,9 @@ func run() error {
 		vacuumInto     = flag.String("sqlite-vacuum-into", "", "run VACUUM INTO <path> and exit")
 		analyze        = flag.Bool("sqlite-analyze", false, "run ANALYZE and exit")
 		extensions     = flag.String("extensions", "", "comma-separated list of allowed media extensions (e.g. .mp4,.mkv)")
+		auditRetention = flag.Duration("audit-retention", 90*24*time.Hour, "how long audit log entries are kept (0 keeps them forever)")
 	)
+	passwords := addPasswordFlags(flag.CommandLine)
 	flag.Parse()
 	extensionList := parseExtensions(*extensions)
 
@@ -76,6 +87,18 @@ func run() error {
 		return err
 	}
 
+	if *scanWorkers < 1 {
+		err := fmt.Errorf("scan workers must be at least 1")
+		log.Printf("level=error msg=\"invalid scan workers\" scanWorkers=%d err=%v", *scanWorkers, err)
+		return err
+	}
+
+	if *offlineGrace < 0 {
+		err := fmt.Errorf("offline grace must not be negative")
+		log.Printf("level=error msg=\"invalid offline grace\" offlineGrace=%s err=%v", *offlineGrace, err)
+		return err
+	}
+
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Printf("level=error msg=\"failed to get working directory\" err=%v", err)
@@ -106,6 +129,12 @@ func run() error {
 		return err
 	}
 
+	passwordHasher, err := passwords.hasher()
+	if err != nil {
+		log.Printf("level=error msg=\"invalid password hash settings\" err=%v", err)
+		return err
+	}
+
 	store, err := storage.Open(*db, options)
 	if err != nil {
 		log.Printf("level=error msg=\"failed to open storage\" path=%s err=%v", *db, err)
@@ -116,7 +145,7 @@ func run() error {
 	// Initialize admin user if this is the first run
 	if !*dbReadOnly {
 		// storage.Store implements auth.Store interface
-		authMgr := auth.NewManager(store, 24*time.Hour)
+		authMgr := passwords.newAuthManager(store, passwordHasher)
 		adminPassword, err := authMgr.InitializeAdmin()
 		if err != nil {
 			log.Printf("level=error msg=\"failed to initialize admin user\" err=%v", err)
@@ -137,7 +166,26 @@ func run() error {
 		Commit:    commit,
 		BuildDate: buildDate,
 	}
-	s, err := server.New(*root, *addr, store, scanInterval, *noInitialScan, *cors, *jsonErrors, versionInfo, true, *readOnlyScan, extensionList, ff)
+	s, err := server.New(server.Options{
+		Root:              *root,
+		Addr:              *addr,
+		Store:             store,
+		ScanInterval:      scanInterval,
+		NoInitialScan:     *noInitialScan,
+		CORS:              *cors,
+		JSONErrors:        *jsonErrors,
+		Version:           versionInfo,
+		FFmpegReady:       true,
+		FFmpegPath:        ff,
+		AllowReadOnlyScan: *readOnlyScan,
+		Extensions:        extensionList,
+		ScanWorkers:       *scanWorkers,
+		OfflineGrace:      *offlineGrace,
+		Watch:             *watch,
+		AuditRetention:    *auditRetention,
+		PasswordPolicy:    passwords.policy(),
+		PasswordHasher:    passwordHasher,
+	})
 	if err != nil {
 		log.Printf("level=error msg=\"failed to initialize server\" addr=%s root=%s err=%v", *addr, *root, err)
 		return err
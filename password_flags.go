@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/treefix50/primetime/internal/auth"
+)
+
+// passwordFlags holds the password policy and hashing flags. The server and the admin
+// commands register the same flags, so both validate and hash passwords alike.
+type passwordFlags struct {
+	minLength     *int
+	mixedCase     *bool
+	digit         *bool
+	symbol        *bool
+	hash          *string
+	argon2Memory  *uint
+	argon2Time    *uint
+	argon2Threads *uint
+}
+
+func addPasswordFlags(fs *flag.FlagSet) *passwordFlags {
+	return &passwordFlags{
+		minLength:     fs.Int("password-min-length", auth.DefaultPasswordPolicy().MinLength, "minimum length of user-chosen passwords"),
+		mixedCase:     fs.Bool("password-require-mixed-case", false, "require upper and lower case letters in passwords"),
+		digit:         fs.Bool("password-require-digit", false, "require a digit in passwords"),
+		symbol:        fs.Bool("password-require-symbol", false, "require a symbol in passwords"),
+		hash:          fs.String("password-hash", "argon2id", "algorithm for new password hashes (argon2id or bcrypt)"),
+		argon2Memory:  fs.Uint("argon2-memory", uint(auth.DefaultArgon2Params().Memory), "argon2id memory in KiB"),
+		argon2Time:    fs.Uint("argon2-iterations", uint(auth.DefaultArgon2Params().Iterations), "argon2id iterations"),
+		argon2Threads: fs.Uint("argon2-parallelism", uint(auth.DefaultArgon2Params().Parallelism), "argon2id parallelism"),
+	}
+}
+
+func (f *passwordFlags) policy() auth.PasswordPolicy {
+	return auth.PasswordPolicy{
+		MinLength:        *f.minLength,
+		RequireMixedCase: *f.mixedCase,
+		RequireDigit:     *f.digit,
+		RequireSymbol:    *f.symbol,
+	}
+}
+
+func (f *passwordFlags) hasher() (auth.PasswordHasher, error) {
+	if *f.argon2Memory > math.MaxUint32 || *f.argon2Time > math.MaxUint32 || *f.argon2Threads > math.MaxUint8 {
+		return nil, fmt.Errorf("argon2 parameters out of range")
+	}
+	return auth.NewPasswordHasher(*f.hash, auth.Argon2Params{
+		Memory:      uint32(*f.argon2Memory),
+		Iterations:  uint32(*f.argon2Time),
+		Parallelism: uint8(*f.argon2Threads),
+	})
+}
+
+// newAuthManager returns an auth manager enforcing the password policy of the flags
+// and hashing with hasher, which comes from f.hasher.
+func (f *passwordFlags) newAuthManager(store auth.Store, hasher auth.PasswordHasher) *auth.Manager {
+	manager := auth.NewManager(store, 24*time.Hour)
+	manager.SetPasswordPolicy(f.policy())
+	manager.SetPasswordHasher(hasher)
+	return manager
+}